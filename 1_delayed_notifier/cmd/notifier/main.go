@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"delayed-notifier/internal/grpcapi"
 	"delayed-notifier/internal/httpapi"
 	"delayed-notifier/internal/queue/kafka"
 	"delayed-notifier/internal/queue/rabbit"
@@ -10,6 +11,7 @@ import (
 	"delayed-notifier/internal/storage/redis"
 	"delayed-notifier/internal/worker"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -21,6 +23,7 @@ import (
 	"github.com/kxddry/wbf/ginext"
 	"github.com/kxddry/wbf/zlog"
 	"github.com/subosito/gotenv"
+	"google.golang.org/grpc"
 )
 
 func main() {
@@ -49,6 +52,15 @@ func main() {
 	}
 	addr := fmt.Sprintf("%s:%s", host, portStr)
 
+	grpcPortStr := cfg.GetString("grpc.port")
+	if grpcPortStr == "" {
+		grpcPortStr = "9090"
+	}
+	if _, err := strconv.Atoi(grpcPortStr); err != nil {
+		grpcPortStr = "9090"
+	}
+	grpcAddr := fmt.Sprintf("%s:%s", host, grpcPortStr)
+
 	redisPort := cfg.GetString("redis.port")
 	if redisPort == "" {
 		redisPort = "6379"
@@ -147,6 +159,20 @@ func main() {
 		}
 	}()
 
+	grpcLis, err := net.Listen("tcp", grpcAddr)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to listen for grpc")
+	}
+	grpcSrv := grpc.NewServer()
+	grpcapi.Register(grpcSrv, redisStore)
+
+	go func() {
+		log.Info().Msgf("grpc server starting on %s", grpcAddr)
+		if err := grpcSrv.Serve(grpcLis); err != nil {
+			log.Err(err).Msg("failed to start grpc server")
+		}
+	}()
+
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 	<-sigCh
@@ -158,6 +184,18 @@ func main() {
 		log.Err(err).Msg("http server shutdown error")
 	}
 
+	// watch streams only end when their context is done, so cancel them after a grace period
+	grpcStopped := make(chan struct{})
+	go func() {
+		grpcSrv.GracefulStop()
+		close(grpcStopped)
+	}()
+	select {
+	case <-grpcStopped:
+	case <-shutdownCtx.Done():
+		grpcSrv.Stop()
+	}
+
 	cancel()
 
 	if err := rmq.Close(); err != nil {
@@ -0,0 +1,181 @@
+// Package grpcapi exposes the notifier over gRPC, alongside the HTTP API.
+package grpcapi
+
+import (
+	"context"
+	"time"
+
+	"delayed-notifier/internal/models"
+	"delayed-notifier/pkg/notifierpb"
+
+	"github.com/kxddry/wbf/zlog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Store is the subset of storage methods used by the gRPC API.
+type Store interface {
+	CreateNotification(ctx context.Context, n *models.Notification) error
+	GetNotification(ctx context.Context, id string) (*models.Notification, error)
+	CancelNotification(ctx context.Context, id string) error
+	ListNotifications(ctx context.Context, offset, limit int64) ([]*models.Notification, error)
+	WatchNotifications(ctx context.Context) (<-chan *models.Notification, error)
+}
+
+// Server implements notifierpb.NotifierServer on top of Store.
+type Server struct {
+	notifierpb.UnimplementedNotifierServer
+	store Store
+}
+
+// NewServer creates a new gRPC API server.
+func NewServer(store Store) *Server {
+	return &Server{store: store}
+}
+
+// Register registers the notifier service on the given gRPC server.
+func Register(gs *grpc.Server, store Store) {
+	notifierpb.RegisterNotifierServer(gs, NewServer(store))
+}
+
+// CreateNotification validates the request and schedules a notification.
+func (s *Server) CreateNotification(ctx context.Context, req *notifierpb.CreateNotificationRequest) (*notifierpb.Notification, error) {
+	log := zlog.Logger.With().Str("component", "grpcapi").Logger()
+
+	var sendAt *time.Time
+	if req.GetSendAt() != nil {
+		t := req.GetSendAt().AsTime()
+		sendAt = &t
+	}
+	n, err := models.NewNotification(req.GetChannel(), req.GetRecipient(), req.GetMessage(), sendAt)
+	if err != nil {
+		log.Error().Err(err).Msg("invalid notification")
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := s.store.CreateNotification(ctx, n); err != nil {
+		log.Error().Err(err).Msg("create notification failed")
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return toProto(n), nil
+}
+
+// GetNotification returns a notification by id.
+func (s *Server) GetNotification(ctx context.Context, req *notifierpb.GetNotificationRequest) (*notifierpb.Notification, error) {
+	n, err := s.get(ctx, req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	return toProto(n), nil
+}
+
+// CancelNotification cancels a notification and returns its new state.
+func (s *Server) CancelNotification(ctx context.Context, req *notifierpb.CancelNotificationRequest) (*notifierpb.Notification, error) {
+	log := zlog.Logger.With().Str("component", "grpcapi").Logger()
+
+	if _, err := s.get(ctx, req.GetId()); err != nil {
+		return nil, err
+	}
+	if err := s.store.CancelNotification(ctx, req.GetId()); err != nil {
+		log.Error().Err(err).Str("id", req.GetId()).Msg("cancel failed")
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	n, err := s.get(ctx, req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	return toProto(n), nil
+}
+
+// ListNotifications returns a page of notifications, newest first.
+func (s *Server) ListNotifications(ctx context.Context, req *notifierpb.ListNotificationsRequest) (*notifierpb.ListNotificationsResponse, error) {
+	if req.GetOffset() < 0 || req.GetLimit() < 0 {
+		return nil, status.Error(codes.InvalidArgument, "offset and limit must not be negative")
+	}
+	list, err := s.store.ListNotifications(ctx, req.GetOffset(), req.GetLimit())
+	if err != nil {
+		zlog.Logger.Error().Err(err).Str("component", "grpcapi").Msg("list notifications failed")
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	resp := &notifierpb.ListNotificationsResponse{Notifications: make([]*notifierpb.Notification, 0, len(list))}
+	for _, n := range list {
+		resp.Notifications = append(resp.Notifications, toProto(n))
+	}
+	return resp, nil
+}
+
+// WatchNotifications streams status changes until the client disconnects.
+func (s *Server) WatchNotifications(req *notifierpb.WatchNotificationsRequest, stream grpc.ServerStreamingServer[notifierpb.Notification]) error {
+	ctx := stream.Context()
+	events, err := s.store.WatchNotifications(ctx)
+	if err != nil {
+		zlog.Logger.Error().Err(err).Str("component", "grpcapi").Msg("watch notifications failed")
+		return status.Error(codes.Internal, err.Error())
+	}
+	filter := make(map[string]struct{}, len(req.GetIds()))
+	for _, id := range req.GetIds() {
+		filter[id] = struct{}{}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case n, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if len(filter) > 0 {
+				if _, ok := filter[n.ID]; !ok {
+					continue
+				}
+			}
+			if err := stream.Send(toProto(n)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *Server) get(ctx context.Context, id string) (*models.Notification, error) {
+	if id == "" {
+		return nil, status.Error(codes.InvalidArgument, "id is required")
+	}
+	n, err := s.store.GetNotification(ctx, id)
+	if err != nil {
+		zlog.Logger.Error().Err(err).Str("component", "grpcapi").Msg("get notification failed")
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if n == nil {
+		return nil, status.Error(codes.NotFound, "not found")
+	}
+	return n, nil
+}
+
+var statusToProto = map[models.NotificationStatus]notifierpb.Status{
+	models.StatusScheduled: notifierpb.Status_STATUS_SCHEDULED,
+	models.StatusQueued:    notifierpb.Status_STATUS_QUEUED,
+	models.StatusSent:      notifierpb.Status_STATUS_SENT,
+	models.StatusFailed:    notifierpb.Status_STATUS_FAILED,
+	models.StatusRetrying:  notifierpb.Status_STATUS_RETRYING,
+	models.StatusCancelled: notifierpb.Status_STATUS_CANCELLED,
+}
+
+func toProto(n *models.Notification) *notifierpb.Notification {
+	out := &notifierpb.Notification{
+		Id:         n.ID,
+		Channel:    n.Channel,
+		Recipient:  n.Recipient,
+		Message:    n.Message,
+		SendAt:     timestamppb.New(n.SendAt),
+		Status:     statusToProto[n.Status],
+		RetryCount: int32(n.RetryCount),
+		LastError:  n.LastError,
+		CreatedAt:  timestamppb.New(n.CreatedAt),
+		UpdatedAt:  timestamppb.New(n.UpdatedAt),
+	}
+	if n.NextAttemptAt != nil {
+		out.NextAttemptAt = timestamppb.New(*n.NextAttemptAt)
+	}
+	return out
+}
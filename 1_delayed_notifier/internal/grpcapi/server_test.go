@@ -0,0 +1,209 @@
+package grpcapi
+
+import (
+	"context"
+	"net"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"delayed-notifier/internal/models"
+	"delayed-notifier/pkg/notifierpb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type fakeStore struct {
+	mu       sync.Mutex
+	items    map[string]*models.Notification
+	watchers []chan *models.Notification
+	watching chan struct{}
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{items: map[string]*models.Notification{}, watching: make(chan struct{}, 1)}
+}
+
+func (s *fakeStore) save(n *models.Notification) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := *n
+	s.items[n.ID] = &c
+	for _, w := range s.watchers {
+		e := c
+		w <- &e
+	}
+}
+
+func (s *fakeStore) CreateNotification(ctx context.Context, n *models.Notification) error {
+	s.save(n)
+	return nil
+}
+
+func (s *fakeStore) GetNotification(ctx context.Context, id string) (*models.Notification, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, ok := s.items[id]
+	if !ok {
+		return nil, nil
+	}
+	c := *n
+	return &c, nil
+}
+
+func (s *fakeStore) CancelNotification(ctx context.Context, id string) error {
+	n, _ := s.GetNotification(ctx, id)
+	if n == nil {
+		return nil
+	}
+	n.Status = models.StatusCancelled
+	s.save(n)
+	return nil
+}
+
+func (s *fakeStore) ListNotifications(ctx context.Context, offset, limit int64) ([]*models.Notification, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]*models.Notification, 0, len(s.items))
+	for _, n := range s.items {
+		out = append(out, n)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.After(out[j].CreatedAt) })
+	if offset >= int64(len(out)) {
+		return nil, nil
+	}
+	out = out[offset:]
+	if limit > 0 && limit < int64(len(out)) {
+		out = out[:limit]
+	}
+	return out, nil
+}
+
+func (s *fakeStore) WatchNotifications(ctx context.Context) (<-chan *models.Notification, error) {
+	ch := make(chan *models.Notification, 16)
+	s.mu.Lock()
+	s.watchers = append(s.watchers, ch)
+	s.mu.Unlock()
+	s.watching <- struct{}{}
+	return ch, nil
+}
+
+func newTestClient(t *testing.T, store Store) notifierpb.NotifierClient {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	gs := grpc.NewServer()
+	Register(gs, store)
+	go func() { _ = gs.Serve(lis) }()
+	t.Cleanup(gs.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("dial bufconn: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return notifierpb.NewNotifierClient(conn)
+}
+
+func TestCreateGetCancel(t *testing.T) {
+	client := newTestClient(t, newFakeStore())
+	ctx := context.Background()
+
+	created, err := client.CreateNotification(ctx, &notifierpb.CreateNotificationRequest{
+		Channel: "telegram", Recipient: "123456789", Message: "hi",
+	})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if created.GetId() == "" || created.GetStatus() != notifierpb.Status_STATUS_SCHEDULED {
+		t.Fatalf("unexpected created notification: %v", created)
+	}
+
+	got, err := client.GetNotification(ctx, &notifierpb.GetNotificationRequest{Id: created.GetId()})
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if got.GetMessage() != "hi" {
+		t.Fatalf("unexpected message %q", got.GetMessage())
+	}
+
+	cancelled, err := client.CancelNotification(ctx, &notifierpb.CancelNotificationRequest{Id: created.GetId()})
+	if err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	if cancelled.GetStatus() != notifierpb.Status_STATUS_CANCELLED {
+		t.Fatalf("expected cancelled status, got %v", cancelled.GetStatus())
+	}
+}
+
+func TestCreateValidation(t *testing.T) {
+	client := newTestClient(t, newFakeStore())
+
+	_, err := client.CreateNotification(context.Background(), &notifierpb.CreateNotificationRequest{
+		Channel: "telegram", Recipient: "not-digits", Message: "hi",
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument, got %v", err)
+	}
+}
+
+func TestGetNotFound(t *testing.T) {
+	client := newTestClient(t, newFakeStore())
+
+	_, err := client.GetNotification(context.Background(), &notifierpb.GetNotificationRequest{Id: "missing"})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("expected NotFound, got %v", err)
+	}
+}
+
+func TestListNotifications(t *testing.T) {
+	store := newFakeStore()
+	client := newTestClient(t, store)
+	now := time.Now().UTC()
+	for i, id := range []string{"a", "b", "c"} {
+		store.save(&models.Notification{ID: id, CreatedAt: now.Add(time.Duration(i) * time.Second)})
+	}
+
+	resp, err := client.ListNotifications(context.Background(), &notifierpb.ListNotificationsRequest{Offset: 1, Limit: 1})
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(resp.GetNotifications()) != 1 || resp.GetNotifications()[0].GetId() != "b" {
+		t.Fatalf("unexpected page: %v", resp.GetNotifications())
+	}
+}
+
+func TestWatchNotifications(t *testing.T) {
+	store := newFakeStore()
+	client := newTestClient(t, store)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	stream, err := client.WatchNotifications(ctx, &notifierpb.WatchNotificationsRequest{Ids: []string{"w1"}})
+	if err != nil {
+		t.Fatalf("watch: %v", err)
+	}
+	select {
+	case <-store.watching:
+	case <-ctx.Done():
+		t.Fatalf("server did not subscribe")
+	}
+
+	store.save(&models.Notification{ID: "other", Status: models.StatusQueued})
+	store.save(&models.Notification{ID: "w1", Status: models.StatusSent})
+
+	ev, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("recv: %v", err)
+	}
+	if ev.GetId() != "w1" || ev.GetStatus() != notifierpb.Status_STATUS_SENT {
+		t.Fatalf("unexpected event: %v", ev)
+	}
+}
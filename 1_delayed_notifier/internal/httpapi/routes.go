@@ -9,7 +9,6 @@ import (
 	"delayed-notifier/internal/storage/redis"
 
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
 	"github.com/kxddry/wbf/ginext"
 	"github.com/kxddry/wbf/zlog"
 )
@@ -34,45 +33,12 @@ func RegisterRoutes(ctx context.Context, r *ginext.Engine, store *redis.Storage)
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		if req.Channel == "" || req.Recipient == "" || req.Message == "" {
-			log.Error().Msg("channel, recipient and message are required")
-			c.JSON(http.StatusBadRequest, gin.H{"error": "channel, recipient and message are required"})
+		n, err := models.NewNotification(req.Channel, req.Recipient, req.Message, req.SendAt)
+		if err != nil {
+			log.Error().Err(err).Msg("invalid notification")
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		// Validate telegram recipient: must be between 3 and 13 digits
-		if req.Channel == "telegram" {
-			valid := len(req.Recipient) >= 3 && len(req.Recipient) <= 13
-			if valid {
-				for i := 0; i < len(req.Recipient); i++ {
-					ch := req.Recipient[i]
-					if ch < '0' || ch > '9' {
-						valid = false
-						break
-					}
-				}
-			}
-			if !valid {
-				log.Error().Msg("telegram recipient must be between 3 and 13 digits")
-				c.JSON(http.StatusBadRequest, gin.H{"error": "telegram recipient must be between 3 and 13 digits"})
-				return
-			}
-		}
-		id := uuid.NewString()
-		now := time.Now().UTC()
-		sendAt := now
-		if req.SendAt != nil {
-			sendAt = req.SendAt.UTC()
-		}
-		n := &models.Notification{
-			ID:        id,
-			Channel:   req.Channel,
-			Recipient: req.Recipient,
-			Message:   req.Message,
-			SendAt:    sendAt,
-			Status:    models.StatusScheduled,
-			CreatedAt: now,
-			UpdatedAt: now,
-		}
 		if err := store.CreateNotification(ctx, n); err != nil {
 			log.Error().Err(err).Msg("create notification failed")
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
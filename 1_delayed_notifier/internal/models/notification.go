@@ -1,7 +1,10 @@
 package models
 
 import (
+	"errors"
 	"time"
+
+	"github.com/google/uuid"
 )
 
 // NotificationStatus represents the current processing state of a notification.
@@ -31,3 +34,49 @@ type Notification struct {
 	CreatedAt     time.Time          `json:"created_at"`
 	UpdatedAt     time.Time          `json:"updated_at"`
 }
+
+// Validation errors returned by NewNotification.
+var (
+	ErrMissingFields            = errors.New("channel, recipient and message are required")
+	ErrInvalidTelegramRecipient = errors.New("telegram recipient must be between 3 and 13 digits")
+)
+
+// NewNotification validates the input and builds a scheduled notification.
+// A nil sendAt means the notification is due immediately.
+// It is shared by the HTTP and gRPC APIs so both accept exactly the same input.
+func NewNotification(channel, recipient, message string, sendAt *time.Time) (*Notification, error) {
+	if channel == "" || recipient == "" || message == "" {
+		return nil, ErrMissingFields
+	}
+	// Validate telegram recipient: must be between 3 and 13 digits
+	if channel == "telegram" {
+		valid := len(recipient) >= 3 && len(recipient) <= 13
+		if valid {
+			for i := 0; i < len(recipient); i++ {
+				ch := recipient[i]
+				if ch < '0' || ch > '9' {
+					valid = false
+					break
+				}
+			}
+		}
+		if !valid {
+			return nil, ErrInvalidTelegramRecipient
+		}
+	}
+	now := time.Now().UTC()
+	at := now
+	if sendAt != nil {
+		at = sendAt.UTC()
+	}
+	return &Notification{
+		ID:        uuid.NewString(),
+		Channel:   channel,
+		Recipient: recipient,
+		Message:   message,
+		SendAt:    at,
+		Status:    StatusScheduled,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}, nil
+}
@@ -30,6 +30,8 @@ const (
 	keyNotificationObj = "notify:obj:%s"
 	keyDueZSet         = "notify:due"
 	keyRetryZSet       = "notify:retry"
+	keyAllZSet         = "notify:all"
+	keyEventsChannel   = "notify:events"
 )
 
 // NewStorage constructs a RedisStorage and pings the server.
@@ -50,7 +52,7 @@ func (s *Storage) Close() error {
 	return s.client.Close()
 }
 
-// SaveNotification updates the stored notification object.
+// SaveNotification updates the stored notification object and publishes the new state to watchers.
 func (s *Storage) SaveNotification(ctx context.Context, n *models.Notification) error {
 	if n == nil || n.ID == "" {
 		return storage.ErrInvalidNotification
@@ -60,7 +62,11 @@ func (s *Storage) SaveNotification(ctx context.Context, n *models.Notification)
 		return err
 	}
 	key := fmt.Sprintf(keyNotificationObj, n.ID)
-	return s.client.Set(ctx, key, bytes, 0).Err()
+	pipe := s.client.TxPipeline()
+	pipe.Set(ctx, key, bytes, 0)
+	pipe.Publish(ctx, keyEventsChannel, bytes)
+	_, err = pipe.Exec(ctx)
+	return err
 }
 
 // CreateNotification stores a new notification and schedules it in the due set.
@@ -71,10 +77,99 @@ func (s *Storage) CreateNotification(ctx context.Context, n *models.Notification
 	if err := s.SaveNotification(ctx, n); err != nil {
 		return err
 	}
-	return s.client.ZAdd(ctx, keyDueZSet, redis.Z{
+	pipe := s.client.TxPipeline()
+	pipe.ZAdd(ctx, keyDueZSet, redis.Z{
 		Score:  float64(n.SendAt.Unix()),
 		Member: n.ID,
-	}).Err()
+	})
+	pipe.ZAdd(ctx, keyAllZSet, redis.Z{
+		Score:  float64(n.CreatedAt.UnixNano()),
+		Member: n.ID,
+	})
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// ListNotifications returns up to 'limit' notifications starting at 'offset', newest first.
+func (s *Storage) ListNotifications(ctx context.Context, offset, limit int64) ([]*models.Notification, error) {
+	log := zlog.Logger.With().Str("component", "redis").Logger()
+
+	if offset < 0 {
+		offset = 0
+	}
+	if limit <= 0 {
+		limit = 50
+	}
+	ids, err := s.client.ZRevRange(ctx, keyAllZSet, offset, offset+limit-1).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	keys := make([]string, 0, len(ids))
+	for _, id := range ids {
+		keys = append(keys, fmt.Sprintf(keyNotificationObj, id))
+	}
+	vals, err := s.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*models.Notification, 0, len(vals))
+	for i, v := range vals {
+		str, ok := v.(string)
+		if !ok {
+			log.Debug().Str("id", ids[i]).Msg("indexed notification not found")
+			continue
+		}
+		var n models.Notification
+		if err := json.Unmarshal([]byte(str), &n); err != nil {
+			log.Error().Err(err).Str("id", ids[i]).Msg("failed to unmarshal notification")
+			continue
+		}
+		out = append(out, &n)
+	}
+	return out, nil
+}
+
+// WatchNotifications streams every saved notification state until ctx is cancelled.
+// The returned channel is closed when the subscription ends.
+func (s *Storage) WatchNotifications(ctx context.Context) (<-chan *models.Notification, error) {
+	log := zlog.Logger.With().Str("component", "redis").Logger()
+
+	sub := s.client.Subscribe(ctx, keyEventsChannel)
+	// wait for the subscription confirmation so no event published after return is lost
+	if _, err := sub.Receive(ctx); err != nil {
+		_ = sub.Close()
+		return nil, err
+	}
+	out := make(chan *models.Notification, 16)
+	go func() {
+		defer close(out)
+		defer sub.Close()
+		msgs := sub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-msgs:
+				if !ok {
+					return
+				}
+				var n models.Notification
+				if err := json.Unmarshal([]byte(msg.Payload), &n); err != nil {
+					log.Error().Err(err).Msg("failed to unmarshal notification event")
+					continue
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case out <- &n:
+				}
+			}
+		}
+	}()
+	return out, nil
 }
 
 // GetNotification returns a notification by id or nil if not found.
@@ -0,0 +1,5 @@
+// Package notifierpb is the generated gRPC client and server code for the notifier API.
+// The source lives in api/proto/notifier/v1/notifier.proto; regenerate it with `buf generate` from the module root.
+package notifierpb
+
+//go:generate sh -c "cd ../.. && buf generate"
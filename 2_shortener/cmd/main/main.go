@@ -3,19 +3,34 @@ package main
 import (
 	"context"
 	"os"
+	"os/signal"
 	"shortener/internal/api"
+	"shortener/internal/card"
+	"shortener/internal/geoip"
+	"shortener/internal/ingest"
+	"shortener/internal/privacy"
+	"shortener/internal/rollup"
+	"shortener/internal/safety"
+	"shortener/internal/shortcode"
 	"shortener/internal/storage/cached"
 	"shortener/internal/storage/postgres"
 	"shortener/internal/validator"
+	"shortener/internal/webhook"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	_ "time/tzdata"
 
 	"github.com/kxddry/wbf/config"
 	"github.com/kxddry/wbf/zlog"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/subosito/gotenv"
 )
 
 func main() {
 	_ = gotenv.Load(".env")
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
 	zlog.Init()
@@ -37,18 +52,165 @@ func main() {
 	}
 	defer store.Close()
 
+	// without a secret the shuffle is keyed with a known value, so anyone could decode codes back to link
+	// numbers and walk to the neighbouring links
+	codeSecret := cfg.GetString("shortener.code_secret")
+	if codeSecret == "" {
+		zlog.Logger.Fatal().Msg("shortener.code_secret is not set; set it to a long random string and keep it")
+	}
+	codec, err := shortcode.New(shortcode.Options{
+		Secret:    codeSecret,
+		MinLength: intOption(cfg, "shortener.code_min_length"),
+		Reserved:  listOption(cfg, "shortener.reserved_words"),
+	})
+	if err != nil {
+		zlog.Logger.Fatal().Err(err).Msg("failed to configure short codes")
+	}
+	store.SetCodeGenerator(store.Sequence(codec))
+
 	cache, err := cached.New(ctx, cfg.GetString("redis.host"), cfg.GetString("redis.password"), 0)
 	if err != nil {
 		zlog.Logger.Warn().Err(err).Msg("failed to connect to redis, caching is disabled")
 		cache = nil
+	} else {
+		cache.Configure(cached.Options{
+			Capacity:    intOption(cfg, "cache.capacity"),
+			Window:      durationOption(cfg, "cache.window"),
+			MinHits:     intOption(cfg, "cache.min_hits"),
+			NegativeTTL: durationOption(cfg, "cache.negative_ttl"),
+		})
 	}
 
 	v := validator.New()
-	srv := api.New(store, store, *v, cache)
-	// Register routes (with ctx for async click logging)
+	// a nil *cached.Redis must not end up as a non-nil interface value
+	var cacheStorage api.CacheStorage
+	var attempts api.AttemptCounter
+	if cache != nil {
+		cacheStorage = cache
+		attempts = cache
+	}
+	ipMode, err := privacy.ParseMode(cfg.GetString("privacy.ip_mode"))
+	if err != nil {
+		zlog.Logger.Fatal().Err(err).Msg("failed to configure privacy")
+	}
+	// clicks have their IPs anonymised before they are saved, and fire their links' webhooks once they are
+	clicks, err := ingest.New(privacy.NewSink(webhook.NewSink(store, store), ipMode, store), ingest.Options{
+		Capacity:      intOption(cfg, "clicks.buffer_size"),
+		BatchSize:     intOption(cfg, "clicks.batch_size"),
+		FlushInterval: durationOption(cfg, "clicks.flush_interval"),
+		BlockTimeout:  durationOption(cfg, "clicks.block_timeout"),
+	}, prometheus.DefaultRegisterer)
+	if err != nil {
+		zlog.Logger.Fatal().Err(err).Msg("failed to create click buffer")
+	}
+	// the buffer outlives the HTTP server so clicks recorded by in-flight requests are flushed
+	clicksCtx, stopClicks := context.WithCancel(context.Background())
+	clicksDone := make(chan struct{})
+	go func() {
+		clicks.Run(clicksCtx)
+		close(clicksDone)
+	}()
+
+	// analytics reads completed hours from rollups kept up to date in the background
+	go rollup.New(store, durationOption(cfg, "analytics.rollup_interval"), durationOption(cfg, "analytics.rollup_lag")).Run(ctx)
+
+	if days := intOption(cfg, "privacy.retention_days"); days > 0 {
+		retention, err := privacy.NewRetention(store, days, cfg.GetString("privacy.retention_mode"), durationOption(cfg, "privacy.retention_interval"))
+		if err != nil {
+			zlog.Logger.Fatal().Err(err).Msg("failed to configure click retention")
+		}
+		go retention.Run(ctx)
+	}
+
+	go webhook.NewDeliverer(store, webhook.Options{
+		Interval:    durationOption(cfg, "webhooks.interval"),
+		Timeout:     durationOption(cfg, "webhooks.timeout"),
+		MaxAttempts: intOption(cfg, "webhooks.max_attempts"),
+		Backoff:     durationOption(cfg, "webhooks.backoff"),
+	}).Run(ctx)
+
+	// a nil *geoip.Resolver must not end up as a non-nil interface value either
+	var geo api.GeoResolver
+	if path := cfg.GetString("geoip.path"); path != "" {
+		resolver, err := geoip.Open(path)
+		if err != nil {
+			zlog.Logger.Warn().Err(err).Msg("failed to load GeoIP database, clicks are stored without a location")
+		} else {
+			go resolver.Watch(ctx, durationOption(cfg, "geoip.reload_interval"))
+			geo = resolver
+		}
+	}
+
+	var checker api.URLChecker
+	allow, deny, blocklist := listOption(cfg, "safety.allow_domains"), listOption(cfg, "safety.deny_domains"), cfg.GetString("safety.blocklist_path")
+	if len(allow) > 0 || len(deny) > 0 || blocklist != "" {
+		// unlike GeoIP, a policy that fails to load must not quietly let everything through
+		policy, err := safety.New(allow, deny, blocklist)
+		if err != nil {
+			zlog.Logger.Fatal().Err(err).Msg("failed to load safety policy")
+		}
+		go policy.Watch(ctx, durationOption(cfg, "safety.reload_interval"))
+		checker = policy
+	}
+
+	srv := api.New(store, store, *v, cacheStorage)
+	srv.Configure(api.Options{
+		ExpiredFallbackURL: cfg.GetString("shortener.expired_fallback_url"),
+		PublicURL:          cfg.GetString("shortener.public_url"),
+		Clicks:             clicks,
+		GeoIP:              geo,
+		Attempts:           attempts,
+		Safety:             checker,
+		AdminToken:         cfg.GetString("shortener.admin_token"),
+		HideIPs:            ipMode != privacy.ModeFull,
+		Cards:              card.NewCache(store, durationOption(cfg, "cards.timeout"), durationOption(cfg, "cards.ttl")),
+	})
 	srv.RegisterRoutes(ctx)
 
 	if err := srv.Run(ctx); err != nil {
-		zlog.Logger.Fatal().Err(err).Msg("server exited with error")
+		zlog.Logger.Error().Err(err).Msg("server exited with error")
+	}
+
+	zlog.Logger.Info().Int("clicks", clicks.Depth()).Msg("flushing clicks")
+	stopClicks()
+	<-clicksDone
+}
+
+// intOption reads an optional integer setting; missing or invalid values yield zero, i.e. the default.
+func intOption(cfg *config.Config, key string) int {
+	v := cfg.GetString(key)
+	if v == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		zlog.Logger.Warn().Err(err).Str("key", key).Msg("invalid integer setting, using the default")
+		return 0
+	}
+	return n
+}
+
+// durationOption reads an optional duration setting such as "500ms"; missing or invalid values yield zero.
+func durationOption(cfg *config.Config, key string) time.Duration {
+	v := cfg.GetString(key)
+	if v == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		zlog.Logger.Warn().Err(err).Str("key", key).Msg("invalid duration setting, using the default")
+		return 0
+	}
+	return d
+}
+
+// listOption reads an optional comma-separated list setting, dropping empty items.
+func listOption(cfg *config.Config, key string) []string {
+	var items []string
+	for _, item := range strings.Split(cfg.GetString(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
 	}
+	return items
 }
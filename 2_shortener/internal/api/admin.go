@@ -0,0 +1,68 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"shortener/internal/domain"
+	"shortener/internal/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kxddry/wbf/ginext"
+	"github.com/kxddry/wbf/zlog"
+)
+
+func (s *Server) postDisable() func(c *ginext.Context) {
+	return func(c *ginext.Context) {
+		key := linkKey(c)
+		// the reason is optional, and so is the body
+		var req domain.DisableLinkRequest
+		if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := s.validator.Struct(req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		err := s.urlStorage.DisableLink(c.Request.Context(), key, req.Reason)
+		if !s.adminUpdated(c, key, err) {
+			return
+		}
+		zlog.Logger.Info().Str("short_code", key).Str("reason", req.Reason).Msg("link disabled")
+	}
+}
+
+func (s *Server) postEnable() func(c *ginext.Context) {
+	return func(c *ginext.Context) {
+		key := linkKey(c)
+		err := s.urlStorage.EnableLink(c.Request.Context(), key)
+		if !s.adminUpdated(c, key, err) {
+			return
+		}
+		zlog.Logger.Info().Str("short_code", key).Msg("link enabled")
+	}
+}
+
+// adminUpdated finishes an admin change to a link, given the error of making it: it drops the link from the
+// cache and answers with the link as it is now. It returns false if the change failed.
+func (s *Server) adminUpdated(c *ginext.Context, key string, err error) bool {
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "short code not found"})
+			return false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	s.invalidate(c.Request.Context(), key)
+
+	link, err := s.urlStorage.GetLink(c.Request.Context(), key)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return true
+	}
+	c.JSON(http.StatusOK, link)
+	return true
+}
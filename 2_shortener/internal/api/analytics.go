@@ -0,0 +1,194 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"shortener/internal/domain"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kxddry/wbf/ginext"
+)
+
+// maxSeriesBuckets is the most buckets a series may have, e.g. about six weeks of hours.
+const maxSeriesBuckets = 1000
+
+// defaultBuckets is how many buckets, up to and including the current one, the series covers when the
+// request leaves the start of the range out.
+var defaultBuckets = map[string]int{
+	domain.GranularityHour:  48,
+	domain.GranularityDay:   30,
+	domain.GranularityWeek:  12,
+	domain.GranularityMonth: 12,
+}
+
+// parseLocation returns the IANA timezone named by v, UTC if v is empty. The process' local timezone is not
+// one the database knows, so it is refused.
+func parseLocation(v string) (*time.Location, error) {
+	if v == "" {
+		return time.UTC, nil
+	}
+	if v == "Local" {
+		return nil, errors.New("unknown time zone Local")
+	}
+	return time.LoadLocation(v)
+}
+
+// bucketStart returns the start of the bucket of the granularity t falls into, on the calendar of t's
+// location. Weeks start on Monday.
+func bucketStart(t time.Time, granularity string) time.Time {
+	y, m, d := t.Date()
+	switch granularity {
+	case domain.GranularityHour:
+		return time.Date(y, m, d, t.Hour(), 0, 0, 0, t.Location())
+	case domain.GranularityWeek:
+		return time.Date(y, m, d-(int(t.Weekday())+6)%7, 0, 0, 0, 0, t.Location())
+	case domain.GranularityMonth:
+		return time.Date(y, m, 1, 0, 0, 0, 0, t.Location())
+	}
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// addBuckets moves t by n buckets of the granularity on the wall clock of its location.
+func addBuckets(t time.Time, granularity string, n int) time.Time {
+	switch granularity {
+	case domain.GranularityHour:
+		return t.Add(time.Duration(n) * time.Hour)
+	case domain.GranularityWeek:
+		return t.AddDate(0, 0, 7*n)
+	case domain.GranularityMonth:
+		return t.AddDate(0, n, 0)
+	}
+	return t.AddDate(0, 0, n)
+}
+
+// seriesRange fills in the bounds the request left out: to is now, and from is defaultBuckets buckets
+// before it. Both bounds are inclusive and in loc.
+func seriesRange(from, to *time.Time, granularity string, loc *time.Location, now time.Time) (time.Time, time.Time) {
+	end := now.In(loc)
+	if to != nil {
+		end = to.In(loc)
+	}
+	if from != nil {
+		return from.In(loc), end
+	}
+	return addBuckets(bucketStart(end, granularity), granularity, 1-defaultBuckets[granularity]), end
+}
+
+// seriesBuckets returns about how many buckets of the granularity the inclusive [from, to] range has, erring on
+// the side of too many.
+func seriesBuckets(from, to time.Time, granularity string) int {
+	// a month is counted as 28 days
+	size := map[string]time.Duration{
+		domain.GranularityHour:  time.Hour,
+		domain.GranularityDay:   24 * time.Hour,
+		domain.GranularityWeek:  7 * 24 * time.Hour,
+		domain.GranularityMonth: 28 * 24 * time.Hour,
+	}[granularity]
+	return int(to.Sub(from)/size) + 1
+}
+
+// analyticsQuery is the query of an analytics request: the range, in loc, and how it is bucketed if at all.
+type analyticsQuery struct {
+	from, to    *time.Time
+	loc         *time.Location
+	granularity string
+	includeBots bool
+}
+
+// parseAnalyticsQuery parses the tz, from, to, granularity and include_bots parameters of an analytics request.
+// A series needs both ends of the range, so they are filled in when a granularity is asked for. If the query is
+// invalid, it answers with a 400 and returns false.
+func parseAnalyticsQuery(c *ginext.Context) (analyticsQuery, bool) {
+	loc, err := parseLocation(c.Query("tz"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid 'tz'; expected an IANA time zone such as Europe/Berlin"})
+		return analyticsQuery{}, false
+	}
+	from, err := parseBound(c.Query("from"), false, loc)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid 'from'; expected YYYY-MM-DD or RFC3339"})
+		return analyticsQuery{}, false
+	}
+	to, err := parseBound(c.Query("to"), true, loc)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid 'to'; expected YYYY-MM-DD or RFC3339"})
+		return analyticsQuery{}, false
+	}
+	granularity := c.Query("granularity")
+	if _, ok := defaultBuckets[granularity]; granularity != "" && !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid 'granularity'; expected hour, day, week or month"})
+		return analyticsQuery{}, false
+	}
+	if granularity != "" {
+		// a series needs both ends, and the rest of the response covers the same range
+		start, end := seriesRange(from, to, granularity, loc, time.Now())
+		from, to = &start, &end
+	}
+	if from != nil && to != nil && to.Before(*from) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "'from' must not be after 'to'"})
+		return analyticsQuery{}, false
+	}
+	if granularity != "" && seriesBuckets(*from, *to, granularity) > maxSeriesBuckets {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "too many buckets; narrow 'from' and 'to' or use a coarser 'granularity'"})
+		return analyticsQuery{}, false
+	}
+
+	includeBots := false
+	if v := c.Query("include_bots"); v != "" {
+		parsed, err := strconv.ParseBool(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid 'include_bots'; expected true or false"})
+			return analyticsQuery{}, false
+		}
+		includeBots = parsed
+	}
+	return analyticsQuery{from: from, to: to, loc: loc, granularity: granularity, includeBots: includeBots}, true
+}
+
+// seriesFunc returns the clicks of the inclusive [from, to] range per bucket of the analytics query.
+type seriesFunc func(ctx context.Context, from, to time.Time) ([]domain.SeriesPoint, error)
+
+// finishAnalytics hides what the server keeps private even from owners in resp and, if the query has a
+// granularity, adds the series of its range and of the period before it, as series has them.
+func (s *Server) finishAnalytics(ctx context.Context, resp *domain.AnalyticsResponse, q analyticsQuery, series seriesFunc) error {
+	if s.opts.HideIPs {
+		resp.TopIPs = nil
+	}
+	if q.granularity == "" {
+		return nil
+	}
+	var err error
+	resp.Granularity, resp.Timezone = q.granularity, q.loc.String()
+	resp.Current, resp.Previous, resp.Change, err = comparePeriods(ctx, *q.from, *q.to, series)
+	return err
+}
+
+// comparePeriods returns the clicks of the inclusive [from, to] range per bucket, the same for the period of
+// the same length right before it, and the percentage change between the two.
+func comparePeriods(ctx context.Context, from, to time.Time, series seriesFunc) (cur, prev *domain.Period, change *float64, err error) {
+	// the bounds are inclusive, so the period is a microsecond, the precision of timestamps, longer than to-from
+	length := to.Sub(from) + time.Microsecond
+	periods := []*domain.Period{
+		{From: from, To: to},
+		{From: from.Add(-length), To: from.Add(-time.Microsecond)},
+	}
+	for _, p := range periods {
+		p.Series, err = series(ctx, p.From, p.To)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		for _, point := range p.Series {
+			p.Clicks += point.Clicks
+		}
+	}
+	cur, prev = periods[0], periods[1]
+	if prev.Clicks > 0 {
+		pct := math.Round(float64(cur.Clicks-prev.Clicks)/float64(prev.Clicks)*1000) / 10
+		change = &pct
+	}
+	return cur, prev, change, nil
+}
@@ -5,39 +5,97 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"maps"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"shortener/internal/domain"
 	"shortener/internal/storage"
 	"shortener/internal/validator"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
 // Mock implementations
 type mockURLStorage struct {
-	urls map[string]string // shortCode -> URL
-	err  error
+	urls    map[string]string // link key -> URL
+	links   map[string]domain.ShortenedURL
+	keys    map[string]string // API key -> owner ID
+	domains map[string]domain.Domain
+	// webhooks are keyed by ID, deliveries by webhook ID
+	webhooks   map[string]domain.Webhook
+	deliveries map[string][]domain.WebhookDelivery
+	groups     map[string]domain.LinkGroup
+	err        error
 }
 
-func (m *mockURLStorage) SaveURL(ctx context.Context, url string, withAlias bool, alias string) (string, error) {
+func (m *mockURLStorage) SaveURL(ctx context.Context, link domain.ShortenedURL) (string, error) {
 	if m.err != nil {
 		return "", m.err
 	}
-	
-	var shortCode string
-	if withAlias {
-		if _, exists := m.urls[alias]; exists {
+
+	if link.ShortCode != "" {
+		if _, exists := m.urls[link.Key()]; exists {
 			return "", errors.New("alias already exists")
 		}
-		shortCode = alias
 	} else {
-		shortCode = "abc123" // Fixed for testing
+		link.ShortCode = "abc123" // Fixed for testing
 	}
-	
-	m.urls[shortCode] = url
-	return shortCode, nil
+
+	m.urls[link.Key()] = link.URL
+	m.links[link.Key()] = link
+	return link.ShortCode, nil
+}
+
+func (m *mockURLStorage) SaveURLs(ctx context.Context, links []domain.ShortenedURL) ([]string, error) {
+	codes := make([]string, len(links))
+	for i, link := range links {
+		if link.ShortCode == "" {
+			link.ShortCode = "gen" + strconv.Itoa(len(m.urls))
+		}
+		code, err := m.SaveURL(ctx, link)
+		if m.err != nil {
+			return codes, m.err
+		}
+		if err == nil {
+			codes[i] = code
+		}
+	}
+	return codes, nil
+}
+
+func (m *mockURLStorage) GetLink(ctx context.Context, shortCode string) (domain.ShortenedURL, error) {
+	if m.err != nil {
+		return domain.ShortenedURL{}, m.err
+	}
+	if link, ok := m.links[shortCode]; ok {
+		return link, nil
+	}
+	if url, ok := m.urls[shortCode]; ok {
+		return domain.ShortenedURL{URL: url, ShortCode: shortCode}, nil
+	}
+	return domain.ShortenedURL{}, storage.ErrNotFound
+}
+
+func (m *mockURLStorage) UseClick(ctx context.Context, shortCode string) error {
+	link, ok := m.links[shortCode]
+	if !ok {
+		return storage.ErrNotFound
+	}
+	if link.Expired(time.Now()) {
+		return storage.ErrExpired
+	}
+	link.ClicksUsed++
+	m.links[shortCode] = link
+	return nil
 }
 
 func (m *mockURLStorage) GetURL(ctx context.Context, shortCode string) (string, error) {
@@ -50,9 +108,312 @@ func (m *mockURLStorage) GetURL(ctx context.Context, shortCode string) (string,
 	return "", storage.ErrNotFound
 }
 
+func (m *mockURLStorage) ListLinks(ctx context.Context, ownerID, query string, limit, offset int) (domain.LinksPage, error) {
+	page := domain.LinksPage{Links: []domain.ShortenedURL{}, Limit: limit, Offset: offset}
+	for _, link := range m.links {
+		if link.OwnerID == ownerID {
+			page.Links = append(page.Links, link)
+		}
+	}
+	page.Total = int64(len(page.Links))
+	return page, nil
+}
+
+func (m *mockURLStorage) UpdateURL(ctx context.Context, ownerID, shortCode, url string) error {
+	link, ok := m.links[shortCode]
+	if !ok || link.OwnerID != ownerID {
+		return storage.ErrNotFound
+	}
+	link.URL = url
+	m.links[shortCode] = link
+	m.urls[shortCode] = url
+	return nil
+}
+
+func (m *mockURLStorage) UpdateRedirect(ctx context.Context, ownerID, shortCode string, redirectType *int, card *domain.Card) error {
+	link, ok := m.links[shortCode]
+	if !ok || link.OwnerID != ownerID {
+		return storage.ErrNotFound
+	}
+	if redirectType != nil {
+		link.RedirectType = *redirectType
+	}
+	if card != nil {
+		link.Card = card
+		if card.Empty() {
+			link.Card = nil
+		}
+	}
+	m.links[shortCode] = link
+	return nil
+}
+
+func (m *mockURLStorage) DeleteURL(ctx context.Context, ownerID, shortCode string) error {
+	link, ok := m.links[shortCode]
+	if !ok || link.OwnerID != ownerID {
+		return storage.ErrNotFound
+	}
+	delete(m.links, shortCode)
+	delete(m.urls, shortCode)
+	return nil
+}
+
+func (m *mockURLStorage) DisableLink(ctx context.Context, shortCode, reason string) error {
+	link, ok := m.links[shortCode]
+	if !ok {
+		return storage.ErrNotFound
+	}
+	if link.DisabledAt == nil {
+		now := time.Now()
+		link.DisabledAt = &now
+	}
+	link.DisabledReason = reason
+	m.links[shortCode] = link
+	return nil
+}
+
+func (m *mockURLStorage) EnableLink(ctx context.Context, shortCode string) error {
+	link, ok := m.links[shortCode]
+	if !ok {
+		return storage.ErrNotFound
+	}
+	link.DisabledAt, link.DisabledReason = nil, ""
+	m.links[shortCode] = link
+	return nil
+}
+
+func (m *mockURLStorage) CreateAPIKey(ctx context.Context) (domain.APIKey, error) {
+	key := domain.APIKey{OwnerID: "owner-" + strconv.Itoa(len(m.keys)+1), Key: "key-" + strconv.Itoa(len(m.keys)+1)}
+	m.keys[key.Key] = key.OwnerID
+	return key, nil
+}
+
+func (m *mockURLStorage) OwnerByAPIKey(ctx context.Context, key string) (string, error) {
+	if owner, ok := m.keys[key]; ok {
+		return owner, nil
+	}
+	return "", storage.ErrUnauthorized
+}
+
+func (m *mockURLStorage) CreateDomain(ctx context.Context, d domain.Domain) (domain.Domain, error) {
+	if _, ok := m.domains[d.Domain]; ok {
+		return domain.Domain{}, storage.ErrDomainTaken
+	}
+	d.CreatedAt = time.Now()
+	m.domains[d.Domain] = d
+	return d, nil
+}
+
+func (m *mockURLStorage) ListDomains(ctx context.Context, ownerID string) ([]domain.Domain, error) {
+	domains := []domain.Domain{}
+	for _, d := range m.domains {
+		if d.OwnerID == ownerID {
+			domains = append(domains, d)
+		}
+	}
+	return domains, nil
+}
+
+func (m *mockURLStorage) Domains(ctx context.Context) ([]domain.Domain, error) {
+	return slices.Collect(maps.Values(m.domains)), nil
+}
+
+func (m *mockURLStorage) DeleteDomain(ctx context.Context, ownerID, host string) error {
+	d, ok := m.domains[host]
+	if !ok || d.OwnerID != ownerID {
+		return storage.ErrNotFound
+	}
+	for _, link := range m.links {
+		if link.Domain == host {
+			return storage.ErrDomainInUse
+		}
+	}
+	delete(m.domains, host)
+	return nil
+}
+
+func (m *mockURLStorage) CreateWebhook(ctx context.Context, w domain.Webhook) (domain.Webhook, error) {
+	link, ok := m.links[domain.LinkKey(w.Domain, w.ShortCode)]
+	if !ok || link.OwnerID != w.OwnerID {
+		return domain.Webhook{}, storage.ErrNotFound
+	}
+	w.ID = fmt.Sprintf("00000000-0000-0000-0000-%012d", len(m.webhooks)+1)
+	w.Secret = "whsec_test"
+	w.CreatedAt = time.Now()
+	m.webhooks[w.ID] = w
+	return w, nil
+}
+
+func (m *mockURLStorage) ListWebhooks(ctx context.Context, ownerID, key string) ([]domain.Webhook, error) {
+	webhooks := []domain.Webhook{}
+	for _, w := range m.webhooks {
+		if w.OwnerID == ownerID && domain.LinkKey(w.Domain, w.ShortCode) == key {
+			w.Secret = ""
+			webhooks = append(webhooks, w)
+		}
+	}
+	return webhooks, nil
+}
+
+func (m *mockURLStorage) DeleteWebhook(ctx context.Context, ownerID, id string) error {
+	if w, ok := m.webhooks[id]; !ok || w.OwnerID != ownerID {
+		return storage.ErrNotFound
+	}
+	delete(m.webhooks, id)
+	return nil
+}
+
+func (m *mockURLStorage) WebhookDeliveries(ctx context.Context, ownerID, id string, limit int) ([]domain.WebhookDelivery, error) {
+	if w, ok := m.webhooks[id]; !ok || w.OwnerID != ownerID {
+		return nil, storage.ErrNotFound
+	}
+	deliveries := append([]domain.WebhookDelivery{}, m.deliveries[id]...)
+	return deliveries[:min(limit, len(deliveries))], nil
+}
+
+func (m *mockURLStorage) CreateGroup(ctx context.Context, g domain.LinkGroup) (domain.LinkGroup, error) {
+	if err := m.checkGroupLinks(g.OwnerID, g.Links); err != nil {
+		return domain.LinkGroup{}, err
+	}
+	g.ID = fmt.Sprintf("00000000-0000-0000-0000-%012d", len(m.groups)+1)
+	g.Links = append([]string{}, g.Links...)
+	g.CreatedAt = time.Now()
+	m.groups[g.ID] = g
+	return g, nil
+}
+
+func (m *mockURLStorage) checkGroupLinks(ownerID string, keys []string) error {
+	var missing []string
+	for _, key := range keys {
+		if link, ok := m.links[key]; !ok || link.OwnerID != ownerID {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: %s", storage.ErrLinksNotFound, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func (m *mockURLStorage) ListGroups(ctx context.Context, ownerID string) ([]domain.LinkGroup, error) {
+	groups := []domain.LinkGroup{}
+	for _, g := range m.groups {
+		if g.OwnerID == ownerID {
+			groups = append(groups, g)
+		}
+	}
+	return groups, nil
+}
+
+func (m *mockURLStorage) GetGroup(ctx context.Context, ownerID, id string) (domain.LinkGroup, error) {
+	g, ok := m.groups[id]
+	if !ok || g.OwnerID != ownerID {
+		return domain.LinkGroup{}, storage.ErrNotFound
+	}
+	return g, nil
+}
+
+func (m *mockURLStorage) AddGroupLinks(ctx context.Context, ownerID, id string, keys []string) error {
+	g, err := m.GetGroup(ctx, ownerID, id)
+	if err != nil {
+		return err
+	}
+	if err := m.checkGroupLinks(ownerID, keys); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if !slices.Contains(g.Links, key) {
+			g.Links = append(g.Links, key)
+		}
+	}
+	m.groups[id] = g
+	return nil
+}
+
+func (m *mockURLStorage) RemoveGroupLink(ctx context.Context, ownerID, id, key string) error {
+	g, err := m.GetGroup(ctx, ownerID, id)
+	if err != nil {
+		return err
+	}
+	i := slices.Index(g.Links, key)
+	if i < 0 {
+		return storage.ErrNotFound
+	}
+	g.Links = slices.Delete(g.Links, i, i+1)
+	m.groups[id] = g
+	return nil
+}
+
+func (m *mockURLStorage) DeleteGroup(ctx context.Context, ownerID, id string) error {
+	if _, err := m.GetGroup(ctx, ownerID, id); err != nil {
+		return err
+	}
+	delete(m.groups, id)
+	return nil
+}
+
+// mockMinHits is how many clicks the mock cache wants before it caches a link.
+const mockMinHits = 10
+
+type mockCache struct {
+	links   map[string]domain.ShortenedURL
+	hits    map[string]float64
+	unknown map[string]bool
+	deleted []string
+}
+
+func newMockCache() *mockCache {
+	return &mockCache{links: map[string]domain.ShortenedURL{}, hits: map[string]float64{}, unknown: map[string]bool{}}
+}
+
+func (m *mockCache) GetLink(ctx context.Context, shortCode string) (domain.ShortenedURL, error) {
+	if link, ok := m.links[shortCode]; ok {
+		return link, nil
+	}
+	if m.unknown[shortCode] {
+		return domain.ShortenedURL{}, storage.ErrUnknownLink
+	}
+	return domain.ShortenedURL{}, storage.ErrNotFound
+}
+
+func (m *mockCache) CountClick(ctx context.Context, shortCode string) (float64, error) {
+	m.hits[shortCode]++
+	return m.hits[shortCode], nil
+}
+
+func (m *mockCache) RecentClicks(ctx context.Context, shortCode string, window time.Duration) (int64, error) {
+	return int64(m.hits[shortCode]), nil
+}
+
+func (m *mockCache) LinkVersion(ctx context.Context, shortCode string) (int64, error) { return 0, nil }
+
+func (m *mockCache) AdmitLink(ctx context.Context, link domain.ShortenedURL, hits float64, version int64) (bool, error) {
+	if hits < mockMinHits {
+		return false, nil
+	}
+	m.links[link.Key()] = link
+	return true, nil
+}
+
+func (m *mockCache) SetUnknown(ctx context.Context, shortCode string, version int64) error {
+	m.unknown[shortCode] = true
+	return nil
+}
+
+func (m *mockCache) DeleteLink(ctx context.Context, shortCodes ...string) error {
+	for _, shortCode := range shortCodes {
+		delete(m.links, shortCode)
+		delete(m.unknown, shortCode)
+	}
+	m.deleted = append(m.deleted, shortCodes...)
+	return nil
+}
+
 type mockClickStorage struct {
 	clicks map[string][]domain.Click
-	err    error
+	// urls has the groups GroupAnalytics reads
+	urls *mockURLStorage
+	err  error
 }
 
 func (m *mockClickStorage) SaveClick(ctx context.Context, click domain.Click) error {
@@ -63,40 +424,167 @@ func (m *mockClickStorage) SaveClick(ctx context.Context, click domain.Click) er
 	return nil
 }
 
-func (m *mockClickStorage) Analytics(ctx context.Context, shortCode string, from, to *time.Time, topLimit int) (domain.AnalyticsResponse, error) {
+func (m *mockClickStorage) Analytics(ctx context.Context, shortCode string, from, to *time.Time, topLimit int, includeBots bool) (domain.AnalyticsResponse, error) {
 	if m.err != nil {
 		return domain.AnalyticsResponse{}, m.err
 	}
-	
+
 	clicks, exists := m.clicks[shortCode]
 	if !exists {
 		return domain.AnalyticsResponse{}, storage.ErrNotFound
 	}
-	
+
+	topIPs := make(map[string]int64)
+	for _, click := range clicks {
+		topIPs[click.IP]++
+	}
 	return domain.AnalyticsResponse{
 		ShortCode:   shortCode,
 		TotalClicks: int64(len(clicks)),
+		TopIPs:      topIPs,
 	}, nil
 }
 
+// ClickSeries buckets the clicks in [from, to] on the calendar of loc.
+func (m *mockClickStorage) ClickSeries(ctx context.Context, shortCode string, from, to time.Time, granularity string, loc *time.Location, includeBots bool) ([]domain.SeriesPoint, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	var series []domain.SeriesPoint
+	for start := bucketStart(from.In(loc), granularity); !start.After(to); start = addBuckets(start, granularity, 1) {
+		series = append(series, domain.SeriesPoint{Start: start})
+	}
+	for _, click := range m.clicks[shortCode] {
+		if click.Timestamp.Before(from) || click.Timestamp.After(to) || click.IsBot && !includeBots {
+			continue
+		}
+		start := bucketStart(click.Timestamp.In(loc), granularity)
+		for i := range series {
+			if series[i].Start.Equal(start) {
+				series[i].Clicks++
+			}
+		}
+	}
+	return series, nil
+}
+
+// GroupAnalytics sums the clicks of the group's links and ranks the links by them.
+func (m *mockClickStorage) GroupAnalytics(ctx context.Context, ownerID, id string, from, to *time.Time, topLimit int, includeBots bool) (domain.GroupAnalyticsResponse, error) {
+	g, err := m.urls.GetGroup(ctx, ownerID, id)
+	if err != nil {
+		return domain.GroupAnalyticsResponse{}, err
+	}
+	resp := domain.GroupAnalyticsResponse{GroupID: g.ID, Name: g.Name, Leaderboard: []domain.LinkStats{}}
+	resp.TopIPs = make(map[string]int64)
+	for _, key := range g.Links {
+		host, shortCode := domain.SplitLinkKey(key)
+		stats := domain.LinkStats{ShortCode: shortCode, Domain: host, URL: m.urls.urls[key]}
+		for _, click := range m.clicks[key] {
+			resp.TopIPs[click.IP]++
+			stats.Clicks++
+		}
+		resp.TotalClicks += stats.Clicks
+		resp.Leaderboard = append(resp.Leaderboard, stats)
+	}
+	slices.SortStableFunc(resp.Leaderboard, func(a, b domain.LinkStats) int { return int(b.Clicks - a.Clicks) })
+	return resp, nil
+}
+
+// GroupClickSeries sums the series of the group's links.
+func (m *mockClickStorage) GroupClickSeries(ctx context.Context, id string, from, to time.Time, granularity string, loc *time.Location, includeBots bool) ([]domain.SeriesPoint, error) {
+	var sum []domain.SeriesPoint
+	for _, key := range m.urls.groups[id].Links {
+		series, err := m.ClickSeries(ctx, key, from, to, granularity, loc, includeBots)
+		if err != nil {
+			return nil, err
+		}
+		if sum == nil {
+			sum = series
+			continue
+		}
+		for i := range sum {
+			sum[i].Clicks += series[i].Clicks
+		}
+	}
+	return sum, nil
+}
+
 // Implement other required methods...
-func (m *mockClickStorage) GetClicks(ctx context.Context, shortCode string, limit, offset int) ([]domain.Click, error) { return nil, nil }
-func (m *mockClickStorage) ClickCount(ctx context.Context, shortCode string) (int64, error) { return 0, nil }
-func (m *mockClickStorage) UniqueClickCount(ctx context.Context, shortCode string) (int64, error) { return 0, nil }
-func (m *mockClickStorage) ClicksByDay(ctx context.Context, shortCode string, start, end *time.Time) (map[string]int64, error) { return nil, nil }
-func (m *mockClickStorage) ClicksByMonth(ctx context.Context, shortCode string, start, end *time.Time) (map[string]int64, error) { return nil, nil }
-func (m *mockClickStorage) ClicksByUserAgent(ctx context.Context, shortCode string, start, end *time.Time, limit int) (map[string]int64, error) { return nil, nil }
-func (m *mockClickStorage) ClicksByReferer(ctx context.Context, shortCode string, start, end *time.Time, limit int) (map[string]int64, error) { return nil, nil }
-func (m *mockClickStorage) ClicksByIP(ctx context.Context, shortCode string, start, end *time.Time, limit int) (map[string]int64, error) { return nil, nil }
+func (m *mockClickStorage) GetClicks(ctx context.Context, shortCode string, limit, offset int) ([]domain.Click, error) {
+	return nil, nil
+}
+func (m *mockClickStorage) ClicksPage(ctx context.Context, shortCode string, after *domain.ClickCursor, limit int) ([]domain.Click, error) {
+	clicks := slices.Clone(m.clicks[shortCode])
+	slices.SortFunc(clicks, func(a, b domain.Click) int {
+		if c := b.Timestamp.Compare(a.Timestamp); c != 0 {
+			return c
+		}
+		return strings.Compare(b.ID, a.ID)
+	})
+	var out []domain.Click
+	for _, c := range clicks {
+		if after != nil && (c.Timestamp.After(after.Timestamp) || c.Timestamp.Equal(after.Timestamp) && c.ID >= after.ID) {
+			continue
+		}
+		if len(out) < limit {
+			out = append(out, c)
+		}
+	}
+	return out, nil
+}
+func (m *mockClickStorage) ExportClicks(ctx context.Context, shortCode string, start, end *time.Time, fn func(domain.Click) error) error {
+	for _, c := range m.clicks[shortCode] {
+		if start != nil && c.Timestamp.Before(*start) || end != nil && c.Timestamp.After(*end) {
+			continue
+		}
+		if err := fn(c); err != nil {
+			return err
+		}
+	}
+	return m.err
+}
+func (m *mockClickStorage) ClickCount(ctx context.Context, shortCode string) (int64, error) {
+	return 0, nil
+}
+func (m *mockClickStorage) UniqueClickCount(ctx context.Context, shortCode string) (int64, error) {
+	return 0, nil
+}
+func (m *mockClickStorage) ClicksByDay(ctx context.Context, shortCode string, start, end *time.Time) (map[string]int64, error) {
+	return nil, nil
+}
+func (m *mockClickStorage) ClicksByMonth(ctx context.Context, shortCode string, start, end *time.Time) (map[string]int64, error) {
+	return nil, nil
+}
+func (m *mockClickStorage) ClicksByUserAgent(ctx context.Context, shortCode string, start, end *time.Time, limit int) (map[string]int64, error) {
+	return nil, nil
+}
+func (m *mockClickStorage) ClicksByReferer(ctx context.Context, shortCode string, start, end *time.Time, limit int) (map[string]int64, error) {
+	return nil, nil
+}
+func (m *mockClickStorage) ClicksByIP(ctx context.Context, shortCode string, start, end *time.Time, limit int) (map[string]int64, error) {
+	return nil, nil
+}
+
+func (m *mockClickStorage) ClicksBySource(ctx context.Context, shortCode string, start, end *time.Time) (map[string]int64, error) {
+	return nil, nil
+}
 
 func newTestServer() (*Server, *mockURLStorage, *mockClickStorage) {
-	urlStorage := &mockURLStorage{urls: make(map[string]string)}
-	clickStorage := &mockClickStorage{clicks: make(map[string][]domain.Click)}
+	urlStorage := &mockURLStorage{
+		urls:       make(map[string]string),
+		links:      make(map[string]domain.ShortenedURL),
+		keys:       make(map[string]string),
+		domains:    make(map[string]domain.Domain),
+		webhooks:   make(map[string]domain.Webhook),
+		deliveries: make(map[string][]domain.WebhookDelivery),
+		groups:     make(map[string]domain.LinkGroup),
+	}
+	clickStorage := &mockClickStorage{clicks: make(map[string][]domain.Click), urls: urlStorage}
 	validator := validator.New()
-	
+
 	server := New(urlStorage, clickStorage, *validator, nil)
 	server.RegisterRoutes(context.Background())
-	
+
 	return server, urlStorage, clickStorage
 }
 
@@ -104,25 +592,25 @@ func newTestServer() (*Server, *mockURLStorage, *mockClickStorage) {
 
 func TestCreateLink_Success(t *testing.T) {
 	server, _, _ := newTestServer()
-	
+
 	reqBody := domain.ShortenRequest{URL: "https://example.com"}
 	body, _ := json.Marshal(reqBody)
-	
+
 	req := httptest.NewRequest("POST", "/shorten", bytes.NewReader(body))
 	req.Header.Set("Content-Type", "application/json")
 	w := httptest.NewRecorder()
-	
+
 	server.g.ServeHTTP(w, req)
-	
+
 	if w.Code != http.StatusOK {
 		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
 	}
-	
+
 	var resp map[string]string
 	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
 		t.Fatalf("failed to unmarshal response: %v", err)
 	}
-	
+
 	if resp["short_code"] == "" {
 		t.Fatalf("expected short_code in response, got: %v", resp)
 	}
@@ -130,19 +618,19 @@ func TestCreateLink_Success(t *testing.T) {
 
 func TestRedirectLink_Success(t *testing.T) {
 	server, urlStorage, _ := newTestServer()
-	
+
 	// Pre-populate storage
 	urlStorage.urls["abc123"] = "https://example.com"
-	
+
 	req := httptest.NewRequest("GET", "/s/abc123", nil)
 	w := httptest.NewRecorder()
-	
+
 	server.g.ServeHTTP(w, req)
-	
+
 	if w.Code != http.StatusTemporaryRedirect {
 		t.Fatalf("expected 307, got %d", w.Code)
 	}
-	
+
 	location := w.Header().Get("Location")
 	if location != "https://example.com" {
 		t.Fatalf("expected redirect to https://example.com, got %s", location)
@@ -151,12 +639,12 @@ func TestRedirectLink_Success(t *testing.T) {
 
 func TestRedirectLink_NotFound(t *testing.T) {
 	server, _, _ := newTestServer()
-	
+
 	req := httptest.NewRequest("GET", "/s/missing", nil)
 	w := httptest.NewRecorder()
-	
+
 	server.g.ServeHTTP(w, req)
-	
+
 	if w.Code != http.StatusNotFound {
 		t.Fatalf("expected 404, got %d", w.Code)
 	}
@@ -164,40 +652,66 @@ func TestRedirectLink_NotFound(t *testing.T) {
 
 func TestAnalytics_Success(t *testing.T) {
 	server, _, clickStorage := newTestServer()
-	
+
 	// Pre-populate with clicks
 	clickStorage.clicks["abc123"] = []domain.Click{
 		{ShortCode: "abc123", IP: "127.0.0.1"},
 		{ShortCode: "abc123", IP: "127.0.0.2"},
 	}
-	
+
 	req := httptest.NewRequest("GET", "/analytics/abc123", nil)
 	w := httptest.NewRecorder()
-	
+
 	server.g.ServeHTTP(w, req)
-	
+
 	if w.Code != http.StatusOK {
 		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
 	}
-	
+
 	var resp domain.AnalyticsResponse
 	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
 		t.Fatalf("failed to unmarshal response: %v", err)
 	}
-	
+
 	if resp.TotalClicks != 2 {
 		t.Fatalf("expected 2 clicks, got %d", resp.TotalClicks)
 	}
 }
 
+func TestAnalytics_HideIPs(t *testing.T) {
+	server, urlStorage, clickStorage := newTestServer()
+	ownClicksLink(urlStorage)
+	urlStorage.keys["mallory-key"] = "mallory"
+	clickStorage.clicks["abc123"] = []domain.Click{{ShortCode: "abc123", IP: "127.0.0.1"}}
+
+	for _, hide := range []bool{false, true} {
+		server.Configure(Options{HideIPs: hide})
+		for key, owner := range map[string]bool{"": false, "mallory-key": false, "owner-key": true} {
+			req := httptest.NewRequest("GET", "/analytics/abc123", nil)
+			if key != "" {
+				req.Header.Set("X-API-Key", key)
+			}
+			w := httptest.NewRecorder()
+			server.g.ServeHTTP(w, req)
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+			}
+			// the top IPs are the owner's, and then only while the IPs are stored in full
+			if shown := strings.Contains(w.Body.String(), `"top_ips"`); shown != (owner && !hide) {
+				t.Fatalf("hide %v, key %q: unexpected response %s", hide, key, w.Body.String())
+			}
+		}
+	}
+}
+
 func TestAnalytics_NotFound(t *testing.T) {
 	server, _, _ := newTestServer()
-	
+
 	req := httptest.NewRequest("GET", "/analytics/missing", nil)
 	w := httptest.NewRecorder()
-	
+
 	server.g.ServeHTTP(w, req)
-	
+
 	if w.Code != http.StatusInternalServerError {
 		t.Fatalf("expected 500, got %d", w.Code)
 	}
@@ -207,28 +721,28 @@ func TestAnalytics_NotFound(t *testing.T) {
 
 func TestCreateLink_CustomAlias_Success(t *testing.T) {
 	server, _, _ := newTestServer()
-	
+
 	reqBody := domain.ShortenRequest{
 		URL:   "https://example.com",
 		Alias: "my-custom-alias",
 	}
 	body, _ := json.Marshal(reqBody)
-	
+
 	req := httptest.NewRequest("POST", "/shorten", bytes.NewReader(body))
 	req.Header.Set("Content-Type", "application/json")
 	w := httptest.NewRecorder()
-	
+
 	server.g.ServeHTTP(w, req)
-	
+
 	if w.Code != http.StatusOK {
 		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
 	}
-	
+
 	var resp map[string]string
 	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
 		t.Fatalf("failed to unmarshal response: %v", err)
 	}
-	
+
 	if resp["short_code"] != "my-custom-alias" {
 		t.Fatalf("expected 'my-custom-alias', got %s", resp["short_code"])
 	}
@@ -236,22 +750,22 @@ func TestCreateLink_CustomAlias_Success(t *testing.T) {
 
 func TestCreateLink_CustomAlias_AlreadyExists(t *testing.T) {
 	server, urlStorage, _ := newTestServer()
-	
+
 	// Pre-populate with existing alias
 	urlStorage.urls["existing"] = "https://other.com"
-	
+
 	reqBody := domain.ShortenRequest{
 		URL:   "https://example.com",
 		Alias: "existing",
 	}
 	body, _ := json.Marshal(reqBody)
-	
+
 	req := httptest.NewRequest("POST", "/shorten", bytes.NewReader(body))
 	req.Header.Set("Content-Type", "application/json")
 	w := httptest.NewRecorder()
-	
+
 	server.g.ServeHTTP(w, req)
-	
+
 	if w.Code != http.StatusInternalServerError {
 		t.Fatalf("expected 500, got %d", w.Code)
 	}
@@ -259,23 +773,1828 @@ func TestCreateLink_CustomAlias_AlreadyExists(t *testing.T) {
 
 func TestCreateLink_CustomAlias_ConflictWithGenerated(t *testing.T) {
 	server, urlStorage, _ := newTestServer()
-	
+
 	// Pre-populate with a generated code that matches our alias
 	urlStorage.urls["abc123"] = "https://other.com"
-	
+
 	reqBody := domain.ShortenRequest{
 		URL:   "https://example.com",
 		Alias: "abc123", // Conflicts with existing generated code
 	}
 	body, _ := json.Marshal(reqBody)
-	
+
 	req := httptest.NewRequest("POST", "/shorten", bytes.NewReader(body))
 	req.Header.Set("Content-Type", "application/json")
 	w := httptest.NewRecorder()
-	
+
 	server.g.ServeHTTP(w, req)
-	
+
 	if w.Code != http.StatusInternalServerError {
 		t.Fatalf("expected 500, got %d", w.Code)
 	}
-}
\ No newline at end of file
+}
+
+// EXPIRATION TESTS
+
+func TestRedirectLink_ExpiredByDate(t *testing.T) {
+	server, urlStorage, _ := newTestServer()
+
+	past := time.Now().Add(-time.Hour)
+	urlStorage.links["old"] = domain.ShortenedURL{URL: "https://example.com", ShortCode: "old", ExpiresAt: &past}
+
+	req := httptest.NewRequest("GET", "/s/old", nil)
+	w := httptest.NewRecorder()
+
+	server.g.ServeHTTP(w, req)
+
+	if w.Code != http.StatusGone {
+		t.Fatalf("expected 410, got %d", w.Code)
+	}
+}
+
+func TestRedirectLink_ClickBudget(t *testing.T) {
+	server, urlStorage, _ := newTestServer()
+
+	budget := int64(2)
+	urlStorage.links["limited"] = domain.ShortenedURL{URL: "https://example.com", ShortCode: "limited", MaxClicks: &budget}
+
+	for i, want := range []int{http.StatusTemporaryRedirect, http.StatusTemporaryRedirect, http.StatusGone} {
+		req := httptest.NewRequest("GET", "/s/limited", nil)
+		w := httptest.NewRecorder()
+
+		server.g.ServeHTTP(w, req)
+
+		if w.Code != want {
+			t.Fatalf("request %d: expected %d, got %d", i+1, want, w.Code)
+		}
+	}
+}
+
+func TestRedirectLink_ExpiredFallback(t *testing.T) {
+	server, urlStorage, _ := newTestServer()
+	server.Configure(Options{ExpiredFallbackURL: "https://example.com/expired"})
+
+	past := time.Now().Add(-time.Hour)
+	urlStorage.links["old"] = domain.ShortenedURL{URL: "https://example.com", ShortCode: "old", ExpiresAt: &past}
+
+	req := httptest.NewRequest("GET", "/s/old", nil)
+	w := httptest.NewRecorder()
+
+	server.g.ServeHTTP(w, req)
+
+	if w.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("expected 307, got %d", w.Code)
+	}
+	if location := w.Header().Get("Location"); location != "https://example.com/expired" {
+		t.Fatalf("expected redirect to fallback, got %s", location)
+	}
+}
+
+func TestCreateLink_ExpiresInPast(t *testing.T) {
+	server, _, _ := newTestServer()
+
+	past := time.Now().Add(-time.Hour)
+	body, _ := json.Marshal(domain.ShortenRequest{URL: "https://example.com", ExpiresAt: &past})
+
+	req := httptest.NewRequest("POST", "/shorten", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	server.g.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", w.Code)
+	}
+}
+
+// LINK MANAGEMENT TESTS
+
+func TestLinks_RequireAPIKey(t *testing.T) {
+	server, _, _ := newTestServer()
+
+	req := httptest.NewRequest("GET", "/links", nil)
+	w := httptest.NewRecorder()
+
+	server.g.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/links", nil)
+	req.Header.Set("X-API-Key", "unknown")
+	w = httptest.NewRecorder()
+
+	server.g.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for unknown key, got %d", w.Code)
+	}
+}
+
+func TestLinks_OwnerLifecycle(t *testing.T) {
+	server, urlStorage, _ := newTestServer()
+	cache := newMockCache()
+	server.cache = cache
+
+	// Issue a key
+	req := httptest.NewRequest("POST", "/keys", nil)
+	w := httptest.NewRecorder()
+	server.g.ServeHTTP(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", w.Code)
+	}
+	var key domain.APIKey
+	if err := json.Unmarshal(w.Body.Bytes(), &key); err != nil {
+		t.Fatalf("failed to unmarshal key: %v", err)
+	}
+
+	// Create an owned link
+	body, _ := json.Marshal(domain.ShortenRequest{URL: "https://example.com", Alias: "owned"})
+	req = httptest.NewRequest("POST", "/shorten", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+key.Key)
+	w = httptest.NewRecorder()
+	server.g.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if urlStorage.links["owned"].OwnerID != key.OwnerID {
+		t.Fatalf("expected link to be owned by %s, got %q", key.OwnerID, urlStorage.links["owned"].OwnerID)
+	}
+
+	// List
+	req = httptest.NewRequest("GET", "/links", nil)
+	req.Header.Set("X-API-Key", key.Key)
+	w = httptest.NewRecorder()
+	server.g.ServeHTTP(w, req)
+	var page domain.LinksPage
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Fatalf("failed to unmarshal page: %v", err)
+	}
+	if page.Total != 1 || page.Links[0].ShortCode != "owned" {
+		t.Fatalf("unexpected page: %+v", page)
+	}
+
+	// Patch invalidates the cached destination
+	cache.links["owned"] = domain.ShortenedURL{ShortCode: "owned", URL: "https://example.com"}
+	body, _ = json.Marshal(domain.UpdateLinkRequest{URL: "https://example.org"})
+	req = httptest.NewRequest("PATCH", "/links/owned", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-Key", key.Key)
+	w = httptest.NewRecorder()
+	server.g.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if _, ok := cache.links["owned"]; ok {
+		t.Fatalf("expected cached link to be invalidated")
+	}
+
+	req = httptest.NewRequest("GET", "/s/owned", nil)
+	w = httptest.NewRecorder()
+	server.g.ServeHTTP(w, req)
+	if location := w.Header().Get("Location"); location != "https://example.org" {
+		t.Fatalf("expected redirect to updated URL, got %s", location)
+	}
+
+	// Delete
+	req = httptest.NewRequest("DELETE", "/links/owned", nil)
+	req.Header.Set("X-API-Key", key.Key)
+	w = httptest.NewRecorder()
+	server.g.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", w.Code)
+	}
+	if len(cache.deleted) != 3 {
+		t.Fatalf("expected invalidations on create, patch and delete, got %v", cache.deleted)
+	}
+}
+
+func TestLinks_ForeignOwnerNotFound(t *testing.T) {
+	server, urlStorage, _ := newTestServer()
+	urlStorage.keys["mallory-key"] = "mallory"
+	urlStorage.links["owned"] = domain.ShortenedURL{ShortCode: "owned", URL: "https://example.com", OwnerID: "alice"}
+
+	req := httptest.NewRequest("DELETE", "/links/owned", nil)
+	req.Header.Set("X-API-Key", "mallory-key")
+	w := httptest.NewRecorder()
+
+	server.g.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", w.Code)
+	}
+	if _, ok := urlStorage.links["owned"]; !ok {
+		t.Fatalf("link must not be deleted by another owner")
+	}
+}
+
+// QR CODE TESTS
+
+func TestQR_PNG(t *testing.T) {
+	server, urlStorage, _ := newTestServer()
+	urlStorage.urls["abc123"] = "https://example.com"
+
+	req := httptest.NewRequest("GET", "/qr/abc123?size=128", nil)
+	w := httptest.NewRecorder()
+
+	server.g.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "image/png" {
+		t.Fatalf("expected image/png, got %s", ct)
+	}
+	if w.Header().Get("Cache-Control") == "" || w.Header().Get("ETag") == "" {
+		t.Fatalf("expected caching headers, got %v", w.Header())
+	}
+
+	// Revalidation with the same ETag
+	req = httptest.NewRequest("GET", "/qr/abc123?size=128", nil)
+	req.Header.Set("If-None-Match", w.Header().Get("ETag"))
+	w = httptest.NewRecorder()
+
+	server.g.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotModified {
+		t.Fatalf("expected 304, got %d", w.Code)
+	}
+}
+
+func TestQR_SVG(t *testing.T) {
+	server, urlStorage, _ := newTestServer()
+	urlStorage.urls["abc123"] = "https://example.com"
+
+	req := httptest.NewRequest("GET", "/qr/abc123?format=svg&fg=%23112233&level=H", nil)
+	w := httptest.NewRecorder()
+
+	server.g.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "image/svg+xml" {
+		t.Fatalf("expected image/svg+xml, got %s", ct)
+	}
+	if !bytes.Contains(w.Body.Bytes(), []byte(`fill="#112233"`)) {
+		t.Fatalf("expected foreground colour in SVG")
+	}
+}
+
+func TestQR_NotFound(t *testing.T) {
+	server, _, _ := newTestServer()
+
+	req := httptest.NewRequest("GET", "/qr/missing", nil)
+	w := httptest.NewRecorder()
+
+	server.g.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", w.Code)
+	}
+}
+
+func TestQR_InvalidOptions(t *testing.T) {
+	server, urlStorage, _ := newTestServer()
+	urlStorage.urls["abc123"] = "https://example.com"
+
+	for _, query := range []string{"format=gif", "size=10", "margin=-1", "level=X", "fg=red"} {
+		req := httptest.NewRequest("GET", "/qr/abc123?"+query, nil)
+		w := httptest.NewRecorder()
+
+		server.g.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", query, w.Code)
+		}
+	}
+}
+
+// CLICK RECORDING TESTS
+
+func TestRedirect_RecordsQRSource(t *testing.T) {
+	server, urlStorage, clickStorage := newTestServer()
+	urlStorage.urls["abc123"] = "https://example.com"
+
+	for _, path := range []string{"/s/abc123?source=qr", "/s/abc123?source=spam", "/s/abc123"} {
+		req := httptest.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		server.g.ServeHTTP(w, req)
+		if w.Code != http.StatusTemporaryRedirect {
+			t.Fatalf("expected 307, got %d", w.Code)
+		}
+	}
+
+	clicks := clickStorage.clicks["abc123"]
+	if len(clicks) != 3 {
+		t.Fatalf("expected 3 clicks, got %d", len(clicks))
+	}
+	if clicks[0].Source != domain.ClickSourceQR || clicks[1].Source != "" || clicks[2].Source != "" {
+		t.Fatalf("unexpected sources: %q, %q, %q", clicks[0].Source, clicks[1].Source, clicks[2].Source)
+	}
+}
+
+type mockRecorder struct {
+	clicks []domain.Click
+}
+
+func (m *mockRecorder) Record(click domain.Click) bool {
+	m.clicks = append(m.clicks, click)
+	return true
+}
+
+func TestRedirect_AdmitsToCacheAfterMisses(t *testing.T) {
+	server, urlStorage, clickStorage := newTestServer()
+	cache := newMockCache()
+	recorder := &mockRecorder{}
+	server.cache = cache
+	server.Configure(Options{Clicks: recorder})
+	urlStorage.urls["abc123"] = "https://example.com"
+
+	for i := 0; i < mockMinHits; i++ {
+		if _, ok := cache.links["abc123"]; ok {
+			t.Fatalf("link cached after %d misses", i)
+		}
+		req := httptest.NewRequest("GET", "/s/abc123", nil)
+		w := httptest.NewRecorder()
+		server.g.ServeHTTP(w, req)
+	}
+
+	if _, ok := cache.links["abc123"]; !ok {
+		t.Fatalf("expected link to be cached after %d misses", mockMinHits)
+	}
+	if len(recorder.clicks) != mockMinHits {
+		t.Fatalf("expected clicks to go to the recorder, got %d", len(recorder.clicks))
+	}
+	if len(clickStorage.clicks["abc123"]) != 0 {
+		t.Fatalf("expected no direct writes to the click storage")
+	}
+}
+
+func TestRedirect_ParsesUserAgent(t *testing.T) {
+	server, urlStorage, clickStorage := newTestServer()
+	urlStorage.urls["abc123"] = "https://example.com"
+
+	req := httptest.NewRequest("GET", "/s/abc123", nil)
+	req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)")
+	w := httptest.NewRecorder()
+	server.g.ServeHTTP(w, req)
+
+	clicks := clickStorage.clicks["abc123"]
+	if len(clicks) != 1 || !clicks[0].IsBot || clicks[0].Browser != "Googlebot" {
+		t.Fatalf("expected a parsed bot click, got %+v", clicks)
+	}
+}
+
+type mockGeo map[string]domain.Location
+
+func (m mockGeo) Lookup(ip string) domain.Location {
+	return m[ip]
+}
+
+func TestRedirect_ResolvesLocation(t *testing.T) {
+	server, urlStorage, clickStorage := newTestServer()
+	server.Configure(Options{GeoIP: mockGeo{"81.2.69.142": {Country: "GB", Region: "England", City: "London"}}})
+	urlStorage.urls["abc123"] = "https://example.com"
+
+	req := httptest.NewRequest("GET", "/s/abc123", nil)
+	req.RemoteAddr = "81.2.69.142:40000"
+	w := httptest.NewRecorder()
+	server.g.ServeHTTP(w, req)
+
+	clicks := clickStorage.clicks["abc123"]
+	if len(clicks) != 1 || clicks[0].Country != "GB" || clicks[0].City != "London" {
+		t.Fatalf("expected a click from London, got %+v", clicks)
+	}
+}
+
+func TestGetAnalytics_InvalidIncludeBots(t *testing.T) {
+	server, _, _ := newTestServer()
+
+	req := httptest.NewRequest("GET", "/analytics/abc123?include_bots=maybe", nil)
+	w := httptest.NewRecorder()
+	server.g.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", w.Code)
+	}
+}
+
+func TestGetAnalytics_Series(t *testing.T) {
+	server, _, clickStorage := newTestServer()
+	clickFixture(clickStorage, 5)
+	// the first is still the 28th in New York, so both are in the previous period
+	clickStorage.clicks["abc123"] = append(clickStorage.clicks["abc123"],
+		domain.Click{ShortCode: "abc123", Timestamp: time.Date(2025, 3, 1, 3, 0, 0, 0, time.UTC)},
+		domain.Click{ShortCode: "abc123", Timestamp: time.Date(2025, 2, 28, 12, 0, 0, 0, time.UTC)},
+	)
+
+	req := httptest.NewRequest("GET", "/analytics/abc123?granularity=day&tz=America/New_York&from=2025-03-01&to=2025-03-02", nil)
+	w := httptest.NewRecorder()
+	server.g.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp domain.AnalyticsResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	ny, _ := time.LoadLocation("America/New_York")
+	if resp.Granularity != "day" || resp.Timezone != "America/New_York" || resp.Current == nil || resp.Previous == nil {
+		t.Fatalf("expected a day series in New York time, got %+v", resp)
+	}
+	// a date 'to' includes the whole day
+	cur, prev := resp.Current, resp.Previous
+	if !cur.From.Equal(time.Date(2025, 3, 1, 0, 0, 0, 0, ny)) || !cur.To.Equal(time.Date(2025, 3, 3, 0, 0, 0, 0, ny).Add(-time.Microsecond)) {
+		t.Fatalf("unexpected current period %v - %v", cur.From, cur.To)
+	}
+	if len(cur.Series) != 2 || cur.Series[0].Clicks != 5 || cur.Series[1].Clicks != 0 || cur.Clicks != 5 ||
+		!cur.Series[0].Start.Equal(time.Date(2025, 3, 1, 0, 0, 0, 0, ny)) {
+		t.Fatalf("unexpected current series %+v", cur.Series)
+	}
+	if !prev.From.Equal(time.Date(2025, 2, 27, 0, 0, 0, 0, ny)) || len(prev.Series) != 2 || prev.Series[1].Clicks != 2 || prev.Clicks != 2 {
+		t.Fatalf("unexpected previous period %+v", prev)
+	}
+	if resp.Change == nil || *resp.Change != 150 {
+		t.Fatalf("expected a 150%% change, got %v", resp.Change)
+	}
+}
+
+func TestGetAnalytics_BadSeriesRequests(t *testing.T) {
+	server, _, _ := newTestServer()
+
+	for _, query := range []string{
+		"granularity=year",
+		"tz=Mars/Olympus",
+		"tz=Local",
+		"from=2025-03-02&to=2025-03-01",
+		"to=2025-03-01T12:00:00",
+		"granularity=hour&from=2024-01-01&to=2025-01-01",
+	} {
+		req := httptest.NewRequest("GET", "/analytics/abc123?"+query, nil)
+		w := httptest.NewRecorder()
+		server.g.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, w.Code)
+		}
+	}
+}
+
+func TestSeriesRange_Defaults(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatalf("LoadLocation: %v", err)
+	}
+	// a Wednesday evening in Berlin
+	now := time.Date(2025, 3, 12, 22, 30, 0, 0, time.UTC)
+
+	for granularity, want := range map[string]time.Time{
+		domain.GranularityHour:  time.Date(2025, 3, 11, 0, 0, 0, 0, berlin),
+		domain.GranularityDay:   time.Date(2025, 2, 11, 0, 0, 0, 0, berlin),
+		domain.GranularityWeek:  time.Date(2024, 12, 23, 0, 0, 0, 0, berlin),
+		domain.GranularityMonth: time.Date(2024, 4, 1, 0, 0, 0, 0, berlin),
+	} {
+		from, to := seriesRange(nil, nil, granularity, berlin, now)
+		if !from.Equal(want) || !to.Equal(now) {
+			t.Errorf("%s: expected %v - %v, got %v - %v", granularity, want, now, from, to)
+		}
+	}
+}
+
+// ownClicksLink makes abc123 a link of the owner whose API key is owner-key.
+func ownClicksLink(urlStorage *mockURLStorage) {
+	urlStorage.keys["owner-key"] = "owner"
+	urlStorage.urls["abc123"] = "https://example.com"
+	urlStorage.links["abc123"] = domain.ShortenedURL{ShortCode: "abc123", URL: "https://example.com", OwnerID: "owner"}
+}
+
+// ownerRequest is a request carrying the API key of the owner of abc123.
+func ownerRequest(target string) *http.Request {
+	req := httptest.NewRequest("GET", target, nil)
+	req.Header.Set("X-API-Key", "owner-key")
+	return req
+}
+
+// clickFixture stores n clicks for abc123, one minute apart, and returns them oldest first.
+func clickFixture(clickStorage *mockClickStorage, n int) []domain.Click {
+	base := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < n; i++ {
+		clickStorage.clicks["abc123"] = append(clickStorage.clicks["abc123"], domain.Click{
+			ID:        "00000000-0000-0000-0000-00000000000" + strconv.Itoa(i),
+			ShortCode: "abc123",
+			IP:        "127.0.0.1",
+			UserAgent: "ua, with a comma",
+			Timestamp: base.Add(time.Duration(i) * time.Minute),
+		})
+	}
+	return clickStorage.clicks["abc123"]
+}
+
+func TestGetClicks_KeysetPagination(t *testing.T) {
+	server, urlStorage, clickStorage := newTestServer()
+	ownClicksLink(urlStorage)
+	clicks := clickFixture(clickStorage, 5)
+
+	var got []string
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatal("pagination does not end")
+		}
+		req := ownerRequest("/analytics/abc123/clicks?limit=2&cursor=" + cursor)
+		w := httptest.NewRecorder()
+		server.g.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var page domain.ClicksPage
+		if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+			t.Fatalf("failed to decode page: %v", err)
+		}
+		for _, c := range page.Clicks {
+			got = append(got, c.ID)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		// a click arriving between pages must not shift the next page
+		clickStorage.clicks["abc123"] = append(clickStorage.clicks["abc123"], domain.Click{
+			ID: "10000000-0000-0000-0000-00000000000" + strconv.Itoa(pages), ShortCode: "abc123", Timestamp: time.Now(),
+		})
+		cursor = page.NextCursor
+	}
+
+	want := []string{clicks[4].ID, clicks[3].ID, clicks[2].ID, clicks[1].ID, clicks[0].ID}
+	if !slices.Equal(got, want) {
+		t.Fatalf("expected clicks %v, got %v", want, got)
+	}
+}
+
+func TestGetClicks_BadRequests(t *testing.T) {
+	server, urlStorage, _ := newTestServer()
+	ownClicksLink(urlStorage)
+
+	for path, code := range map[string]int{
+		"/analytics/abc123/clicks?limit=0":           http.StatusBadRequest,
+		"/analytics/abc123/clicks?limit=5000":        http.StatusBadRequest,
+		"/analytics/abc123/clicks?cursor=garbage!":   http.StatusBadRequest,
+		"/analytics/abc123/clicks?cursor=MTIzOmFiYw": http.StatusBadRequest, // "123:abc", not a UUID
+		"/analytics/missing/clicks":                  http.StatusNotFound,
+	} {
+		req := ownerRequest(path)
+		w := httptest.NewRecorder()
+		server.g.ServeHTTP(w, req)
+		if w.Code != code {
+			t.Errorf("%s: expected %d, got %d", path, code, w.Code)
+		}
+	}
+}
+
+func TestExportClicks_CSV(t *testing.T) {
+	server, urlStorage, clickStorage := newTestServer()
+	ownClicksLink(urlStorage)
+	clickFixture(clickStorage, 3)
+
+	req := ownerRequest("/analytics/abc123/export?format=csv&from=2025-03-01T12:01:00Z&to=2025-03-01")
+	w := httptest.NewRecorder()
+	server.g.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/csv") {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	want := "id,short_code,timestamp,ip,user_agent,referer,source,browser,browser_version,os,device,is_bot,country,region,city,variant,rule\n" +
+		"00000000-0000-0000-0000-000000000001,abc123,2025-03-01T12:01:00Z,127.0.0.1,\"ua, with a comma\",,,,,,,false,,,,,\n" +
+		"00000000-0000-0000-0000-000000000002,abc123,2025-03-01T12:02:00Z,127.0.0.1,\"ua, with a comma\",,,,,,,false,,,,,\n"
+	if w.Body.String() != want {
+		t.Fatalf("unexpected export:\n%s", w.Body.String())
+	}
+	if status := w.Result().Trailer.Get("X-Export-Status"); status != "complete" {
+		t.Fatalf("expected a complete export, got %q", status)
+	}
+}
+
+func TestExportClicks_NDJSON(t *testing.T) {
+	server, urlStorage, clickStorage := newTestServer()
+	ownClicksLink(urlStorage)
+	clickFixture(clickStorage, 2)
+	clickStorage.err = errors.New("connection reset")
+
+	req := ownerRequest("/analytics/abc123/export?format=ndjson")
+	w := httptest.NewRecorder()
+	server.g.ServeHTTP(w, req)
+
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %q", w.Body.String())
+	}
+	var click domain.Click
+	if err := json.Unmarshal([]byte(lines[1]), &click); err != nil || click.ID != "00000000-0000-0000-0000-000000000001" {
+		t.Fatalf("unexpected line %q: %v", lines[1], err)
+	}
+	// the rows made it out before the failure, which only the trailer reports
+	if status := w.Result().Trailer.Get("X-Export-Status"); status != "error" {
+		t.Fatalf("expected a failed export, got %q", status)
+	}
+}
+
+func TestExportClicks_BadRequests(t *testing.T) {
+	server, urlStorage, _ := newTestServer()
+	ownClicksLink(urlStorage)
+
+	for path, code := range map[string]int{
+		"/analytics/abc123/export?format=xml":     http.StatusBadRequest,
+		"/analytics/abc123/export?from=yesterday": http.StatusBadRequest,
+		"/analytics/missing/export":               http.StatusNotFound,
+	} {
+		req := ownerRequest(path)
+		w := httptest.NewRecorder()
+		server.g.ServeHTTP(w, req)
+		if w.Code != code {
+			t.Errorf("%s: expected %d, got %d", path, code, w.Code)
+		}
+	}
+}
+
+func TestClicks_OwnerOnly(t *testing.T) {
+	server, urlStorage, clickStorage := newTestServer()
+	ownClicksLink(urlStorage)
+	urlStorage.keys["mallory-key"] = "mallory"
+	clickFixture(clickStorage, 2)
+
+	for _, path := range []string{"/analytics/abc123/clicks", "/analytics/abc123/export"} {
+		for key, code := range map[string]int{
+			"":            http.StatusUnauthorized,
+			"unknown":     http.StatusUnauthorized,
+			"mallory-key": http.StatusNotFound,
+		} {
+			req := httptest.NewRequest("GET", path, nil)
+			if key != "" {
+				req.Header.Set("X-API-Key", key)
+			}
+			w := httptest.NewRecorder()
+			server.g.ServeHTTP(w, req)
+			if w.Code != code {
+				t.Errorf("%s with key %q: expected %d, got %d", path, key, code, w.Code)
+			}
+			if strings.Contains(w.Body.String(), "127.0.0.1") {
+				t.Errorf("%s with key %q: the clicks leaked: %s", path, key, w.Body.String())
+			}
+		}
+	}
+
+	// a link without an owner has nobody to show its clicks to
+	urlStorage.urls["anon"] = "https://example.com"
+	req := ownerRequest("/analytics/anon/clicks")
+	w := httptest.NewRecorder()
+	server.g.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an ownerless link, got %d", w.Code)
+	}
+}
+
+func TestClicks_HideIPs(t *testing.T) {
+	server, urlStorage, clickStorage := newTestServer()
+	server.Configure(Options{HideIPs: true})
+	ownClicksLink(urlStorage)
+	clickFixture(clickStorage, 2)
+
+	for _, path := range []string{
+		"/analytics/abc123/clicks",
+		"/analytics/abc123/export?format=csv",
+		"/analytics/abc123/export?format=ndjson",
+	} {
+		w := httptest.NewRecorder()
+		server.g.ServeHTTP(w, ownerRequest(path))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d: %s", path, w.Code, w.Body.String())
+		}
+		if strings.Contains(w.Body.String(), "127.0.0.1") {
+			t.Errorf("%s: expected the IPs to be hidden, got %s", path, w.Body.String())
+		}
+		if !strings.Contains(w.Body.String(), "00000000-0000-0000-0000-000000000001") {
+			t.Errorf("%s: expected the clicks themselves, got %s", path, w.Body.String())
+		}
+	}
+}
+
+// createProtected creates a link with the password "s3cret" through the API and returns its short code.
+func createProtected(t *testing.T, server *Server, extra string) string {
+	t.Helper()
+	body := `{"url": "https://example.com/doc", "alias": "doc", "password": "s3cret"` + extra + `}`
+	req := httptest.NewRequest("POST", "/shorten", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	server.g.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	return "doc"
+}
+
+// unlock posts a password for the short code from the given client address.
+func unlock(server *Server, shortCode, password, remoteAddr string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/s/"+shortCode, strings.NewReader(url.Values{"password": {password}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.RemoteAddr = remoteAddr
+	w := httptest.NewRecorder()
+	server.g.ServeHTTP(w, req)
+	return w
+}
+
+func TestProtectedLink_PromptAndUnlock(t *testing.T) {
+	server, urlStorage, clickStorage := newTestServer()
+	code := createProtected(t, server, "")
+
+	hash := urlStorage.links[code].PasswordHash
+	if hash == "" || hash == "s3cret" || bcrypt.CompareHashAndPassword([]byte(hash), []byte("s3cret")) != nil {
+		t.Fatalf("expected a bcrypt hash of the password, got %q", hash)
+	}
+
+	req := httptest.NewRequest("GET", "/s/"+code, nil)
+	w := httptest.NewRecorder()
+	server.g.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `type="password"`) {
+		t.Fatalf("expected the password prompt, got %d: %s", w.Code, w.Body.String())
+	}
+	if strings.Contains(w.Body.String(), "example.com/doc") {
+		t.Fatal("the prompt must not reveal the destination")
+	}
+
+	if w := unlock(server, code, "wrong", "192.0.2.1:1234"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for a wrong password, got %d", w.Code)
+	}
+	if len(clickStorage.clicks[code]) != 0 {
+		t.Fatal("no click may be recorded before the link is unlocked")
+	}
+
+	w = unlock(server, code, "s3cret", "192.0.2.1:1234")
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "https://example.com/doc" {
+		t.Fatalf("expected a 303 to the destination, got %d %q", w.Code, w.Header().Get("Location"))
+	}
+	if len(clickStorage.clicks[code]) != 1 {
+		t.Fatalf("expected the unlock to record a click, got %d", len(clickStorage.clicks[code]))
+	}
+}
+
+func TestProtectedLink_Lockout(t *testing.T) {
+	server, _, _ := newTestServer()
+	code := createProtected(t, server, "")
+
+	for i := 0; i < maxUnlockAttempts; i++ {
+		if w := unlock(server, code, "guess"+strconv.Itoa(i), "192.0.2.1:1234"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: expected 401, got %d", i, w.Code)
+		}
+	}
+	// locked out, even with the right password
+	w := unlock(server, code, "s3cret", "192.0.2.1:1234")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected 429 with Retry-After, got %d %v", w.Code, w.Header())
+	}
+	// other clients are not
+	if w := unlock(server, code, "s3cret", "192.0.2.2:1234"); w.Code != http.StatusSeeOther {
+		t.Fatalf("expected another client to unlock, got %d", w.Code)
+	}
+}
+
+func TestMemoryAttempts_Window(t *testing.T) {
+	m := newMemoryAttempts()
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	m.now = func() time.Time { return now }
+
+	m.CountAttempt(context.Background(), "a", time.Minute)
+	n, left, _ := m.CountAttempt(context.Background(), "a", time.Minute)
+	if n != 2 || left != time.Minute {
+		t.Fatalf("expected 2 attempts with a minute left, got %d, %v", n, left)
+	}
+	_ = m.ForgetAttempt(context.Background(), "a")
+
+	now = now.Add(time.Minute)
+	if n, _, _ := m.CountAttempt(context.Background(), "a", time.Minute); n != 1 {
+		t.Fatalf("expected a new window, got %d attempts", n)
+	}
+}
+
+func TestSingleUseLink(t *testing.T) {
+	server, _, _ := newTestServer()
+
+	req := httptest.NewRequest("POST", "/shorten", bytes.NewBufferString(`{"url": "https://example.com", "alias": "once", "single_use": true}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	server.g.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	for i, want := range []int{http.StatusTemporaryRedirect, http.StatusGone, http.StatusGone} {
+		w := httptest.NewRecorder()
+		server.g.ServeHTTP(w, httptest.NewRequest("GET", "/s/once", nil))
+		if w.Code != want {
+			t.Fatalf("request %d: expected %d, got %d", i, want, w.Code)
+		}
+	}
+
+	req = httptest.NewRequest("POST", "/shorten", bytes.NewBufferString(`{"url": "https://example.com", "single_use": true, "max_clicks": 3}`))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	server.g.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for single_use with max_clicks, got %d", w.Code)
+	}
+}
+
+func TestProtectedSingleUseLink_ConsumedOnUnlock(t *testing.T) {
+	server, _, _ := newTestServer()
+	code := createProtected(t, server, `, "single_use": true`)
+
+	// showing the prompt does not use up the link
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		server.g.ServeHTTP(w, httptest.NewRequest("GET", "/s/"+code, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected the prompt, got %d", w.Code)
+		}
+	}
+	if w := unlock(server, code, "s3cret", "192.0.2.1:1234"); w.Code != http.StatusSeeOther {
+		t.Fatalf("expected the first unlock to redirect, got %d", w.Code)
+	}
+	if w := unlock(server, code, "s3cret", "192.0.2.1:1234"); w.Code != http.StatusGone {
+		t.Fatalf("expected the link to be used up, got %d", w.Code)
+	}
+}
+
+func TestProtectedLink_CacheHitStillPrompts(t *testing.T) {
+	server, urlStorage, _ := newTestServer()
+	code := createProtected(t, server, "")
+	cache := newMockCache()
+	cache.links[code] = urlStorage.links[code]
+	server.cache = cache
+
+	w := httptest.NewRecorder()
+	server.g.ServeHTTP(w, httptest.NewRequest("GET", "/s/"+code, nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `type="password"`) {
+		t.Fatalf("expected the password prompt from the cache, got %d", w.Code)
+	}
+}
+
+const abBody = `{"alias": "promo", "variants": [
+	{"name": "a", "url": "https://example.com/a", "weight": 1},
+	{"name": "b", "url": "https://example.com/b", "weight": 3}
+]}`
+
+func TestCreateLink_Variants(t *testing.T) {
+	server, urlStorage, _ := newTestServer()
+
+	for body, want := range map[string]int{
+		abBody: http.StatusOK,
+		`{"url": "https://example.com", "variants": [{"name": "a", "url": "https://example.com/a", "weight": 1}, {"name": "b", "url": "https://example.com/b", "weight": 1}]}`: http.StatusBadRequest,
+		`{"variants": [{"name": "a", "url": "https://example.com/a", "weight": 1}, {"name": "a", "url": "https://example.com/b", "weight": 1}]}`:                               http.StatusBadRequest,
+		`{"variants": [{"name": "a", "url": "https://example.com/a", "weight": 1}]}`:                                                                                           http.StatusBadRequest,
+		`{"variants": [{"name": "a", "url": "https://example.com/a", "weight": 0}, {"name": "b", "url": "https://example.com/b", "weight": 1}]}`:                               http.StatusBadRequest,
+		`{"variants": [{"name": "a b", "url": "https://example.com/a", "weight": 1}, {"name": "b", "url": "https://example.com/b", "weight": 1}]}`:                             http.StatusBadRequest,
+	} {
+		req := httptest.NewRequest("POST", "/shorten", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		server.g.ServeHTTP(w, req)
+		if w.Code != want {
+			t.Errorf("%s: expected %d, got %d: %s", body, want, w.Code, w.Body.String())
+		}
+	}
+	if v := urlStorage.links["promo"].Variants; len(v) != 2 || v[1].Weight != 3 {
+		t.Fatalf("expected the variants to be saved, got %+v", v)
+	}
+}
+
+func TestRedirect_Variants(t *testing.T) {
+	server, _, clickStorage := newTestServer()
+	req := httptest.NewRequest("POST", "/shorten", bytes.NewBufferString(abBody))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	server.g.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// without a cookie the same client always lands on the same variant, and is given the cookie
+	get := func(cookie string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/s/promo", nil)
+		req.Header.Set("User-Agent", "test-agent")
+		if cookie != "" {
+			req.AddCookie(&http.Cookie{Name: "ab_promo", Value: cookie})
+		}
+		w := httptest.NewRecorder()
+		server.g.ServeHTTP(w, req)
+		return w
+	}
+	first := get("")
+	if first.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("expected 307, got %d", first.Code)
+	}
+	if again := get(""); again.Header().Get("Location") != first.Header().Get("Location") {
+		t.Fatalf("expected a stable variant, got %q and %q", first.Header().Get("Location"), again.Header().Get("Location"))
+	}
+	cookies := first.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "ab_promo" || cookies[0].Path != "/s/promo" || !cookies[0].HttpOnly {
+		t.Fatalf("expected the variant cookie, got %+v", cookies)
+	}
+
+	// the cookie wins over the hash, unless it names a variant that no longer exists
+	for _, name := range []string{"a", "b"} {
+		if loc := get(name).Header().Get("Location"); loc != "https://example.com/"+name {
+			t.Fatalf("cookie %q: expected variant %s, got %s", name, name, loc)
+		}
+	}
+	if loc := get("gone").Header().Get("Location"); loc != first.Header().Get("Location") {
+		t.Fatalf("expected a stale cookie to be ignored, got %s", loc)
+	}
+
+	clicks := clickStorage.clicks["promo"]
+	if len(clicks) != 5 || clicks[2].Variant != "a" || clicks[3].Variant != "b" || clicks[0].Variant == "" {
+		t.Fatalf("expected clicks to record their variant, got %+v", clicks)
+	}
+}
+
+func TestWeightedVariant_Distribution(t *testing.T) {
+	variants := []domain.Variant{{Name: "a", Weight: 1}, {Name: "b", Weight: 3}}
+	counts := map[string]int{}
+	for h := uint64(0); h < 4000; h++ {
+		counts[weightedVariant(variants, h).Name]++
+	}
+	if counts["a"] != 1000 || counts["b"] != 3000 {
+		t.Fatalf("expected a 1:3 split, got %v", counts)
+	}
+}
+
+const rulesBody = `{"url": "https://example.com", "alias": "app", "rules": [
+	{"name": "ios", "url": "https://apps.apple.com/app/id1", "devices": ["ios"]},
+	{"name": "android", "url": "https://play.google.com/store/apps/details?id=app", "devices": ["android"]},
+	{"name": "de", "url": "https://example.com/de", "countries": ["DE"], "languages": ["de"]},
+	{"name": "sale", "url": "https://example.com/sale", "starts_at": "2000-01-01T00:00:00Z", "ends_at": "2001-01-01T00:00:00Z"}
+]}`
+
+func TestCreateLink_Rules(t *testing.T) {
+	server, urlStorage, _ := newTestServer()
+
+	for body, want := range map[string]int{
+		rulesBody: http.StatusOK,
+		`{"url": "https://example.com", "rules": [{"name": "x", "url": "https://example.com/x"}]}`:                                                                                            http.StatusBadRequest,
+		`{"url": "https://example.com", "rules": [{"name": "x", "url": "https://example.com/x", "devices": ["fridge"]}]}`:                                                                     http.StatusBadRequest,
+		`{"url": "https://example.com", "rules": [{"name": "x", "url": "https://example.com/x", "countries": ["DEU"]}]}`:                                                                      http.StatusBadRequest,
+		`{"url": "https://example.com", "rules": [{"name": "x", "url": "https://example.com/x", "languages": ["de_DE"]}]}`:                                                                    http.StatusBadRequest,
+		`{"url": "https://example.com", "rules": [{"name": "x", "url": "https://example.com/x", "starts_at": "2025-02-01T00:00:00Z", "ends_at": "2025-01-01T00:00:00Z"}]}`:                    http.StatusBadRequest,
+		`{"url": "https://example.com", "rules": [{"name": "x", "url": "https://example.com/x", "devices": ["ios"]}, {"name": "x", "url": "https://example.com/y", "devices": ["android"]}]}`: http.StatusBadRequest,
+	} {
+		req := httptest.NewRequest("POST", "/shorten", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		server.g.ServeHTTP(w, req)
+		if w.Code != want {
+			t.Errorf("%s: expected %d, got %d: %s", body, want, w.Code, w.Body.String())
+		}
+	}
+	if r := urlStorage.links["app"].Rules; len(r) != 4 || r[2].Countries[0] != "DE" {
+		t.Fatalf("expected the rules to be saved, got %+v", r)
+	}
+}
+
+func TestRedirect_Rules(t *testing.T) {
+	server, _, clickStorage := newTestServer()
+	server.opts.GeoIP = mockGeo{"192.0.2.1": {Country: "DE"}}
+	req := httptest.NewRequest("POST", "/shorten", bytes.NewBufferString(rulesBody))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	server.g.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	iphone := "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Mobile/15E148 Safari/604.1"
+	android := "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36"
+	windows := "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+	cases := []struct {
+		ua, ip, language string
+		want, rule       string
+	}{
+		{iphone, "192.0.2.1", "de-DE,de;q=0.9", "https://apps.apple.com/app/id1", "ios"},
+		{android, "198.51.100.1", "", "https://play.google.com/store/apps/details?id=app", "android"},
+		{windows, "192.0.2.1", "en;q=0.5, de-AT", "https://example.com/de", "de"},
+		// every condition of a rule must hold, and a window in the past never does
+		{windows, "192.0.2.1", "en-US,de;q=0.9", "https://example.com", ""},
+		{windows, "198.51.100.1", "de", "https://example.com", ""},
+	}
+	for i, tc := range cases {
+		req := httptest.NewRequest("GET", "/s/app", nil)
+		req.Header.Set("User-Agent", tc.ua)
+		req.Header.Set("Accept-Language", tc.language)
+		req.RemoteAddr = tc.ip + ":1234"
+		w := httptest.NewRecorder()
+		server.g.ServeHTTP(w, req)
+		if loc := w.Header().Get("Location"); loc != tc.want {
+			t.Errorf("case %d: expected %s, got %s", i, tc.want, loc)
+		}
+		if clicks := clickStorage.clicks["app"]; len(clicks) != i+1 || clicks[i].Rule != tc.rule {
+			t.Errorf("case %d: expected the click to record rule %q, got %+v", i, tc.rule, clicks)
+		}
+	}
+}
+
+func TestRedirect_RulesBeforeVariants(t *testing.T) {
+	server, urlStorage, _ := newTestServer()
+	urlStorage.links["promo"] = domain.ShortenedURL{
+		URL: "https://example.com/a", ShortCode: "promo",
+		Variants: []domain.Variant{{Name: "a", URL: "https://example.com/a", Weight: 1}, {Name: "b", URL: "https://example.com/b", Weight: 1}},
+		Rules:    []domain.Rule{{Name: "android", URL: "https://play.google.com", Devices: []string{"android"}}},
+	}
+
+	req := httptest.NewRequest("GET", "/s/promo", nil)
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36")
+	req.AddCookie(&http.Cookie{Name: "ab_promo", Value: "b"})
+	w := httptest.NewRecorder()
+	server.g.ServeHTTP(w, req)
+	if loc := w.Header().Get("Location"); loc != "https://play.google.com" {
+		t.Fatalf("expected the rule to win over the variant, got %s", loc)
+	}
+}
+
+func TestPreferredLanguage(t *testing.T) {
+	for header, want := range map[string]string{
+		"":                         "",
+		"de":                       "de",
+		"de-DE,de;q=0.9,en;q=0.8":  "de-DE",
+		"en;q=0.5, fr-CA;q=0.7, *": "fr-CA",
+		"en;q=0.8, de;q=0.8":       "en",
+		"en;q=0, *;q=0.5":          "",
+		"en;q=bogus, pt-BR;q=0.1":  "pt-BR",
+	} {
+		if got := preferredLanguage(header); got != want {
+			t.Errorf("%q: expected %q, got %q", header, want, got)
+		}
+	}
+}
+
+// batchResults reads the NDJSON results of a bulk shorten request.
+func batchResults(t *testing.T, w *httptest.ResponseRecorder) []domain.BatchResult {
+	t.Helper()
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var results []domain.BatchResult
+	dec := json.NewDecoder(w.Body)
+	for dec.More() {
+		var r domain.BatchResult
+		if err := dec.Decode(&r); err != nil {
+			t.Fatalf("failed to decode result: %v", err)
+		}
+		results = append(results, r)
+	}
+	return results
+}
+
+func TestShortenBatch(t *testing.T) {
+	server, urlStorage, _ := newTestServer()
+	urlStorage.urls["taken"] = "https://example.com/taken"
+
+	body := `[
+		{"url": "https://example.com/a"},
+		{"url": "https://example.com/b", "alias": "bee"},
+		{"url": "not a url"},
+		{"url": "https://example.com/c", "alias": "bee"},
+		{"url": "https://example.com/d", "alias": "taken"},
+		{"url": 5},
+		{"url": "https://example.com/a"},
+		{"url": "https://example.com/a", "single_use": true}
+	]`
+	req := httptest.NewRequest("POST", "/shorten/batch?dedupe=true", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	server.g.ServeHTTP(w, req)
+
+	results := batchResults(t, w)
+	if len(results) != 8 {
+		t.Fatalf("expected 8 results, got %+v", results)
+	}
+	for i, r := range results {
+		if r.Row != i+1 {
+			t.Fatalf("expected results in row order, got %+v", results)
+		}
+	}
+	ok := func(r domain.BatchResult) bool { return r.Error == "" && r.ShortCode != "" }
+	if !ok(results[0]) || results[1].ShortCode != "bee" || !ok(results[7]) {
+		t.Fatalf("expected valid rows to be saved, got %+v", results)
+	}
+	if results[2].Error == "" || results[3].Error != "duplicate alias" || results[4].Error != "alias already exists" || results[5].Error == "" {
+		t.Fatalf("expected per-row errors, got %+v", results)
+	}
+	// the same URL with different options is a different link
+	if !results[6].Duplicate || results[6].ShortCode != results[0].ShortCode || results[7].ShortCode == results[0].ShortCode {
+		t.Fatalf("expected the repeated URL to share its short code, got %+v", results)
+	}
+	if status := w.Result().Trailer.Get("X-Batch-Status"); status != "complete" {
+		t.Fatalf("expected a complete batch, got %q", status)
+	}
+	if len(urlStorage.links) != 3 {
+		t.Fatalf("expected 3 saved links, got %d", len(urlStorage.links))
+	}
+}
+
+func TestShortenBatch_DedupeAcrossChunks(t *testing.T) {
+	server, urlStorage, _ := newTestServer()
+
+	rows := make([]string, batchChunk+10)
+	for i := range rows {
+		rows[i] = `{"url": "https://example.com/same"}`
+	}
+	req := httptest.NewRequest("POST", "/shorten/batch?dedupe=true", strings.NewReader("["+strings.Join(rows, ",")+"]"))
+	w := httptest.NewRecorder()
+	server.g.ServeHTTP(w, req)
+
+	results := batchResults(t, w)
+	if len(results) != len(rows) || results[len(rows)-1].ShortCode != results[0].ShortCode || !results[len(rows)-1].Duplicate {
+		t.Fatalf("expected every row to share one short code, got %d results", len(results))
+	}
+	if len(urlStorage.links) != 1 {
+		t.Fatalf("expected one saved link, got %d", len(urlStorage.links))
+	}
+
+	// without dedupe every row is a link of its own
+	w = httptest.NewRecorder()
+	server.g.ServeHTTP(w, httptest.NewRequest("POST", "/shorten/batch", strings.NewReader(`[{"url": "https://example.com/x"}, {"url": "https://example.com/x"}]`)))
+	if results := batchResults(t, w); results[0].ShortCode == results[1].ShortCode || results[1].Duplicate {
+		t.Fatalf("expected two links, got %+v", results)
+	}
+}
+
+func TestShortenBatch_BrokenJSON(t *testing.T) {
+	server, _, _ := newTestServer()
+
+	for body, want := range map[string]int{
+		`{"url": "https://example.com"}`:  http.StatusBadRequest,
+		`[{"url": "https://example.com"}`: http.StatusOK,
+	} {
+		w := httptest.NewRecorder()
+		server.g.ServeHTTP(w, httptest.NewRequest("POST", "/shorten/batch", strings.NewReader(body)))
+		if w.Code != want {
+			t.Fatalf("%s: expected %d, got %d", body, want, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	server.g.ServeHTTP(w, httptest.NewRequest("POST", "/shorten/batch", strings.NewReader(`[{"url": "https://example.com"}, {"url": `)))
+	results := batchResults(t, w)
+	if len(results) != 2 || results[0].ShortCode == "" || results[1].Error == "" {
+		t.Fatalf("expected the saved row and an error, got %+v", results)
+	}
+	if status := w.Result().Trailer.Get("X-Batch-Status"); status != "error" {
+		t.Fatalf("expected the batch to be cut short, got %q", status)
+	}
+}
+
+func TestShortenImport_CSV(t *testing.T) {
+	server, urlStorage, _ := newTestServer()
+
+	csv := "\uFEFFAlias,URL,comment\n,https://example.com/a,first\nsale,https://example.com/sale,\nbad,not a url,\n\"\",https://example.com/a\n"
+	req := httptest.NewRequest("POST", "/shorten/import?dedupe=1", strings.NewReader(csv))
+	req.Header.Set("Content-Type", "text/csv")
+	w := httptest.NewRecorder()
+	server.g.ServeHTTP(w, req)
+
+	results := batchResults(t, w)
+	if len(results) != 4 || results[0].ShortCode == "" || results[1].ShortCode != "sale" || results[2].Error == "" ||
+		results[3].ShortCode != results[0].ShortCode {
+		t.Fatalf("unexpected import results: %+v", results)
+	}
+	if urlStorage.urls["sale"] != "https://example.com/sale" {
+		t.Fatalf("expected the aliased link to be saved")
+	}
+
+	// the same file as a form upload
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("file", "links.csv")
+	_, _ = fw.Write([]byte("url\nhttps://example.com/upload\n"))
+	_ = mw.Close()
+	req = httptest.NewRequest("POST", "/shorten/import", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	w = httptest.NewRecorder()
+	server.g.ServeHTTP(w, req)
+	if results := batchResults(t, w); len(results) != 1 || results[0].URL != "https://example.com/upload" || results[0].ShortCode == "" {
+		t.Fatalf("unexpected upload results: %+v", results)
+	}
+
+	for body, query := range map[string]string{"alias,link\nx,https://example.com\n": "", "url\n": "?dedupe=maybe"} {
+		w := httptest.NewRecorder()
+		server.g.ServeHTTP(w, httptest.NewRequest("POST", "/shorten/import"+query, strings.NewReader(body)))
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("%q: expected 400, got %d", body, w.Code)
+		}
+	}
+}
+
+// mockChecker blocks any URL containing one of its substrings.
+type mockChecker []string
+
+func (m mockChecker) Check(rawURL string) error {
+	for _, blocked := range m {
+		if strings.Contains(rawURL, blocked) {
+			return errors.New("destination not allowed: " + blocked)
+		}
+	}
+	return nil
+}
+
+func TestSafety_CheckedOnCreate(t *testing.T) {
+	server, urlStorage, _ := newTestServer()
+	server.Configure(Options{Safety: mockChecker{"evil.test"}})
+
+	for body, want := range map[string]int{
+		`{"url": "https://evil.test/login"}`: http.StatusBadRequest,
+		`{"variants": [{"name": "a", "url": "https://example.com", "weight": 1}, {"name": "b", "url": "https://evil.test", "weight": 1}]}`: http.StatusBadRequest,
+		`{"url": "https://example.com", "rules": [{"name": "de", "url": "https://evil.test/de", "countries": ["DE"]}]}`:                    http.StatusBadRequest,
+		`{"url": "https://example.com", "alias": "fine"}`:                                                                                  http.StatusOK,
+	} {
+		req := httptest.NewRequest("POST", "/shorten", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		server.g.ServeHTTP(w, req)
+		if w.Code != want {
+			t.Fatalf("%s: expected %d, got %d: %s", body, want, w.Code, w.Body.String())
+		}
+	}
+
+	// bulk rows go through the same check
+	w := httptest.NewRecorder()
+	server.g.ServeHTTP(w, httptest.NewRequest("POST", "/shorten/batch", strings.NewReader(`[{"url": "https://evil.test"}, {"url": "https://example.com/ok"}]`)))
+	if results := batchResults(t, w); len(results) != 2 || results[0].Error == "" || results[1].ShortCode == "" {
+		t.Fatalf("unexpected batch results: %+v", results)
+	}
+
+	// and so do new destinations of existing links
+	urlStorage.keys["key"] = "owner"
+	link := urlStorage.links["fine"]
+	link.OwnerID = "owner"
+	urlStorage.links["fine"] = link
+	req := httptest.NewRequest("PATCH", "/links/fine", strings.NewReader(`{"url": "https://evil.test"}`))
+	req.Header.Set("X-API-Key", "key")
+	w = httptest.NewRecorder()
+	server.g.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest || urlStorage.links["fine"].URL != "https://example.com" {
+		t.Fatalf("expected the update to be refused, got %d", w.Code)
+	}
+}
+
+func TestSafety_CheckedOnRedirect(t *testing.T) {
+	server, urlStorage, clickStorage := newTestServer()
+	budget := int64(5)
+	urlStorage.links["old"] = domain.ShortenedURL{URL: "https://example.com/a", ShortCode: "old", MaxClicks: &budget,
+		Rules: []domain.Rule{{Name: "ios", URL: "https://bad.example.com/app", Devices: []string{"ios"}}}}
+
+	// the policy changed after the link was made
+	server.Configure(Options{Safety: mockChecker{"bad.example.com"}})
+
+	w := httptest.NewRecorder()
+	server.g.ServeHTTP(w, httptest.NewRequest("GET", "/s/old", nil))
+	if w.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("expected the default destination to redirect, got %d", w.Code)
+	}
+
+	req := httptest.NewRequest("GET", "/s/old", nil)
+	req.Header.Set("User-Agent", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 Mobile/15E148")
+	w = httptest.NewRecorder()
+	server.g.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "destination blocked") {
+		t.Fatalf("expected 403 for the blocked rule destination, got %d: %s", w.Code, w.Body.String())
+	}
+	if used := urlStorage.links["old"].ClicksUsed; used != 1 {
+		t.Fatalf("expected only the allowed redirect to spend a click, got %d", used)
+	}
+	if n := len(clickStorage.clicks["old"]); n != 1 {
+		t.Fatalf("expected only the allowed redirect to be recorded, got %d", n)
+	}
+}
+
+func TestAdmin_DisableAndEnable(t *testing.T) {
+	server, urlStorage, _ := newTestServer()
+	urlStorage.links["promo"] = domain.ShortenedURL{URL: "https://example.com", ShortCode: "promo"}
+
+	admin := func(path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("X-Admin-Token", token)
+		}
+		w := httptest.NewRecorder()
+		server.g.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := admin("/admin/links/promo/disable", "anything", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected the admin api to be off without a token, got %d", w.Code)
+	}
+
+	server.Configure(Options{AdminToken: "t0ken"})
+	cache := newMockCache()
+	cache.links["promo"] = urlStorage.links["promo"]
+	server.cache = cache
+
+	if w := admin("/admin/links/promo/disable", "wrong", ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for a wrong token, got %d", w.Code)
+	}
+	if w := admin("/admin/links/missing/disable", "t0ken", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown code, got %d", w.Code)
+	}
+
+	w := admin("/admin/links/promo/disable", "t0ken", `{"reason": "phishing report"}`)
+	var link domain.ShortenedURL
+	if err := json.Unmarshal(w.Body.Bytes(), &link); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("expected the disabled link, got %d: %s", w.Code, w.Body.String())
+	}
+	if link.DisabledAt == nil || link.DisabledReason != "phishing report" {
+		t.Fatalf("unexpected link: %+v", link)
+	}
+	if !slices.Contains(cache.deleted, "promo") {
+		t.Fatalf("expected the link to be dropped from the cache")
+	}
+
+	for _, path := range []string{"/s/promo", "/s/promo+"} {
+		w := httptest.NewRecorder()
+		server.g.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "link disabled") {
+			t.Fatalf("%s: expected 403, got %d", path, w.Code)
+		}
+	}
+
+	if w := admin("/admin/links/promo/enable", "t0ken", ""); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	server.g.ServeHTTP(w, httptest.NewRequest("GET", "/s/promo", nil))
+	if w.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("expected the enabled link to redirect, got %d", w.Code)
+	}
+}
+
+func TestPreview(t *testing.T) {
+	server, urlStorage, clickStorage := newTestServer()
+	server.Configure(Options{PublicURL: "https://sho.rt"})
+	once := int64(1)
+	urlStorage.links["once"] = domain.ShortenedURL{URL: "https://example.com/<b>", ShortCode: "once", MaxClicks: &once}
+
+	w := httptest.NewRecorder()
+	server.g.ServeHTTP(w, httptest.NewRequest("GET", "/s/once+", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, "https://example.com/&lt;b&gt;") || !strings.Contains(body, `href="https://sho.rt/s/once"`) {
+		t.Fatalf("expected the escaped destination and a link to continue, got %s", body)
+	}
+	if urlStorage.links["once"].ClicksUsed != 0 || len(clickStorage.clicks["once"]) != 0 {
+		t.Fatalf("expected the preview to leave the click budget and analytics alone")
+	}
+
+	code := createProtected(t, server, "")
+	w = httptest.NewRecorder()
+	server.g.ServeHTTP(w, httptest.NewRequest("GET", "/s/"+code+"+", nil))
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "example.com/doc") {
+		t.Fatalf("expected the preview to hide a protected destination, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	server.g.ServeHTTP(w, httptest.NewRequest("GET", "/s/missing+", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", w.Code)
+	}
+}
+
+func TestBrandedDomains(t *testing.T) {
+	server, urlStorage, clickStorage := newTestServer()
+	server.Configure(Options{PublicURL: "https://sho.rt"})
+	urlStorage.keys["brand-key"] = "brand"
+	urlStorage.keys["other-key"] = "other"
+
+	send := func(method, target, apiKey string, body any) *httptest.ResponseRecorder {
+		var r *bytes.Reader
+		if body != nil {
+			raw, _ := json.Marshal(body)
+			r = bytes.NewReader(raw)
+		} else {
+			r = bytes.NewReader(nil)
+		}
+		req := httptest.NewRequest(method, target, r)
+		req.Header.Set("Content-Type", "application/json")
+		if apiKey != "" {
+			req.Header.Set("X-API-Key", apiKey)
+		}
+		w := httptest.NewRecorder()
+		server.g.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, host := range []string{"Go.Brand-A.com", "go.brand-b.com"} {
+		if w := send("POST", "/domains", "brand-key", domain.CreateDomainRequest{Domain: host}); w.Code != http.StatusCreated {
+			t.Fatalf("register %s: expected 201, got %d: %s", host, w.Code, w.Body.String())
+		}
+	}
+	if w := send("POST", "/domains", "other-key", domain.CreateDomainRequest{Domain: "go.brand-a.com"}); w.Code != http.StatusConflict {
+		t.Fatalf("expected a taken domain to be refused with 409, got %d", w.Code)
+	}
+	if w := send("POST", "/domains", "other-key", domain.CreateDomainRequest{Domain: "sho.rt"}); w.Code != http.StatusConflict {
+		t.Fatalf("expected the service's own host to be refused with 409, got %d", w.Code)
+	}
+
+	// the same alias on the default domain and on both branded ones
+	for _, host := range []string{"", "go.brand-a.com", "go.brand-b.com"} {
+		req := domain.ShortenRequest{URL: "https://example.com/" + host, Alias: "sale", Domain: host}
+		if w := send("POST", "/shorten", "brand-key", req); w.Code != http.StatusOK {
+			t.Fatalf("shorten on %q: expected 200, got %d: %s", host, w.Code, w.Body.String())
+		}
+	}
+	if w := send("POST", "/shorten", "other-key", domain.ShortenRequest{URL: "https://example.com", Alias: "x1y", Domain: "go.brand-a.com"}); w.Code != http.StatusForbidden {
+		t.Fatalf("expected shortening onto someone else's domain to be refused with 403, got %d", w.Code)
+	}
+
+	tests := []struct {
+		host, path, want string
+	}{
+		{"sho.rt", "/s/sale", "https://example.com/"},
+		{"go.brand-a.com", "/s/sale", "https://example.com/go.brand-a.com"},
+		{"go.brand-b.com:443", "/sale", "https://example.com/go.brand-b.com"},
+		{"unknown.example", "/s/sale", "https://example.com/"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		req.Host = tt.host
+		w := httptest.NewRecorder()
+		server.g.ServeHTTP(w, req)
+		if w.Code != http.StatusTemporaryRedirect || w.Header().Get("Location") != tt.want {
+			t.Errorf("GET %s%s: expected a redirect to %s, got %d %s", tt.host, tt.path, tt.want, w.Code, w.Header().Get("Location"))
+		}
+	}
+	if n := len(clickStorage.clicks["go.brand-b.com/sale"]); n != 1 {
+		t.Fatalf("expected the click under the branded link's key, got %d", n)
+	}
+
+	// the root of the default domain still only serves the UI and the API
+	req := httptest.NewRequest("GET", "/sale", nil)
+	req.Host = "sho.rt"
+	w := httptest.NewRecorder()
+	server.g.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for a root short code on the default domain, got %d", w.Code)
+	}
+
+	if w := send("GET", "/qr/sale?format=svg&domain=go.brand-a.com", "", nil); w.Code != http.StatusOK {
+		t.Fatalf("expected a QR code for the branded link, got %d", w.Code)
+	}
+	if w := send("DELETE", "/domains/go.brand-a.com", "brand-key", nil); w.Code != http.StatusConflict {
+		t.Fatalf("expected a domain with links to stay, got %d", w.Code)
+	}
+	if w := send("DELETE", "/links/sale?domain=go.brand-a.com", "brand-key", nil); w.Code != http.StatusNoContent {
+		t.Fatalf("expected the branded link to be deleted, got %d", w.Code)
+	}
+	if _, ok := urlStorage.links["sale"]; !ok {
+		t.Fatalf("expected the default domain's link to stay")
+	}
+	if w := send("DELETE", "/domains/go.brand-a.com", "brand-key", nil); w.Code != http.StatusNoContent {
+		t.Fatalf("expected the empty domain to be removed, got %d", w.Code)
+	}
+
+	w = send("GET", "/domains", "brand-key", nil)
+	var domains []domain.Domain
+	if err := json.Unmarshal(w.Body.Bytes(), &domains); err != nil || len(domains) != 1 || domains[0].Domain != "go.brand-b.com" {
+		t.Fatalf("unexpected domains %s: %v", w.Body.String(), err)
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	server, urlStorage, _ := newTestServer()
+	urlStorage.keys["owner-key"] = "owner"
+	urlStorage.keys["other-key"] = "other"
+	urlStorage.links["promo"] = domain.ShortenedURL{ShortCode: "promo", URL: "https://example.com", OwnerID: "owner"}
+
+	send := func(method, target, apiKey string, body any) *httptest.ResponseRecorder {
+		raw, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, target, bytes.NewReader(raw))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-API-Key", apiKey)
+		w := httptest.NewRecorder()
+		server.g.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, bad := range []domain.CreateWebhookRequest{
+		{URL: "https://hooks.example.com", Event: "milestone", Threshold: 1000},
+		{URL: "https://hooks.example.com", Event: domain.WebhookThreshold},
+		{URL: "ftp://hooks.example.com", Event: domain.WebhookThreshold, Threshold: 1000},
+		{URL: "https://hooks.example.com", Event: domain.WebhookSpike, Threshold: 50, WindowSeconds: 10},
+		{URL: "http://169.254.169.254/latest/meta-data", Event: domain.WebhookThreshold, Threshold: 1000},
+		{URL: "http://localhost:6379", Event: domain.WebhookThreshold, Threshold: 1000},
+	} {
+		if w := send("POST", "/links/promo/webhooks", "owner-key", bad); w.Code != http.StatusBadRequest {
+			t.Errorf("expected %+v to be refused with 400, got %d", bad, w.Code)
+		}
+	}
+	req := domain.CreateWebhookRequest{URL: "https://hooks.example.com", Event: domain.WebhookThreshold, Threshold: 1000}
+	if w := send("POST", "/links/promo/webhooks", "other-key", req); w.Code != http.StatusNotFound {
+		t.Fatalf("expected a webhook on someone else's link to be refused with 404, got %d", w.Code)
+	}
+	w := send("POST", "/links/promo/webhooks", "owner-key", req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var created domain.Webhook
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil || created.Secret == "" || created.ShortCode != "promo" {
+		t.Fatalf("expected the new webhook with its secret, got %s: %v", w.Body.String(), err)
+	}
+
+	w = send("GET", "/links/promo/webhooks", "owner-key", nil)
+	var listed []domain.Webhook
+	if err := json.Unmarshal(w.Body.Bytes(), &listed); err != nil || len(listed) != 1 || listed[0].Secret != "" {
+		t.Fatalf("expected the webhook without its secret, got %s: %v", w.Body.String(), err)
+	}
+
+	urlStorage.deliveries[created.ID] = []domain.WebhookDelivery{
+		{ID: "d2", WebhookID: created.ID, Status: domain.DeliveryPending, Attempts: 2, ResponseStatus: 500, Payload: json.RawMessage(`{"clicks":1000}`)},
+		{ID: "d1", WebhookID: created.ID, Status: domain.DeliveryDelivered, Attempts: 1, ResponseStatus: 200, Payload: json.RawMessage(`{}`)},
+	}
+	w = send("GET", "/webhooks/"+created.ID+"/deliveries?limit=1", "owner-key", nil)
+	var deliveries []domain.WebhookDelivery
+	if err := json.Unmarshal(w.Body.Bytes(), &deliveries); err != nil || len(deliveries) != 1 || deliveries[0].ID != "d2" ||
+		string(deliveries[0].Payload) != `{"clicks":1000}` {
+		t.Fatalf("expected the latest delivery with its payload, got %s: %v", w.Body.String(), err)
+	}
+	if w := send("GET", "/webhooks/"+created.ID+"/deliveries", "other-key", nil); w.Code != http.StatusNotFound {
+		t.Fatalf("expected someone else's delivery log to be 404, got %d", w.Code)
+	}
+	if w := send("GET", "/webhooks/not-a-uuid/deliveries", "owner-key", nil); w.Code != http.StatusNotFound {
+		t.Fatalf("expected a malformed webhook ID to be 404, got %d", w.Code)
+	}
+
+	if w := send("DELETE", "/webhooks/"+created.ID, "other-key", nil); w.Code != http.StatusNotFound {
+		t.Fatalf("expected deleting someone else's webhook to be 404, got %d", w.Code)
+	}
+	if w := send("DELETE", "/webhooks/"+created.ID, "owner-key", nil); w.Code != http.StatusNoContent {
+		t.Fatalf("expected the webhook to be deleted, got %d", w.Code)
+	}
+}
+
+func TestLinkGroups(t *testing.T) {
+	server, urlStorage, clickStorage := newTestServer()
+	server.Configure(Options{HideIPs: true})
+	urlStorage.keys["owner-key"] = "owner"
+	urlStorage.keys["other-key"] = "other"
+	for key, owner := range map[string]string{"promo": "owner", "go.brand.com/sale": "owner", "theirs": "other"} {
+		host, shortCode := domain.SplitLinkKey(key)
+		urlStorage.links[key] = domain.ShortenedURL{ShortCode: shortCode, Domain: host, URL: "https://example.com/" + shortCode, OwnerID: owner}
+		urlStorage.urls[key] = "https://example.com/" + shortCode
+	}
+	now := time.Now()
+	clickStorage.clicks["promo"] = []domain.Click{{ShortCode: "promo", IP: "1.1.1.1", Timestamp: now}}
+	clickStorage.clicks["go.brand.com/sale"] = []domain.Click{
+		{ShortCode: "go.brand.com/sale", IP: "1.1.1.1", Timestamp: now},
+		{ShortCode: "go.brand.com/sale", IP: "2.2.2.2", Timestamp: now},
+	}
+
+	send := func(method, target, apiKey string, body any) *httptest.ResponseRecorder {
+		raw, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, target, bytes.NewReader(raw))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-API-Key", apiKey)
+		w := httptest.NewRecorder()
+		server.g.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := send("POST", "/groups", "owner-key", domain.CreateGroupRequest{Links: []string{"promo"}}); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected a group without a name to be refused with 400, got %d", w.Code)
+	}
+	w := send("POST", "/groups", "owner-key", domain.CreateGroupRequest{Name: "Spring", Links: []string{"promo", "theirs"}})
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "theirs") {
+		t.Fatalf("expected someone else's link to be refused by name with 400, got %d: %s", w.Code, w.Body.String())
+	}
+	w = send("POST", "/groups", "owner-key", domain.CreateGroupRequest{Name: "Spring", Links: []string{"promo"}})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var group domain.LinkGroup
+	if err := json.Unmarshal(w.Body.Bytes(), &group); err != nil || group.ID == "" || len(group.Links) != 1 {
+		t.Fatalf("expected the new group, got %s: %v", w.Body.String(), err)
+	}
+
+	// branded links are named by domain/short_code, in any case
+	w = send("POST", "/groups/"+group.ID+"/links", "owner-key", domain.GroupLinksRequest{Links: []string{"GO.Brand.com/sale"}})
+	if err := json.Unmarshal(w.Body.Bytes(), &group); err != nil || w.Code != http.StatusOK ||
+		!slices.Equal(group.Links, []string{"promo", "go.brand.com/sale"}) {
+		t.Fatalf("expected the branded link to be added, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := send("POST", "/groups/"+group.ID+"/links", "other-key", domain.GroupLinksRequest{Links: []string{"theirs"}}); w.Code != http.StatusNotFound {
+		t.Fatalf("expected adding to someone else's group to be 404, got %d", w.Code)
+	}
+
+	w = send("GET", "/groups/"+group.ID+"/analytics?granularity=day", "owner-key", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp domain.GroupAnalyticsResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode analytics: %v", err)
+	}
+	if resp.TotalClicks != 3 || resp.Current == nil || resp.Current.Clicks != 3 || resp.TopIPs != nil ||
+		len(resp.Leaderboard) != 2 || resp.Leaderboard[0].ShortCode != "sale" || resp.Leaderboard[0].Domain != "go.brand.com" {
+		t.Fatalf("unexpected group analytics: %s", w.Body.String())
+	}
+	if w := send("GET", "/groups/"+group.ID+"/analytics", "other-key", nil); w.Code != http.StatusNotFound {
+		t.Fatalf("expected someone else's group analytics to be 404, got %d", w.Code)
+	}
+	if w := send("GET", "/groups/not-a-uuid/analytics", "owner-key", nil); w.Code != http.StatusNotFound {
+		t.Fatalf("expected a malformed group ID to be 404, got %d", w.Code)
+	}
+
+	if w := send("DELETE", "/groups/"+group.ID+"/links/sale?domain=go.brand.com", "owner-key", nil); w.Code != http.StatusNoContent {
+		t.Fatalf("expected the link to be removed, got %d", w.Code)
+	}
+	if w := send("DELETE", "/groups/"+group.ID+"/links/sale?domain=go.brand.com", "owner-key", nil); w.Code != http.StatusNotFound {
+		t.Fatalf("expected removing a link twice to be 404, got %d", w.Code)
+	}
+	w = send("GET", "/groups", "owner-key", nil)
+	var groups []domain.LinkGroup
+	if err := json.Unmarshal(w.Body.Bytes(), &groups); err != nil || len(groups) != 1 || !slices.Equal(groups[0].Links, []string{"promo"}) {
+		t.Fatalf("expected the group with one link, got %s: %v", w.Body.String(), err)
+	}
+	if w := send("DELETE", "/groups/"+group.ID, "owner-key", nil); w.Code != http.StatusNoContent {
+		t.Fatalf("expected the group to be deleted, got %d", w.Code)
+	}
+	if w := send("GET", "/groups/"+group.ID, "owner-key", nil); w.Code != http.StatusNotFound {
+		t.Fatalf("expected a deleted group to be 404, got %d", w.Code)
+	}
+}
+
+type mockCards struct {
+	cards   map[string]domain.Card
+	fetches int
+}
+
+func (m *mockCards) Card(ctx context.Context, destination string) (domain.Card, error) {
+	m.fetches++
+	return m.cards[destination], nil
+}
+
+func TestRedirectTypes(t *testing.T) {
+	server, urlStorage, _ := newTestServer()
+	urlStorage.keys["owner-key"] = "owner"
+
+	send := func(method, target string, body any) *httptest.ResponseRecorder {
+		raw, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, target, bytes.NewReader(raw))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-API-Key", "owner-key")
+		w := httptest.NewRecorder()
+		server.g.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := send("POST", "/shorten", domain.ShortenRequest{URL: "https://example.com", Alias: "moved", RedirectType: 303}); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 303 to be refused with 400, got %d", w.Code)
+	}
+	if w := send("POST", "/shorten", domain.ShortenRequest{URL: "https://example.com", Alias: "moved", RedirectType: 301}); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	w := httptest.NewRecorder()
+	server.g.ServeHTTP(w, httptest.NewRequest("GET", "/s/moved", nil))
+	if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "https://example.com" {
+		t.Fatalf("expected a 301 to the destination, got %d to %q", w.Code, w.Header().Get("Location"))
+	}
+
+	if w := send("PATCH", "/links/moved", map[string]any{}); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected an empty patch to be refused with 400, got %d", w.Code)
+	}
+	if w := send("PATCH", "/links/moved", map[string]any{"redirect_type": 302}); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	link := urlStorage.links["moved"]
+	if link.RedirectType != http.StatusFound || link.URL != "https://example.com" {
+		t.Fatalf("expected only the redirect type to change, got %+v", link)
+	}
+	w = httptest.NewRecorder()
+	server.g.ServeHTTP(w, httptest.NewRequest("GET", "/s/moved", nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("expected 302, got %d", w.Code)
+	}
+	if w := send("PATCH", "/links/moved", map[string]any{"redirect_type": 300}); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 300 to be refused with 400, got %d", w.Code)
+	}
+}
+
+func TestPreviewBotCard(t *testing.T) {
+	server, urlStorage, clickStorage := newTestServer()
+	cards := &mockCards{cards: map[string]domain.Card{
+		"https://example.com/sale": {Title: "Example", Description: "Fetched <description>", Image: "https://example.com/og.png"},
+	}}
+	server.Configure(Options{PublicURL: "https://sho.rt", Cards: cards})
+	urlStorage.links["sale"] = domain.ShortenedURL{ShortCode: "sale", URL: "https://example.com/sale", Card: &domain.Card{Title: "Summer sale"}}
+	urlStorage.links["plain"] = domain.ShortenedURL{ShortCode: "plain", URL: "https://example.org/page"}
+
+	preview := func(code, userAgent string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/s/"+code, nil)
+		req.Header.Set("User-Agent", userAgent)
+		w := httptest.NewRecorder()
+		server.g.ServeHTTP(w, req)
+		return w
+	}
+
+	w := preview("sale", "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected the card with 200, got %d", w.Code)
+	}
+	body := w.Body.String()
+	for _, want := range []string{
+		`<meta property="og:title" content="Summer sale">`,
+		`<meta property="og:description" content="Fetched &lt;description&gt;">`,
+		`<meta property="og:image" content="https://example.com/og.png">`,
+		`<meta property="og:url" content="https://sho.rt/s/sale">`,
+		`<meta name="twitter:card" content="summary_large_image">`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected the card to contain %s, got %s", want, body)
+		}
+	}
+	if len(clickStorage.clicks["sale"]) != 0 {
+		t.Fatalf("expected the preview not to be recorded as a click")
+	}
+
+	w = preview("plain", "TelegramBot (like TwitterBot)")
+	if !strings.Contains(w.Body.String(), `<meta property="og:title" content="example.org">`) {
+		t.Fatalf("expected a card named after the host, got %s", w.Body.String())
+	}
+
+	w = preview("sale", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Safari/537.36")
+	if w.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("expected browsers to be redirected, got %d", w.Code)
+	}
+	if cards.fetches != 2 {
+		t.Fatalf("expected only the previews to ask for destination cards, got %d", cards.fetches)
+	}
+}
+
+func TestRedirect_CachesUnknownCodes(t *testing.T) {
+	server, urlStorage, _ := newTestServer()
+	cache := newMockCache()
+	server.cache = cache
+
+	for range 2 {
+		w := httptest.NewRecorder()
+		server.g.ServeHTTP(w, httptest.NewRequest("GET", "/s/later", nil))
+		if w.Code != http.StatusNotFound {
+			t.Fatalf("expected 404, got %d", w.Code)
+		}
+	}
+	if !cache.unknown["later"] {
+		t.Fatalf("expected the unknown code to be remembered")
+	}
+
+	// a link made under the code is found straight away
+	body, _ := json.Marshal(domain.ShortenRequest{URL: "https://example.com", Alias: "later"})
+	req := httptest.NewRequest("POST", "/shorten", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	server.g.ServeHTTP(httptest.NewRecorder(), req)
+	if _, ok := urlStorage.links["later"]; !ok {
+		t.Fatalf("expected the link to be created")
+	}
+	w := httptest.NewRecorder()
+	server.g.ServeHTTP(w, httptest.NewRequest("GET", "/s/later", nil))
+	if w.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("expected the new link to redirect, got %d", w.Code)
+	}
+}
+
+func TestLiveClicks(t *testing.T) {
+	server, urlStorage, _ := newTestServer()
+	urlStorage.links["promo"] = domain.ShortenedURL{ShortCode: "promo", URL: "https://example.com"}
+
+	w := httptest.NewRecorder()
+	server.g.ServeHTTP(w, httptest.NewRequest("GET", "/analytics/promo/live", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 without a cache, got %d", w.Code)
+	}
+
+	server.cache = newMockCache()
+	for range 3 {
+		server.g.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/s/promo", nil))
+	}
+	// previews are not clicks
+	server.g.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/s/promo+", nil))
+
+	w = httptest.NewRecorder()
+	server.g.ServeHTTP(w, httptest.NewRequest("GET", "/analytics/promo/live", nil))
+	var live domain.LiveClicks
+	if err := json.Unmarshal(w.Body.Bytes(), &live); err != nil {
+		t.Fatalf("failed to unmarshal live clicks: %v", err)
+	}
+	if live.Clicks != 3 || live.WindowSeconds != 300 {
+		t.Fatalf("expected 3 clicks in 300 seconds, got %+v", live)
+	}
+}
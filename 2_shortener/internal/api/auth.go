@@ -0,0 +1,63 @@
+package api
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"shortener/internal/storage"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kxddry/wbf/ginext"
+)
+
+// ownerKey is the gin context key holding the authenticated owner ID.
+const ownerKey = "owner_id"
+
+// authenticate resolves the API key from the X-API-Key header or an "Authorization: Bearer" header.
+// An unknown key is always rejected; a missing key is rejected only if required is true.
+func (s *Server) authenticate(required bool) func(c *ginext.Context) {
+	return func(c *ginext.Context) {
+		key := c.GetHeader("X-API-Key")
+		if key == "" {
+			key, _ = strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		}
+		if key == "" {
+			if required {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "api key is required"})
+				return
+			}
+			c.Next()
+			return
+		}
+
+		ownerID, err := s.urlStorage.OwnerByAPIKey(c.Request.Context(), key)
+		if err != nil {
+			if errors.Is(err, storage.ErrUnauthorized) {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.Set(ownerKey, ownerID)
+		c.Next()
+	}
+}
+
+// requireAdmin admits requests carrying the admin token in the X-Admin-Token header. Without a configured
+// token the admin API is switched off and answers 404.
+func (s *Server) requireAdmin() func(c *ginext.Context) {
+	return func(c *ginext.Context) {
+		if s.opts.AdminToken == "" {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "admin api is disabled"})
+			return
+		}
+		token := c.GetHeader("X-Admin-Token")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.opts.AdminToken)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid admin token"})
+			return
+		}
+		c.Next()
+	}
+}
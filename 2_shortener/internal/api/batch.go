@@ -0,0 +1,323 @@
+package api
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"shortener/internal/domain"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kxddry/wbf/ginext"
+	"github.com/kxddry/wbf/zlog"
+)
+
+const (
+	// batchChunk is how many rows of a bulk request are saved, and their results sent, at a time.
+	batchChunk = 500
+	// maxBatchRows is the most rows a bulk request may have.
+	maxBatchRows = 100_000
+	// batchStatusTrailer is the trailer that tells a bulk request read to the end from one cut short.
+	batchStatusTrailer = "X-Batch-Status"
+)
+
+func (s *Server) postBatch() func(c *ginext.Context) {
+	return func(c *ginext.Context) {
+		dedupe, ok := dedupeOption(c)
+		if !ok {
+			return
+		}
+		dec := json.NewDecoder(c.Request.Body)
+		if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expected a JSON array of shorten requests"})
+			return
+		}
+
+		s.shortenAll(c, dedupe, func() (domain.ShortenRequest, error) {
+			var req domain.ShortenRequest
+			if !dec.More() {
+				return req, io.EOF
+			}
+			// a row of the wrong shape is that row's problem; only broken JSON ends the request
+			var raw json.RawMessage
+			if err := dec.Decode(&raw); err != nil {
+				return req, err
+			}
+			if err := json.Unmarshal(raw, &req); err != nil {
+				return req, badRow{err}
+			}
+			return req, nil
+		})
+	}
+}
+
+func (s *Server) postImport() func(c *ginext.Context) {
+	return func(c *ginext.Context) {
+		dedupe, ok := dedupeOption(c)
+		if !ok {
+			return
+		}
+		body := io.Reader(c.Request.Body)
+		if strings.HasPrefix(c.ContentType(), "multipart/form-data") {
+			f, _, err := c.Request.FormFile("file")
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "expected a CSV file in the 'file' field"})
+				return
+			}
+			defer f.Close()
+			body = f
+		}
+
+		r := csv.NewReader(body)
+		r.FieldsPerRecord = -1
+		r.ReuseRecord = true
+		header, err := r.Read()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expected a CSV header with a url column"})
+			return
+		}
+		urlCol, aliasCol := -1, -1
+		for i, name := range header {
+			switch strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\uFEFF"))) {
+			case "url":
+				urlCol = i
+			case "alias":
+				aliasCol = i
+			}
+		}
+		if urlCol < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expected a CSV header with a url column"})
+			return
+		}
+
+		s.shortenAll(c, dedupe, func() (domain.ShortenRequest, error) {
+			record, err := r.Read()
+			if err != nil {
+				return domain.ShortenRequest{}, err
+			}
+			return domain.ShortenRequest{URL: field(record, urlCol), Alias: field(record, aliasCol)}, nil
+		})
+	}
+}
+
+// dedupeOption parses the 'dedupe' query parameter of a bulk request, answering 400 if it is invalid.
+func dedupeOption(c *ginext.Context) (bool, bool) {
+	v := c.Query("dedupe")
+	if v == "" {
+		return false, true
+	}
+	dedupe, err := strconv.ParseBool(v)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid 'dedupe'; expected true or false"})
+		return false, false
+	}
+	return dedupe, true
+}
+
+// field returns column i of a CSV record, or "" if the record is too short or there is no such column.
+func field(record []string, i int) string {
+	if i < 0 || i >= len(record) {
+		return ""
+	}
+	return strings.TrimSpace(record[i])
+}
+
+// badRow is an error confined to one row of a bulk request; the rows after it can still be read.
+type badRow struct{ err error }
+
+func (e badRow) Error() string { return e.err.Error() }
+
+// shortenAll creates the links of a bulk request, reading its rows with next until io.EOF, and streams back
+// one result per row as NDJSON. Rows are saved in chunks of batchChunk, and each chunk's results are sent once
+// it is saved. An error from next other than badRow ends the request after a result for that row.
+func (s *Server) shortenAll(c *ginext.Context, dedupe bool, next func() (domain.ShortenRequest, error)) {
+	// a failure mid-stream can't change the status any more, so the outcome goes into a trailer
+	c.Header("Trailer", batchStatusTrailer)
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Status(http.StatusOK)
+
+	b := &shortenBatch{
+		s:       s,
+		ctx:     c.Request.Context(),
+		ownerID: c.GetString(ownerKey),
+		dedupe:  dedupe,
+		w:       c.Writer,
+		enc:     json.NewEncoder(c.Writer),
+		aliases: make(map[string]bool),
+		domains: make(map[string]error),
+		saved:   make(map[string]string),
+		pending: make(map[string]int),
+	}
+	status := "complete"
+	for row := 1; ; row++ {
+		req, err := next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if row > maxBatchRows {
+			err = fmt.Errorf("too many rows; at most %d are allowed", maxBatchRows)
+		}
+		var bad badRow
+		if err != nil && !errors.As(err, &bad) {
+			zlog.Logger.Error().Err(err).Int("row", row).Msg("bulk shorten request cut short")
+			if b.flush() {
+				_ = b.enc.Encode(domain.BatchResult{Row: row, Error: err.Error()})
+			}
+			status = "error"
+			break
+		}
+
+		b.add(row, req, err)
+		if len(b.rows) == batchChunk && !b.flush() {
+			status = "error"
+			break
+		}
+	}
+	if status == "complete" && !b.flush() {
+		status = "error"
+	}
+	c.Writer.Header().Set(batchStatusTrailer, status)
+}
+
+// shortenBatch is the state of a bulk request: the rows of the chunk being read and what earlier rows claimed.
+type shortenBatch struct {
+	s       *Server
+	ctx     context.Context
+	ownerID string
+	dedupe  bool
+	w       http.Flusher
+	enc     *json.Encoder
+
+	rows    []batchRow
+	aliases map[string]bool   // link keys of the aliases asked for so far
+	domains map[string]error  // domain -> the result of checking it, for the domains asked for so far
+	saved   map[string]string // dedupe key -> short code, for links saved in earlier chunks
+	pending map[string]int    // dedupe key -> index in rows, for links of this chunk
+}
+
+type batchRow struct {
+	result domain.BatchResult
+	link   domain.ShortenedURL
+	save   bool
+	key    string // dedupe key of a link that later rows may repeat
+	first  int    // index in rows of the earlier row this one repeats, or -1
+}
+
+func (b *shortenBatch) add(row int, req domain.ShortenRequest, err error) {
+	r := batchRow{result: domain.BatchResult{Row: row, URL: req.URL}, first: -1}
+	if err == nil {
+		err = b.prepare(&r, req)
+	}
+	if err != nil {
+		r.result.Error = err.Error()
+	}
+	b.rows = append(b.rows, r)
+}
+
+// prepare validates a row and decides where its short code comes from: a new link, or an identical earlier
+// row when deduplicating.
+func (b *shortenBatch) prepare(r *batchRow, req domain.ShortenRequest) error {
+	if err := b.s.checkShorten(&req); err != nil {
+		return err
+	}
+	r.result.URL = req.URL
+	checked, ok := b.domains[req.Domain]
+	if !ok {
+		checked = b.s.checkDomain(b.ctx, b.ownerID, req.Domain)
+		b.domains[req.Domain] = checked
+	}
+	if checked != nil {
+		return checked
+	}
+	if req.Alias != "" {
+		key := domain.LinkKey(req.Domain, req.Alias)
+		if b.aliases[key] {
+			return errors.New("duplicate alias")
+		}
+		b.aliases[key] = true
+	} else if b.dedupe {
+		raw, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		key := string(raw)
+		if code, ok := b.saved[key]; ok {
+			r.result.ShortCode, r.result.Duplicate = code, true
+			return nil
+		}
+		if i, ok := b.pending[key]; ok {
+			r.first, r.result.Duplicate = i, true
+			return nil
+		}
+		b.pending[key], r.key = len(b.rows), key
+	}
+
+	link, err := newLink(req, b.ownerID)
+	if err != nil {
+		return err
+	}
+	r.link, r.save = link, true
+	return nil
+}
+
+// flush saves the links of the rows read so far and sends their results. It reports whether the results
+// reached the client.
+func (b *shortenBatch) flush() bool {
+	var links []domain.ShortenedURL
+	var saving []int
+	for i, r := range b.rows {
+		if r.save {
+			links = append(links, r.link)
+			saving = append(saving, i)
+		}
+	}
+	if len(links) > 0 {
+		codes, err := b.s.urlStorage.SaveURLs(b.ctx, links)
+		if err != nil {
+			zlog.Logger.Error().Err(err).Int("links", len(links)).Msg("failed to save bulk links")
+		}
+		var keys []string
+		for j, code := range codes {
+			if code != "" {
+				keys = append(keys, domain.LinkKey(links[j].Domain, code))
+			}
+		}
+		b.s.invalidate(b.ctx, keys...)
+		for j, i := range saving {
+			r := &b.rows[i]
+			switch {
+			case j < len(codes) && codes[j] != "":
+				r.result.ShortCode = codes[j]
+			case err != nil:
+				r.result.Error = err.Error()
+			default:
+				r.result.Error = "alias already exists"
+			}
+		}
+	}
+
+	defer func() {
+		b.rows = b.rows[:0]
+		clear(b.pending)
+	}()
+	for i := range b.rows {
+		r := &b.rows[i]
+		if r.first >= 0 {
+			first := b.rows[r.first].result
+			r.result.ShortCode, r.result.Error = first.ShortCode, first.Error
+		}
+		if r.key != "" && r.result.ShortCode != "" {
+			b.saved[r.key] = r.result.ShortCode
+		}
+		if err := b.enc.Encode(r.result); err != nil {
+			return false
+		}
+	}
+	b.w.Flush()
+	return true
+}
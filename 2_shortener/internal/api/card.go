@@ -0,0 +1,72 @@
+package api
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+	"shortener/internal/domain"
+
+	"github.com/kxddry/wbf/ginext"
+	"github.com/kxddry/wbf/zlog"
+)
+
+var cardPage = template.Must(template.New("card").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<title>{{.Title}}</title>
+<meta property="og:type" content="website">
+<meta property="og:url" content="{{.ShortURL}}">
+<meta property="og:title" content="{{.Title}}">
+{{if .Description}}<meta property="og:description" content="{{.Description}}">
+<meta name="description" content="{{.Description}}">
+{{end}}{{if .Image}}<meta property="og:image" content="{{.Image}}">
+<meta name="twitter:card" content="summary_large_image">
+{{else}}<meta name="twitter:card" content="summary">
+{{end}}</head>
+<body>
+<a href="{{.Destination}}">{{.Title}}</a>
+</body>
+</html>
+`))
+
+type cardData struct {
+	domain.Card
+	ShortURL    string
+	Destination string
+}
+
+// card renders the Open Graph card of a link for a preview bot, in place of the redirect. What the owner left
+// out of the card is filled in from the destination; a page without a title is named after its host.
+func (s *Server) card(c *ginext.Context, link domain.ShortenedURL) {
+	if !s.destinationAllowed(c, link.Key(), link.URL) {
+		return
+	}
+
+	var card domain.Card
+	if link.Card != nil {
+		card = *link.Card
+	}
+	if s.opts.Cards != nil && (card.Title == "" || card.Description == "" || card.Image == "") {
+		fetched, err := s.opts.Cards.Card(c.Request.Context(), link.URL)
+		if err != nil {
+			zlog.Logger.Error().Err(err).Str("short_code", link.Key()).Msg("failed to get destination card")
+		}
+		card = card.Or(fetched)
+	}
+	if card.Title == "" {
+		card.Title = link.URL
+		if u, err := url.Parse(link.URL); err == nil && u.Host != "" {
+			card.Title = u.Host
+		}
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(http.StatusOK)
+	data := cardData{Card: card, ShortURL: s.shortURL(c, link.Domain, link.ShortCode), Destination: link.URL}
+	if err := cardPage.Execute(c.Writer, data); err != nil {
+		zlog.Logger.Error().Err(err).Msg("failed to render link card")
+	}
+}
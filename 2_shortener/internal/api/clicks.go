@@ -0,0 +1,244 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"shortener/internal/domain"
+	"shortener/internal/storage"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/kxddry/wbf/ginext"
+	"github.com/kxddry/wbf/zlog"
+)
+
+const (
+	// maxClicksPage is the largest page GET /analytics/:short_code/clicks returns.
+	maxClicksPage = 1000
+	// exportFlushEvery is how many exported rows are sent to the client at a time.
+	exportFlushEvery = 500
+	// exportStatusTrailer is the trailer that tells a complete export from one cut short by an error.
+	exportStatusTrailer = "X-Export-Status"
+)
+
+// exportColumns is the CSV header of a click export, in the order of clickRecord.
+var exportColumns = []string{
+	"id", "short_code", "timestamp", "ip", "user_agent", "referer", "source",
+	"browser", "browser_version", "os", "device", "is_bot", "country", "region", "city", "variant", "rule",
+}
+
+func clickRecord(c domain.Click) []string {
+	return []string{
+		c.ID, c.ShortCode, c.Timestamp.UTC().Format(time.RFC3339Nano), c.IP, c.UserAgent, c.Referer, c.Source,
+		c.Browser, c.BrowserVersion, c.OS, c.Device, strconv.FormatBool(c.IsBot), c.Country, c.Region, c.City, c.Variant, c.Rule,
+	}
+}
+
+func (s *Server) getClicks() func(c *ginext.Context) {
+	return func(c *ginext.Context) {
+		key := linkKey(c)
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+		if err != nil || limit < 1 || limit > maxClicksPage {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid 'limit'; expected 1 to " + strconv.Itoa(maxClicksPage)})
+			return
+		}
+		var after *domain.ClickCursor
+		if v := c.Query("cursor"); v != "" {
+			cursor, err := decodeCursor(v)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid 'cursor'"})
+				return
+			}
+			after = &cursor
+		}
+
+		if !s.ownedLink(c, key) {
+			return
+		}
+
+		// one extra click tells whether there is a next page
+		clicks, err := s.clickStorage.ClicksPage(c.Request.Context(), key, after, limit+1)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		page := domain.ClicksPage{Clicks: clicks}
+		if len(clicks) > limit {
+			page.Clicks = clicks[:limit]
+			page.NextCursor = encodeCursor(clicks[limit-1])
+		}
+		if s.opts.HideIPs {
+			for i := range page.Clicks {
+				page.Clicks[i].IP = ""
+			}
+		}
+		c.JSON(http.StatusOK, page)
+	}
+}
+
+func (s *Server) exportClicks() func(c *ginext.Context) {
+	return func(c *ginext.Context) {
+		key := linkKey(c)
+		format := c.DefaultQuery("format", "csv")
+		if format != "csv" && format != "ndjson" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid 'format'; expected csv or ndjson"})
+			return
+		}
+		from, err := parseBound(c.Query("from"), false, time.UTC)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid 'from'; expected YYYY-MM-DD or RFC3339"})
+			return
+		}
+		to, err := parseBound(c.Query("to"), true, time.UTC)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid 'to'; expected YYYY-MM-DD or RFC3339"})
+			return
+		}
+
+		if !s.ownedLink(c, key) {
+			return
+		}
+
+		// a failure mid-stream can't change the status any more, so the outcome goes into a trailer
+		c.Header("Trailer", exportStatusTrailer)
+		c.Header("Content-Disposition", `attachment; filename="`+c.Param("short_code")+`-clicks.`+format+`"`)
+		var out clickWriter
+		if format == "csv" {
+			c.Header("Content-Type", "text/csv; charset=utf-8")
+			out = csvClicks{csv.NewWriter(c.Writer)}
+		} else {
+			c.Header("Content-Type", "application/x-ndjson")
+			out = ndjsonClicks{json.NewEncoder(c.Writer)}
+		}
+		c.Status(http.StatusOK)
+
+		n := 0
+		err = out.Begin()
+		if err == nil {
+			err = s.clickStorage.ExportClicks(c.Request.Context(), key, from, to, func(click domain.Click) error {
+				if s.opts.HideIPs {
+					click.IP = ""
+				}
+				if err := out.Write(click); err != nil {
+					return err
+				}
+				if n++; n%exportFlushEvery == 0 {
+					if err := out.Flush(); err != nil {
+						return err
+					}
+					c.Writer.Flush()
+				}
+				return nil
+			})
+		}
+		if err == nil {
+			err = out.Flush()
+		}
+		if err != nil {
+			zlog.Logger.Error().Err(err).Str("short_code", key).Int("rows", n).Msg("click export failed")
+			c.Writer.Header().Set(exportStatusTrailer, "error")
+			return
+		}
+		c.Writer.Header().Set(exportStatusTrailer, "complete")
+	}
+}
+
+// clickWriter writes exported clicks in one of the export formats.
+type clickWriter interface {
+	Begin() error
+	Write(click domain.Click) error
+	Flush() error
+}
+
+type csvClicks struct{ w *csv.Writer }
+
+func (e csvClicks) Begin() error                   { return e.w.Write(exportColumns) }
+func (e csvClicks) Write(click domain.Click) error { return e.w.Write(clickRecord(click)) }
+func (e csvClicks) Flush() error                   { e.w.Flush(); return e.w.Error() }
+
+type ndjsonClicks struct{ enc *json.Encoder }
+
+func (e ndjsonClicks) Begin() error                   { return nil }
+func (e ndjsonClicks) Write(click domain.Click) error { return e.enc.Encode(click) }
+func (e ndjsonClicks) Flush() error                   { return nil }
+
+// ownedLink answers 404 and returns false unless the link with the given key exists and belongs to the
+// authenticated owner. Someone else's link is not told apart from a missing one.
+func (s *Server) ownedLink(c *ginext.Context, key string) bool {
+	owner, err := s.ownsLink(c, key)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	if !owner {
+		c.JSON(http.StatusNotFound, gin.H{"error": "short code not found"})
+		return false
+	}
+	return true
+}
+
+// ownsLink reports whether the link with the given key exists and belongs to the authenticated owner, if any.
+func (s *Server) ownsLink(c *ginext.Context, key string) (bool, error) {
+	ownerID := c.GetString(ownerKey)
+	if ownerID == "" {
+		return false, nil
+	}
+	link, err := s.urlStorage.GetLink(c.Request.Context(), key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return link.OwnerID == ownerID, nil
+}
+
+// parseBound parses an optional RFC3339 timestamp or YYYY-MM-DD date, a day of the calendar of loc. As an upper
+// bound, a date includes the whole day.
+func parseBound(v string, upper bool, loc *time.Location) (*time.Time, error) {
+	if v == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return &t, nil
+	}
+	t, err := time.ParseInLocation(time.DateOnly, v, loc)
+	if err != nil {
+		return nil, err
+	}
+	if upper {
+		t = t.AddDate(0, 0, 1).Add(-time.Microsecond)
+	}
+	return &t, nil
+}
+
+// encodeCursor returns the opaque cursor pointing just past the click.
+func encodeCursor(c domain.Click) string {
+	raw := strconv.FormatInt(c.Timestamp.UnixMicro(), 10) + ":" + c.ID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(v string) (domain.ClickCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(v)
+	if err != nil {
+		return domain.ClickCursor{}, err
+	}
+	ts, id, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return domain.ClickCursor{}, errors.New("malformed cursor")
+	}
+	micros, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return domain.ClickCursor{}, err
+	}
+	if _, err := uuid.Parse(id); err != nil {
+		return domain.ClickCursor{}, err
+	}
+	return domain.ClickCursor{Timestamp: time.UnixMicro(micros).UTC(), ID: id}, nil
+}
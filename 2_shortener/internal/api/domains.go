@@ -0,0 +1,212 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"shortener/internal/domain"
+	"shortener/internal/storage"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kxddry/wbf/ginext"
+	"github.com/kxddry/wbf/zlog"
+)
+
+// domainTTL is how long the registered domains are trusted before they are loaded again, so domains
+// registered or removed through another instance take effect here within it.
+const domainTTL = time.Minute
+
+// errDomainNotOwned is the error for shortening onto a domain the caller has not registered.
+var errDomainNotOwned = errors.New("domain is not registered to this api key")
+
+// domainRegistry knows which hosts are branded domains, to tell which domain a redirect is for without asking
+// the database on every request. Unknown hosts never trigger a reload, so a flood of made-up Host headers
+// costs nothing.
+type domainRegistry struct {
+	load func(ctx context.Context) ([]domain.Domain, error)
+	now  func() time.Time
+
+	mu     sync.RWMutex
+	hosts  map[string]bool
+	loaded time.Time
+}
+
+func newDomainRegistry(load func(ctx context.Context) ([]domain.Domain, error)) *domainRegistry {
+	return &domainRegistry{load: load, now: time.Now}
+}
+
+// has reports whether host is a registered branded domain, loading the domains again first if they are stale.
+// If they can't be loaded, the ones loaded last are used.
+func (r *domainRegistry) has(ctx context.Context, host string) bool {
+	r.mu.RLock()
+	fresh := r.now().Sub(r.loaded) < domainTTL
+	ok := r.hosts[host]
+	r.mu.RUnlock()
+	if fresh {
+		return ok
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// another request may have reloaded while this one waited
+	if r.now().Sub(r.loaded) >= domainTTL {
+		// a failed load is retried after domainTTL too, not on every request
+		r.loaded = r.now()
+		domains, err := r.load(ctx)
+		if err != nil {
+			zlog.Logger.Error().Err(err).Msg("failed to load domains")
+		} else {
+			r.hosts = make(map[string]bool, len(domains))
+			for _, d := range domains {
+				r.hosts[d.Domain] = true
+			}
+		}
+	}
+	return r.hosts[host]
+}
+
+// set records a domain registered or removed through this instance.
+func (r *domainRegistry) set(host string, registered bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.hosts == nil {
+		r.hosts = make(map[string]bool)
+	}
+	if registered {
+		r.hosts[host] = true
+	} else {
+		delete(r.hosts, host)
+	}
+}
+
+// normalizeHost returns the canonical form of a domain name or Host header: lower case, without a port or
+// a trailing dot.
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+}
+
+// requestDomain returns the branded domain the request was made to, or "" for the default domain. Hosts
+// that aren't registered, such as the service's own, are the default domain.
+func (s *Server) requestDomain(c *ginext.Context) string {
+	host := normalizeHost(c.Request.Host)
+	if host == "" || !s.domains.has(c.Request.Context(), host) {
+		return ""
+	}
+	return host
+}
+
+// linkKey returns the key of the link a management or analytics request is about: its short_code on the
+// domain given by the 'domain' query parameter, or on the default domain.
+func linkKey(c *ginext.Context) string {
+	return domain.LinkKey(normalizeHost(c.Query("domain")), c.Param("short_code"))
+}
+
+// checkDomain checks that a link may be made on host by the owner: the default domain is open to everyone,
+// a branded one only to the owner who registered it.
+func (s *Server) checkDomain(ctx context.Context, ownerID, host string) error {
+	if host == "" {
+		return nil
+	}
+	if ownerID == "" {
+		return errDomainNotOwned
+	}
+	domains, err := s.urlStorage.ListDomains(ctx, ownerID)
+	if err != nil {
+		return err
+	}
+	for _, d := range domains {
+		if d.Domain == host {
+			return nil
+		}
+	}
+	return errDomainNotOwned
+}
+
+// brandedRoute serves short links at the root of branded domains, as go.brand.com/sale next to
+// go.brand.com/s/sale. Any other unknown route gets the usual 404.
+func (s *Server) brandedRoute() func(c *ginext.Context) {
+	getShorten, postUnlock := s.getShorten(), s.postUnlock()
+	return func(c *ginext.Context) {
+		shortCode := strings.TrimPrefix(c.Request.URL.Path, "/")
+		if shortCode == "" || strings.Contains(shortCode, "/") || s.requestDomain(c) == "" {
+			return
+		}
+		c.Params = append(c.Params, gin.Param{Key: "short_code", Value: shortCode})
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead:
+			getShorten(c)
+		case http.MethodPost:
+			postUnlock(c)
+		}
+	}
+}
+
+func (s *Server) postDomain() func(c *ginext.Context) {
+	return func(c *ginext.Context) {
+		var req domain.CreateDomainRequest
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		req.Domain = normalizeHost(req.Domain)
+		if err := s.validator.Struct(req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		// registering the service's own host would take every default link off the air
+		if base, err := url.Parse(s.opts.PublicURL); err == nil && normalizeHost(base.Host) == req.Domain {
+			c.JSON(http.StatusConflict, gin.H{"error": storage.ErrDomainTaken.Error()})
+			return
+		}
+
+		d, err := s.urlStorage.CreateDomain(c.Request.Context(), domain.Domain{Domain: req.Domain, OwnerID: c.GetString(ownerKey)})
+		if err != nil {
+			if errors.Is(err, storage.ErrDomainTaken) {
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		s.domains.set(d.Domain, true)
+		c.JSON(http.StatusCreated, d)
+	}
+}
+
+func (s *Server) getDomains() func(c *ginext.Context) {
+	return func(c *ginext.Context) {
+		domains, err := s.urlStorage.ListDomains(c.Request.Context(), c.GetString(ownerKey))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, domains)
+	}
+}
+
+func (s *Server) deleteDomain() func(c *ginext.Context) {
+	return func(c *ginext.Context) {
+		host := normalizeHost(c.Param("domain"))
+		if err := s.urlStorage.DeleteDomain(c.Request.Context(), c.GetString(ownerKey), host); err != nil {
+			switch {
+			case errors.Is(err, storage.ErrNotFound):
+				c.JSON(http.StatusNotFound, gin.H{"error": "domain not found"})
+			case errors.Is(err, storage.ErrDomainInUse):
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			default:
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			}
+			return
+		}
+		s.domains.set(host, false)
+		c.Status(http.StatusNoContent)
+	}
+}
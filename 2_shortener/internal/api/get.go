@@ -6,6 +6,8 @@ import (
 	"net/http"
 	"shortener/internal/domain"
 	"shortener/internal/storage"
+	"shortener/internal/useragent"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -13,105 +15,224 @@ import (
 	"github.com/kxddry/wbf/zlog"
 )
 
-func (s *Server) getShorten(ctx context.Context) func(c *ginext.Context) {
+func (s *Server) getShorten() func(c *ginext.Context) {
 	return func(c *ginext.Context) {
-		shortCode := c.Param("short_code")
+		// a trailing + asks for the preview page instead of the redirect
+		shortCode, preview := strings.CutSuffix(c.Param("short_code"), "+")
 		if shortCode == "" {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "short code is required"})
 			return
 		}
 
-		if s.cache != nil {
-			url, err := s.cache.GetURL(c.Request.Context(), shortCode)
-			if err != nil && !errors.Is(err, storage.ErrNotFound) {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		// the same short code can be a different link on each domain
+		key := domain.LinkKey(s.requestDomain(c), shortCode)
+		link, version, cacheable, err := s.lookupLink(c.Request.Context(), key)
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "short code not found"})
 				return
 			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
 
-			if err == nil {
-				go func() {
-					if err := s.clickStorage.SaveClick(ctx, domain.Click{
-						ShortCode: shortCode,
-						UserAgent: c.GetHeader("User-Agent"),
-						IP:        c.ClientIP(),
-						Referer:   c.GetHeader("Referer"),
-						Timestamp: time.Now(),
-					}); err != nil {
-						zlog.Logger.Error().Err(err).Msg("failed to save click")
-					}
-				}()
-
-				c.Redirect(http.StatusTemporaryRedirect, url)
-				return
-			}
+		if link.Disabled() {
+			c.JSON(http.StatusForbidden, gin.H{"error": "link disabled"})
+			return
+		}
+		if link.Expired(time.Now()) {
+			s.expired(c, key)
+			return
+		}
+		if preview {
+			s.preview(c, link)
+			return
+		}
+		if link.Protected() {
+			s.prompt(c, http.StatusOK, "")
+			return
 		}
+		// messengers unfurling the link get its card; following it would count them and spend its budget
+		if useragent.IsPreviewBot(c.GetHeader("User-Agent")) {
+			s.card(c, link)
+			return
+		}
+		s.follow(c, link, version, cacheable, link.RedirectStatus())
+	}
+}
 
-		url, err := s.urlStorage.GetURL(c.Request.Context(), shortCode)
-		if err != nil {
-			if errors.Is(err, storage.ErrNotFound) {
-				c.JSON(http.StatusNotFound, gin.H{"error": "short code not found"})
+// follow picks the destination, spends a click of the link's budget, records the click and redirects with status.
+// A targeting rule the visitor matches decides the destination first, then the visitor's variant of a
+// multi-destination link. A destination the safety policy blocks is refused before the click is spent.
+// Spending the click is atomic, so a single-use link redirects exactly once.
+func (s *Server) follow(c *ginext.Context, link domain.ShortenedURL, version int64, cacheable bool, status int) {
+	click := domain.Click{
+		ShortCode: link.Key(),
+		UserAgent: c.GetHeader("User-Agent"),
+		IP:        c.ClientIP(),
+		Referer:   c.GetHeader("Referer"),
+		Source:    domain.ClickSource(c.Query("source")),
+		Timestamp: time.Now(),
+		Client:    useragent.Parse(c.GetHeader("User-Agent")),
+	}
+	if s.opts.GeoIP != nil {
+		click.Location = s.opts.GeoIP.Lookup(click.IP)
+	}
+	destination := link.URL
+	if rule, ok := s.pickRule(c, link, click); ok {
+		destination, click.Rule = rule.URL, rule.Name
+	} else if len(link.Variants) > 0 {
+		v := s.pickVariant(c, link)
+		destination, click.Variant = v.URL, v.Name
+	}
+	if !s.destinationAllowed(c, link.Key(), destination) {
+		return
+	}
+
+	if link.MaxClicks != nil {
+		if err := s.urlStorage.UseClick(c.Request.Context(), link.Key()); err != nil {
+			if errors.Is(err, storage.ErrExpired) {
+				s.expired(c, link.Key())
 				return
 			}
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+	}
+	s.clicks.Record(click)
+	s.countClick(c.Request.Context(), link, version, cacheable)
 
-		go func() {
-			if err := s.clickStorage.SaveClick(ctx, domain.Click{
-				ShortCode: shortCode,
-				UserAgent: c.GetHeader("User-Agent"),
-				IP:        c.ClientIP(),
-				Referer:   c.GetHeader("Referer"),
-				Timestamp: time.Now(),
-			}); err != nil {
-				zlog.Logger.Error().Err(err).Msg("failed to save click")
-			}
+	c.Redirect(status, destination)
+}
 
-			if usage, err := s.clickStorage.ClickCount(ctx, shortCode); err == nil && usage >= domain.MinUsageForCache {
-				if s.cache == nil {
-					return
-				}
-				if err := s.cache.SetURL(ctx, shortCode, url, usage); err != nil {
-					zlog.Logger.Error().Err(err).Msg("failed to set cached URL")
-				}
-			}
-		}()
+// lookupLink returns the link from the cache if possible, falling back to the URL storage.
+// If the link came from the URL storage and may be cached, cacheable is true and version is
+// the cache version read before the database, to be passed to AdmitLink. A key no link has is
+// remembered in the cache, so a flood of requests for it doesn't reach the database.
+func (s *Server) lookupLink(ctx context.Context, key string) (link domain.ShortenedURL, version int64, cacheable bool, err error) {
+	if s.cache != nil {
+		link, err = s.cache.GetLink(ctx, key)
+		if err == nil {
+			return link, 0, false, nil
+		}
+		if errors.Is(err, storage.ErrUnknownLink) {
+			return domain.ShortenedURL{}, 0, false, storage.ErrNotFound
+		}
+		if !errors.Is(err, storage.ErrNotFound) {
+			return domain.ShortenedURL{}, 0, false, err
+		}
+		// the version must be read before the database so a concurrent update is never undone
+		version, err = s.cache.LinkVersion(ctx, key)
+		if err != nil {
+			zlog.Logger.Error().Err(err).Str("short_code", key).Msg("failed to get cache version")
+		} else {
+			cacheable = true
+		}
+	}
+
+	link, err = s.urlStorage.GetLink(ctx, key)
+	if errors.Is(err, storage.ErrNotFound) && cacheable {
+		if err := s.cache.SetUnknown(ctx, key, version); err != nil {
+			zlog.Logger.Error().Err(err).Str("short_code", key).Msg("failed to cache unknown link")
+		}
+	}
+	return link, version, cacheable, err
+}
+
+// countClick counts the click in the cache, for the live counters and for deciding what is cached. A link
+// that came from the database is cached once it is clicked often enough.
+func (s *Server) countClick(ctx context.Context, link domain.ShortenedURL, version int64, cacheable bool) {
+	if s.cache == nil {
+		return
+	}
+	hits, err := s.cache.CountClick(ctx, link.Key())
+	if err != nil {
+		zlog.Logger.Error().Err(err).Str("short_code", link.Key()).Msg("failed to count click")
+		return
+	}
+	if !cacheable {
+		return
+	}
+	if _, err := s.cache.AdmitLink(ctx, link, hits, version); err != nil {
+		zlog.Logger.Error().Err(err).Msg("failed to set cached URL")
+	}
+}
+
+// expired answers a request for an expired link and drops it from the cache straight away.
+func (s *Server) expired(c *ginext.Context, key string) {
+	s.invalidate(c.Request.Context(), key)
+	if s.opts.ExpiredFallbackURL != "" {
+		c.Redirect(http.StatusTemporaryRedirect, s.opts.ExpiredFallbackURL)
+		return
+	}
+	c.JSON(http.StatusGone, gin.H{"error": "link expired"})
+}
 
-		c.Redirect(http.StatusTemporaryRedirect, url)
+// invalidate drops the links with the given keys from the cache, and forgets that keys had none.
+func (s *Server) invalidate(ctx context.Context, keys ...string) {
+	if s.cache == nil || len(keys) == 0 {
+		return
+	}
+	if err := s.cache.DeleteLink(ctx, keys...); err != nil {
+		zlog.Logger.Error().Err(err).Strs("short_codes", keys).Msg("failed to invalidate cached link")
 	}
 }
 
 func (s *Server) getAnalytics() func(c *ginext.Context) {
 	return func(c *ginext.Context) {
-		shortCode := c.Param("short_code")
-		if shortCode == "" {
+		if c.Param("short_code") == "" {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "short code is required"})
 			return
 		}
 
-		var from, to time.Time
-		if v := c.Query("from"); v != "" {
-			parsed, err := time.Parse(time.DateOnly, v)
-			if err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid 'from' date; expected YYYY-MM-DD"})
-				return
-			}
-			from = parsed
-		}
-		if v := c.Query("to"); v != "" {
-			parsed, err := time.Parse(time.DateOnly, v)
-			if err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid 'to' date; expected YYYY-MM-DD"})
-				return
-			}
-			to = parsed
+		q, ok := parseAnalyticsQuery(c)
+		if !ok {
+			return
 		}
 
-		resp, err := s.clickStorage.Analytics(c.Request.Context(), shortCode, &from, &to, 10)
+		key := linkKey(c)
+		resp, err := s.clickStorage.Analytics(c.Request.Context(), key, q.from, q.to, 10, q.includeBots)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+		// anyone who knows the short code can read the analytics, but only its owner sees who clicked
+		owner, err := s.ownsLink(c, key)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if !owner {
+			resp.TopIPs = nil
+		}
+		series := func(ctx context.Context, from, to time.Time) ([]domain.SeriesPoint, error) {
+			return s.clickStorage.ClickSeries(ctx, key, from, to, q.granularity, q.loc, q.includeBots)
+		}
+		if err := s.finishAnalytics(c.Request.Context(), &resp, q, series); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusOK, resp)
 	}
 }
+
+// liveWindow is how far back the live counter of a link looks.
+const liveWindow = 5 * time.Minute
+
+// getLive answers with the clicks of a link in the last few minutes, from the counters in the cache rather
+// than the database, which only has the clicks once they are flushed.
+func (s *Server) getLive() func(c *ginext.Context) {
+	return func(c *ginext.Context) {
+		if s.cache == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "live clicks need redis"})
+			return
+		}
+		key := linkKey(c)
+		clicks, err := s.cache.RecentClicks(c.Request.Context(), key, liveWindow)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, domain.LiveClicks{ShortCode: key, Clicks: clicks, WindowSeconds: int(liveWindow.Seconds())})
+	}
+}
@@ -0,0 +1,190 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"shortener/internal/domain"
+	"shortener/internal/storage"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/kxddry/wbf/ginext"
+)
+
+// groupKeys returns the keys of the links of a group request, which are short codes, or domain/short_code
+// for links on a branded domain.
+func groupKeys(links []string) []string {
+	keys := make([]string, len(links))
+	for i, l := range links {
+		host, shortCode := domain.SplitLinkKey(l)
+		keys[i] = domain.LinkKey(normalizeHost(host), shortCode)
+	}
+	return keys
+}
+
+// groupID returns the ID of the group in the path, answering with a 404 and returning false if it can't be one.
+func groupID(c *ginext.Context) (string, bool) {
+	id := c.Param("id")
+	if _, err := uuid.Parse(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "group not found"})
+		return "", false
+	}
+	return id, true
+}
+
+// groupError answers with the response for an error of the group storage.
+func groupError(c *ginext.Context, err error) {
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "group not found"})
+	case errors.Is(err, storage.ErrLinksNotFound):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+func (s *Server) postGroup() func(c *ginext.Context) {
+	return func(c *ginext.Context) {
+		var req domain.CreateGroupRequest
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := s.validator.Struct(req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		g, err := s.urlStorage.CreateGroup(c.Request.Context(), domain.LinkGroup{
+			OwnerID: c.GetString(ownerKey),
+			Name:    req.Name,
+			Links:   groupKeys(req.Links),
+		})
+		if err != nil {
+			groupError(c, err)
+			return
+		}
+		c.JSON(http.StatusCreated, g)
+	}
+}
+
+func (s *Server) getGroups() func(c *ginext.Context) {
+	return func(c *ginext.Context) {
+		groups, err := s.urlStorage.ListGroups(c.Request.Context(), c.GetString(ownerKey))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, groups)
+	}
+}
+
+func (s *Server) getGroup() func(c *ginext.Context) {
+	return func(c *ginext.Context) {
+		id, ok := groupID(c)
+		if !ok {
+			return
+		}
+		g, err := s.urlStorage.GetGroup(c.Request.Context(), c.GetString(ownerKey), id)
+		if err != nil {
+			groupError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, g)
+	}
+}
+
+func (s *Server) deleteGroup() func(c *ginext.Context) {
+	return func(c *ginext.Context) {
+		id, ok := groupID(c)
+		if !ok {
+			return
+		}
+		if err := s.urlStorage.DeleteGroup(c.Request.Context(), c.GetString(ownerKey), id); err != nil {
+			groupError(c, err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// postGroupLinks adds links to a group and answers with the group.
+func (s *Server) postGroupLinks() func(c *ginext.Context) {
+	return func(c *ginext.Context) {
+		id, ok := groupID(c)
+		if !ok {
+			return
+		}
+		var req domain.GroupLinksRequest
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := s.validator.Struct(req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ownerID := c.GetString(ownerKey)
+		if err := s.urlStorage.AddGroupLinks(c.Request.Context(), ownerID, id, groupKeys(req.Links)); err != nil {
+			groupError(c, err)
+			return
+		}
+		g, err := s.urlStorage.GetGroup(c.Request.Context(), ownerID, id)
+		if err != nil {
+			groupError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, g)
+	}
+}
+
+func (s *Server) deleteGroupLink() func(c *ginext.Context) {
+	return func(c *ginext.Context) {
+		id, ok := groupID(c)
+		if !ok {
+			return
+		}
+		if err := s.urlStorage.RemoveGroupLink(c.Request.Context(), c.GetString(ownerKey), id, linkKey(c)); err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "link not in group"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// getGroupAnalytics serves the analytics of all the links of a group together, with the same query as the
+// analytics of a link, and how many clicks each link got.
+func (s *Server) getGroupAnalytics() func(c *ginext.Context) {
+	return func(c *ginext.Context) {
+		id, ok := groupID(c)
+		if !ok {
+			return
+		}
+		q, ok := parseAnalyticsQuery(c)
+		if !ok {
+			return
+		}
+
+		resp, err := s.clickStorage.GroupAnalytics(c.Request.Context(), c.GetString(ownerKey), id, q.from, q.to, 10, q.includeBots)
+		if err != nil {
+			groupError(c, err)
+			return
+		}
+		series := func(ctx context.Context, from, to time.Time) ([]domain.SeriesPoint, error) {
+			return s.clickStorage.GroupClickSeries(ctx, id, from, to, q.granularity, q.loc, q.includeBots)
+		}
+		if err := s.finishAnalytics(c.Request.Context(), &resp.AnalyticsResponse, q, series); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, resp)
+	}
+}
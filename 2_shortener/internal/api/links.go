@@ -0,0 +1,100 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"shortener/internal/domain"
+	"shortener/internal/storage"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kxddry/wbf/ginext"
+)
+
+func (s *Server) getLinks() func(c *ginext.Context) {
+	return func(c *ginext.Context) {
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid 'limit'"})
+			return
+		}
+		offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid 'offset'"})
+			return
+		}
+
+		page, err := s.urlStorage.ListLinks(c.Request.Context(), c.GetString(ownerKey), c.Query("q"), limit, offset)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, page)
+	}
+}
+
+func (s *Server) patchLink() func(c *ginext.Context) {
+	return func(c *ginext.Context) {
+		key := linkKey(c)
+		var req domain.UpdateLinkRequest
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := s.validator.Struct(req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if req.URL == "" && req.RedirectType == nil && req.Card == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "nothing to update"})
+			return
+		}
+		if req.URL != "" && s.opts.Safety != nil {
+			if err := s.opts.Safety.Check(req.URL); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		owner := c.GetString(ownerKey)
+		var err error
+		if req.URL != "" {
+			err = s.urlStorage.UpdateURL(c.Request.Context(), owner, key, req.URL)
+		}
+		if err == nil && (req.RedirectType != nil || req.Card != nil) {
+			err = s.urlStorage.UpdateRedirect(c.Request.Context(), owner, key, req.RedirectType, req.Card)
+		}
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "short code not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		s.invalidate(c.Request.Context(), key)
+
+		link, err := s.urlStorage.GetLink(c.Request.Context(), key)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, link)
+	}
+}
+
+func (s *Server) deleteLink() func(c *ginext.Context) {
+	return func(c *ginext.Context) {
+		key := linkKey(c)
+		if err := s.urlStorage.DeleteURL(c.Request.Context(), c.GetString(ownerKey), key); err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "short code not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		s.invalidate(c.Request.Context(), key)
+		c.Status(http.StatusNoContent)
+	}
+}
@@ -2,12 +2,15 @@ package api
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"regexp"
 	"shortener/internal/domain"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/kxddry/wbf/ginext"
+	"golang.org/x/crypto/bcrypt"
 )
 
 var aliasRe = regexp.MustCompile(`^[a-zA-Z0-9_-]{3,32}$`)
@@ -19,19 +22,102 @@ func (s *Server) postShorten(ctx context.Context) func(c *ginext.Context) {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		if err := s.validator.Struct(req); err != nil {
+		if err := s.checkShorten(&req); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		if req.Alias != "" && !aliasRe.MatchString(req.Alias) {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid alias"})
+		if err := s.checkDomain(c.Request.Context(), c.GetString(ownerKey), req.Domain); err != nil {
+			if errors.Is(err, errDomainNotOwned) {
+				c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		link, err := newLink(req, c.GetString(ownerKey))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
-		shortCode, err := s.urlStorage.SaveURL(c.Request.Context(), req.URL, req.Alias != "", req.Alias)
+		shortCode, err := s.urlStorage.SaveURL(c.Request.Context(), link)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+		// the key may be remembered as unknown from before the link existed
+		s.invalidate(c.Request.Context(), domain.LinkKey(link.Domain, shortCode))
 		c.JSON(http.StatusOK, gin.H{"short_code": shortCode})
 	}
 }
+
+// checkShorten validates a shorten request and fills in what it implies: the URL of a multi-destination link
+// and the click budget of a single-use one.
+func (s *Server) checkShorten(req *domain.ShortenRequest) error {
+	req.Domain = normalizeHost(req.Domain)
+	if len(req.Variants) > 0 {
+		// the first variant stands in for the destination wherever a single URL is shown
+		if req.URL != "" {
+			return errors.New("url conflicts with variants")
+		}
+		req.URL = req.Variants[0].URL
+	}
+	if err := s.validator.Struct(*req); err != nil {
+		return err
+	}
+	if err := validateVariants(req.Variants); err != nil {
+		return err
+	}
+	if err := validateRules(req.Rules); err != nil {
+		return err
+	}
+	if req.Alias != "" && !aliasRe.MatchString(req.Alias) {
+		return errors.New("invalid alias")
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return errors.New("expires_at must be in the future")
+	}
+	if req.SingleUse {
+		if req.MaxClicks != nil && *req.MaxClicks != 1 {
+			return errors.New("single_use conflicts with max_clicks")
+		}
+		one := int64(1)
+		req.MaxClicks = &one
+	}
+	return s.checkDestinations(*req)
+}
+
+// newLink builds the link a checked shorten request asks for, hashing its password.
+func newLink(req domain.ShortenRequest, ownerID string) (domain.ShortenedURL, error) {
+	var passwordHash string
+	if req.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return domain.ShortenedURL{}, err
+		}
+		passwordHash = string(hash)
+	}
+	return domain.ShortenedURL{
+		URL:          req.URL,
+		ShortCode:    req.Alias,
+		ExpiresAt:    req.ExpiresAt,
+		MaxClicks:    req.MaxClicks,
+		OwnerID:      ownerID,
+		Domain:       req.Domain,
+		PasswordHash: passwordHash,
+		Variants:     req.Variants,
+		Rules:        req.Rules,
+		RedirectType: req.RedirectType,
+		Card:         req.Card,
+	}, nil
+}
+
+func (s *Server) postKey() func(c *ginext.Context) {
+	return func(c *ginext.Context) {
+		key, err := s.urlStorage.CreateAPIKey(c.Request.Context())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, key)
+	}
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"html/template"
+	"net/http"
+	"shortener/internal/domain"
+
+	"github.com/kxddry/wbf/ginext"
+	"github.com/kxddry/wbf/zlog"
+)
+
+var previewPage = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>Link preview</title>
+<style>
+body { font-family: system-ui, sans-serif; background: #f5f5f5; display: flex; justify-content: center; padding-top: 15vh; }
+main { background: #fff; padding: 2rem; border-radius: 8px; box-shadow: 0 1px 4px rgba(0,0,0,.1); width: 28rem; }
+.destination { word-break: break-all; font-family: ui-monospace, monospace; background: #f5f5f5; padding: .6rem; }
+a.button { display: block; text-align: center; padding: .6rem; margin-top: .8rem; background: #1a73e8; color: #fff; text-decoration: none; border-radius: 4px; }
+</style>
+</head>
+<body>
+<main>
+<h1>Link preview</h1>
+<p>{{.ShortURL}} leads to:</p>
+{{if .Protected}}<p>This link is password protected; its destination is shown once the password is entered.</p>
+{{else}}<p class="destination">{{.Destination}}</p>
+{{if .Varies}}<p>Some visitors are sent to a different destination, depending on their device, location or language.</p>{{end}}
+{{end}}<a class="button" href="{{.ShortURL}}" rel="nofollow">Continue</a>
+</main>
+</body>
+</html>
+`))
+
+type previewData struct {
+	ShortURL    string
+	Destination string
+	Protected   bool
+	Varies      bool
+}
+
+// preview renders the page showing where a link leads, without following it. The destination of a protected
+// link stays hidden.
+func (s *Server) preview(c *ginext.Context, link domain.ShortenedURL) {
+	data := previewData{
+		ShortURL:  s.shortURL(c, link.Domain, link.ShortCode),
+		Protected: link.Protected(),
+		Varies:    len(link.Variants) > 0 || len(link.Rules) > 0,
+	}
+	if !data.Protected {
+		if !s.destinationAllowed(c, link.Key(), link.URL) {
+			return
+		}
+		data.Destination = link.URL
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(http.StatusOK)
+	if err := previewPage.Execute(c.Writer, data); err != nil {
+		zlog.Logger.Error().Err(err).Msg("failed to render link preview")
+	}
+}
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"shortener/internal/domain"
+	"shortener/internal/qr"
+	"shortener/internal/storage"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kxddry/wbf/ginext"
+)
+
+// qrMaxAge is how long clients and CDNs may cache a QR code. The image only encodes the
+// short link, so it stays valid when the destination changes.
+const qrMaxAge = 24 * 60 * 60
+
+func (s *Server) getQR() func(c *ginext.Context) {
+	return func(c *ginext.Context) {
+		shortCode := c.Param("short_code")
+		if shortCode == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "short code is required"})
+			return
+		}
+
+		opts, err := parseQROptions(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		host := normalizeHost(c.Query("domain"))
+		if _, err := s.urlStorage.GetURL(c.Request.Context(), domain.LinkKey(host, shortCode)); err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "short code not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		content := s.shortURL(c, host, shortCode) + "?source=" + domain.ClickSourceQR
+		sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%+v", content, opts)))
+		etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+		c.Header("Cache-Control", "public, max-age="+strconv.Itoa(qrMaxAge))
+		c.Header("ETag", etag)
+		if match := c.GetHeader("If-None-Match"); match != "" && strings.Contains(match, etag) {
+			c.Status(http.StatusNotModified)
+			return
+		}
+
+		img, err := qr.Render(content, opts)
+		if err != nil {
+			if errors.Is(err, qr.ErrInvalidOptions) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.Data(http.StatusOK, opts.Format.ContentType(), img)
+	}
+}
+
+// parseQROptions reads the rendering options from the query, keeping the defaults for missing ones.
+func parseQROptions(c *ginext.Context) (qr.Options, error) {
+	opts := qr.DefaultOptions()
+	if v := c.Query("format"); v != "" {
+		opts.Format = qr.Format(strings.ToLower(v))
+	}
+	if v := c.Query("size"); v != "" {
+		size, err := strconv.Atoi(v)
+		if err != nil {
+			return opts, errors.New("invalid 'size'; expected an integer")
+		}
+		opts.Size = size
+	}
+	if v := c.Query("margin"); v != "" {
+		margin, err := strconv.Atoi(v)
+		if err != nil {
+			return opts, errors.New("invalid 'margin'; expected an integer")
+		}
+		opts.Margin = margin
+	}
+	if v := c.Query("level"); v != "" {
+		opts.Level = strings.ToUpper(v)
+	}
+	if v := c.Query("fg"); v != "" {
+		fg, err := qr.ParseColor(v)
+		if err != nil {
+			return opts, fmt.Errorf("invalid 'fg': %w", err)
+		}
+		opts.Foreground = fg
+	}
+	if v := c.Query("bg"); v != "" {
+		bg, err := qr.ParseColor(v)
+		if err != nil {
+			return opts, fmt.Errorf("invalid 'bg': %w", err)
+		}
+		opts.Background = bg
+	}
+	return opts, opts.Validate()
+}
+
+// shortURL returns the public URL of the short link on host, or on the default domain if host is empty,
+// whose base URL is derived from the request if none is configured. Links on a branded domain live at its root.
+func (s *Server) shortURL(c *ginext.Context, host, shortCode string) string {
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	base := strings.TrimRight(s.opts.PublicURL, "/")
+	if host != "" {
+		if u, err := url.Parse(base); err == nil && u.Scheme != "" {
+			scheme = u.Scheme
+		}
+		return scheme + "://" + host + "/" + url.PathEscape(shortCode)
+	}
+	if base == "" {
+		base = scheme + "://" + c.Request.Host
+	}
+	return base + "/s/" + url.PathEscape(shortCode)
+}
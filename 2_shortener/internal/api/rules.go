@@ -0,0 +1,75 @@
+package api
+
+import (
+	"errors"
+	"regexp"
+	"shortener/internal/domain"
+	"strconv"
+	"strings"
+
+	"github.com/kxddry/wbf/ginext"
+)
+
+var languageRe = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{1,8})*$`)
+
+// validateRules checks what the struct tags can't: rule names are well-formed and unique, every rule has
+// a condition, language tags are well-formed and time windows are not empty.
+func validateRules(rules []domain.Rule) error {
+	seen := make(map[string]bool, len(rules))
+	for _, r := range rules {
+		if !nameRe.MatchString(r.Name) {
+			return errors.New("invalid rule name " + r.Name)
+		}
+		if seen[r.Name] {
+			return errors.New("duplicate rule name " + r.Name)
+		}
+		seen[r.Name] = true
+
+		if len(r.Devices) == 0 && len(r.Countries) == 0 && len(r.Languages) == 0 && r.StartsAt == nil && r.EndsAt == nil {
+			return errors.New("rule " + r.Name + " has no conditions")
+		}
+		for _, l := range r.Languages {
+			if !languageRe.MatchString(l) {
+				return errors.New("invalid language " + l + " in rule " + r.Name)
+			}
+		}
+		if r.StartsAt != nil && r.EndsAt != nil && !r.EndsAt.After(*r.StartsAt) {
+			return errors.New("rule " + r.Name + " ends before it starts")
+		}
+	}
+	return nil
+}
+
+// pickRule returns the first rule of the link that the visitor of the click matches.
+func (s *Server) pickRule(c *ginext.Context, link domain.ShortenedURL, click domain.Click) (domain.Rule, bool) {
+	language := preferredLanguage(c.GetHeader("Accept-Language"))
+	for _, r := range link.Rules {
+		if r.Matches(click.Client, click.Location, language, click.Timestamp) {
+			return r, true
+		}
+	}
+	return domain.Rule{}, false
+}
+
+// preferredLanguage returns the language an Accept-Language header gives the highest weight, the first one
+// on a tie, or "" if it names none.
+func preferredLanguage(header string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		tag = strings.TrimSpace(tag)
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if tag == "" || tag == "*" || q <= bestQ {
+			continue
+		}
+		best, bestQ = tag, q
+	}
+	return best
+}
@@ -0,0 +1,44 @@
+package api
+
+import (
+	"net/http"
+	"shortener/internal/domain"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kxddry/wbf/ginext"
+	"github.com/kxddry/wbf/zlog"
+)
+
+// checkDestinations checks every destination of a shorten request against the safety policy.
+func (s *Server) checkDestinations(req domain.ShortenRequest) error {
+	if s.opts.Safety == nil {
+		return nil
+	}
+	urls := []string{req.URL}
+	for _, v := range req.Variants {
+		urls = append(urls, v.URL)
+	}
+	for _, r := range req.Rules {
+		urls = append(urls, r.URL)
+	}
+	for _, u := range urls {
+		if err := s.opts.Safety.Check(u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// destinationAllowed checks a destination against the safety policy again at redirect time, since the policy
+// may have changed since the link was made. It answers 403 and returns false if the destination is blocked.
+func (s *Server) destinationAllowed(c *ginext.Context, key, destination string) bool {
+	if s.opts.Safety == nil {
+		return true
+	}
+	if err := s.opts.Safety.Check(destination); err != nil {
+		zlog.Logger.Warn().Err(err).Str("short_code", key).Msg("blocked redirect")
+		c.JSON(http.StatusForbidden, gin.H{"error": "destination blocked"})
+		return false
+	}
+	return true
+}
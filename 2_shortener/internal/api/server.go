@@ -2,6 +2,7 @@ package api
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"shortener/internal/domain"
 	"shortener/internal/validator"
@@ -9,33 +10,131 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/kxddry/wbf/ginext"
+	"github.com/kxddry/wbf/zlog"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-// URLStorage is the interface for the URL storage.
+// URLStorage is the interface for the URL storage. Links are looked up by their key, see domain.LinkKey.
 type URLStorage interface {
-	SaveURL(ctx context.Context, url string, withAlias bool, alias string) (string, error)
-	GetURL(ctx context.Context, shortCode string) (string, error)
+	SaveURL(ctx context.Context, link domain.ShortenedURL) (string, error)
+	SaveURLs(ctx context.Context, links []domain.ShortenedURL) ([]string, error)
+	GetURL(ctx context.Context, key string) (string, error)
+	GetLink(ctx context.Context, key string) (domain.ShortenedURL, error)
+	UseClick(ctx context.Context, key string) error
+	ListLinks(ctx context.Context, ownerID, query string, limit, offset int) (domain.LinksPage, error)
+	UpdateURL(ctx context.Context, ownerID, key, url string) error
+	UpdateRedirect(ctx context.Context, ownerID, key string, redirectType *int, card *domain.Card) error
+	DeleteURL(ctx context.Context, ownerID, key string) error
+	DisableLink(ctx context.Context, key, reason string) error
+	EnableLink(ctx context.Context, key string) error
+	CreateAPIKey(ctx context.Context) (domain.APIKey, error)
+	OwnerByAPIKey(ctx context.Context, key string) (string, error)
+	CreateDomain(ctx context.Context, d domain.Domain) (domain.Domain, error)
+	ListDomains(ctx context.Context, ownerID string) ([]domain.Domain, error)
+	Domains(ctx context.Context) ([]domain.Domain, error)
+	DeleteDomain(ctx context.Context, ownerID, host string) error
+	CreateWebhook(ctx context.Context, w domain.Webhook) (domain.Webhook, error)
+	ListWebhooks(ctx context.Context, ownerID, key string) ([]domain.Webhook, error)
+	DeleteWebhook(ctx context.Context, ownerID, id string) error
+	WebhookDeliveries(ctx context.Context, ownerID, id string, limit int) ([]domain.WebhookDelivery, error)
+	CreateGroup(ctx context.Context, g domain.LinkGroup) (domain.LinkGroup, error)
+	ListGroups(ctx context.Context, ownerID string) ([]domain.LinkGroup, error)
+	GetGroup(ctx context.Context, ownerID, id string) (domain.LinkGroup, error)
+	AddGroupLinks(ctx context.Context, ownerID, id string, keys []string) error
+	RemoveGroupLink(ctx context.Context, ownerID, id, key string) error
+	DeleteGroup(ctx context.Context, ownerID, id string) error
 }
 
-// ClickStorage is the interface for the click storage.
+// ClickStorage is the interface for the click storage. Its short codes are link keys, see domain.LinkKey.
 type ClickStorage interface {
 	SaveClick(ctx context.Context, click domain.Click) error
 	GetClicks(ctx context.Context, shortCode string, limit, offset int) ([]domain.Click, error)
+	ClicksPage(ctx context.Context, shortCode string, after *domain.ClickCursor, limit int) ([]domain.Click, error)
+	ExportClicks(ctx context.Context, shortCode string, start, end *time.Time, fn func(domain.Click) error) error
 	ClickCount(ctx context.Context, shortCode string) (int64, error)
 	UniqueClickCount(ctx context.Context, shortCode string) (int64, error)
 	ClicksByDay(ctx context.Context, shortCode string, start, end *time.Time) (map[string]int64, error)
 	ClicksByMonth(ctx context.Context, shortCode string, start, end *time.Time) (map[string]int64, error)
 	ClicksByUserAgent(ctx context.Context, shortCode string, start, end *time.Time, limit int) (map[string]int64, error)
-	Analytics(ctx context.Context, shortCode string, from, to *time.Time, topLimit int) (domain.AnalyticsResponse, error)
+	Analytics(ctx context.Context, shortCode string, from, to *time.Time, topLimit int, includeBots bool) (domain.AnalyticsResponse, error)
+	ClickSeries(ctx context.Context, shortCode string, from, to time.Time, granularity string, loc *time.Location, includeBots bool) ([]domain.SeriesPoint, error)
+	GroupAnalytics(ctx context.Context, ownerID, id string, from, to *time.Time, topLimit int, includeBots bool) (domain.GroupAnalyticsResponse, error)
+	GroupClickSeries(ctx context.Context, id string, from, to time.Time, granularity string, loc *time.Location, includeBots bool) ([]domain.SeriesPoint, error)
 	ClicksByReferer(ctx context.Context, shortCode string, start, end *time.Time, limit int) (map[string]int64, error)
 	ClicksByIP(ctx context.Context, shortCode string, start, end *time.Time, limit int) (map[string]int64, error)
+	ClicksBySource(ctx context.Context, shortCode string, start, end *time.Time) (map[string]int64, error)
 }
 
-// CacheStorage is the interface for the cache storage.
+// ClickRecorder is the interface for recording clicks off the redirect path.
+type ClickRecorder interface {
+	Record(click domain.Click) bool
+}
+
+// GeoResolver is the interface for resolving the location of a click IP.
+type GeoResolver interface {
+	Lookup(ip string) domain.Location
+}
+
+// URLChecker is the interface for deciding which destinations links may point to.
+type URLChecker interface {
+	// Check returns an error saying why rawURL is not allowed, or nil if it is.
+	Check(rawURL string) error
+}
+
+// CacheStorage is the interface for the cache storage. Links are cached under their key, see domain.LinkKey.
 type CacheStorage interface {
-	GetURL(ctx context.Context, shortCode string) (string, error)
-	SetURL(ctx context.Context, shortCode, url string, usage int64) error
+	// GetLink returns the cached link, storage.ErrNotFound if it isn't cached, or storage.ErrUnknownLink if
+	// the key is remembered as having no link.
+	GetLink(ctx context.Context, key string) (domain.ShortenedURL, error)
+	// CountClick counts a click of the link and returns its recent clicks, which decide whether it is cached.
+	CountClick(ctx context.Context, key string) (float64, error)
+	// RecentClicks returns the clicks CountClick counted within the last window.
+	RecentClicks(ctx context.Context, key string, window time.Duration) (int64, error)
+	LinkVersion(ctx context.Context, key string) (int64, error)
+	// AdmitLink caches the link if hits are enough, evicting a less clicked link if the cache is full, and
+	// reports whether it did.
+	AdmitLink(ctx context.Context, link domain.ShortenedURL, hits float64, version int64) (bool, error)
+	// SetUnknown remembers for a while that the key has no link.
+	SetUnknown(ctx context.Context, key string, version int64) error
+	DeleteLink(ctx context.Context, keys ...string) error
+}
+
+// CardSource is the interface for the Open Graph metadata of link destinations.
+type CardSource interface {
+	Card(ctx context.Context, destination string) (domain.Card, error)
+}
+
+// AttemptCounter is the interface for counting password attempts per client.
+type AttemptCounter interface {
+	// CountAttempt counts an attempt and returns the attempts in the client's window, which starts with
+	// the first one and lasts window, and how long the window has left.
+	CountAttempt(ctx context.Context, client string, window time.Duration) (int64, time.Duration, error)
+	// ForgetAttempt takes back an attempt that turned out to be successful.
+	ForgetAttempt(ctx context.Context, client string) error
+}
+
+// Options holds the optional behaviour of the server.
+type Options struct {
+	// ExpiredFallbackURL is where expired links redirect to. If empty, they return 410 Gone.
+	ExpiredFallbackURL string
+	// PublicURL is the base URL short links are served from, e.g. https://sho.rt. If empty, it is
+	// derived from the request.
+	PublicURL string
+	// Clicks records redirects. If nil, each click is saved to the click storage before redirecting.
+	Clicks ClickRecorder
+	// GeoIP resolves the location of clicks. If nil, clicks are stored without one.
+	GeoIP GeoResolver
+	// Attempts counts password attempts. If nil, they are counted in memory, per instance.
+	Attempts AttemptCounter
+	// Safety checks destinations when links are made and again on every redirect. If nil, any URL is allowed.
+	Safety URLChecker
+	// AdminToken is the secret the admin API expects in the X-Admin-Token header. If empty, the admin API is off.
+	AdminToken string
+	// HideIPs leaves the IPs out of the analytics, raw clicks and exports even of the owner of a link.
+	HideIPs bool
+	// Cards fills in what owners leave out of the cards preview bots are shown. If nil, the cards only have
+	// what owners set.
+	Cards CardSource
 }
 
 // Server is the server.
@@ -46,6 +145,10 @@ type Server struct {
 	clickStorage ClickStorage
 	validator    validator.Validator
 	cache        CacheStorage
+	opts         Options
+	clicks       ClickRecorder
+	attempts     AttemptCounter
+	domains      *domainRegistry
 }
 
 // New creates a new server.
@@ -57,20 +160,114 @@ func New(urlStorage URLStorage, clickStorage ClickStorage, validator validator.V
 
 	_ = g.SetTrustedProxies(nil)
 
-	return &Server{g: g, addrs: addrs, urlStorage: urlStorage, clickStorage: clickStorage, validator: validator, cache: cache}
+	s := &Server{g: g, addrs: addrs, urlStorage: urlStorage, clickStorage: clickStorage, validator: validator, cache: cache}
+	s.clicks = syncRecorder{clickStorage}
+	s.attempts = newMemoryAttempts()
+	s.domains = newDomainRegistry(urlStorage.Domains)
+	return s
+}
+
+// Configure sets the optional behaviour of the server. It must be called before RegisterRoutes.
+func (s *Server) Configure(opts Options) {
+	s.opts = opts
+	s.clicks = opts.Clicks
+	if s.clicks == nil {
+		s.clicks = syncRecorder{s.clickStorage}
+	}
+	s.attempts = opts.Attempts
+	if s.attempts == nil {
+		s.attempts = newMemoryAttempts()
+	}
 }
 
-// Run runs the server.
+// syncRecorder saves every click straight away. It is the fallback when no buffer is configured.
+type syncRecorder struct {
+	storage ClickStorage
+}
+
+func (r syncRecorder) Record(click domain.Click) bool {
+	if err := r.storage.SaveClick(context.Background(), click); err != nil {
+		zlog.Logger.Error().Err(err).Msg("failed to save click")
+		return false
+	}
+	return true
+}
+
+// shutdownTimeout is how long in-flight requests get to finish once the server is stopped.
+const shutdownTimeout = 10 * time.Second
+
+// Run runs the server until ctx is done, then shuts it down gracefully.
 func (s *Server) Run(ctx context.Context) error {
-	return s.g.Run(s.addrs...)
+	servers := make([]*http.Server, len(s.addrs))
+	errCh := make(chan error, len(s.addrs))
+	for i, addr := range s.addrs {
+		servers[i] = &http.Server{Addr: addr, Handler: s.g}
+		go func(srv *http.Server) {
+			if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				errCh <- err
+			}
+		}(servers[i])
+	}
+
+	var err error
+	select {
+	case <-ctx.Done():
+	case err = <-errCh:
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	for _, srv := range servers {
+		if shutdownErr := srv.Shutdown(shutdownCtx); shutdownErr != nil && err == nil {
+			err = shutdownErr
+		}
+	}
+	return err
 }
 
 // RegisterRoutes registers the routes.
 func (s *Server) RegisterRoutes(ctx context.Context) {
 	// API routes
-	s.g.POST("/shorten", s.postShorten(ctx))
-	s.g.GET("/s/:short_code", s.getShorten(ctx))
-	s.g.GET("/analytics/:short_code", s.getAnalytics())
+	s.g.POST("/shorten", s.authenticate(false), s.postShorten(ctx))
+	s.g.POST("/shorten/batch", s.authenticate(false), s.postBatch())
+	s.g.POST("/shorten/import", s.authenticate(false), s.postImport())
+	s.g.GET("/s/:short_code", s.getShorten())
+	s.g.POST("/s/:short_code", s.postUnlock())
+	s.g.GET("/qr/:short_code", s.getQR())
+	s.g.GET("/analytics/:short_code", s.authenticate(false), s.getAnalytics())
+	s.g.GET("/analytics/:short_code/clicks", s.authenticate(true), s.getClicks())
+	s.g.GET("/analytics/:short_code/export", s.authenticate(true), s.exportClicks())
+	s.g.GET("/analytics/:short_code/live", s.getLive())
+
+	// Link management routes
+	s.g.POST("/keys", s.postKey())
+	s.g.GET("/links", s.authenticate(true), s.getLinks())
+	s.g.PATCH("/links/:short_code", s.authenticate(true), s.patchLink())
+	s.g.DELETE("/links/:short_code", s.authenticate(true), s.deleteLink())
+
+	// Webhook routes
+	s.g.POST("/links/:short_code/webhooks", s.authenticate(true), s.postWebhook())
+	s.g.GET("/links/:short_code/webhooks", s.authenticate(true), s.getWebhooks())
+	s.g.DELETE("/webhooks/:id", s.authenticate(true), s.deleteWebhook())
+	s.g.GET("/webhooks/:id/deliveries", s.authenticate(true), s.getDeliveries())
+
+	// Link group routes
+	s.g.POST("/groups", s.authenticate(true), s.postGroup())
+	s.g.GET("/groups", s.authenticate(true), s.getGroups())
+	s.g.GET("/groups/:id", s.authenticate(true), s.getGroup())
+	s.g.DELETE("/groups/:id", s.authenticate(true), s.deleteGroup())
+	s.g.POST("/groups/:id/links", s.authenticate(true), s.postGroupLinks())
+	s.g.DELETE("/groups/:id/links/:short_code", s.authenticate(true), s.deleteGroupLink())
+	s.g.GET("/groups/:id/analytics", s.authenticate(true), s.getGroupAnalytics())
+
+	// Branded domain routes
+	s.g.POST("/domains", s.authenticate(true), s.postDomain())
+	s.g.GET("/domains", s.authenticate(true), s.getDomains())
+	s.g.DELETE("/domains/:domain", s.authenticate(true), s.deleteDomain())
+
+	// Admin routes
+	s.g.POST("/admin/links/:short_code/disable", s.requireAdmin(), s.postDisable())
+	s.g.POST("/admin/links/:short_code/enable", s.requireAdmin(), s.postEnable())
 
 	s.g.GET("/health", func(c *gin.Context) {
 		c.Status(http.StatusOK)
@@ -81,4 +278,7 @@ func (s *Server) RegisterRoutes(ctx context.Context) {
 	// Static UI routes
 	s.g.Static("/ui", "./web")
 	s.g.StaticFile("/", "./web/index.html")
+
+	// short links at the root of branded domains
+	s.g.NoRoute(s.brandedRoute())
 }
@@ -0,0 +1,172 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"html/template"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"shortener/internal/domain"
+	"shortener/internal/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kxddry/wbf/ginext"
+	"github.com/kxddry/wbf/zlog"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// maxUnlockAttempts is how many wrong passwords a client may try within unlockWindow before it is locked out.
+	maxUnlockAttempts = 5
+	unlockWindow      = 15 * time.Minute
+)
+
+var promptPage = template.Must(template.New("prompt").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>Password required</title>
+<style>
+body { font-family: system-ui, sans-serif; background: #f5f5f5; display: flex; justify-content: center; padding-top: 15vh; }
+form { background: #fff; padding: 2rem; border-radius: 8px; box-shadow: 0 1px 4px rgba(0,0,0,.1); width: 20rem; }
+input, button { width: 100%; box-sizing: border-box; padding: .6rem; margin-top: .8rem; font-size: 1rem; }
+.error { color: #b00020; }
+</style>
+</head>
+<body>
+<form method="post">
+<h1>Password required</h1>
+<p>This link is password protected.</p>
+{{if .}}<p class="error">{{.}}</p>{{end}}
+<input type="password" name="password" autocomplete="current-password" required autofocus>
+<button type="submit">Continue</button>
+</form>
+</body>
+</html>
+`))
+
+// prompt renders the password form for a protected link, with an optional error message.
+func (s *Server) prompt(c *ginext.Context, status int, message string) {
+	c.Header("Cache-Control", "no-store")
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(status)
+	if err := promptPage.Execute(c.Writer, message); err != nil {
+		zlog.Logger.Error().Err(err).Msg("failed to render password prompt")
+	}
+}
+
+func (s *Server) postUnlock() func(c *ginext.Context) {
+	return func(c *ginext.Context) {
+		key := domain.LinkKey(s.requestDomain(c), c.Param("short_code"))
+		link, version, cacheable, err := s.lookupLink(c.Request.Context(), key)
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "short code not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if link.Disabled() {
+			c.JSON(http.StatusForbidden, gin.H{"error": "link disabled"})
+			return
+		}
+		if link.Expired(time.Now()) {
+			s.expired(c, key)
+			return
+		}
+		if !link.Protected() {
+			// nothing to unlock; send the browser to the plain redirect
+			c.Redirect(http.StatusSeeOther, c.Request.URL.RequestURI())
+			return
+		}
+
+		// Every attempt counts until it succeeds, so parallel guesses can't all slip in before the first failure is counted
+		client := c.ClientIP()
+		attempts, left, err := s.attempts.CountAttempt(c.Request.Context(), client, unlockWindow)
+		if err != nil {
+			// without the counter there is no brute-force protection, so don't check passwords at all
+			zlog.Logger.Error().Err(err).Msg("failed to count password attempt")
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "try again later"})
+			return
+		}
+		if attempts > maxUnlockAttempts {
+			s.lockedOut(c, left)
+			return
+		}
+		if bcrypt.CompareHashAndPassword([]byte(link.PasswordHash), []byte(c.PostForm("password"))) != nil {
+			s.prompt(c, http.StatusUnauthorized, "Incorrect password.")
+			return
+		}
+		if err := s.attempts.ForgetAttempt(c.Request.Context(), client); err != nil {
+			zlog.Logger.Error().Err(err).Msg("failed to forget password attempt")
+		}
+
+		// 303 turns the POST into a GET of the destination
+		s.follow(c, link, version, cacheable, http.StatusSeeOther)
+	}
+}
+
+// lockedOut answers a client that has run out of password attempts.
+func (s *Server) lockedOut(c *ginext.Context, retryAfter time.Duration) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	s.prompt(c, http.StatusTooManyRequests, "Too many incorrect passwords. Try again later.")
+}
+
+// memoryAttempts counts password attempts in memory. It is the fallback when Redis is not available,
+// so each instance keeps its own counts.
+type memoryAttempts struct {
+	mu      sync.Mutex
+	windows map[string]attemptWindow
+	swept   time.Time
+	now     func() time.Time
+}
+
+type attemptWindow struct {
+	attempts int64
+	ends     time.Time
+}
+
+func newMemoryAttempts() *memoryAttempts {
+	return &memoryAttempts{windows: make(map[string]attemptWindow), now: time.Now}
+}
+
+func (m *memoryAttempts) CountAttempt(ctx context.Context, client string, window time.Duration) (int64, time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	w, ok := m.windows[client]
+	if !ok || !now.Before(w.ends) {
+		// only new windows grow the map, so this is where stale ones are dropped, at most once a minute
+		if now.Sub(m.swept) >= time.Minute {
+			for k, v := range m.windows {
+				if !now.Before(v.ends) {
+					delete(m.windows, k)
+				}
+			}
+			m.swept = now
+		}
+		w = attemptWindow{ends: now.Add(window)}
+	}
+	w.attempts++
+	m.windows[client] = w
+	return w.attempts, w.ends.Sub(now), nil
+}
+
+func (m *memoryAttempts) ForgetAttempt(ctx context.Context, client string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if w, ok := m.windows[client]; ok && w.attempts > 0 {
+		w.attempts--
+		m.windows[client] = w
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"errors"
+	"hash/fnv"
+	"regexp"
+	"shortener/internal/domain"
+
+	"github.com/kxddry/wbf/ginext"
+)
+
+// variantCookieMaxAge is how long, in seconds, a visitor stays on the variant of an A/B link they were given.
+const variantCookieMaxAge = 30 * 24 * 60 * 60
+
+// nameRe is what the names of variants and rules look like.
+var nameRe = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,32}$`)
+
+// validateVariants checks what the struct tags can't: variant names are well-formed and unique.
+func validateVariants(variants []domain.Variant) error {
+	seen := make(map[string]bool, len(variants))
+	for _, v := range variants {
+		if !nameRe.MatchString(v.Name) {
+			return errors.New("invalid variant name " + v.Name)
+		}
+		if seen[v.Name] {
+			return errors.New("duplicate variant name " + v.Name)
+		}
+		seen[v.Name] = true
+	}
+	return nil
+}
+
+// variantCookie is the name of the cookie that keeps a visitor on one variant of the link.
+func variantCookie(shortCode string) string {
+	return "ab_" + shortCode
+}
+
+// pickVariant returns the variant of a multi-destination link for this visitor. A visitor with a cookie naming
+// a current variant gets that one. Anyone else is placed by weight from a hash of their IP and User-Agent,
+// so clients that drop cookies stay on one variant too, and is given the cookie.
+func (s *Server) pickVariant(c *ginext.Context, link domain.ShortenedURL) domain.Variant {
+	name, err := c.Cookie(variantCookie(link.ShortCode))
+	if err == nil {
+		for _, v := range link.Variants {
+			if v.Name == name {
+				return v
+			}
+		}
+	}
+
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(link.Key() + "\x00" + c.ClientIP() + "\x00" + c.GetHeader("User-Agent")))
+	v := weightedVariant(link.Variants, h.Sum64())
+	// the cookie is scoped to the host, and to the path the link was reached by: /s/code or a branded /code
+	c.SetCookie(variantCookie(link.ShortCode), v.Name, variantCookieMaxAge, c.Request.URL.Path, "", c.Request.TLS != nil, true)
+	return v
+}
+
+// weightedVariant maps a hash onto the variants in proportion to their weights.
+func weightedVariant(variants []domain.Variant, h uint64) domain.Variant {
+	total := 0
+	for _, v := range variants {
+		total += v.Weight
+	}
+	n := int(h % uint64(total))
+	for _, v := range variants {
+		if n < v.Weight {
+			return v
+		}
+		n -= v.Weight
+	}
+	return variants[len(variants)-1]
+}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"shortener/internal/domain"
+	"shortener/internal/netguard"
+	"shortener/internal/storage"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/kxddry/wbf/ginext"
+)
+
+// maxDeliveriesPage is the most deliveries GET /webhooks/:id/deliveries returns.
+const maxDeliveriesPage = 200
+
+func (s *Server) postWebhook() func(c *ginext.Context) {
+	return func(c *ginext.Context) {
+		var req domain.CreateWebhookRequest
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := s.validator.Struct(req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		// the callbacks are sent from inside the service's network, so they must not be aimed back into it
+		if err := netguard.CheckURL(req.URL); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid webhook url: " + err.Error()})
+			return
+		}
+
+		host, shortCode := domain.SplitLinkKey(linkKey(c))
+		w, err := s.urlStorage.CreateWebhook(c.Request.Context(), domain.Webhook{
+			ShortCode:     shortCode,
+			Domain:        host,
+			OwnerID:       c.GetString(ownerKey),
+			URL:           req.URL,
+			Event:         req.Event,
+			Threshold:     req.Threshold,
+			WindowSeconds: req.WindowSeconds,
+			Factor:        req.Factor,
+		})
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "short code not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, w)
+	}
+}
+
+func (s *Server) getWebhooks() func(c *ginext.Context) {
+	return func(c *ginext.Context) {
+		webhooks, err := s.urlStorage.ListWebhooks(c.Request.Context(), c.GetString(ownerKey), linkKey(c))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, webhooks)
+	}
+}
+
+func (s *Server) deleteWebhook() func(c *ginext.Context) {
+	return func(c *ginext.Context) {
+		id := c.Param("id")
+		if _, err := uuid.Parse(id); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "webhook not found"})
+			return
+		}
+		if err := s.urlStorage.DeleteWebhook(c.Request.Context(), c.GetString(ownerKey), id); err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "webhook not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// getDeliveries serves the delivery log of a webhook, newest first.
+func (s *Server) getDeliveries() func(c *ginext.Context) {
+	return func(c *ginext.Context) {
+		id := c.Param("id")
+		if _, err := uuid.Parse(id); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "webhook not found"})
+			return
+		}
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+		if err != nil || limit < 1 || limit > maxDeliveriesPage {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid 'limit'; expected 1 to " + strconv.Itoa(maxDeliveriesPage)})
+			return
+		}
+
+		deliveries, err := s.urlStorage.WebhookDeliveries(c.Request.Context(), c.GetString(ownerKey), id, limit)
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "webhook not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, deliveries)
+	}
+}
@@ -0,0 +1,214 @@
+// Package card fetches the Open Graph metadata of link destinations, for the cards preview bots are shown.
+package card
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"shortener/internal/domain"
+	"shortener/internal/netguard"
+	"shortener/internal/storage"
+
+	"github.com/kxddry/wbf/zlog"
+	"golang.org/x/net/html"
+)
+
+// Defaults for the cache.
+const (
+	DefaultTimeout = 5 * time.Second
+	DefaultTTL     = 24 * time.Hour
+)
+
+// maxPage is how much of a page is read looking for its metadata, which sits in the head.
+const maxPage = 1 << 20
+
+// Store keeps the fetched metadata.
+type Store interface {
+	// DestinationCard returns the metadata fetched from url and when it was fetched, or storage.ErrNotFound.
+	DestinationCard(ctx context.Context, url string) (domain.Card, time.Time, error)
+	// SaveDestinationCard stores the metadata fetched from url at fetchedAt.
+	SaveDestinationCard(ctx context.Context, url string, card domain.Card, fetchedAt time.Time) error
+}
+
+// Cache returns the metadata of destinations, fetching each one at most once per TTL. A failed fetch is kept
+// as an empty card, so a destination that is down is not asked again on every preview.
+type Cache struct {
+	store  Store
+	client *http.Client
+	ttl    time.Duration
+	now    func() time.Time
+}
+
+// NewCache creates a new Cache. Non-positive durations fall back to the defaults.
+func NewCache(store Store, timeout, ttl time.Duration) *Cache {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	return &Cache{store: store, client: newClient(timeout, netguard.PublicOnly), ttl: ttl, now: time.Now}
+}
+
+// newClient returns the client fetching pages. control vets every address it connects to, redirects included.
+func newClient(timeout time.Duration, control func(network, address string, c syscall.RawConn) error) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout, Control: control}
+	transport := &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: timeout, ResponseHeaderTimeout: timeout}
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+// Card returns the metadata of the destination, from the store if it was fetched within the TTL.
+func (c *Cache) Card(ctx context.Context, destination string) (domain.Card, error) {
+	card, fetchedAt, err := c.store.DestinationCard(ctx, destination)
+	if err == nil && c.now().Sub(fetchedAt) < c.ttl {
+		return card, nil
+	}
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return domain.Card{}, err
+	}
+
+	card, err = c.fetch(ctx, destination)
+	if err != nil {
+		zlog.Logger.Debug().Err(err).Str("url", destination).Msg("failed to fetch destination card")
+		card = domain.Card{}
+	}
+	if err := c.store.SaveDestinationCard(ctx, destination, card, c.now()); err != nil {
+		zlog.Logger.Error().Err(err).Str("url", destination).Msg("failed to save destination card")
+	}
+	return card, nil
+}
+
+// fetch reads the metadata from the head of the page at destination.
+func (c *Cache) fetch(ctx context.Context, destination string) (domain.Card, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, destination, nil)
+	if err != nil {
+		return domain.Card{}, err
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; shortener-cards/1.0)")
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return domain.Card{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return domain.Card{}, fmt.Errorf("unexpected response status %d", resp.StatusCode)
+	}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return domain.Card{}, fmt.Errorf("unexpected content type %q", mediaType)
+	}
+	// relative images are relative to where the redirects ended
+	return Parse(io.LimitReader(resp.Body, maxPage), resp.Request.URL), nil
+}
+
+// Parse reads the card from the head of an HTML page at base. Open Graph tags win over Twitter ones, which
+// win over the title and description of the page itself.
+func Parse(page io.Reader, base *url.URL) domain.Card {
+	var og, fallback domain.Card
+	z := html.NewTokenizer(page)
+	inTitle := false
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return finish(og.Or(fallback), base)
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			switch string(name) {
+			case "meta":
+				if hasAttr {
+					readMeta(z, &og, &fallback)
+				}
+			case "title":
+				inTitle = true
+			case "body":
+				return finish(og.Or(fallback), base)
+			}
+		case html.TextToken:
+			if inTitle && fallback.Title == "" {
+				fallback.Title = strings.TrimSpace(string(z.Text()))
+			}
+		case html.EndTagToken:
+			switch name, _ := z.TagName(); string(name) {
+			case "title":
+				inTitle = false
+			case "head":
+				return finish(og.Or(fallback), base)
+			}
+		}
+	}
+}
+
+// readMeta puts what a meta tag says into the card it belongs to.
+func readMeta(z *html.Tokenizer, og, fallback *domain.Card) {
+	var key, content string
+	for {
+		name, value, more := z.TagAttr()
+		switch string(name) {
+		case "property", "name":
+			key = strings.ToLower(string(value))
+		case "content":
+			content = strings.TrimSpace(string(value))
+		}
+		if !more {
+			break
+		}
+	}
+	if content == "" {
+		return
+	}
+
+	set := func(field *string) {
+		if *field == "" {
+			*field = content
+		}
+	}
+	switch key {
+	case "og:title":
+		set(&og.Title)
+	case "og:description":
+		set(&og.Description)
+	case "og:image", "og:image:url", "og:image:secure_url":
+		set(&og.Image)
+	case "twitter:title":
+		set(&fallback.Title)
+	case "twitter:description", "description":
+		set(&fallback.Description)
+	case "twitter:image", "twitter:image:src":
+		set(&fallback.Image)
+	}
+}
+
+// finish resolves the image against base, drops one that isn't http(s) and cuts the text to the lengths
+// owners are held to.
+func finish(card domain.Card, base *url.URL) domain.Card {
+	card.Title = truncate(card.Title, 300)
+	card.Description = truncate(card.Description, 1000)
+	if card.Image != "" {
+		image, err := base.Parse(card.Image)
+		if err != nil || image.Scheme != "http" && image.Scheme != "https" {
+			card.Image = ""
+		} else {
+			card.Image = image.String()
+		}
+	}
+	return card
+}
+
+// truncate cuts s to at most n runes.
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
@@ -0,0 +1,126 @@
+package card
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"shortener/internal/domain"
+	"shortener/internal/storage"
+)
+
+type stored struct {
+	card      domain.Card
+	fetchedAt time.Time
+}
+
+type mockStore struct {
+	cards map[string]stored
+}
+
+func (m *mockStore) DestinationCard(ctx context.Context, url string) (domain.Card, time.Time, error) {
+	s, ok := m.cards[url]
+	if !ok {
+		return domain.Card{}, time.Time{}, storage.ErrNotFound
+	}
+	return s.card, s.fetchedAt, nil
+}
+
+func (m *mockStore) SaveDestinationCard(ctx context.Context, url string, card domain.Card, fetchedAt time.Time) error {
+	m.cards[url] = stored{card: card, fetchedAt: fetchedAt}
+	return nil
+}
+
+func TestParse(t *testing.T) {
+	base, _ := url.Parse("https://example.com/blog/post")
+	tests := []struct {
+		name string
+		page string
+		want domain.Card
+	}{
+		{
+			name: "open graph wins",
+			page: `<html><head><title>Page</title>
+				<meta name="twitter:title" content="Twitter title">
+				<meta property="og:title" content="OG title">
+				<meta name="description" content="Plain description">
+				<meta property="og:image" content="/img/cover.png">
+				</head><body><meta property="og:description" content="too late"></body></html>`,
+			want: domain.Card{Title: "OG title", Description: "Plain description", Image: "https://example.com/img/cover.png"},
+		},
+		{
+			name: "twitter and title",
+			page: `<head><title> Page title </title><meta name="twitter:image" content="cover.png"><meta name="twitter:description" content="About"></head>`,
+			want: domain.Card{Title: "Page title", Description: "About", Image: "https://example.com/blog/cover.png"},
+		},
+		{
+			name: "unsafe image",
+			page: `<head><meta property="og:title" content="T"><meta property="og:image" content="javascript:alert(1)"></head>`,
+			want: domain.Card{Title: "T"},
+		},
+		{
+			name: "nothing",
+			page: `not html at all`,
+			want: domain.Card{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Parse(strings.NewReader(tt.page), base); got != tt.want {
+				t.Fatalf("expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestCache(t *testing.T) {
+	var fetches atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		switch r.URL.Path {
+		case "/page":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			_, _ = w.Write([]byte(`<head><meta property="og:title" content="Hello"></head>`))
+		case "/file":
+			w.Header().Set("Content-Type", "application/pdf")
+			_, _ = w.Write([]byte("%PDF"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	store := &mockStore{cards: map[string]stored{}}
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	c := &Cache{store: store, client: newClient(time.Second, nil), ttl: time.Hour, now: func() time.Time { return now }}
+
+	card, err := c.Card(context.Background(), srv.URL+"/page")
+	if err != nil || card.Title != "Hello" {
+		t.Fatalf("expected the fetched card, got %+v, %v", card, err)
+	}
+	if _, err := c.Card(context.Background(), srv.URL+"/page"); err != nil || fetches.Load() != 1 {
+		t.Fatalf("expected the card to come from the store, got %d fetches, %v", fetches.Load(), err)
+	}
+	now = now.Add(time.Hour)
+	if _, err := c.Card(context.Background(), srv.URL+"/page"); err != nil || fetches.Load() != 2 {
+		t.Fatalf("expected a stale card to be fetched again, got %d fetches, %v", fetches.Load(), err)
+	}
+
+	// failures are remembered as empty cards
+	for _, path := range []string{"/file", "/missing"} {
+		for range 2 {
+			card, err := c.Card(context.Background(), srv.URL+path)
+			if err != nil || !card.Empty() {
+				t.Fatalf("expected an empty card for %s, got %+v, %v", path, card, err)
+			}
+		}
+	}
+	if fetches.Load() != 4 {
+		t.Fatalf("expected each failing destination to be fetched once, got %d fetches", fetches.Load())
+	}
+}
@@ -1,44 +1,474 @@
 package domain
 
-import "time"
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
 
 // ShortenedURL is the struct for the shortened URL.
 type ShortenedURL struct {
-	ID        string    `json:"id"`
-	URL       string    `json:"url"`
-	ShortCode string    `json:"short_code"`
-	CreatedAt time.Time `json:"created_at"`
+	ID         string     `json:"id"`
+	URL        string     `json:"url"`
+	ShortCode  string     `json:"short_code"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	MaxClicks  *int64     `json:"max_clicks,omitempty"`
+	ClicksUsed int64      `json:"clicks_used"`
+	OwnerID    string     `json:"owner_id,omitempty"`
+	// Domain is the branded domain the short code lives on, or empty for the default domain.
+	Domain string `json:"domain,omitempty"`
+	// PasswordHash is the bcrypt hash of the link's password, or empty if the link is not protected.
+	PasswordHash string `json:"-"`
+	// Variants are the weighted destinations of an A/B test. URL is the first of them.
+	Variants []Variant `json:"variants,omitempty"`
+	// Rules send the visitors they match elsewhere, ahead of URL and Variants. The first matching rule wins.
+	Rules []Rule `json:"rules,omitempty"`
+	// DisabledAt is when an administrator disabled the link, or nil if it is live.
+	DisabledAt     *time.Time `json:"disabled_at,omitempty"`
+	DisabledReason string     `json:"disabled_reason,omitempty"`
+	// RedirectType is the status the link redirects with: 301, 302, 307 or 308. Zero means DefaultRedirectType.
+	RedirectType int `json:"redirect_type,omitempty"`
+	// Card is what preview bots are shown instead of the redirect. Fields left empty are filled in from the
+	// destination.
+	Card *Card `json:"card,omitempty"`
+}
+
+// DefaultRedirectType is the status links redirect with unless they say otherwise.
+const DefaultRedirectType = 307
+
+// Card is the struct for the Open Graph card of a link.
+type Card struct {
+	Title       string `json:"title,omitempty" validate:"max=300"`
+	Description string `json:"description,omitempty" validate:"max=1000"`
+	Image       string `json:"image,omitempty" validate:"omitempty,url,startswith=http"`
+}
+
+// Empty reports whether the card sets nothing.
+func (c Card) Empty() bool {
+	return c == Card{}
+}
+
+// Or returns the card with its empty fields taken from fallback.
+func (c Card) Or(fallback Card) Card {
+	if c.Title == "" {
+		c.Title = fallback.Title
+	}
+	if c.Description == "" {
+		c.Description = fallback.Description
+	}
+	if c.Image == "" {
+		c.Image = fallback.Image
+	}
+	return c
+}
+
+// Variant is the struct for one weighted destination of a multi-destination link.
+type Variant struct {
+	Name   string `json:"name" validate:"required"`
+	URL    string `json:"url" validate:"required,url"`
+	Weight int    `json:"weight" validate:"gt=0,lte=1000"`
+}
+
+// Rule is the struct for a targeting rule of a link. A rule matches a visitor when every condition it sets holds,
+// and a condition holds when any of its values does.
+type Rule struct {
+	Name string `json:"name" validate:"required"`
+	URL  string `json:"url" validate:"required,url"`
+	// Devices are device classes: ios and android by operating system, desktop, mobile and tablet by form factor.
+	Devices []string `json:"devices,omitempty" validate:"omitempty,dive,oneof=ios android desktop mobile tablet"`
+	// Countries are ISO 3166-1 codes, as resolved by GeoIP.
+	Countries []string `json:"countries,omitempty" validate:"omitempty,dive,len=2,alpha"`
+	// Languages are language tags; "de" matches any German, "pt-BR" only Brazilian Portuguese.
+	Languages []string   `json:"languages,omitempty"`
+	StartsAt  *time.Time `json:"starts_at,omitempty"`
+	EndsAt    *time.Time `json:"ends_at,omitempty"`
+}
+
+// Matches reports whether a visitor with the client and location, preferring language, matches the rule at now.
+func (r Rule) Matches(client Client, loc Location, language string, now time.Time) bool {
+	if r.StartsAt != nil && now.Before(*r.StartsAt) || r.EndsAt != nil && !now.Before(*r.EndsAt) {
+		return false
+	}
+	return anyOf(r.Devices, func(d string) bool { return client.Is(d) }) &&
+		anyOf(r.Countries, func(c string) bool { return strings.EqualFold(c, loc.Country) }) &&
+		anyOf(r.Languages, func(l string) bool { return languageMatches(l, language) })
+}
+
+// languageMatches reports whether the language falls under the tag: it is the tag itself or one of its subtags.
+func languageMatches(tag, language string) bool {
+	if len(language) > len(tag) && language[len(tag)] == '-' {
+		language = language[:len(tag)]
+	}
+	return strings.EqualFold(tag, language)
+}
+
+// anyOf reports whether match holds for any of the values; no values means no condition.
+func anyOf(values []string, match func(string) bool) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if match(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// RedirectStatus returns the status the link redirects with.
+func (u ShortenedURL) RedirectStatus() int {
+	if u.RedirectType == 0 {
+		return DefaultRedirectType
+	}
+	return u.RedirectType
+}
+
+// Key returns the key the link is stored, cached and counted under.
+func (u ShortenedURL) Key() string {
+	return LinkKey(u.Domain, u.ShortCode)
+}
+
+// LinkKey returns the key of the link with the short code on host: the short code itself on the default
+// domain, where host is empty, and host/code on a branded one. Short codes never contain a slash, so keys
+// are unambiguous, and links made before branded domains keep their keys.
+func LinkKey(host, shortCode string) string {
+	if host == "" {
+		return shortCode
+	}
+	return host + "/" + shortCode
+}
+
+// SplitLinkKey returns the host and short code of a link key.
+func SplitLinkKey(key string) (host, shortCode string) {
+	if host, shortCode, ok := strings.Cut(key, "/"); ok {
+		return host, shortCode
+	}
+	return "", key
+}
+
+// Protected reports whether the link asks for a password before redirecting.
+func (u ShortenedURL) Protected() bool {
+	return u.PasswordHash != ""
+}
+
+// Disabled reports whether an administrator has taken the link down.
+func (u ShortenedURL) Disabled() bool {
+	return u.DisabledAt != nil
 }
 
-// Click is the struct for the click.
+// Expired reports whether the link has passed its expiration date or used up its click budget.
+func (u ShortenedURL) Expired(now time.Time) bool {
+	if u.ExpiresAt != nil && !now.Before(*u.ExpiresAt) {
+		return true
+	}
+	return u.MaxClicks != nil && u.ClicksUsed >= *u.MaxClicks
+}
+
+// Click is the struct for the click. Its ShortCode is the key of the link, see LinkKey.
 type Click struct {
 	ID        string    `json:"id"`
 	ShortCode string    `json:"short_code"`
 	UserAgent string    `json:"user_agent"`
 	IP        string    `json:"ip"`
 	Referer   string    `json:"referer"`
+	Source    string    `json:"source,omitempty"`
+	Variant   string    `json:"variant,omitempty"`
+	Rule      string    `json:"rule,omitempty"`
 	Timestamp time.Time `json:"timestamp"`
+	Client
+	Location
+}
+
+// ClickCursor is the position of a click in the newest-first order of a link's clicks.
+type ClickCursor struct {
+	Timestamp time.Time
+	ID        string
+}
+
+// ClicksPage is the struct for a page of a link's clicks, newest first.
+// NextCursor is empty on the last page.
+type ClicksPage struct {
+	Clicks     []Click `json:"clicks"`
+	NextCursor string  `json:"next_cursor,omitempty"`
+}
+
+// Client is the struct for what the User-Agent of a click says about the client.
+type Client struct {
+	Browser        string `json:"browser"`
+	BrowserVersion string `json:"browser_version"`
+	OS             string `json:"os"`
+	Device         string `json:"device"`
+	IsBot          bool   `json:"is_bot"`
+}
+
+// Is reports whether the client belongs to a device class of Rule.Devices.
+func (c Client) Is(class string) bool {
+	switch class {
+	case "ios":
+		return c.OS == "iOS"
+	case "android":
+		return c.OS == "Android"
+	}
+	return c.Device == class
+}
+
+// Location is the struct for where the IP of a click is, as far as the GeoIP database knows.
+type Location struct {
+	Country string `json:"country"`
+	Region  string `json:"region"`
+	City    string `json:"city"`
+}
+
+// ClickSourceQR marks clicks that came from scanning a QR code.
+const ClickSourceQR = "qr"
+
+// ClickSource returns the recognised click source for a "source" query value, or "" for anything else.
+func ClickSource(v string) string {
+	if v == ClickSourceQR {
+		return v
+	}
+	return ""
 }
 
 // ShortenRequest is the struct for the shorten request.
 type ShortenRequest struct {
-	URL   string `json:"url" validate:"required,url"`
-	Alias string `json:"alias,omitempty"`
+	URL       string     `json:"url" validate:"required,url"`
+	Alias     string     `json:"alias,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	MaxClicks *int64     `json:"max_clicks,omitempty" validate:"omitempty,gt=0"`
+	// Password protects the link; bcrypt only looks at the first 72 bytes, so longer ones are refused.
+	Password string `json:"password,omitempty" validate:"omitempty,min=4,max=72"`
+	// SingleUse is shorthand for a click budget of one.
+	SingleUse bool `json:"single_use,omitempty"`
+	// Variants turn the link into an A/B test; URL may then be left out.
+	Variants []Variant `json:"variants,omitempty" validate:"omitempty,min=2,max=10,dive"`
+	Rules    []Rule    `json:"rules,omitempty" validate:"omitempty,max=20,dive"`
+	// Domain puts the link on a branded domain of the caller instead of the default one.
+	Domain       string `json:"domain,omitempty" validate:"omitempty,fqdn"`
+	RedirectType int    `json:"redirect_type,omitempty" validate:"omitempty,oneof=301 302 307 308"`
+	Card         *Card  `json:"card,omitempty" validate:"omitempty"`
+}
+
+// UpdateLinkRequest is the struct for the link update request. Only what it sets is changed; an empty card
+// clears the owner's one.
+type UpdateLinkRequest struct {
+	URL          string `json:"url,omitempty" validate:"omitempty,url"`
+	RedirectType *int   `json:"redirect_type,omitempty" validate:"omitempty,oneof=301 302 307 308"`
+	Card         *Card  `json:"card,omitempty" validate:"omitempty"`
+}
+
+// DisableLinkRequest is the struct for the admin request to disable a link.
+type DisableLinkRequest struct {
+	Reason string `json:"reason" validate:"max=500"`
+}
+
+// BatchResult is the struct for the outcome of one row of a bulk shorten request. Rows are numbered from 1,
+// not counting a CSV header.
+type BatchResult struct {
+	Row       int    `json:"row"`
+	URL       string `json:"url,omitempty"`
+	ShortCode string `json:"short_code,omitempty"`
+	// Duplicate is set when the row repeats an earlier one and was given its short code.
+	Duplicate bool   `json:"duplicate,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// LinksPage is the struct for a page of the owner's links.
+type LinksPage struct {
+	Links  []ShortenedURL `json:"links"`
+	Total  int64          `json:"total"`
+	Limit  int            `json:"limit"`
+	Offset int            `json:"offset"`
+}
+
+// Domain is the struct for a branded domain. Short codes are unique per domain, so the same code can lead
+// to different links on different domains.
+type Domain struct {
+	Domain    string    `json:"domain"`
+	OwnerID   string    `json:"owner_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CreateDomainRequest is the struct for the domain registration request.
+type CreateDomainRequest struct {
+	Domain string `json:"domain" validate:"required,fqdn"`
+}
+
+// Webhook events.
+const (
+	// WebhookThreshold fires once, when the link's human clicks reach the threshold.
+	WebhookThreshold = "threshold"
+	// WebhookSpike fires when the human clicks in the last window reach the threshold and are at least factor
+	// times what the link usually gets in a window, judged by the day before. It fires at most once per window.
+	WebhookSpike = "spike"
+)
+
+// Webhook is the struct for a link owner's subscription to a click event of one of their links.
+type Webhook struct {
+	ID        string `json:"id"`
+	ShortCode string `json:"short_code"`
+	Domain    string `json:"domain,omitempty"`
+	OwnerID   string `json:"-"`
+	URL       string `json:"url"`
+	// Secret signs the callbacks. It is only ever returned when the webhook is created.
+	Secret        string     `json:"secret,omitempty"`
+	Event         string     `json:"event"`
+	Threshold     int64      `json:"threshold"`
+	WindowSeconds int        `json:"window_seconds,omitempty"`
+	Factor        float64    `json:"factor,omitempty"`
+	FiredAt       *time.Time `json:"fired_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+// CreateWebhookRequest is the struct for the webhook subscription request.
+type CreateWebhookRequest struct {
+	URL       string `json:"url" validate:"required,url,startswith=http"`
+	Event     string `json:"event" validate:"required,oneof=threshold spike"`
+	Threshold int64  `json:"threshold" validate:"required,gt=0"`
+	// WindowSeconds and Factor only apply to spike webhooks; they default to 5 minutes and 3.
+	WindowSeconds int     `json:"window_seconds,omitempty" validate:"omitempty,min=60,max=86400"`
+	Factor        float64 `json:"factor,omitempty" validate:"omitempty,gte=1,lte=1000"`
+}
+
+// Webhook delivery statuses.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// WebhookDelivery is the struct for one callback of a webhook and the outcome of its latest attempt.
+type WebhookDelivery struct {
+	ID        string `json:"id"`
+	WebhookID string `json:"webhook_id"`
+	Event     string `json:"event"`
+	// Payload is the JSON body sent, the same on every attempt.
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	ResponseStatus int             `json:"response_status,omitempty"`
+	Error          string          `json:"error,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at,omitempty"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+	// URL and Secret are the webhook's, filled in for sending.
+	URL    string `json:"-"`
+	Secret string `json:"-"`
+}
+
+// APIKey is the struct for a newly issued API key. The key itself is only ever returned once.
+type APIKey struct {
+	OwnerID string `json:"owner_id"`
+	Key     string `json:"api_key"`
 }
 
 // AnalyticsResponse is the struct for the analytics response.
 type AnalyticsResponse struct {
-	ShortCode     string           `json:"short_code"`
-	From          *time.Time       `json:"from,omitempty"`
-	To            *time.Time       `json:"to,omitempty"`
-	TotalClicks   int64            `json:"total_clicks"`
-	UniqueClicks  int64            `json:"unique_clicks"`
-	ClicksByDay   map[string]int64 `json:"clicks_by_day,omitempty"`
-	ClicksByMonth map[string]int64 `json:"clicks_by_month,omitempty"`
-	TopUserAgents map[string]int64 `json:"top_user_agents,omitempty"`
-	TopReferers   map[string]int64 `json:"top_referers,omitempty"`
-	TopIPs        map[string]int64 `json:"top_ips,omitempty"`
-}
-
-// MinUsageForCache is the minimum number of clicks required to cache a URL.
-const MinUsageForCache = 10
\ No newline at end of file
+	ShortCode       string           `json:"short_code,omitempty"`
+	From            *time.Time       `json:"from,omitempty"`
+	To              *time.Time       `json:"to,omitempty"`
+	TotalClicks     int64            `json:"total_clicks"`
+	UniqueClicks    int64            `json:"unique_clicks"`
+	BotClicks       int64            `json:"bot_clicks"`
+	ClicksByDay     map[string]int64 `json:"clicks_by_day,omitempty"`
+	ClicksByMonth   map[string]int64 `json:"clicks_by_month,omitempty"`
+	TopUserAgents   map[string]int64 `json:"top_user_agents,omitempty"`
+	TopReferers     map[string]int64 `json:"top_referers,omitempty"`
+	TopIPs          map[string]int64 `json:"top_ips,omitempty"`
+	ClicksBySource  map[string]int64 `json:"clicks_by_source,omitempty"`
+	ClicksByBrowser map[string]int64 `json:"clicks_by_browser,omitempty"`
+	ClicksByOS      map[string]int64 `json:"clicks_by_os,omitempty"`
+	ClicksByDevice  map[string]int64 `json:"clicks_by_device,omitempty"`
+	ClicksByCountry map[string]int64 `json:"clicks_by_country,omitempty"`
+	ClicksByCity    map[string]int64 `json:"clicks_by_city,omitempty"`
+	ClicksByRule    map[string]int64 `json:"clicks_by_rule,omitempty"`
+	// Variants are the clicks and unique visitors per variant of a multi-destination link.
+	Variants map[string]VariantStats `json:"variants,omitempty"`
+	// Granularity and Timezone are how Current and Previous are bucketed; they are only set when a
+	// granularity was asked for.
+	Granularity string `json:"granularity,omitempty"`
+	Timezone    string `json:"timezone,omitempty"`
+	// Current is the clicks of the range, and Previous those of the period of the same length right before it.
+	Current  *Period `json:"current,omitempty"`
+	Previous *Period `json:"previous,omitempty"`
+	// Change is the percentage change in clicks from Previous to Current, or nil if Previous had none.
+	Change *float64 `json:"change,omitempty"`
+}
+
+// Analytics granularities, the sizes of the buckets of a series.
+const (
+	GranularityHour  = "hour"
+	GranularityDay   = "day"
+	GranularityWeek  = "week"
+	GranularityMonth = "month"
+)
+
+// Period is the struct for the clicks of a time range, in total and per bucket.
+type Period struct {
+	From   time.Time `json:"from"`
+	To     time.Time `json:"to"`
+	Clicks int64     `json:"clicks"`
+	// Series has a point for every bucket the range overlaps, oldest first, empty ones included.
+	Series []SeriesPoint `json:"series"`
+}
+
+// SeriesPoint is the struct for the clicks of one bucket of a series. Start is in the series' timezone.
+type SeriesPoint struct {
+	Start  time.Time `json:"start"`
+	Clicks int64     `json:"clicks"`
+}
+
+// VariantStats is the struct for the clicks of one variant of a multi-destination link.
+type VariantStats struct {
+	Clicks       int64 `json:"clicks"`
+	UniqueClicks int64 `json:"unique_clicks"`
+}
+
+// LiveClicks is the struct for the clicks a link got in the last few minutes.
+type LiveClicks struct {
+	ShortCode     string `json:"short_code"`
+	Clicks        int64  `json:"clicks"`
+	WindowSeconds int    `json:"window_seconds"`
+}
+
+// LinkGroup is the struct for a named group of an owner's links, such as the links of a campaign.
+type LinkGroup struct {
+	ID      string `json:"id"`
+	OwnerID string `json:"-"`
+	Name    string `json:"name"`
+	// Links are the keys of the links in the group, in the order they were added.
+	Links     []string  `json:"links"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CreateGroupRequest is the struct for the link group creation request. Links are short codes, or
+// domain/short_code for links on a branded domain.
+type CreateGroupRequest struct {
+	Name  string   `json:"name" validate:"required,max=100"`
+	Links []string `json:"links" validate:"max=1000,dive,required"`
+}
+
+// GroupLinksRequest is the struct for the request adding links to a group.
+type GroupLinksRequest struct {
+	Links []string `json:"links" validate:"required,min=1,max=1000,dive,required"`
+}
+
+// GroupAnalyticsResponse is the struct for the analytics of a link group: those of all its links
+// together, and each link's share of the clicks.
+type GroupAnalyticsResponse struct {
+	GroupID string `json:"group_id"`
+	Name    string `json:"name"`
+	AnalyticsResponse
+	// Leaderboard has every link in the group, most clicked in the range first.
+	Leaderboard []LinkStats `json:"leaderboard"`
+}
+
+// LinkStats is the struct for the clicks of one link of a group in the range of its analytics.
+type LinkStats struct {
+	ShortCode    string `json:"short_code"`
+	Domain       string `json:"domain,omitempty"`
+	URL          string `json:"url"`
+	Clicks       int64  `json:"clicks"`
+	UniqueClicks int64  `json:"unique_clicks"`
+}
@@ -0,0 +1,137 @@
+// Package geoip resolves click IPs to countries, regions and cities with an offline MaxMind-format database.
+package geoip
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"shortener/internal/domain"
+
+	"github.com/kxddry/wbf/zlog"
+	"github.com/oschwald/geoip2-golang"
+)
+
+// DefaultReloadInterval is how often Watch checks the database file for changes.
+const DefaultReloadInterval = time.Minute
+
+// Resolver looks up the location of IPs in a GeoIP2 or GeoLite2 City database.
+// The database is read into memory rather than mapped, so a reload can swap it while lookups are running.
+type Resolver struct {
+	path string
+	db   atomic.Pointer[geoip2.Reader]
+
+	mu      sync.Mutex // serialises reloads
+	modTime time.Time
+	size    int64
+}
+
+// Open loads the database at path.
+func Open(path string) (*Resolver, error) {
+	r := &Resolver{path: path}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Lookup returns the location of ip. Unknown, private and malformed IPs have an empty location.
+func (r *Resolver) Lookup(ip string) domain.Location {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return domain.Location{}
+	}
+	city, err := r.db.Load().City(parsed)
+	if err != nil {
+		return domain.Location{}
+	}
+
+	loc := domain.Location{
+		Country: city.Country.IsoCode,
+		City:    city.City.Names["en"],
+	}
+	if len(city.Subdivisions) > 0 {
+		loc.Region = city.Subdivisions[0].Names["en"]
+		if loc.Region == "" {
+			loc.Region = city.Subdivisions[0].IsoCode
+		}
+	}
+	return loc
+}
+
+// Reload reads the database file again and swaps it in. On error the current database stays in use.
+func (r *Resolver) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return fmt.Errorf("geoip: %w", err)
+	}
+	return r.load(info)
+}
+
+// Watch reloads the database whenever the file changes, checking every interval until ctx is done.
+// Database updaters replace the file atomically, so a change is seen as a new modification time or size.
+func (r *Resolver) Watch(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultReloadInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		reloaded, err := r.reloadIfChanged()
+		if err != nil {
+			zlog.Logger.Error().Err(err).Str("path", r.path).Msg("failed to reload GeoIP database")
+			continue
+		}
+		if reloaded {
+			zlog.Logger.Info().Str("path", r.path).Msg("GeoIP database reloaded")
+		}
+	}
+}
+
+// reloadIfChanged reloads the database if the file differs from the one loaded last.
+func (r *Resolver) reloadIfChanged() (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return false, fmt.Errorf("geoip: %w", err)
+	}
+	if info.ModTime().Equal(r.modTime) && info.Size() == r.size {
+		return false, nil
+	}
+	return true, r.load(info)
+}
+
+// load reads the database described by info. The caller holds mu.
+func (r *Resolver) load(info os.FileInfo) error {
+	raw, err := os.ReadFile(r.path)
+	if err != nil {
+		return fmt.Errorf("geoip: %w", err)
+	}
+	db, err := geoip2.FromBytes(raw)
+	if err != nil {
+		return fmt.Errorf("geoip: %s: %w", r.path, err)
+	}
+	// City lookups fail on every IP against a Country or ASN database; refuse it instead of resolving nothing
+	if _, err := db.City(net.IPv4zero); err != nil {
+		return fmt.Errorf("geoip: %s: %w", r.path, err)
+	}
+
+	r.db.Store(db)
+	r.modTime, r.size = info.ModTime(), info.Size()
+	return nil
+}
@@ -0,0 +1,143 @@
+package geoip
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"shortener/internal/domain"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+// fixtureCity is one network of a generated fixture database.
+type fixtureCity struct {
+	network string
+	country string
+	region  string
+	city    string
+}
+
+// writeFixture writes a tiny GeoLite2-City style database with the given networks to path.
+func writeFixture(t *testing.T, path, dbType string, cities ...fixtureCity) {
+	t.Helper()
+	tree, err := mmdbwriter.New(mmdbwriter.Options{DatabaseType: dbType, RecordSize: 24})
+	if err != nil {
+		t.Fatalf("failed to create fixture: %v", err)
+	}
+	for _, c := range cities {
+		_, network, err := net.ParseCIDR(c.network)
+		if err != nil {
+			t.Fatalf("bad fixture network %q: %v", c.network, err)
+		}
+		record := mmdbtype.Map{
+			"country": mmdbtype.Map{"iso_code": mmdbtype.String(c.country)},
+		}
+		if c.city != "" {
+			record["city"] = mmdbtype.Map{"names": mmdbtype.Map{"en": mmdbtype.String(c.city)}}
+		}
+		if c.region != "" {
+			record["subdivisions"] = mmdbtype.Slice{
+				mmdbtype.Map{"names": mmdbtype.Map{"en": mmdbtype.String(c.region)}},
+			}
+		}
+		if err := tree.Insert(network, record); err != nil {
+			t.Fatalf("failed to insert %s: %v", c.network, err)
+		}
+	}
+
+	// write next to the target and rename, the way database updaters replace the file
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		t.Fatalf("failed to create fixture file: %v", err)
+	}
+	if _, err := tree.WriteTo(f); err != nil {
+		t.Fatalf("failed to write fixture: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("failed to write fixture: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatalf("failed to write fixture: %v", err)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "city.mmdb")
+	writeFixture(t, path, "GeoLite2-City",
+		fixtureCity{"81.2.69.0/24", "GB", "England", "London"},
+		fixtureCity{"2a02:2e0::/32", "DE", "", "Berlin"},
+		fixtureCity{"89.160.20.0/24", "SE", "", ""},
+	)
+	r, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	tests := []struct {
+		ip   string
+		want domain.Location
+	}{
+		{"81.2.69.142", domain.Location{Country: "GB", Region: "England", City: "London"}},
+		{"2a02:2e0:3fe:1001::1", domain.Location{Country: "DE", City: "Berlin"}},
+		{"89.160.20.112", domain.Location{Country: "SE"}},
+		{"8.8.8.8", domain.Location{}},
+		{"10.0.0.1", domain.Location{}},
+		{"not an ip", domain.Location{}},
+	}
+	for _, tt := range tests {
+		if got := r.Lookup(tt.ip); got != tt.want {
+			t.Errorf("Lookup(%q) = %+v, want %+v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestOpen_RejectsNonCityDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "asn.mmdb")
+	writeFixture(t, path, "GeoLite2-ASN", fixtureCity{"81.2.69.0/24", "GB", "", ""})
+	if _, err := Open(path); err == nil {
+		t.Fatal("expected an error for an ASN database")
+	}
+	if _, err := Open(filepath.Join(t.TempDir(), "missing.mmdb")); err == nil {
+		t.Fatal("expected an error for a missing file")
+	}
+}
+
+func TestWatch_ReloadsChangedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "city.mmdb")
+	writeFixture(t, path, "GeoLite2-City", fixtureCity{"81.2.69.0/24", "GB", "England", "London"})
+	r, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Watch(ctx, 5*time.Millisecond)
+
+	writeFixture(t, path, "GeoLite2-City", fixtureCity{"81.2.69.0/24", "GB", "Scotland", "Edinburgh"})
+	want := domain.Location{Country: "GB", Region: "Scotland", City: "Edinburgh"}
+	deadline := time.Now().Add(2 * time.Second)
+	for r.Lookup("81.2.69.142") != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("database was not reloaded, got %+v", r.Lookup("81.2.69.142"))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// a broken update keeps the last good database
+	if err := os.WriteFile(path, []byte("not a database"), 0o644); err != nil {
+		t.Fatalf("failed to corrupt fixture: %v", err)
+	}
+	if err := r.Reload(); err == nil {
+		t.Fatal("expected an error reloading a corrupt file")
+	}
+	if got := r.Lookup("81.2.69.142"); got != want {
+		t.Fatalf("Lookup after failed reload = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,193 @@
+// Package ingest batches click events in memory and writes them to storage in bulk.
+package ingest
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"shortener/internal/domain"
+
+	"github.com/kxddry/wbf/zlog"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Sink is where flushed batches go.
+type Sink interface {
+	SaveClicks(ctx context.Context, clicks []domain.Click) error
+}
+
+// Options configures the buffer. Zero values fall back to the defaults.
+type Options struct {
+	// Capacity is the maximum number of clicks waiting to be flushed.
+	Capacity int
+	// BatchSize is the number of clicks that triggers a flush.
+	BatchSize int
+	// FlushInterval is the longest a click waits before it is flushed.
+	FlushInterval time.Duration
+	// BlockTimeout is how long Record waits for room in a full buffer before dropping the click.
+	// Zero drops immediately, so redirects never wait on the database.
+	BlockTimeout time.Duration
+	// FlushTimeout bounds a single batch write, including the final one on shutdown.
+	FlushTimeout time.Duration
+}
+
+// Defaults for Options.
+const (
+	DefaultCapacity      = 10000
+	DefaultBatchSize     = 500
+	DefaultFlushInterval = time.Second
+	DefaultFlushTimeout  = 10 * time.Second
+)
+
+func (o Options) withDefaults() Options {
+	if o.Capacity <= 0 {
+		o.Capacity = DefaultCapacity
+	}
+	if o.BatchSize <= 0 {
+		o.BatchSize = DefaultBatchSize
+	}
+	o.BatchSize = min(o.BatchSize, o.Capacity)
+	if o.FlushInterval <= 0 {
+		o.FlushInterval = DefaultFlushInterval
+	}
+	if o.FlushTimeout <= 0 {
+		o.FlushTimeout = DefaultFlushTimeout
+	}
+	return o
+}
+
+// Buffer is a bounded in-process click buffer. Record never spawns goroutines; a single Run loop
+// drains the buffer and writes batches to the sink.
+type Buffer struct {
+	sink  Sink
+	opts  Options
+	queue chan domain.Click
+
+	stopOnce sync.Once
+	stopped  chan struct{}
+
+	dropped prometheus.Counter
+	flushed prometheus.Counter
+	failed  prometheus.Counter
+}
+
+// New creates a new Buffer and registers its metrics with reg.
+func New(sink Sink, opts Options, reg prometheus.Registerer) (*Buffer, error) {
+	opts = opts.withDefaults()
+	b := &Buffer{
+		sink:    sink,
+		opts:    opts,
+		queue:   make(chan domain.Click, opts.Capacity),
+		stopped: make(chan struct{}),
+		dropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "shortener_clicks_dropped_total",
+			Help: "Clicks dropped because the ingestion buffer was full or stopped.",
+		}),
+		flushed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "shortener_clicks_flushed_total",
+			Help: "Clicks written to storage by the ingestion buffer.",
+		}),
+		failed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "shortener_clicks_failed_total",
+			Help: "Clicks lost because a batch write failed.",
+		}),
+	}
+	depth := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "shortener_click_buffer_depth",
+		Help: "Clicks waiting in the ingestion buffer.",
+	}, func() float64 { return float64(len(b.queue)) })
+
+	for _, c := range []prometheus.Collector{depth, b.dropped, b.flushed, b.failed} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// Record queues a click. It reports false if the click was dropped.
+func (b *Buffer) Record(click domain.Click) bool {
+	select {
+	case <-b.stopped:
+		b.dropped.Inc()
+		return false
+	default:
+	}
+
+	select {
+	case b.queue <- click:
+		return true
+	default:
+	}
+	if b.opts.BlockTimeout > 0 {
+		t := time.NewTimer(b.opts.BlockTimeout)
+		defer t.Stop()
+		select {
+		case b.queue <- click:
+			return true
+		case <-t.C:
+		case <-b.stopped:
+		}
+	}
+	b.dropped.Inc()
+	return false
+}
+
+// Depth returns the number of clicks waiting to be flushed.
+func (b *Buffer) Depth() int {
+	return len(b.queue)
+}
+
+// Run flushes the buffer until ctx is done, then stops accepting clicks and flushes what is left.
+// Stop the HTTP server before cancelling ctx so that no click is recorded after the final flush.
+func (b *Buffer) Run(ctx context.Context) {
+	ticker := time.NewTicker(b.opts.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]domain.Click, 0, b.opts.BatchSize)
+	for {
+		select {
+		case click := <-b.queue:
+			batch = append(batch, click)
+			if len(batch) >= b.opts.BatchSize {
+				batch = b.flush(batch)
+			}
+		case <-ticker.C:
+			batch = b.flush(batch)
+		case <-ctx.Done():
+			b.stopOnce.Do(func() { close(b.stopped) })
+			for {
+				select {
+				case click := <-b.queue:
+					batch = append(batch, click)
+					if len(batch) >= b.opts.BatchSize {
+						batch = b.flush(batch)
+					}
+				default:
+					b.flush(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// flush writes the batch and returns it emptied for reuse. It uses its own context
+// so that the final flush still runs after the Run context is cancelled.
+func (b *Buffer) flush(batch []domain.Click) []domain.Click {
+	if len(batch) == 0 {
+		return batch
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), b.opts.FlushTimeout)
+	defer cancel()
+
+	if err := b.sink.SaveClicks(ctx, batch); err != nil {
+		b.failed.Add(float64(len(batch)))
+		zlog.Logger.Error().Err(err).Int("clicks", len(batch)).Msg("failed to save click batch")
+	} else {
+		b.flushed.Add(float64(len(batch)))
+	}
+	clear(batch)
+	return batch[:0]
+}
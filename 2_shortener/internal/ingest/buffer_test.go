@@ -0,0 +1,154 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"shortener/internal/domain"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+type mockSink struct {
+	mu      sync.Mutex
+	batches [][]domain.Click
+	err     error
+}
+
+func (m *mockSink) SaveClicks(ctx context.Context, clicks []domain.Click) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return m.err
+	}
+	m.batches = append(m.batches, append([]domain.Click(nil), clicks...))
+	return nil
+}
+
+func (m *mockSink) sizes() []int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]int, len(m.batches))
+	for i, b := range m.batches {
+		out[i] = len(b)
+	}
+	return out
+}
+
+func click(code string) domain.Click {
+	return domain.Click{ShortCode: code, Timestamp: time.Now()}
+}
+
+func TestBuffer_FlushesOnBatchSize(t *testing.T) {
+	sink := &mockSink{}
+	b, err := New(sink, Options{BatchSize: 3, FlushInterval: time.Hour}, prometheus.NewRegistry())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() { b.Run(ctx); close(done) }()
+
+	for i := 0; i < 7; i++ {
+		b.Record(click("abc"))
+	}
+	deadline := time.Now().Add(time.Second)
+	for len(sink.sizes()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if sizes := sink.sizes(); len(sizes) != 2 || sizes[0] != 3 || sizes[1] != 3 {
+		t.Fatalf("expected two full batches before shutdown, got %v", sizes)
+	}
+
+	// The remainder is flushed on shutdown
+	cancel()
+	<-done
+	if sizes := sink.sizes(); len(sizes) != 3 || sizes[2] != 1 {
+		t.Fatalf("expected the last click to be flushed on shutdown, got %v", sizes)
+	}
+	if got := testutil.ToFloat64(b.flushed); got != 7 {
+		t.Fatalf("expected 7 flushed clicks, got %v", got)
+	}
+}
+
+func TestBuffer_FlushesOnInterval(t *testing.T) {
+	sink := &mockSink{}
+	b, err := New(sink, Options{BatchSize: 100, FlushInterval: 10 * time.Millisecond}, prometheus.NewRegistry())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go b.Run(ctx)
+
+	b.Record(click("abc"))
+	deadline := time.Now().Add(time.Second)
+	for len(sink.sizes()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if sizes := sink.sizes(); len(sizes) != 1 || sizes[0] != 1 {
+		t.Fatalf("expected a single timed flush, got %v", sizes)
+	}
+}
+
+func TestBuffer_DropsWhenFull(t *testing.T) {
+	sink := &mockSink{}
+	reg := prometheus.NewRegistry()
+	b, err := New(sink, Options{Capacity: 2, BlockTimeout: time.Millisecond}, reg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Nothing drains the buffer until Run starts
+	if !b.Record(click("a")) || !b.Record(click("b")) {
+		t.Fatalf("expected clicks to fit into the buffer")
+	}
+	if b.Record(click("c")) {
+		t.Fatalf("expected the click to be dropped")
+	}
+	if got := testutil.ToFloat64(b.dropped); got != 1 {
+		t.Fatalf("expected 1 dropped click, got %v", got)
+	}
+	if b.Depth() != 2 {
+		t.Fatalf("expected depth 2, got %d", b.Depth())
+	}
+	if n, err := testutil.GatherAndCount(reg, "shortener_click_buffer_depth", "shortener_clicks_dropped_total"); err != nil || n != 2 {
+		t.Fatalf("expected the buffer metrics to be registered, got %d (%v)", n, err)
+	}
+}
+
+func TestBuffer_DropsAfterStop(t *testing.T) {
+	b, err := New(&mockSink{}, Options{}, prometheus.NewRegistry())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	b.Run(ctx)
+
+	if b.Record(click("late")) {
+		t.Fatalf("expected clicks after shutdown to be dropped")
+	}
+}
+
+func TestBuffer_CountsFailedBatches(t *testing.T) {
+	sink := &mockSink{err: errors.New("db down")}
+	b, err := New(sink, Options{}, prometheus.NewRegistry())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b.Record(click("a"))
+	b.Record(click("b"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	b.Run(ctx)
+
+	if got := testutil.ToFloat64(b.failed); got != 2 {
+		t.Fatalf("expected 2 failed clicks, got %v", got)
+	}
+}
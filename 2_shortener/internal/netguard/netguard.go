@@ -0,0 +1,55 @@
+// Package netguard keeps the requests the service makes on behalf of its users away from the network it runs in.
+package netguard
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+	"syscall"
+)
+
+// ErrPrivate is the error for an address of the network the service runs in.
+var ErrPrivate = errors.New("address is not a public one")
+
+// PublicOnly is a net.Dialer Control that refuses to connect to loopback, private and link-local addresses, so
+// the URLs users give the service can't be used to read what the service can reach and the internet can't.
+// It vets the address actually dialed, after DNS, so a name pointing inside is refused too.
+func PublicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if !public(net.ParseIP(host)) {
+		return ErrPrivate
+	}
+	return nil
+}
+
+func public(ip net.IP) bool {
+	return ip != nil && ip.IsGlobalUnicast() && !ip.IsPrivate()
+}
+
+// CheckURL returns an error unless raw is an absolute http or https URL whose host could be public: a name
+// other than localhost, or a public IP. Names are not resolved; PublicOnly catches those pointing inside
+// when they are dialed.
+func CheckURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.New("url must be http or https")
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if host == "" {
+		return errors.New("url must have a host")
+	}
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return ErrPrivate
+	}
+	if ip := net.ParseIP(host); ip != nil && !public(ip) {
+		return ErrPrivate
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package netguard
+
+import "testing"
+
+func TestPublicOnly(t *testing.T) {
+	for addr, allowed := range map[string]bool{
+		"93.184.216.34:443":       true,
+		"[2606:2800:220:1::]:443": true,
+		"127.0.0.1:80":            false,
+		"10.1.2.3:80":             false,
+		"192.168.0.10:80":         false,
+		"169.254.169.254:80":      false,
+		"[::1]:80":                false,
+		"[fd00::1]:80":            false,
+		"0.0.0.0:80":              false,
+	} {
+		if err := PublicOnly("tcp", addr, nil); (err == nil) != allowed {
+			t.Errorf("%s: expected allowed=%v, got %v", addr, allowed, err)
+		}
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	for raw, allowed := range map[string]bool{
+		"https://hooks.example.com/shortener": true,
+		"http://93.184.216.34:8080/hook":      true,
+		"ftp://example.com/hook":              false,
+		"https:///hook":                       false,
+		"http://localhost:8080/hook":          false,
+		"http://api.LOCALHOST./hook":          false,
+		"http://127.0.0.1/hook":               false,
+		"http://169.254.169.254/latest/meta":  false,
+		"http://[::1]/hook":                   false,
+		"http://10.0.0.5/hook":                false,
+	} {
+		if err := CheckURL(raw); (err == nil) != allowed {
+			t.Errorf("%s: expected allowed=%v, got %v", raw, allowed, err)
+		}
+	}
+}
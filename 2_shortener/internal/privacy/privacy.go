@@ -0,0 +1,224 @@
+// Package privacy anonymises the IPs of clicks before they are stored and enforces how long raw clicks are kept.
+package privacy
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"shortener/internal/domain"
+
+	"github.com/kxddry/wbf/zlog"
+)
+
+// IP modes.
+const (
+	// ModeFull stores IPs as they are.
+	ModeFull = "full"
+	// ModeTruncate stores the network of an IP: its /24 for IPv4 and its /48 for IPv6.
+	ModeTruncate = "truncate"
+	// ModeHash stores a keyed hash of an IP, with a key that changes every day; see Hash.
+	ModeHash = "hash"
+)
+
+// ParseMode returns the IP mode named by v, ModeFull if v is empty.
+func ParseMode(v string) (string, error) {
+	switch v {
+	case "", ModeFull:
+		return ModeFull, nil
+	case ModeTruncate, ModeHash:
+		return v, nil
+	}
+	return "", fmt.Errorf("unknown IP mode %q; expected full, truncate or hash", v)
+}
+
+// Truncate zeroes the host part of ip, keeping the first 24 bits of an IPv4 address and the first 48 of an
+// IPv6 one. Anything that isn't an IP is returned as is.
+func Truncate(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ip
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return parsed.Mask(net.CIDRMask(48, 128)).String()
+}
+
+// Hash returns the HMAC-SHA256 of ip keyed with salt, shaped as an IPv6 address in fd00::/8 so it fits where
+// IPs are stored. The range is private, so a hash is never mistaken for the address of a client.
+func Hash(salt []byte, ip string) string {
+	mac := hmac.New(sha256.New, salt)
+	mac.Write([]byte(ip))
+	sum := mac.Sum(nil)
+
+	hashed := make(net.IP, net.IPv6len)
+	hashed[0] = 0xfd
+	copy(hashed[1:], sum)
+	return hashed.String()
+}
+
+// ClickSaver saves click batches.
+type ClickSaver interface {
+	SaveClicks(ctx context.Context, clicks []domain.Click) error
+}
+
+// SaltStore keeps the daily salts. Every instance must hash with the same salt for unique counts to work, so
+// they are shared through it rather than made up by each instance.
+type SaltStore interface {
+	// DailySalt returns the salt of the UTC day of day, creating it if it doesn't exist yet.
+	DailySalt(ctx context.Context, day time.Time) ([]byte, error)
+}
+
+// Sink is a click sink that anonymises the IP of every click before passing the batch on. A click is
+// hashed with the salt of the UTC day it was made on, so a visitor counts once per day.
+type Sink struct {
+	next  ClickSaver
+	mode  string
+	salts SaltStore
+
+	mu     sync.Mutex
+	cached map[string][]byte
+}
+
+// NewSink returns a sink saving batches to next with their IPs anonymised as mode says. salts is only
+// used in ModeHash.
+func NewSink(next ClickSaver, mode string, salts SaltStore) *Sink {
+	return &Sink{next: next, mode: mode, salts: salts, cached: make(map[string][]byte)}
+}
+
+// SaveClicks anonymises the IPs of the clicks and saves them. If a salt can't be had, the batch fails rather
+// than being saved with the IPs it has.
+func (s *Sink) SaveClicks(ctx context.Context, clicks []domain.Click) error {
+	clicks = slices.Clone(clicks)
+	switch s.mode {
+	case ModeTruncate:
+		for i := range clicks {
+			clicks[i].IP = Truncate(clicks[i].IP)
+		}
+	case ModeHash:
+		for i := range clicks {
+			// what isn't an IP is left for the storage to turn away
+			if net.ParseIP(clicks[i].IP) == nil {
+				continue
+			}
+			salt, err := s.salt(ctx, clicks[i].Timestamp)
+			if err != nil {
+				return fmt.Errorf("failed to get the IP salt: %w", err)
+			}
+			clicks[i].IP = Hash(salt, clicks[i].IP)
+		}
+	}
+	return s.next.SaveClicks(ctx, clicks)
+}
+
+// salt returns the salt of the UTC day of t. Salts are fetched once per day and instance.
+func (s *Sink) salt(ctx context.Context, t time.Time) ([]byte, error) {
+	day := t.UTC().Truncate(24 * time.Hour)
+	key := day.Format(time.DateOnly)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if salt, ok := s.cached[key]; ok {
+		return salt, nil
+	}
+	salt, err := s.salts.DailySalt(ctx, day)
+	if err != nil {
+		return nil, err
+	}
+	// clicks are at most a few minutes old, so only the salts of today and yesterday are ever needed
+	for k := range s.cached {
+		if k < day.AddDate(0, 0, -1).Format(time.DateOnly) {
+			delete(s.cached, k)
+		}
+	}
+	s.cached[key] = salt
+	return salt, nil
+}
+
+// Pruner deletes old raw clicks.
+type Pruner interface {
+	// PruneClicks deletes the raw clicks before the hour containing before and returns how many it did. With
+	// keepRollups, only clicks that are already rolled up are deleted and their rollups stay, so analytics
+	// still counts them; otherwise the rollups of those hours are deleted too.
+	PruneClicks(ctx context.Context, before time.Time, keepRollups bool) (int64, error)
+}
+
+// Retention modes.
+const (
+	// RetentionAggregate deletes raw clicks but keeps their hourly rollups.
+	RetentionAggregate = "aggregate"
+	// RetentionDelete deletes raw clicks and their rollups.
+	RetentionDelete = "delete"
+)
+
+// DefaultRetentionInterval is how often old clicks are pruned by default.
+const DefaultRetentionInterval = time.Hour
+
+// Retention periodically deletes the clicks older than the retention period.
+type Retention struct {
+	pruner      Pruner
+	period      time.Duration
+	keepRollups bool
+	interval    time.Duration
+	now         func() time.Time
+}
+
+// NewRetention creates a new Retention keeping clicks for days days, or their rollups only with
+// RetentionAggregate. A non-positive interval falls back to the default.
+func NewRetention(pruner Pruner, days int, mode string, interval time.Duration) (*Retention, error) {
+	if days <= 0 {
+		return nil, fmt.Errorf("retention must be at least a day, got %d", days)
+	}
+	switch mode {
+	case "":
+		mode = RetentionAggregate
+	case RetentionAggregate, RetentionDelete:
+	default:
+		return nil, fmt.Errorf("unknown retention mode %q; expected aggregate or delete", mode)
+	}
+	if interval <= 0 {
+		interval = DefaultRetentionInterval
+	}
+	return &Retention{
+		pruner:      pruner,
+		period:      time.Duration(days) * 24 * time.Hour,
+		keepRollups: mode == RetentionAggregate,
+		interval:    interval,
+		now:         time.Now,
+	}, nil
+}
+
+// Run prunes immediately and then every interval until ctx is done.
+func (r *Retention) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		r.Tick(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Tick deletes the clicks older than the retention period.
+func (r *Retention) Tick(ctx context.Context) {
+	pruned, err := r.pruner.PruneClicks(ctx, r.now().Add(-r.period), r.keepRollups)
+	if err != nil {
+		if ctx.Err() == nil {
+			zlog.Logger.Error().Err(err).Msg("failed to prune clicks")
+		}
+		return
+	}
+	if pruned > 0 {
+		zlog.Logger.Info().Int64("clicks", pruned).Msg("old clicks pruned")
+	}
+}
@@ -0,0 +1,131 @@
+package privacy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"shortener/internal/domain"
+)
+
+type mockSaver struct {
+	saved []domain.Click
+}
+
+func (m *mockSaver) SaveClicks(ctx context.Context, clicks []domain.Click) error {
+	m.saved = append(m.saved, clicks...)
+	return nil
+}
+
+type mockSalts struct {
+	calls []string
+	err   error
+}
+
+func (m *mockSalts) DailySalt(ctx context.Context, day time.Time) ([]byte, error) {
+	m.calls = append(m.calls, day.Format(time.DateOnly))
+	return []byte("salt of " + day.Format(time.DateOnly)), m.err
+}
+
+func TestTruncate(t *testing.T) {
+	for ip, want := range map[string]string{
+		"81.2.69.142":                          "81.2.69.0",
+		"::ffff:81.2.69.142":                   "81.2.69.0",
+		"2001:db8:85a3:8d3:1319:8a2e:370:7348": "2001:db8:85a3::",
+		"not an ip":                            "not an ip",
+	} {
+		if got := Truncate(ip); got != want {
+			t.Errorf("Truncate(%q) = %q, expected %q", ip, got, want)
+		}
+	}
+}
+
+func TestHash(t *testing.T) {
+	a := Hash([]byte("monday"), "81.2.69.142")
+	if a != Hash([]byte("monday"), "81.2.69.142") {
+		t.Fatal("expected the same IP and salt to hash the same")
+	}
+	if a == Hash([]byte("tuesday"), "81.2.69.142") || a == Hash([]byte("monday"), "81.2.69.143") {
+		t.Fatal("expected another salt or IP to hash differently")
+	}
+	_, private, _ := net.ParseCIDR("fd00::/8")
+	if ip := net.ParseIP(a); ip == nil || !private.Contains(ip) {
+		t.Fatalf("expected an address in fd00::/8, got %q", a)
+	}
+}
+
+func TestSink_Hash(t *testing.T) {
+	saver, salts := &mockSaver{}, &mockSalts{}
+	s := NewSink(saver, ModeHash, salts)
+	before := time.Date(2025, 3, 1, 23, 59, 0, 0, time.UTC)
+	after := time.Date(2025, 3, 2, 0, 1, 0, 0, time.UTC)
+	clicks := []domain.Click{
+		{IP: "81.2.69.142", Timestamp: before},
+		{IP: "81.2.69.142", Timestamp: after},
+		{IP: "81.2.69.142", Timestamp: after.Add(time.Hour)},
+		{IP: "", Timestamp: after},
+	}
+
+	if err := s.SaveClicks(context.Background(), clicks); err != nil {
+		t.Fatalf("SaveClicks: %v", err)
+	}
+	got := saver.saved
+	// a visitor is the same within a day and another one the next
+	if got[1].IP != got[2].IP || got[0].IP == got[1].IP || got[0].IP != Hash([]byte("salt of 2025-03-01"), "81.2.69.142") {
+		t.Fatalf("unexpected hashes %q, %q, %q", got[0].IP, got[1].IP, got[2].IP)
+	}
+	if got[3].IP != "" || clicks[0].IP != "81.2.69.142" {
+		t.Fatal("expected invalid IPs and the caller's clicks to be left alone")
+	}
+	// each day's salt is fetched once
+	if err := s.SaveClicks(context.Background(), clicks); err != nil {
+		t.Fatalf("SaveClicks: %v", err)
+	}
+	if len(salts.calls) != 2 {
+		t.Fatalf("expected two salt lookups, got %v", salts.calls)
+	}
+}
+
+func TestSink_NoSaltNoSave(t *testing.T) {
+	saver := &mockSaver{}
+	s := NewSink(saver, ModeHash, &mockSalts{err: errors.New("db down")})
+	if err := s.SaveClicks(context.Background(), []domain.Click{{IP: "81.2.69.142"}}); err == nil {
+		t.Fatal("expected the salt error")
+	}
+	if len(saver.saved) != 0 {
+		t.Fatalf("expected nothing saved without a salt, got %v", saver.saved)
+	}
+}
+
+type mockPruner struct {
+	before      time.Time
+	keepRollups bool
+}
+
+func (m *mockPruner) PruneClicks(ctx context.Context, before time.Time, keepRollups bool) (int64, error) {
+	m.before, m.keepRollups = before, keepRollups
+	return 0, nil
+}
+
+func TestRetention(t *testing.T) {
+	if _, err := NewRetention(&mockPruner{}, 0, "", 0); err == nil {
+		t.Fatal("expected an error for no retention period")
+	}
+	if _, err := NewRetention(&mockPruner{}, 30, "archive", 0); err == nil {
+		t.Fatal("expected an error for an unknown mode")
+	}
+
+	pruner := &mockPruner{}
+	r, err := NewRetention(pruner, 30, "", 0)
+	if err != nil {
+		t.Fatalf("NewRetention: %v", err)
+	}
+	now := time.Date(2025, 3, 31, 12, 0, 0, 0, time.UTC)
+	r.now = func() time.Time { return now }
+	r.Tick(context.Background())
+	if !pruner.before.Equal(now.AddDate(0, 0, -30)) || !pruner.keepRollups {
+		t.Fatalf("expected rollups kept and clicks before %v pruned, got %v, %v", now.AddDate(0, 0, -30), pruner.before, pruner.keepRollups)
+	}
+}
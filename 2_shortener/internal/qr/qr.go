@@ -0,0 +1,175 @@
+// Package qr renders QR codes for short links.
+package qr
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strconv"
+	"strings"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// Format is the output format of a QR code.
+type Format string
+
+// Supported formats.
+const (
+	PNG Format = "png"
+	SVG Format = "svg"
+)
+
+// Limits of the rendering options.
+const (
+	MinSize   = 64
+	MaxSize   = 2048
+	MaxMargin = 16
+)
+
+var (
+	// ErrInvalidOptions is returned when the rendering options are out of range.
+	ErrInvalidOptions = errors.New("invalid qr options")
+
+	levels = map[string]qrcode.RecoveryLevel{
+		"L": qrcode.Low,
+		"M": qrcode.Medium,
+		"Q": qrcode.High,
+		"H": qrcode.Highest,
+	}
+)
+
+// Options is the struct for the QR code rendering options.
+type Options struct {
+	Format     Format
+	Size       int    // width and height in pixels
+	Margin     int    // quiet zone in modules
+	Level      string // error correction level: L, M, Q or H
+	Foreground color.RGBA
+	Background color.RGBA
+}
+
+// DefaultOptions returns the options used when the caller does not override them.
+func DefaultOptions() Options {
+	return Options{
+		Format:     PNG,
+		Size:       256,
+		Margin:     4,
+		Level:      "M",
+		Foreground: color.RGBA{A: 0xff},
+		Background: color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff},
+	}
+}
+
+// ContentType returns the MIME type of the format.
+func (f Format) ContentType() string {
+	if f == SVG {
+		return "image/svg+xml"
+	}
+	return "image/png"
+}
+
+// ParseColor parses a hex colour in RRGGBB form, with or without a leading '#'.
+func ParseColor(s string) (color.RGBA, error) {
+	s = strings.TrimPrefix(s, "#")
+	if len(s) != 6 {
+		return color.RGBA{}, fmt.Errorf("%w: colour must be RRGGBB", ErrInvalidOptions)
+	}
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("%w: colour must be RRGGBB", ErrInvalidOptions)
+	}
+	return color.RGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 0xff}, nil
+}
+
+// Validate checks that the options are within the supported limits.
+func (o Options) Validate() error {
+	if o.Format != PNG && o.Format != SVG {
+		return fmt.Errorf("%w: format must be png or svg", ErrInvalidOptions)
+	}
+	if o.Size < MinSize || o.Size > MaxSize {
+		return fmt.Errorf("%w: size must be between %d and %d", ErrInvalidOptions, MinSize, MaxSize)
+	}
+	if o.Margin < 0 || o.Margin > MaxMargin {
+		return fmt.Errorf("%w: margin must be between 0 and %d", ErrInvalidOptions, MaxMargin)
+	}
+	if _, ok := levels[o.Level]; !ok {
+		return fmt.Errorf("%w: level must be one of L, M, Q, H", ErrInvalidOptions)
+	}
+	return nil
+}
+
+// Render encodes content as a QR code in the requested format.
+func Render(content string, o Options) ([]byte, error) {
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+
+	code, err := qrcode.New(content, levels[o.Level])
+	if err != nil {
+		return nil, err
+	}
+	code.DisableBorder = true
+	bitmap := code.Bitmap()
+
+	if len(bitmap)+2*o.Margin > o.Size {
+		return nil, fmt.Errorf("%w: size is too small for the code", ErrInvalidOptions)
+	}
+
+	if o.Format == SVG {
+		return renderSVG(bitmap, o), nil
+	}
+	return renderPNG(bitmap, o)
+}
+
+// renderPNG scales the bitmap to exactly o.Size pixels, quiet zone included.
+func renderPNG(bitmap [][]bool, o Options) ([]byte, error) {
+	modules := len(bitmap) + 2*o.Margin
+	img := image.NewPaletted(image.Rect(0, 0, o.Size, o.Size), color.Palette{o.Background, o.Foreground})
+	for y := 0; y < o.Size; y++ {
+		my := y*modules/o.Size - o.Margin
+		if my < 0 || my >= len(bitmap) {
+			continue
+		}
+		for x := 0; x < o.Size; x++ {
+			mx := x*modules/o.Size - o.Margin
+			if mx >= 0 && mx < len(bitmap) && bitmap[my][mx] {
+				img.SetColorIndex(x, y, 1)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// renderSVG draws the dark modules as a single path in a viewBox measured in modules.
+func renderSVG(bitmap [][]bool, o Options) []byte {
+	modules := len(bitmap) + 2*o.Margin
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<?xml version="1.0" encoding="UTF-8"?>`+"\n")
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`,
+		o.Size, o.Size, modules, modules)
+	fmt.Fprintf(&b, `<rect width="100%%" height="100%%" fill="%s"/>`, hex(o.Background))
+	fmt.Fprintf(&b, `<path fill="%s" d="`, hex(o.Foreground))
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(&b, "M%d %dh1v1h-1z", x+o.Margin, y+o.Margin)
+			}
+		}
+	}
+	b.WriteString(`"/></svg>`)
+	return []byte(b.String())
+}
+
+func hex(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
@@ -0,0 +1,93 @@
+package qr
+
+import (
+	"bytes"
+	"errors"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestRender_PNG(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Size = 200
+	opts.Foreground = color.RGBA{R: 0xff, A: 0xff}
+
+	data, err := Render("https://sho.rt/s/abc123?source=qr", opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("invalid png: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != 200 || b.Dy() != 200 {
+		t.Fatalf("expected 200x200, got %v", b)
+	}
+
+	// The quiet zone is background
+	if r, g, b, _ := img.At(0, 0).RGBA(); r != 0xffff || g != 0xffff || b != 0xffff {
+		t.Fatalf("expected background in the quiet zone")
+	}
+
+	// Without a margin, the corner is the top-left finder pattern
+	opts.Margin = 0
+	data, err = Render("https://sho.rt/s/abc123?source=qr", opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	img, err = png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("invalid png: %v", err)
+	}
+	if r, g, _, _ := img.At(0, 0).RGBA(); r != 0xffff || g != 0 {
+		t.Fatalf("expected foreground in the finder pattern")
+	}
+}
+
+func TestRender_SVG(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Format = SVG
+	opts.Margin = 0
+	opts.Background = color.RGBA{R: 0xee, G: 0xee, B: 0xee, A: 0xff}
+
+	data, err := Render("https://sho.rt/s/abc123", opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	svg := string(data)
+	if !strings.Contains(svg, `width="256"`) || !strings.Contains(svg, `fill="#eeeeee"`) {
+		t.Fatalf("unexpected svg: %s", svg)
+	}
+	if !strings.Contains(svg, "M0 0h1v1h-1z") {
+		t.Fatalf("expected the finder pattern to start at the origin without a margin")
+	}
+}
+
+func TestRender_InvalidOptions(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Size = MinSize
+	opts.Margin = MaxMargin
+
+	// 21 modules plus a 32 module quiet zone don't fit in 64 pixels
+	if _, err := Render(strings.Repeat("a", 100), opts); !errors.Is(err, ErrInvalidOptions) {
+		t.Fatalf("expected ErrInvalidOptions, got %v", err)
+	}
+}
+
+func TestParseColor(t *testing.T) {
+	c, err := ParseColor("#0a0B0c")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c != (color.RGBA{R: 0x0a, G: 0x0b, B: 0x0c, A: 0xff}) {
+		t.Fatalf("unexpected colour: %v", c)
+	}
+	for _, bad := range []string{"", "fff", "#gggggg", "1234567"} {
+		if _, err := ParseColor(bad); !errors.Is(err, ErrInvalidOptions) {
+			t.Fatalf("%q: expected ErrInvalidOptions, got %v", bad, err)
+		}
+	}
+}
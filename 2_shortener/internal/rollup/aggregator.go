@@ -0,0 +1,68 @@
+// Package rollup keeps the hourly click rollups up to date.
+package rollup
+
+import (
+	"context"
+	"time"
+
+	"github.com/kxddry/wbf/zlog"
+)
+
+// Roller rolls up every click before the hour containing until and returns the new watermark.
+type Roller interface {
+	RollupClicks(ctx context.Context, until time.Time) (time.Time, error)
+}
+
+// Defaults for the aggregator.
+const (
+	DefaultInterval = time.Minute
+	// DefaultLag must exceed the longest a click can wait in the ingestion buffer, because an hour
+	// is rolled up only once and later clicks with a timestamp in it would be missed.
+	DefaultLag = 5 * time.Minute
+)
+
+// Aggregator periodically rolls up the clicks of completed hours.
+type Aggregator struct {
+	roller   Roller
+	interval time.Duration
+	lag      time.Duration
+	now      func() time.Time
+}
+
+// New creates a new Aggregator. Non-positive durations fall back to the defaults.
+func New(roller Roller, interval, lag time.Duration) *Aggregator {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	if lag <= 0 {
+		lag = DefaultLag
+	}
+	return &Aggregator{roller: roller, interval: interval, lag: lag, now: time.Now}
+}
+
+// Run rolls up immediately and then every interval until ctx is done.
+func (a *Aggregator) Run(ctx context.Context) {
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+
+	for {
+		a.Tick(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Tick rolls up every hour that ended at least lag ago.
+func (a *Aggregator) Tick(ctx context.Context) {
+	rolled, err := a.roller.RollupClicks(ctx, a.now().Add(-a.lag))
+	if err != nil {
+		if ctx.Err() == nil {
+			zlog.Logger.Error().Err(err).Msg("failed to roll up clicks")
+		}
+		return
+	}
+	zlog.Logger.Debug().Time("rolled_until", rolled).Msg("clicks rolled up")
+}
@@ -0,0 +1,48 @@
+package rollup
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type mockRoller struct {
+	calls []time.Time
+	err   error
+}
+
+func (m *mockRoller) RollupClicks(ctx context.Context, until time.Time) (time.Time, error) {
+	m.calls = append(m.calls, until)
+	return until.Truncate(time.Hour), m.err
+}
+
+func TestAggregator_TickLagsBehindTheClock(t *testing.T) {
+	roller := &mockRoller{}
+	a := New(roller, 0, 10*time.Minute)
+	now := time.Date(2025, 1, 1, 12, 5, 0, 0, time.UTC)
+	a.now = func() time.Time { return now }
+
+	a.Tick(context.Background())
+
+	if len(roller.calls) != 1 {
+		t.Fatalf("expected one rollup, got %d", len(roller.calls))
+	}
+	// 11:55 keeps the 11:00 hour open until buffered clicks have landed
+	if want := now.Add(-10 * time.Minute); !roller.calls[0].Equal(want) {
+		t.Fatalf("expected rollup until %v, got %v", want, roller.calls[0])
+	}
+}
+
+func TestAggregator_RunStopsWithContext(t *testing.T) {
+	roller := &mockRoller{err: errors.New("db down")}
+	a := New(roller, time.Millisecond, 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	a.Run(ctx)
+
+	if len(roller.calls) < 2 {
+		t.Fatalf("expected repeated rollups despite errors, got %d", len(roller.calls))
+	}
+}
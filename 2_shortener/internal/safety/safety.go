@@ -0,0 +1,245 @@
+// Package safety decides which destinations links may point to, with domain allow and deny lists and a local
+// blocklist of URL hash prefixes.
+package safety
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kxddry/wbf/zlog"
+)
+
+// DefaultReloadInterval is how often Watch checks the blocklist file for changes.
+const DefaultReloadInterval = time.Minute
+
+// ErrBlocked is wrapped by the errors of destinations the policy refuses.
+var ErrBlocked = errors.New("destination not allowed")
+
+// Policy checks destinations against the domain lists and the blocklist.
+//
+// The blocklist file holds one hex-encoded SHA-256 prefix of 4 to 32 bytes per line, as in the Safe Browsing
+// hash-prefix format; blank lines and lines starting with # are ignored. A URL is blocked if the hash of any
+// of its host suffix and path prefix expressions starts with a listed prefix. There is no full-hash check,
+// so short prefixes block more than they name.
+type Policy struct {
+	allow, deny []string
+	path        string
+	prefixes    atomic.Pointer[prefixSet]
+
+	mu      sync.Mutex // serialises reloads
+	modTime time.Time
+	size    int64
+}
+
+// New returns a policy with the domain lists and, if blocklistPath is not empty, the blocklist at that path.
+// A domain in a list also covers its subdomains. If allow is not empty, only its domains may be linked to.
+func New(allow, deny []string, blocklistPath string) (*Policy, error) {
+	p := &Policy{allow: normalize(allow), deny: normalize(deny), path: blocklistPath}
+	if blocklistPath != "" {
+		if err := p.Reload(); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// Check returns an error wrapping ErrBlocked if links may not point to rawURL.
+func (p *Policy) Check(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Hostname() == "" {
+		return fmt.Errorf("%w: invalid URL", ErrBlocked)
+	}
+	host := canonicalHost(u.Hostname())
+	if inDomains(p.deny, host) {
+		return fmt.Errorf("%w: %s is denied", ErrBlocked, host)
+	}
+	if len(p.allow) > 0 && !inDomains(p.allow, host) {
+		return fmt.Errorf("%w: %s is not on the allow list", ErrBlocked, host)
+	}
+	if set := p.prefixes.Load(); set != nil && set.matches(u) {
+		return fmt.Errorf("%w: %s is on the blocklist", ErrBlocked, host)
+	}
+	return nil
+}
+
+// Reload reads the blocklist file again and swaps it in. On error the current blocklist stays in use.
+func (p *Policy) Reload() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return fmt.Errorf("safety: %w", err)
+	}
+	return p.load(info)
+}
+
+// Watch reloads the blocklist whenever the file changes, checking every interval until ctx is done.
+// It returns straight away if the policy has no blocklist.
+func (p *Policy) Watch(ctx context.Context, interval time.Duration) {
+	if p.path == "" {
+		return
+	}
+	if interval <= 0 {
+		interval = DefaultReloadInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		reloaded, err := p.reloadIfChanged()
+		if err != nil {
+			zlog.Logger.Error().Err(err).Str("path", p.path).Msg("failed to reload blocklist")
+			continue
+		}
+		if reloaded {
+			zlog.Logger.Info().Str("path", p.path).Msg("blocklist reloaded")
+		}
+	}
+}
+
+// reloadIfChanged reloads the blocklist if the file differs from the one loaded last.
+func (p *Policy) reloadIfChanged() (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return false, fmt.Errorf("safety: %w", err)
+	}
+	if info.ModTime().Equal(p.modTime) && info.Size() == p.size {
+		return false, nil
+	}
+	return true, p.load(info)
+}
+
+// load reads the blocklist described by info. The caller holds mu.
+func (p *Policy) load(info os.FileInfo) error {
+	raw, err := os.ReadFile(p.path)
+	if err != nil {
+		return fmt.Errorf("safety: %w", err)
+	}
+	set := prefixSet{}
+	sc := bufio.NewScanner(bytes.NewReader(raw))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		prefix, err := hex.DecodeString(line)
+		if err != nil || len(prefix) < 4 || len(prefix) > sha256.Size {
+			return fmt.Errorf("safety: %s:%d: expected a hex hash prefix of 4 to 32 bytes", p.path, n)
+		}
+		if set[len(prefix)] == nil {
+			set[len(prefix)] = make(map[string]struct{})
+		}
+		set[len(prefix)][string(prefix)] = struct{}{}
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("safety: %w", err)
+	}
+
+	p.prefixes.Store(&set)
+	p.modTime, p.size = info.ModTime(), info.Size()
+	return nil
+}
+
+// prefixSet holds the blocklisted hash prefixes by length.
+type prefixSet map[int]map[string]struct{}
+
+func (s prefixSet) matches(u *url.URL) bool {
+	for _, expr := range expressions(u) {
+		sum := sha256.Sum256([]byte(expr))
+		for n, prefixes := range s {
+			if _, ok := prefixes[string(sum[:n])]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// expressions returns what a URL is looked up by in the blocklist: each of its host suffixes joined with
+// each of its path prefixes. The hosts are the exact host and up to four suffixes of its last five
+// components, leaving out the top-level domain. The paths are the path with and without the query, "/",
+// and up to three leading directories.
+func expressions(u *url.URL) []string {
+	host := canonicalHost(u.Hostname())
+	hosts := []string{host}
+	if net.ParseIP(host) == nil {
+		parts := strings.Split(host, ".")
+		for i := max(1, len(parts)-5); i <= len(parts)-2; i++ {
+			hosts = append(hosts, strings.Join(parts[i:], "."))
+		}
+	}
+
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	var paths []string
+	if u.RawQuery != "" {
+		paths = append(paths, path+"?"+u.RawQuery)
+	}
+	paths = append(paths, path)
+	dir := "/"
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for i := 0; ; i++ {
+		if dir != path {
+			paths = append(paths, dir)
+		}
+		if i == 3 || i >= len(segments)-1 {
+			break
+		}
+		dir += segments[i] + "/"
+	}
+
+	exprs := make([]string, 0, len(hosts)*len(paths))
+	for _, h := range hosts {
+		for _, p := range paths {
+			exprs = append(exprs, h+p)
+		}
+	}
+	return exprs
+}
+
+func canonicalHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+func normalize(domains []string) []string {
+	var out []string
+	for _, d := range domains {
+		if d = canonicalHost(strings.TrimSpace(d)); d != "" {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+// inDomains reports whether host is one of the domains or a subdomain of one.
+func inDomains(domains []string, host string) bool {
+	for _, d := range domains {
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,178 @@
+package safety
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// prefix returns the hex hash prefix of a blocklist expression, as it appears in a blocklist file.
+func prefix(expr string, n int) string {
+	sum := sha256.Sum256([]byte(expr))
+	return hex.EncodeToString(sum[:n])
+}
+
+func mustParse(t *testing.T, raw string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatalf("bad URL %q: %v", raw, err)
+	}
+	return u
+}
+
+// writeBlocklist writes the lines to path, next to it first and then renamed, the way updaters replace the file.
+func writeBlocklist(t *testing.T, path string, lines ...string) {
+	t.Helper()
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+		t.Fatalf("failed to write blocklist: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatalf("failed to write blocklist: %v", err)
+	}
+}
+
+func TestCheck_DomainLists(t *testing.T) {
+	p, err := New([]string{"example.com", " Example.ORG. "}, []string{"bad.example.com"}, "")
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	tests := []struct {
+		url     string
+		blocked bool
+	}{
+		{"https://example.com/a", false},
+		{"https://www.example.com/a", false},
+		{"https://example.org", false},
+		{"https://EXAMPLE.org./x", false},
+		{"https://bad.example.com/", true},
+		{"https://deep.bad.example.com/", true},
+		{"https://notexample.com/", true},
+		{"https://example.net/", true},
+		{"not a url", true},
+	}
+	for _, tt := range tests {
+		err := p.Check(tt.url)
+		if got := errors.Is(err, ErrBlocked); got != tt.blocked {
+			t.Errorf("Check(%q) = %v, want blocked %v", tt.url, err, tt.blocked)
+		}
+	}
+}
+
+func TestCheck_DenyWithoutAllow(t *testing.T) {
+	p, err := New(nil, []string{"evil.test"}, "")
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if err := p.Check("https://anything.example/"); err != nil {
+		t.Errorf("Check of an unlisted domain = %v, want nil", err)
+	}
+	if err := p.Check("https://www.evil.test/login"); !errors.Is(err, ErrBlocked) {
+		t.Errorf("Check of a denied subdomain = %v, want ErrBlocked", err)
+	}
+}
+
+func TestCheck_Blocklist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	writeBlocklist(t, path,
+		"# phishing",
+		prefix("phish.test/", 4),
+		"",
+		prefix("files.example.com/malware/", 8),
+		prefix("example.net/exact.html?id=1", 32),
+	)
+	p, err := New(nil, nil, path)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	tests := []struct {
+		url     string
+		blocked bool
+	}{
+		{"https://phish.test/", true},
+		{"http://login.secure.phish.test/account/verify?u=1", true},
+		{"https://files.example.com/malware/a/b.exe", true},
+		{"https://files.example.com/malware", false},
+		{"https://files.example.com/other/", false},
+		{"https://example.net/exact.html?id=1", true},
+		{"https://example.net/exact.html?id=2", false},
+		{"https://example.com/", false},
+	}
+	for _, tt := range tests {
+		err := p.Check(tt.url)
+		if got := errors.Is(err, ErrBlocked); got != tt.blocked {
+			t.Errorf("Check(%q) = %v, want blocked %v", tt.url, err, tt.blocked)
+		}
+	}
+}
+
+func TestExpressions(t *testing.T) {
+	got := expressions(mustParse(t, "http://a.b.c.d.e.f.g/1/2.html?param=1"))
+	want := []string{
+		"a.b.c.d.e.f.g/1/2.html?param=1", "a.b.c.d.e.f.g/1/2.html", "a.b.c.d.e.f.g/", "a.b.c.d.e.f.g/1/",
+		"c.d.e.f.g/1/2.html?param=1", "c.d.e.f.g/1/2.html", "c.d.e.f.g/", "c.d.e.f.g/1/",
+		"d.e.f.g/1/2.html?param=1", "d.e.f.g/1/2.html", "d.e.f.g/", "d.e.f.g/1/",
+		"e.f.g/1/2.html?param=1", "e.f.g/1/2.html", "e.f.g/", "e.f.g/1/",
+		"f.g/1/2.html?param=1", "f.g/1/2.html", "f.g/", "f.g/1/",
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("expressions =\n%v\nwant\n%v", got, want)
+	}
+
+	if got := expressions(mustParse(t, "http://1.2.3.4/")); len(got) != 1 || got[0] != "1.2.3.4/" {
+		t.Errorf("expressions of an IP = %v, want [1.2.3.4/]", got)
+	}
+}
+
+func TestNew_RejectsMalformedBlocklist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	writeBlocklist(t, path, prefix("ok.test/", 4), "abc")
+	if _, err := New(nil, nil, path); err == nil || !strings.Contains(err.Error(), ":2:") {
+		t.Fatalf("New with a malformed line = %v, want an error naming line 2", err)
+	}
+	if _, err := New(nil, nil, filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Fatal("New with a missing blocklist succeeded")
+	}
+}
+
+func TestWatch_ReloadsChangedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	writeBlocklist(t, path, prefix("first.test/", 4))
+	p, err := New(nil, nil, path)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.Watch(ctx, 10*time.Millisecond)
+
+	writeBlocklist(t, path, prefix("second.test/", 4), prefix("third.test/", 4))
+	deadline := time.Now().Add(2 * time.Second)
+	for p.Check("https://second.test/") == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("blocklist was not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := p.Check("https://first.test/"); err != nil {
+		t.Errorf("Check of an entry dropped on reload = %v, want nil", err)
+	}
+
+	// a broken update leaves the last good blocklist in place
+	writeBlocklist(t, path, "zz")
+	time.Sleep(50 * time.Millisecond)
+	if err := p.Check("https://second.test/"); !errors.Is(err, ErrBlocked) {
+		t.Errorf("Check after a broken update = %v, want ErrBlocked", err)
+	}
+}
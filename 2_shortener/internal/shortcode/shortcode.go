@@ -0,0 +1,147 @@
+// Package shortcode turns sequence numbers into short codes that don't give away the order they were made in.
+package shortcode
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+	"strings"
+)
+
+const (
+	// DefaultMinLength is the length of the first codes handed out.
+	DefaultMinLength = 6
+	// MaxLength is the length of the last codes there are; 62^10 of them still fit a uint64 comfortably.
+	MaxLength = 10
+
+	alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	// rounds is the number of Feistel rounds; four make a keyed pseudo-random permutation.
+	rounds = 4
+)
+
+// ErrExhausted is returned for numbers past the last code of MaxLength.
+var ErrExhausted = errors.New("shortcode: no codes left")
+
+// pow62[l] is the number of codes of length l.
+var pow62 [MaxLength + 1]uint64
+
+func init() {
+	pow62[0] = 1
+	for l := 1; l <= MaxLength; l++ {
+		pow62[l] = pow62[l-1] * 62
+	}
+}
+
+// Options configures a Codec.
+type Options struct {
+	// Secret keys the permutation. Codes can only be told apart from random ones by someone who knows it,
+	// and changing it reshuffles which code each number gets.
+	Secret string
+	// MinLength is the length of the first codes, DefaultMinLength if zero. Codes grow a character longer
+	// each time every code of the current length has been handed out.
+	MinLength int
+	// Reserved are words codes must not contain, compared ignoring case.
+	Reserved []string
+}
+
+// Codec maps sequence numbers to short codes one-to-one.
+//
+// Numbers are split into blocks, one per code length: the first 62^MinLength numbers get codes of MinLength
+// characters, the next 62^(MinLength+1) one character longer, and so on. Within a block the number is
+// shuffled by a keyed Feistel permutation of the block, then written in base62, padded to the block's length.
+// Codes of different lengths are different strings and the permutation is a bijection, so different numbers
+// always get different codes, and a sequence never needs to retry.
+type Codec struct {
+	key       [sha256.Size]byte
+	minLength int
+	reserved  []string
+}
+
+// New returns a codec with the options.
+func New(opts Options) (*Codec, error) {
+	if opts.MinLength == 0 {
+		opts.MinLength = DefaultMinLength
+	}
+	if opts.MinLength < 1 || opts.MinLength > MaxLength {
+		return nil, fmt.Errorf("shortcode: min length must be 1 to %d, got %d", MaxLength, opts.MinLength)
+	}
+	c := &Codec{key: sha256.Sum256([]byte(opts.Secret)), minLength: opts.MinLength}
+	for _, w := range opts.Reserved {
+		if w = strings.ToLower(strings.TrimSpace(w)); w != "" {
+			c.reserved = append(c.reserved, w)
+		}
+	}
+	return c, nil
+}
+
+// Encode returns the code of sequence number n.
+func (c *Codec) Encode(n uint64) (string, error) {
+	start := uint64(0)
+	for l := c.minLength; l <= MaxLength; l++ {
+		if n-start < pow62[l] {
+			return format(c.permute(n-start, l), l), nil
+		}
+		start += pow62[l]
+	}
+	return "", ErrExhausted
+}
+
+// Reserved reports whether code contains a reserved word and must not be handed out.
+func (c *Codec) Reserved(code string) bool {
+	if len(c.reserved) == 0 {
+		return false
+	}
+	code = strings.ToLower(code)
+	for _, w := range c.reserved {
+		if strings.Contains(code, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// permute shuffles x within [0, 62^l). The Feistel network permutes the smallest even power of two that
+// holds the block; results outside the block are encrypted again ("cycle walking") until they land in it,
+// which keeps the mapping a bijection of the block. The power of two is less than four times the block, so
+// that takes under four rounds on average.
+func (c *Codec) permute(x uint64, l int) uint64 {
+	half := (bits.Len64(pow62[l]-1) + 1) / 2
+	for {
+		x = c.feistel(x, l, half)
+		if x < pow62[l] {
+			return x
+		}
+	}
+}
+
+// feistel encrypts x, a number of 2*half bits, with a balanced Feistel network.
+func (c *Codec) feistel(x uint64, l, half int) uint64 {
+	mask := uint64(1)<<half - 1
+	left, right := x>>half, x&mask
+	for r := 0; r < rounds; r++ {
+		left, right = right, left^(c.round(l, r, right)&mask)
+	}
+	return left<<half | right
+}
+
+// round is the keyed round function: a hash of the key, the block, the round and the input.
+func (c *Codec) round(l, r int, x uint64) uint64 {
+	var buf [sha256.Size + 10]byte
+	copy(buf[:], c.key[:])
+	buf[sha256.Size], buf[sha256.Size+1] = byte(l), byte(r)
+	binary.BigEndian.PutUint64(buf[sha256.Size+2:], x)
+	sum := sha256.Sum256(buf[:])
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+// format writes x in base62, padded with leading zeros to l characters.
+func format(x uint64, l int) string {
+	out := make([]byte, l)
+	for i := l - 1; i >= 0; i-- {
+		out[i] = alphabet[x%62]
+		x /= 62
+	}
+	return string(out)
+}
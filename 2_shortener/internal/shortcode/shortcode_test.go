@@ -0,0 +1,175 @@
+package shortcode
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func mustNew(t testing.TB, opts Options) *Codec {
+	t.Helper()
+	c, err := New(opts)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return c
+}
+
+func TestEncode_BijectionAndGrowth(t *testing.T) {
+	c := mustNew(t, Options{Secret: "s3cret", MinLength: 1})
+
+	// every number of the 1-, 2- and 3-character blocks gets its own code of the block's length
+	seen := make(map[string]bool)
+	total := pow62[1] + pow62[2] + pow62[3]
+	for n := uint64(0); n < total; n++ {
+		code, err := c.Encode(n)
+		if err != nil {
+			t.Fatalf("Encode(%d): %v", n, err)
+		}
+		want := 1
+		if n >= pow62[1] {
+			want = 2
+		}
+		if n >= pow62[1]+pow62[2] {
+			want = 3
+		}
+		if len(code) != want {
+			t.Fatalf("Encode(%d) = %q, want %d characters", n, code, want)
+		}
+		if seen[code] {
+			t.Fatalf("Encode(%d) = %q, handed out twice", n, code)
+		}
+		seen[code] = true
+	}
+	if code, _ := c.Encode(total); len(code) != 4 {
+		t.Fatalf("expected the code after the 3-character block to be 4 characters, got %q", code)
+	}
+}
+
+func TestEncode_NotSequential(t *testing.T) {
+	c := mustNew(t, Options{Secret: "s3cret"})
+	other := mustNew(t, Options{Secret: "other"})
+
+	prev, _ := c.Encode(1000)
+	same := 0
+	for n := uint64(1001); n < 1100; n++ {
+		code, err := c.Encode(n)
+		if err != nil {
+			t.Fatalf("Encode(%d): %v", n, err)
+		}
+		if len(code) != DefaultMinLength {
+			t.Fatalf("Encode(%d) = %q, want %d characters", n, code, DefaultMinLength)
+		}
+		// consecutive numbers should share no more of a prefix than random codes do
+		if code[:2] == prev[:2] {
+			same++
+		}
+		if again, _ := c.Encode(n); again != code {
+			t.Fatalf("Encode(%d) is not deterministic: %q then %q", n, code, again)
+		}
+		if o, _ := other.Encode(n); o == code {
+			t.Fatalf("Encode(%d) = %q under both secrets", n, code)
+		}
+		prev = code
+	}
+	if same > 3 {
+		t.Fatalf("%d of 99 consecutive codes share their first two characters", same)
+	}
+}
+
+func TestEncode_Exhausted(t *testing.T) {
+	c := mustNew(t, Options{MinLength: MaxLength})
+	if _, err := c.Encode(pow62[MaxLength] - 1); err != nil {
+		t.Fatalf("expected the last code to exist, got %v", err)
+	}
+	if _, err := c.Encode(pow62[MaxLength]); err != ErrExhausted {
+		t.Fatalf("expected ErrExhausted, got %v", err)
+	}
+}
+
+func TestNew_RejectsBadLength(t *testing.T) {
+	for _, l := range []int{-1, MaxLength + 1} {
+		if _, err := New(Options{MinLength: l}); err == nil {
+			t.Errorf("New with min length %d succeeded", l)
+		}
+	}
+}
+
+func TestReserved(t *testing.T) {
+	c := mustNew(t, Options{Reserved: []string{" Admin ", "", "xyz"}})
+	tests := map[string]bool{
+		"aDMINx": true,
+		"00xYz0": true,
+		"adm1n0": false,
+		"abc123": false,
+	}
+	for code, want := range tests {
+		if got := c.Reserved(code); got != want {
+			t.Errorf("Reserved(%q) = %v, want %v", code, got, want)
+		}
+	}
+	if mustNew(t, Options{}).Reserved("admin") {
+		t.Error("expected nothing reserved without reserved words")
+	}
+}
+
+func TestFormat(t *testing.T) {
+	if got := format(61, 3); got != "00z" {
+		t.Fatalf("format(61, 3) = %q, want 00z", got)
+	}
+	if got := format(pow62[4]-1, 4); got != strings.Repeat("z", 4) {
+		t.Fatalf("format of the last 4-character number = %q, want zzzz", got)
+	}
+}
+
+// BenchmarkSequence hands out codes from a sequence already a billion links in, the way the storage does,
+// and reports how many had to be retried because they were taken. A bijection never repeats itself, so it
+// is always zero.
+func BenchmarkSequence(b *testing.B) {
+	c := mustNew(b, Options{Secret: "bench"})
+	const start = 1_000_000_000
+	taken := make(map[string]struct{}, b.N)
+	retries := 0
+	b.ResetTimer()
+	for n := uint64(start); n < start+uint64(b.N); n++ {
+		code, err := c.Encode(n)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, ok := taken[code]; ok {
+			retries++
+		}
+		taken[code] = struct{}{}
+	}
+	b.ReportMetric(float64(retries)/float64(b.N), "retries/op")
+}
+
+// BenchmarkRandomHex is the generator the sequence replaced, six random hex digits, on a table of five
+// million links: new codes already take around half a retry each, and more as the table fills up.
+func BenchmarkRandomHex(b *testing.B) {
+	const existing = 5_000_000
+	taken := make(map[string]struct{}, existing+b.N)
+	random := func() string {
+		var raw [3]byte
+		_, _ = rand.Read(raw[:])
+		return hex.EncodeToString(raw[:])
+	}
+	for len(taken) < existing {
+		taken[random()] = struct{}{}
+	}
+	retries := 0
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		code := random()
+		for {
+			if _, ok := taken[code]; !ok {
+				break
+			}
+			retries++
+			code = random()
+		}
+		taken[code] = struct{}{}
+	}
+	b.ReportMetric(float64(retries)/float64(b.N), "retries/op")
+}
@@ -2,9 +2,12 @@ package cached
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"shortener/internal/domain"
 	"shortener/internal/storage"
+	"strconv"
 	"time"
 
 	// sorry, i am not using wb-go/wbf/redis because it is shit and does not support TTL
@@ -12,17 +15,154 @@ import (
 )
 
 const (
-	ttl     = 24 * time.Hour
-	keyLink = "link:%s"
-	keyHits = "hits:%s"
+	ttl         = 24 * time.Hour
+	versionTTL  = 2 * ttl
+	keyLink     = "link:%s"
+	keyVersion  = "ver:%s"
+	keyAttempts = "attempts:%s"
+	// keyFreq is a sorted set of the link keys clicked in a frequency window, by their clicks, per window number.
+	keyFreq = "freq:%d"
+	// keyCached is the set of the link keys in the cache, which eviction samples.
+	keyCached = "cached"
+	// keyLive counts the clicks of a link in a live slot, per slot number.
+	keyLive = "live:%s:%d"
+	// unknown is what link:{key} holds for a key no link has.
+	unknown = "-"
 )
 
+// Defaults for the options.
+const (
+	DefaultCapacity    = 100000
+	DefaultWindow      = 10 * time.Minute
+	DefaultMinHits     = 10
+	DefaultNegativeTTL = time.Minute
+)
+
+// LiveWindow is how far back RecentClicks can count. Clicks are counted in slots of liveSlot, so windows are
+// exact to the slot.
+const (
+	LiveWindow = 5 * time.Minute
+	liveSlot   = 10 * time.Second
+)
+
+// evictionSamples is how many cached links are looked at to find one to evict.
+const evictionSamples = 5
+
+// countClick counts a click in the live slot and the current frequency window, and returns the clicks of the
+// link over the sliding window: the current window plus the part of the previous one it still overlaps.
+var countClick = redis.NewScript(`
+redis.call('INCR', KEYS[1])
+redis.call('PEXPIRE', KEYS[1], ARGV[2])
+local current = tonumber(redis.call('ZINCRBY', KEYS[2], 1, ARGV[1]))
+redis.call('PEXPIRE', KEYS[2], ARGV[3])
+local previous = tonumber(redis.call('ZSCORE', KEYS[3], ARGV[1]) or 0)
+return tostring(current + tonumber(ARGV[4]) * previous)
+`)
+
+// admit caches the link if it has been clicked at least the minimum over the sliding window, unless it was
+// invalidated after the caller read the version, so a redirect that loaded the link before a PATCH/DELETE
+// cannot put the stale destination back. When the cache is full, a few cached links are sampled, like Redis
+// does for its own LFU eviction, and the least clicked one makes room if it was clicked less than the link.
+var admit = redis.NewScript(`
+if (redis.call('GET', KEYS[2]) or '0') ~= ARGV[2] then
+	return 0
+end
+local hits = tonumber(ARGV[5])
+if hits < tonumber(ARGV[6]) then
+	return 0
+end
+local capacity = tonumber(ARGV[7])
+if capacity > 0 and redis.call('SISMEMBER', KEYS[3], ARGV[4]) == 0 then
+	local size = redis.call('SCARD', KEYS[3])
+	if size >= capacity then
+		local victim, least
+		for _, member in ipairs(redis.call('SRANDMEMBER', KEYS[3], ARGV[9])) do
+			if redis.call('EXISTS', 'link:' .. member) == 0 then
+				-- the link expired from the cache on its own and left its room
+				redis.call('SREM', KEYS[3], member)
+				size = size - 1
+			else
+				local current = tonumber(redis.call('ZSCORE', KEYS[4], member) or 0)
+				local previous = tonumber(redis.call('ZSCORE', KEYS[5], member) or 0)
+				local clicks = current + tonumber(ARGV[8]) * previous
+				if least == nil or clicks < least then
+					victim, least = member, clicks
+				end
+			end
+		end
+		if size >= capacity then
+			if least == nil or least >= hits then
+				return 0
+			end
+			redis.call('SREM', KEYS[3], victim)
+			redis.call('DEL', 'link:' .. victim)
+		end
+	end
+end
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[3])
+redis.call('SADD', KEYS[3], ARGV[4])
+return 1
+`)
+
+// setUnknown remembers that a key has no link, unless a link was made under it after the caller read the
+// version. It never replaces a cached link.
+var setUnknown = redis.NewScript(`
+if (redis.call('GET', KEYS[2]) or '0') ~= ARGV[1] then
+	return 0
+end
+if redis.call('SET', KEYS[1], ARGV[3], 'PX', ARGV[2], 'NX') then
+	return 1
+end
+return 0
+`)
+
+// forgetAttempt decrements an attempt counter without creating it, or its expiry, if the window is over.
+var forgetAttempt = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	return redis.call('DECR', KEYS[1])
+end
+return 0
+`)
+
+// entry is the cached representation of a link.
+// It carries everything the redirect path needs to decide without touching Postgres.
+type entry struct {
+	URL       string     `json:"url"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	MaxClicks *int64     `json:"max_clicks,omitempty"`
+	// PasswordHash keeps protected links behind their prompt on cache hits.
+	PasswordHash string           `json:"password_hash,omitempty"`
+	Variants     []domain.Variant `json:"variants,omitempty"`
+	Rules        []domain.Rule    `json:"rules,omitempty"`
+	// DisabledAt keeps disabled links down on cache hits.
+	DisabledAt *time.Time `json:"disabled_at,omitempty"`
+	// RedirectType and Card keep cache hits redirecting, and previewing, as the owner set them.
+	RedirectType int          `json:"redirect_type,omitempty"`
+	Card         *domain.Card `json:"card,omitempty"`
+}
+
+// Options holds the tuning of the cache.
+type Options struct {
+	// Capacity is how many links the cache holds before admitting one evicts another. Zero means
+	// DefaultCapacity, a negative value no limit.
+	Capacity int
+	// Window is the length of the windows clicks are counted in; a link's clicks are counted over the
+	// sliding window of that length. Zero means DefaultWindow.
+	Window time.Duration
+	// MinHits is how many clicks within the window a link needs to be cached. Zero means DefaultMinHits.
+	MinHits int
+	// NegativeTTL is how long a key no link has is remembered as such. Zero means DefaultNegativeTTL.
+	NegativeTTL time.Duration
+}
+
 // Redis is an implementation of the CacheStorage interface.
 type Redis struct {
 	client *redis.Client
+	opts   Options
+	now    func() time.Time
 }
 
-// New creates a new Redis instance.
+// New creates a new Redis instance with the default options.
 func New(ctx context.Context, addr, password string, db int) (*Redis, error) {
 	client := redis.NewClient(&redis.Options{
 		Addr:     addr,
@@ -32,7 +172,27 @@ func New(ctx context.Context, addr, password string, db int) (*Redis, error) {
 	if err := client.Ping(ctx).Err(); err != nil {
 		return nil, err
 	}
-	return &Redis{client: client}, nil
+	r := &Redis{client: client, now: time.Now}
+	r.Configure(Options{})
+	return r, nil
+}
+
+// Configure sets the options of the cache, filling in the defaults. Every instance sharing the Redis
+// database should use the same ones.
+func (r *Redis) Configure(opts Options) {
+	if opts.Capacity == 0 {
+		opts.Capacity = DefaultCapacity
+	}
+	if opts.Window <= 0 {
+		opts.Window = DefaultWindow
+	}
+	if opts.MinHits <= 0 {
+		opts.MinHits = DefaultMinHits
+	}
+	if opts.NegativeTTL <= 0 {
+		opts.NegativeTTL = DefaultNegativeTTL
+	}
+	r.opts = opts
 }
 
 // Close closes the Redis client.
@@ -40,29 +200,204 @@ func (r *Redis) Close() error {
 	return r.client.Close()
 }
 
-// GetURL gets the URL from the Redis client.
-func (r *Redis) GetURL(ctx context.Context, shortCode string) (string, error) {
-	keyL := fmt.Sprintf(keyLink, shortCode)
+// GetLink gets the link with the given key from the Redis client. It returns storage.ErrNotFound if the link
+// is not cached, and storage.ErrUnknownLink if the key is remembered as having no link.
+func (r *Redis) GetLink(ctx context.Context, key string) (domain.ShortenedURL, error) {
+	keyL := fmt.Sprintf(keyLink, key)
 
-	url, err := r.client.Get(ctx, keyL).Result()
+	raw, err := r.client.Get(ctx, keyL).Bytes()
 	if err != nil {
 		if errors.Is(err, redis.Nil) {
-			return "", storage.ErrNotFound
+			return domain.ShortenedURL{}, storage.ErrNotFound
 		}
-		return "", err
+		return domain.ShortenedURL{}, err
+	}
+	if string(raw) == unknown {
+		return domain.ShortenedURL{}, storage.ErrUnknownLink
 	}
-	go r.client.Incr(ctx, fmt.Sprintf(keyHits, shortCode))
-	return url, nil
+	var e entry
+	if err := json.Unmarshal(raw, &e); err != nil {
+		// entries written before links carried metadata are plain URLs; treat them as a miss
+		return domain.ShortenedURL{}, storage.ErrNotFound
+	}
+	host, shortCode := domain.SplitLinkKey(key)
+	return domain.ShortenedURL{
+		URL:          e.URL,
+		ShortCode:    shortCode,
+		Domain:       host,
+		ExpiresAt:    e.ExpiresAt,
+		MaxClicks:    e.MaxClicks,
+		PasswordHash: e.PasswordHash,
+		Variants:     e.Variants,
+		Rules:        e.Rules,
+		DisabledAt:   e.DisabledAt,
+		RedirectType: e.RedirectType,
+		Card:         e.Card,
+	}, nil
 }
 
-// SetURL sets the URL in the Redis client.
-func (r *Redis) SetURL(ctx context.Context, shortCode, url string, usage int64) error {
-	keyL := fmt.Sprintf(keyLink, shortCode)
-	keyH := fmt.Sprintf(keyHits, shortCode)
+// SetLink sets the link in the Redis client unconditionally, whatever its clicks and the room left.
+// Links with an expiration date never outlive it in the cache.
+func (r *Redis) SetLink(ctx context.Context, link domain.ShortenedURL) error {
+	raw, exp, err := encode(link)
+	if err != nil {
+		return err
+	}
+	if exp <= 0 {
+		return r.DeleteLink(ctx, link.Key())
+	}
 
 	pipe := r.client.TxPipeline()
-	pipe.Set(ctx, keyL, url, ttl)
-	pipe.Set(ctx, keyH, usage, ttl)
+	pipe.Set(ctx, fmt.Sprintf(keyLink, link.Key()), raw, exp)
+	pipe.SAdd(ctx, keyCached, link.Key())
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// CountClick counts a click of the link and returns its clicks over the sliding window, this one included.
+func (r *Redis) CountClick(ctx context.Context, key string) (float64, error) {
+	now := r.now()
+	current, previous, weight := r.windows(now)
+	keys := []string{fmt.Sprintf(keyLive, key, now.UnixMilli()/liveSlot.Milliseconds()), current, previous}
+	args := []any{key, (LiveWindow + liveSlot).Milliseconds(), (2 * r.opts.Window).Milliseconds(), weight}
+	return countClick.Run(ctx, r.client, keys, args...).Float64()
+}
+
+// RecentClicks returns the clicks of the link within the last window, as counted by CountClick. The window
+// is at most LiveWindow.
+func (r *Redis) RecentClicks(ctx context.Context, key string, window time.Duration) (int64, error) {
+	slot := r.now().UnixMilli() / liveSlot.Milliseconds()
+	keys := make([]string, max(min(window, LiveWindow)/liveSlot, 1))
+	for i := range keys {
+		keys[i] = fmt.Sprintf(keyLive, key, slot-int64(i))
+	}
+	counts, err := r.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, count := range counts {
+		if s, ok := count.(string); ok {
+			n, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			total += n
+		}
+	}
+	return total, nil
+}
+
+// windows returns the keys of the current and previous frequency windows at t, and how much of the previous
+// window the sliding one still overlaps: all of it as the current window starts, none as it ends.
+func (r *Redis) windows(t time.Time) (current, previous string, weight float64) {
+	size := r.opts.Window.Milliseconds()
+	n := t.UnixMilli() / size
+	elapsed := float64(t.UnixMilli()%size) / float64(size)
+	return fmt.Sprintf(keyFreq, n), fmt.Sprintf(keyFreq, n-1), 1 - elapsed
+}
+
+// LinkVersion returns the invalidation counter of the link. Read it before loading the link from the database
+// and pass it to AdmitLink or SetUnknown.
+func (r *Redis) LinkVersion(ctx context.Context, key string) (int64, error) {
+	v, err := r.client.Get(ctx, fmt.Sprintf(keyVersion, key)).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return v, err
+}
+
+// AdmitLink caches the link if hits, its clicks over the sliding window as CountClick returned them, reach
+// the minimum, and it wasn't invalidated after version was read. If the cache is full, the least clicked of
+// a few sampled links is evicted to make room, unless it was clicked as much as this one. It reports whether
+// the link was cached.
+func (r *Redis) AdmitLink(ctx context.Context, link domain.ShortenedURL, hits float64, version int64) (bool, error) {
+	raw, exp, err := encode(link)
+	if err != nil {
+		return false, err
+	}
+	if exp <= 0 {
+		return false, nil
+	}
+
+	current, previous, weight := r.windows(r.now())
+	keys := []string{
+		fmt.Sprintf(keyLink, link.Key()),
+		fmt.Sprintf(keyVersion, link.Key()),
+		keyCached,
+		current,
+		previous,
+	}
+	args := []any{raw, version, exp.Milliseconds(), link.Key(), hits, r.opts.MinHits, r.opts.Capacity, weight, evictionSamples}
+	admitted, err := admit.Run(ctx, r.client, keys, args...).Int()
+	return admitted == 1, err
+}
+
+// SetUnknown remembers for the negative TTL that no link has the key, so lookups of it don't reach the
+// database, unless the key was invalidated after version was read.
+func (r *Redis) SetUnknown(ctx context.Context, key string, version int64) error {
+	keys := []string{fmt.Sprintf(keyLink, key), fmt.Sprintf(keyVersion, key)}
+	return setUnknown.Run(ctx, r.client, keys, version, r.opts.NegativeTTL.Milliseconds(), unknown).Err()
+}
+
+// DeleteLink removes the links, or what is remembered about keys without one, from the Redis client and
+// bumps their versions, so in-flight AdmitLink and SetUnknown calls that loaded them before are discarded.
+func (r *Redis) DeleteLink(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	pipe := r.client.TxPipeline()
+	for _, key := range keys {
+		keyV := fmt.Sprintf(keyVersion, key)
+		pipe.Del(ctx, fmt.Sprintf(keyLink, key))
+		pipe.Incr(ctx, keyV)
+		pipe.Expire(ctx, keyV, versionTTL)
+	}
+	members := make([]any, len(keys))
+	for i, key := range keys {
+		members[i] = key
+	}
+	pipe.SRem(ctx, keyCached, members...)
 	_, err := pipe.Exec(ctx)
 	return err
 }
+
+// CountAttempt counts a password attempt of the client and returns the attempts in its window,
+// which starts with the first attempt and lasts window, and how long the window has left.
+func (r *Redis) CountAttempt(ctx context.Context, client string, window time.Duration) (int64, time.Duration, error) {
+	keyA := fmt.Sprintf(keyAttempts, client)
+
+	pipe := r.client.TxPipeline()
+	incr := pipe.Incr(ctx, keyA)
+	pipe.ExpireNX(ctx, keyA, window)
+	pttl := pipe.PTTL(ctx, keyA)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, 0, err
+	}
+	return incr.Val(), max(pttl.Val(), 0), nil
+}
+
+// ForgetAttempt takes back a password attempt of the client that was successful.
+func (r *Redis) ForgetAttempt(ctx context.Context, client string) error {
+	return forgetAttempt.Run(ctx, r.client, []string{fmt.Sprintf(keyAttempts, client)}).Err()
+}
+
+// encode marshals the link and computes its TTL. A non-positive TTL means the link is already expired.
+func encode(link domain.ShortenedURL) ([]byte, time.Duration, error) {
+	exp := ttl
+	if link.ExpiresAt != nil {
+		exp = min(exp, time.Until(*link.ExpiresAt))
+	}
+	raw, err := json.Marshal(entry{
+		URL:          link.URL,
+		ExpiresAt:    link.ExpiresAt,
+		MaxClicks:    link.MaxClicks,
+		PasswordHash: link.PasswordHash,
+		Variants:     link.Variants,
+		Rules:        link.Rules,
+		DisabledAt:   link.DisabledAt,
+		RedirectType: link.RedirectType,
+		Card:         link.Card,
+	})
+	return raw, exp, err
+}
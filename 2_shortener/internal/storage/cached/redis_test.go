@@ -3,7 +3,9 @@ package cached
 import (
 	"context"
 	"testing"
+	"time"
 
+	"shortener/internal/domain"
 	"shortener/internal/storage"
 
 	"github.com/stretchr/testify/assert"
@@ -67,16 +69,16 @@ func TestRedis_GetURL_Integration(t *testing.T) {
 	expectedURL := "https://example.com"
 
 	// Set URL
-	err = redis.SetURL(ctx, shortCode, expectedURL, 1)
+	err = redis.SetLink(ctx, domain.ShortenedURL{ShortCode: shortCode, URL: expectedURL})
 	require.NoError(t, err)
 
 	// Get URL
-	url, err := redis.GetURL(ctx, shortCode)
+	link, err := redis.GetLink(ctx, shortCode)
 	require.NoError(t, err)
-	assert.Equal(t, expectedURL, url)
+	assert.Equal(t, expectedURL, link.URL)
 
 	// Test getting non-existent URL
-	_, err = redis.GetURL(ctx, "nonexistent")
+	_, err = redis.GetLink(ctx, "nonexistent")
 	assert.ErrorIs(t, err, storage.ErrNotFound)
 }
 
@@ -95,35 +97,31 @@ func TestRedis_SetURL_Integration(t *testing.T) {
 		name        string
 		shortCode   string
 		url         string
-		usage       int64
 		expectError bool
 	}{
 		{
 			name:        "valid url",
 			shortCode:   "abc123",
 			url:         "https://example.com",
-			usage:       5,
 			expectError: false,
 		},
 		{
 			name:        "empty short code",
 			shortCode:   "",
 			url:         "https://example.com",
-			usage:       0,
 			expectError: false,
 		},
 		{
 			name:        "empty url",
 			shortCode:   "abc123",
 			url:         "",
-			usage:       1,
 			expectError: false,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := redis.SetURL(ctx, tt.shortCode, tt.url, tt.usage)
+			err := redis.SetLink(ctx, domain.ShortenedURL{ShortCode: tt.shortCode, URL: tt.url})
 
 			if tt.expectError {
 				assert.Error(t, err)
@@ -132,9 +130,9 @@ func TestRedis_SetURL_Integration(t *testing.T) {
 
 				// Verify we can retrieve the URL
 				if tt.shortCode != "" {
-					url, err := redis.GetURL(ctx, tt.shortCode)
+					link, err := redis.GetLink(ctx, tt.shortCode)
 					assert.NoError(t, err)
-					assert.Equal(t, tt.url, url)
+					assert.Equal(t, tt.url, link.URL)
 				}
 			}
 		})
@@ -175,19 +173,19 @@ func TestRedis_Integration_Workflow(t *testing.T) {
 
 	// Set URLs
 	for shortCode, url := range testData {
-		err := redis.SetURL(ctx, shortCode, url, 1)
+		err := redis.SetLink(ctx, domain.ShortenedURL{ShortCode: shortCode, URL: url})
 		require.NoError(t, err)
 	}
 
 	// Get URLs and verify
 	for shortCode, expectedURL := range testData {
-		url, err := redis.GetURL(ctx, shortCode)
+		link, err := redis.GetLink(ctx, shortCode)
 		require.NoError(t, err)
-		assert.Equal(t, expectedURL, url)
+		assert.Equal(t, expectedURL, link.URL)
 	}
 
 	// Test getting a non-existent URL
-	_, err = redis.GetURL(ctx, "nonexistent")
+	_, err = redis.GetLink(ctx, "nonexistent")
 	assert.ErrorIs(t, err, storage.ErrNotFound)
 }
 
@@ -203,7 +201,7 @@ func TestRedis_ConcurrentAccess_Integration(t *testing.T) {
 	defer redis.Close()
 
 	// Set initial data
-	err = redis.SetURL(ctx, "concurrent", "https://concurrent.com", 0)
+	err = redis.SetLink(ctx, domain.ShortenedURL{ShortCode: "concurrent", URL: "https://concurrent.com"})
 	require.NoError(t, err)
 
 	// Create multiple goroutines to access the same URL
@@ -212,9 +210,9 @@ func TestRedis_ConcurrentAccess_Integration(t *testing.T) {
 
 	for i := 0; i < numGoroutines; i++ {
 		go func() {
-			url, err := redis.GetURL(ctx, "concurrent")
+			link, err := redis.GetLink(ctx, "concurrent")
 			assert.NoError(t, err)
-			assert.Equal(t, "https://concurrent.com", url)
+			assert.Equal(t, "https://concurrent.com", link.URL)
 			done <- true
 		}()
 	}
@@ -237,13 +235,13 @@ func TestRedis_TTL_Integration(t *testing.T) {
 	defer redis.Close()
 
 	// Set URL
-	err = redis.SetURL(ctx, "ttltest", "https://ttl.com", 5)
+	err = redis.SetLink(ctx, domain.ShortenedURL{ShortCode: "ttltest", URL: "https://ttl.com"})
 	require.NoError(t, err)
 
 	// Verify we can retrieve it immediately
-	url, err := redis.GetURL(ctx, "ttltest")
+	link, err := redis.GetLink(ctx, "ttltest")
 	require.NoError(t, err)
-	assert.Equal(t, "https://ttl.com", url)
+	assert.Equal(t, "https://ttl.com", link.URL)
 }
 
 // TestRedis_KeyFormat_Integration tests the key format consistency
@@ -261,13 +259,189 @@ func TestRedis_KeyFormat_Integration(t *testing.T) {
 	expectedURL := "https://test.com"
 
 	// Set URL
-	err = redis.SetURL(ctx, shortCode, expectedURL, 1)
+	err = redis.SetLink(ctx, domain.ShortenedURL{ShortCode: shortCode, URL: expectedURL})
 	require.NoError(t, err)
 
 	// Get URL and verify
-	url, err := redis.GetURL(ctx, shortCode)
+	link, err := redis.GetLink(ctx, shortCode)
 	require.NoError(t, err)
-	assert.Equal(t, expectedURL, url)
+	assert.Equal(t, expectedURL, link.URL)
+}
+
+// TestRedis_Metadata_Integration tests that link metadata survives the cache round trip
+func TestRedis_Metadata_Integration(t *testing.T) {
+	ctx := context.Background()
+
+	// Try to connect to Redis
+	redis, err := New(ctx, "localhost:6379", "", 0)
+	if err != nil {
+		t.Skip("Redis not available, skipping integration test")
+	}
+	defer redis.Close()
+
+	expires := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	budget := int64(3)
+	err = redis.SetLink(ctx, domain.ShortenedURL{
+		ShortCode: "meta", URL: "https://meta.com", ExpiresAt: &expires, MaxClicks: &budget, PasswordHash: "$2a$10$hash",
+		RedirectType: 308, Card: &domain.Card{Title: "Meta"},
+	})
+	require.NoError(t, err)
+
+	link, err := redis.GetLink(ctx, "meta")
+	require.NoError(t, err)
+	require.NotNil(t, link.ExpiresAt)
+	assert.True(t, expires.Equal(*link.ExpiresAt))
+	require.NotNil(t, link.MaxClicks)
+	assert.Equal(t, budget, *link.MaxClicks)
+	assert.True(t, link.Protected())
+	assert.Equal(t, 308, link.RedirectStatus())
+	assert.Equal(t, &domain.Card{Title: "Meta"}, link.Card)
+
+	// Deleting the link makes it a miss immediately
+	require.NoError(t, redis.DeleteLink(ctx, "meta"))
+	_, err = redis.GetLink(ctx, "meta")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+// TestRedis_AdmitLink_Integration tests that an invalidation discards in-flight cache fills
+func TestRedis_AdmitLink_Integration(t *testing.T) {
+	ctx := context.Background()
+
+	// Try to connect to Redis
+	redis, err := New(ctx, "localhost:6379", "", 0)
+	if err != nil {
+		t.Skip("Redis not available, skipping integration test")
+	}
+	defer redis.Close()
+
+	version, err := redis.LinkVersion(ctx, "stale")
+	require.NoError(t, err)
+
+	// The link is updated (and invalidated) after the version was read
+	require.NoError(t, redis.DeleteLink(ctx, "stale"))
+
+	admitted, err := redis.AdmitLink(ctx, domain.ShortenedURL{ShortCode: "stale", URL: "https://old.com"}, DefaultMinHits, version)
+	require.NoError(t, err)
+	assert.False(t, admitted)
+	_, err = redis.GetLink(ctx, "stale")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	// A fill with the current version goes through, once the link has been clicked enough
+	version, err = redis.LinkVersion(ctx, "stale")
+	require.NoError(t, err)
+	admitted, err = redis.AdmitLink(ctx, domain.ShortenedURL{ShortCode: "stale", URL: "https://new.com"}, DefaultMinHits-1, version)
+	require.NoError(t, err)
+	assert.False(t, admitted)
+	admitted, err = redis.AdmitLink(ctx, domain.ShortenedURL{ShortCode: "stale", URL: "https://new.com"}, DefaultMinHits, version)
+	require.NoError(t, err)
+	assert.True(t, admitted)
+	link, err := redis.GetLink(ctx, "stale")
+	require.NoError(t, err)
+	assert.Equal(t, "https://new.com", link.URL)
+}
+
+// TestRedis_Eviction_Integration tests that a full cache evicts its least clicked link for a more clicked one
+func TestRedis_Eviction_Integration(t *testing.T) {
+	ctx := context.Background()
+
+	redis, err := New(ctx, "localhost:6379", "", 15)
+	if err != nil {
+		t.Skip("Redis not available, skipping integration test")
+	}
+	defer redis.Close()
+	require.NoError(t, redis.client.FlushDB(ctx).Err())
+
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	redis.now = func() time.Time { return now }
+	redis.Configure(Options{Capacity: 2, MinHits: 2})
+
+	click := func(key string, n int) float64 {
+		var hits float64
+		for range n {
+			hits, err = redis.CountClick(ctx, key)
+			require.NoError(t, err)
+		}
+		return hits
+	}
+	admit := func(key string, hits float64) bool {
+		admitted, err := redis.AdmitLink(ctx, domain.ShortenedURL{ShortCode: key, URL: "https://" + key + ".com"}, hits, 0)
+		require.NoError(t, err)
+		return admitted
+	}
+
+	assert.True(t, admit("hot", click("hot", 5)))
+	assert.True(t, admit("warm", click("warm", 3)))
+	// the cache is full and both cached links were clicked more
+	assert.False(t, admit("cold", click("cold", 2)))
+	// warm is now the least clicked
+	assert.True(t, admit("busy", click("busy", 4)))
+	_, err = redis.GetLink(ctx, "warm")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	_, err = redis.GetLink(ctx, "hot")
+	assert.NoError(t, err)
+
+	// clicks of the previous window fade out as the current one goes by
+	now = now.Add(DefaultWindow + DefaultWindow/2)
+	hits := click("hot", 1)
+	assert.InDelta(t, 1+0.5*5, hits, 0.01)
+}
+
+// TestRedis_Unknown_Integration tests negative caching and that making the link forgets it
+func TestRedis_Unknown_Integration(t *testing.T) {
+	ctx := context.Background()
+
+	redis, err := New(ctx, "localhost:6379", "", 0)
+	if err != nil {
+		t.Skip("Redis not available, skipping integration test")
+	}
+	defer redis.Close()
+	require.NoError(t, redis.DeleteLink(ctx, "nobody"))
+
+	version, err := redis.LinkVersion(ctx, "nobody")
+	require.NoError(t, err)
+	require.NoError(t, redis.SetUnknown(ctx, "nobody", version))
+	_, err = redis.GetLink(ctx, "nobody")
+	assert.ErrorIs(t, err, storage.ErrUnknownLink)
+
+	// a link made under the key afterwards is found straight away, and a late SetUnknown can't hide it
+	require.NoError(t, redis.DeleteLink(ctx, "nobody"))
+	_, err = redis.GetLink(ctx, "nobody")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	require.NoError(t, redis.SetUnknown(ctx, "nobody", version))
+	_, err = redis.GetLink(ctx, "nobody")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+// TestRedis_RecentClicks_Integration tests the live click counter
+func TestRedis_RecentClicks_Integration(t *testing.T) {
+	ctx := context.Background()
+
+	redis, err := New(ctx, "localhost:6379", "", 15)
+	if err != nil {
+		t.Skip("Redis not available, skipping integration test")
+	}
+	defer redis.Close()
+	require.NoError(t, redis.client.FlushDB(ctx).Err())
+
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	redis.now = func() time.Time { return now }
+	for range 3 {
+		_, err := redis.CountClick(ctx, "live")
+		require.NoError(t, err)
+	}
+	now = now.Add(4 * time.Minute)
+	_, err = redis.CountClick(ctx, "live")
+	require.NoError(t, err)
+
+	clicks, err := redis.RecentClicks(ctx, "live", LiveWindow)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), clicks)
+
+	// the first three fall out of the window
+	now = now.Add(time.Minute + liveSlot)
+	clicks, err = redis.RecentClicks(ctx, "live", LiveWindow)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), clicks)
 }
 
 // TestRedis_ErrorHandling_Integration tests error scenarios
@@ -282,3 +456,33 @@ func TestRedis_ErrorHandling_Integration(t *testing.T) {
 	_, err = New(ctx, "localhost:9999", "", 0)
 	assert.Error(t, err)
 }
+
+// TestRedis_Attempts_Integration tests the password attempt counter window
+func TestRedis_Attempts_Integration(t *testing.T) {
+	ctx := context.Background()
+
+	redis, err := New(ctx, "localhost:6379", "", 0)
+	if err != nil {
+		t.Skip("Redis not available, skipping integration test")
+	}
+	defer redis.Close()
+	defer redis.client.Del(ctx, "attempts:198.51.100.7")
+
+	for i := int64(1); i <= 3; i++ {
+		n, left, err := redis.CountAttempt(ctx, "198.51.100.7", time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, i, n)
+		// the window starts at the first attempt and later ones don't extend it
+		assert.True(t, left > 0 && left <= time.Minute, "unexpected window %v", left)
+	}
+
+	require.NoError(t, redis.ForgetAttempt(ctx, "198.51.100.7"))
+	n, _, err := redis.CountAttempt(ctx, "198.51.100.7", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+
+	// forgetting after the window is over must not leave a counter without an expiry behind
+	redis.client.Del(ctx, "attempts:198.51.100.7")
+	require.NoError(t, redis.ForgetAttempt(ctx, "198.51.100.7"))
+	assert.Zero(t, redis.client.Exists(ctx, "attempts:198.51.100.7").Val())
+}
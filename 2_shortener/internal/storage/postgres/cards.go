@@ -0,0 +1,46 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"shortener/internal/domain"
+	"shortener/internal/storage"
+)
+
+// DestinationCard returns the Open Graph metadata fetched from url and when it was fetched.
+// It returns storage.ErrNotFound if url was never fetched.
+func (s *Storage) DestinationCard(ctx context.Context, url string) (domain.Card, time.Time, error) {
+	const q = `SELECT title, description, image, fetched_at FROM destination_cards WHERE url = $1`
+
+	r, err := s.db.QueryWithRetry(ctx, Strategy, q, url)
+	if err != nil {
+		return domain.Card{}, time.Time{}, err
+	}
+	defer r.Close()
+
+	if !r.Next() {
+		if err := r.Err(); err != nil {
+			return domain.Card{}, time.Time{}, err
+		}
+		return domain.Card{}, time.Time{}, storage.ErrNotFound
+	}
+	var card domain.Card
+	var fetchedAt time.Time
+	if err := r.Scan(&card.Title, &card.Description, &card.Image, &fetchedAt); err != nil {
+		return domain.Card{}, time.Time{}, err
+	}
+	return card, fetchedAt, nil
+}
+
+// SaveDestinationCard stores the metadata fetched from url at fetchedAt, replacing what was fetched before.
+func (s *Storage) SaveDestinationCard(ctx context.Context, url string, card domain.Card, fetchedAt time.Time) error {
+	const q = `
+		INSERT INTO destination_cards (url, title, description, image, fetched_at) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (url) DO UPDATE SET
+			title = EXCLUDED.title, description = EXCLUDED.description, image = EXCLUDED.image, fetched_at = EXCLUDED.fetched_at
+	`
+
+	_, err := s.db.ExecWithRetry(ctx, Strategy, q, url, card.Title, card.Description, card.Image, fetchedAt)
+	return err
+}
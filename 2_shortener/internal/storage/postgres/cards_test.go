@@ -0,0 +1,44 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"shortener/internal/domain"
+	"shortener/internal/storage"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestDestinationCard(t *testing.T) {
+	s, mock, done := newClickStorage(t)
+	defer done()
+
+	fetchedAt := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+	cols := []string{"title", "description", "image", "fetched_at"}
+	mock.ExpectQuery(`SELECT title, description, image, fetched_at FROM destination_cards`).
+		WithArgs("https://example.com").
+		WillReturnRows(sqlmock.NewRows(cols).AddRow("Example", "", "https://example.com/og.png", fetchedAt))
+	mock.ExpectQuery(`SELECT title, description, image, fetched_at FROM destination_cards`).
+		WithArgs("https://example.org").
+		WillReturnRows(sqlmock.NewRows(cols))
+	mock.ExpectExec(`INSERT INTO destination_cards[\s\S]*ON CONFLICT \(url\) DO UPDATE`).
+		WithArgs("https://example.org", "", "", "", fetchedAt).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	card, at, err := s.DestinationCard(context.Background(), "https://example.com")
+	if err != nil || card != (domain.Card{Title: "Example", Image: "https://example.com/og.png"}) || !at.Equal(fetchedAt) {
+		t.Fatalf("unexpected card %+v fetched at %v: %v", card, at, err)
+	}
+	if _, _, err := s.DestinationCard(context.Background(), "https://example.org"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if err := s.SaveDestinationCard(context.Background(), "https://example.org", domain.Card{}, fetchedAt); err != nil {
+		t.Fatalf("SaveDestinationCard: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
@@ -2,7 +2,10 @@ package postgres
 
 import (
 	"context"
+	"database/sql"
 	"errors"
+	"net"
+	"strings"
 	"time"
 
 	"shortener/internal/domain"
@@ -11,28 +14,114 @@ import (
 
 const topLimit = 20
 
-// SaveClick stores a click event for a short code.
-func (s *Storage) SaveClick(ctx context.Context, c domain.Click) error {
+// clickColumns are the columns written for every click, in the order of clickValues.
+// The casts let them be used in a VALUES list, where Postgres can't infer the types.
+var clickColumns = []struct{ name, cast string }{
+	{"short_code", ""},
+	{"user_agent", ""},
+	{"ip", "::inet"},
+	{"referer", ""},
+	{"source", ""},
+	{"timestamp", "::timestamptz"},
+	{"browser", ""},
+	{"browser_version", ""},
+	{"os", ""},
+	{"device", ""},
+	{"is_bot", "::boolean"},
+	{"country", ""},
+	{"region", ""},
+	{"city", ""},
+	{"variant", ""},
+	{"rule", ""},
+}
+
+func clickValues(c domain.Click) []any {
 	if c.Timestamp.IsZero() {
 		c.Timestamp = time.Now().UTC()
 	}
+	return []any{
+		c.ShortCode, c.UserAgent, c.IP, c.Referer, c.Source, c.Timestamp,
+		c.Browser, c.BrowserVersion, c.OS, c.Device, c.IsBot,
+		c.Country, c.Region, c.City, c.Variant, c.Rule,
+	}
+}
 
-	const insert = `
-		INSERT INTO clicks (
-			short_code, user_agent, ip, referer, timestamp
-		) VALUES (
-			$1, $2, $3, $4, $5
-		)`
+// SaveClick stores a click event for a short code.
+func (s *Storage) SaveClick(ctx context.Context, c domain.Click) error {
+	names := make([]string, len(clickColumns))
+	params := make([]string, len(clickColumns))
+	for i, col := range clickColumns {
+		names[i] = col.name
+		params[i] = "$" + itoa(i+1)
+	}
+	insert := `INSERT INTO clicks (` + strings.Join(names, ", ") + `) VALUES (` + strings.Join(params, ", ") + `)`
 
-	_, err := s.db.ExecWithRetry(
-		ctx,
-		Strategy,
-		insert,
-		c.ShortCode, c.UserAgent, c.IP, c.Referer, c.Timestamp,
-	)
+	_, err := s.db.ExecWithRetry(ctx, Strategy, insert, clickValues(c)...)
 	return err
 }
 
+// clicksPerInsert caps the rows of one multi-row INSERT, well below the 65535 parameter limit.
+const clicksPerInsert = 1000
+
+// SaveClicks stores a batch of click events with multi-row inserts. A single bad click must not
+// fail the whole batch, so clicks without a valid IP and clicks of links deleted in the meantime are skipped.
+func (s *Storage) SaveClicks(ctx context.Context, clicks []domain.Click) error {
+	valid := make([]domain.Click, 0, len(clicks))
+	for _, c := range clicks {
+		if net.ParseIP(c.IP) != nil {
+			valid = append(valid, c)
+		}
+	}
+
+	names := make([]string, len(clickColumns))
+	for i, col := range clickColumns {
+		names[i] = col.name
+	}
+	columns := strings.Join(names, ", ")
+
+	for len(valid) > 0 {
+		n := min(len(valid), clicksPerInsert)
+		chunk := valid[:n]
+		valid = valid[n:]
+
+		var q strings.Builder
+		q.WriteString(`INSERT INTO clicks (` + columns + `) SELECT v.* FROM (VALUES `)
+		args := make([]any, 0, len(chunk)*len(clickColumns))
+		for i, c := range chunk {
+			if i > 0 {
+				q.WriteString(", ")
+			}
+			q.WriteString("(")
+			for j, col := range clickColumns {
+				if j > 0 {
+					q.WriteString(", ")
+				}
+				q.WriteString("$" + itoa(len(args)+j+1) + col.cast)
+			}
+			q.WriteString(")")
+			args = append(args, clickValues(c)...)
+		}
+		q.WriteString(`) AS v (` + columns + `)
+		WHERE EXISTS (SELECT 1 FROM shortened_urls u WHERE u.link_key = v.short_code)`)
+
+		if _, err := s.db.ExecWithRetry(ctx, Strategy, q.String(), args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clickSelect is the column list read back for a click, in the order of scanClick.
+const clickSelect = `id, short_code, user_agent, ip, referer, source, timestamp,
+	browser, browser_version, os, device, is_bot, country, region, city, variant, rule`
+
+func scanClick(r *sql.Rows) (domain.Click, error) {
+	var c domain.Click
+	err := r.Scan(&c.ID, &c.ShortCode, &c.UserAgent, &c.IP, &c.Referer, &c.Source, &c.Timestamp,
+		&c.Browser, &c.BrowserVersion, &c.OS, &c.Device, &c.IsBot, &c.Country, &c.Region, &c.City, &c.Variant, &c.Rule)
+	return c, err
+}
+
 // GetClicks returns a paginated list of clicks for the given short code.
 func (s *Storage) GetClicks(ctx context.Context, shortCode string, limit, offset int) ([]domain.Click, error) {
 	if limit <= 0 {
@@ -42,8 +131,7 @@ func (s *Storage) GetClicks(ctx context.Context, shortCode string, limit, offset
 		offset = 0
 	}
 
-	const q = `
-		SELECT id, short_code, user_agent, ip, referer, timestamp
+	const q = `SELECT ` + clickSelect + `
 		FROM clicks
 		WHERE short_code = $1
 		ORDER BY timestamp DESC
@@ -58,8 +146,8 @@ func (s *Storage) GetClicks(ctx context.Context, shortCode string, limit, offset
 
 	var out []domain.Click
 	for rows.Next() {
-		var c domain.Click
-		if err := rows.Scan(&c.ID, &c.ShortCode, &c.UserAgent, &c.IP, &c.Referer, &c.Timestamp); err != nil {
+		c, err := scanClick(rows)
+		if err != nil {
 			return nil, err
 		}
 		out = append(out, c)
@@ -67,6 +155,99 @@ func (s *Storage) GetClicks(ctx context.Context, shortCode string, limit, offset
 	return out, rows.Err()
 }
 
+// ClicksPage returns up to limit clicks for the given short code, newest first, starting after the cursor.
+// A nil cursor starts at the newest click. Unlike offsets, the cursor stays put while new clicks come in.
+func (s *Storage) ClicksPage(ctx context.Context, shortCode string, after *domain.ClickCursor, limit int) ([]domain.Click, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+	var ts, id any
+	if after != nil {
+		ts, id = after.Timestamp, after.ID
+	}
+
+	const q = `SELECT ` + clickSelect + `
+		FROM clicks
+		WHERE short_code = $1 AND ($2::timestamptz IS NULL OR (timestamp, id) < ($2, $3::uuid))
+		ORDER BY timestamp DESC, id DESC
+		LIMIT $4
+	`
+
+	rows, err := s.db.QueryWithRetry(ctx, Strategy, q, shortCode, ts, id, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]domain.Click, 0, limit)
+	for rows.Next() {
+		c, err := scanClick(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// exportBatch is how many rows ExportClicks fetches from the cursor at a time.
+const exportBatch = 1000
+
+// ExportClicks calls fn for every click of the given short code in the optional [start, end] range, oldest first.
+// The rows come from a server-side cursor in batches of exportBatch, so neither side holds the whole result.
+// An error from fn stops the export and is returned.
+func (s *Storage) ExportClicks(ctx context.Context, shortCode string, start, end *time.Time, fn func(domain.Click) error) error {
+	// cursors only live as long as their transaction
+	tx, err := s.db.Master.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	const declare = `DECLARE click_export NO SCROLL CURSOR FOR
+		SELECT ` + clickSelect + `
+		FROM clicks
+		WHERE short_code = $1
+			AND ($2::timestamptz IS NULL OR timestamp >= $2)
+			AND ($3::timestamptz IS NULL OR timestamp <= $3)
+		ORDER BY timestamp, id`
+	if _, err := tx.ExecContext(ctx, declare, shortCode, start, end); err != nil {
+		return err
+	}
+
+	for {
+		n, err := fetchClicks(ctx, tx, fn)
+		if err != nil {
+			return err
+		}
+		if n < exportBatch {
+			return tx.Commit()
+		}
+	}
+}
+
+// fetchClicks passes the next batch of the export cursor to fn and returns how many rows it had.
+func fetchClicks(ctx context.Context, tx *sql.Tx, fn func(domain.Click) error) (int, error) {
+	rows, err := tx.QueryContext(ctx, `FETCH `+itoa(exportBatch)+` FROM click_export`)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	n := 0
+	for rows.Next() {
+		c, err := scanClick(rows)
+		if err != nil {
+			return n, err
+		}
+		if err := fn(c); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, rows.Err()
+}
+
 // ClickCount returns the total number of clicks for the given short code.
 func (s *Storage) ClickCount(ctx context.Context, shortCode string) (int64, error) {
 	const q = `SELECT COUNT(*) FROM clicks WHERE short_code = $1`
@@ -215,14 +396,10 @@ func (s *Storage) ClicksByUserAgent(ctx context.Context, shortCode string, start
 }
 
 // Analytics builds a composite analytics response for a short code and optional time range.
-func (s *Storage) Analytics(ctx context.Context, shortCode string, from, to *time.Time, topLimit int) (domain.AnalyticsResponse, error) {
-	var start, end *time.Time
-	if from != nil && !from.IsZero() {
-		start = from
-	}
-	if to != nil && !to.IsZero() {
-		end = to
-	}
+// Bots are left out of the total and unique counts unless includeBots is set. Whole hours below the rollup watermark are read from the hourly rollups and the rest from the raw clicks,
+// so the result is the same as aggregating the raw clicks alone.
+func (s *Storage) Analytics(ctx context.Context, shortCode string, from, to *time.Time, topLimit int, includeBots bool) (domain.AnalyticsResponse, error) {
+	start, end := analyticsRange(from, to)
 
 	if _, err := s.GetURL(ctx, shortCode); err != nil {
 		if errors.Is(err, storage.ErrNotFound) {
@@ -231,47 +408,73 @@ func (s *Storage) Analytics(ctx context.Context, shortCode string, from, to *tim
 		return domain.AnalyticsResponse{}, err
 	}
 
-	total, err := s.ClickCount(ctx, shortCode)
+	rolled, err := s.rolledUntil(ctx)
 	if err != nil {
 		return domain.AnalyticsResponse{}, err
 	}
-	unique, err := s.UniqueClickCount(ctx, shortCode)
+	resp, err := s.analytics(ctx, linkScope(shortCode), start, end, rolled, topLimit, includeBots)
 	if err != nil {
 		return domain.AnalyticsResponse{}, err
 	}
-	byDay, err := s.ClicksByDay(ctx, shortCode, start, end)
-	if err != nil {
-		return domain.AnalyticsResponse{}, err
-	}
-	byMonth, err := s.ClicksByMonth(ctx, shortCode, start, end)
-	if err != nil {
+	resp.ShortCode = shortCode
+	if resp.Variants, err = s.variantStats(ctx, shortCode, start, end, newRollupWindow(start, end, rolled), includeBots); err != nil {
 		return domain.AnalyticsResponse{}, err
 	}
-	ua, err := s.ClicksByUserAgent(ctx, shortCode, start, end, topLimit)
-	if err != nil {
-		return domain.AnalyticsResponse{}, err
+	return resp, nil
+}
+
+// analyticsRange returns the range of an analytics request, where nil and zero times mean unbounded.
+func analyticsRange(from, to *time.Time) (start, end *time.Time) {
+	if from != nil && !from.IsZero() {
+		start = from
 	}
-	referers, err := s.ClicksByReferer(ctx, shortCode, start, end, topLimit)
-	if err != nil {
-		return domain.AnalyticsResponse{}, err
+	if to != nil && !to.IsZero() {
+		end = to
 	}
-	ips, err := s.ClicksByIP(ctx, shortCode, start, end, topLimit)
+	return start, end
+}
+
+// analytics computes the totals and breakdowns of the clicks of the scope, with rollups up to rolled.
+func (s *Storage) analytics(ctx context.Context, sc scope, start, end *time.Time, rolled time.Time, topLimit int, includeBots bool) (domain.AnalyticsResponse, error) {
+	// total and unique clicks are all-time figures
+	total, unique, bots, err := s.rollupTotals(ctx, sc, newRollupWindow(nil, nil, rolled), includeBots)
 	if err != nil {
 		return domain.AnalyticsResponse{}, err
 	}
 
-	return domain.AnalyticsResponse{
-		ShortCode:     shortCode,
-		TotalClicks:   total,
-		UniqueClicks:  unique,
-		ClicksByDay:   byDay,
-		ClicksByMonth: byMonth,
-		TopUserAgents: ua,
-		TopReferers:   referers,
-		TopIPs:        ips,
-		From:          start,
-		To:            end,
-	}, nil
+	w := newRollupWindow(start, end, rolled)
+	resp := domain.AnalyticsResponse{
+		TotalClicks:  total,
+		UniqueClicks: unique,
+		BotClicks:    bots,
+		From:         start,
+		To:           end,
+	}
+	for _, b := range []struct {
+		dst   *map[string]int64
+		b     breakdown
+		limit int
+	}{
+		{&resp.ClicksByDay, byDay, 0},
+		{&resp.ClicksByMonth, byMonth, 0},
+		{&resp.TopUserAgents, byUserAgent, topLimit},
+		{&resp.TopReferers, byReferer, topLimit},
+		{&resp.TopIPs, byIP, topLimit},
+		{&resp.ClicksBySource, bySource, 0},
+		{&resp.ClicksByBrowser, byBrowser, topLimit},
+		{&resp.ClicksByOS, byOS, topLimit},
+		{&resp.ClicksByDevice, byDevice, 0},
+		{&resp.ClicksByCountry, byCountry, topLimit},
+		{&resp.ClicksByCity, byCity, topLimit},
+		{&resp.ClicksByRule, byRule, 0},
+	} {
+		res, err := s.rollupCounts(ctx, sc, b.b, start, end, w, b.limit)
+		if err != nil {
+			return domain.AnalyticsResponse{}, err
+		}
+		*b.dst = res
+	}
+	return resp, nil
 }
 
 // ClicksByReferer aggregates click counts by referer. Empty referers are grouped as "(direct)".
@@ -352,6 +555,41 @@ func (s *Storage) ClicksByIP(ctx context.Context, shortCode string, start, end *
 	return res, r.Err()
 }
 
+// ClicksBySource aggregates click counts by source. Clicks without a source are grouped as "(link)".
+func (s *Storage) ClicksBySource(ctx context.Context, shortCode string, start, end *time.Time) (map[string]int64, error) {
+	base := `SELECT COALESCE(NULLIF(source, ''), '(link)') AS src, COUNT(*) FROM clicks WHERE short_code = $1`
+	args := []any{shortCode}
+	idx := 2
+	if start != nil && !start.IsZero() {
+		base += ` AND timestamp >= $` + itoa(idx)
+		args = append(args, start)
+		idx++
+	}
+	if end != nil && !end.IsZero() {
+		base += ` AND timestamp <= $` + itoa(idx)
+		args = append(args, end)
+		idx++
+	}
+	base += ` GROUP BY src ORDER BY COUNT(*) DESC`
+
+	r, err := s.db.QueryWithRetry(ctx, Strategy, base, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	res := make(map[string]int64)
+	for r.Next() {
+		var k string
+		var v int64
+		if err := r.Scan(&k, &v); err != nil {
+			return nil, err
+		}
+		res[k] = v
+	}
+	return res, r.Err()
+}
+
 // itoa converts an int to string without importing strconv to keep deps minimal here.
 func itoa(i int) string {
 	if i < 10 {
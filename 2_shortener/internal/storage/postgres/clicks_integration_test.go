@@ -0,0 +1,71 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"shortener/internal/domain"
+)
+
+func TestClicksPageAndExport_Integration(t *testing.T) {
+	s := newIntegrationStorage(t)
+	ctx := context.Background()
+
+	if _, err := s.SaveURL(ctx, domain.ShortenedURL{URL: "https://example.com", ShortCode: "fixture"}); err != nil {
+		t.Fatalf("failed to save url: %v", err)
+	}
+	// pairs of clicks share a timestamp, so pages must break ties by id
+	base := time.Now().UTC().Truncate(time.Second).Add(-time.Hour)
+	var clicks []domain.Click
+	for i := 0; i < 2*exportBatch+3; i++ {
+		clicks = append(clicks, domain.Click{
+			ShortCode: "fixture",
+			IP:        fmt.Sprintf("10.0.0.%d", i%250),
+			Timestamp: base.Add(time.Duration(i/2) * time.Second),
+		})
+	}
+	if err := s.SaveClicks(ctx, clicks); err != nil {
+		t.Fatalf("failed to save clicks: %v", err)
+	}
+
+	seen := make(map[string]bool)
+	var after *domain.ClickCursor
+	var last time.Time
+	for {
+		page, err := s.ClicksPage(ctx, "fixture", after, 7)
+		if err != nil {
+			t.Fatalf("ClicksPage: %v", err)
+		}
+		if len(page) == 0 {
+			break
+		}
+		for _, c := range page {
+			if seen[c.ID] {
+				t.Fatalf("click %s returned twice", c.ID)
+			}
+			if !last.IsZero() && c.Timestamp.After(last) {
+				t.Fatalf("clicks out of order: %v after %v", c.Timestamp, last)
+			}
+			seen[c.ID], last = true, c.Timestamp
+		}
+		c := page[len(page)-1]
+		after = &domain.ClickCursor{Timestamp: c.Timestamp, ID: c.ID}
+	}
+	if len(seen) != len(clicks) {
+		t.Fatalf("expected %d paged clicks, got %d", len(clicks), len(seen))
+	}
+
+	from, to := base.Add(10*time.Second), base.Add(20*time.Second)
+	exported := 0
+	err := s.ExportClicks(ctx, "fixture", nil, nil, func(domain.Click) error { exported++; return nil })
+	if err != nil || exported != len(clicks) {
+		t.Fatalf("expected %d exported clicks, got %d: %v", len(clicks), exported, err)
+	}
+	exported = 0
+	err = s.ExportClicks(ctx, "fixture", &from, &to, func(domain.Click) error { exported++; return nil })
+	if err != nil || exported != 22 {
+		t.Fatalf("expected 22 exported clicks in range, got %d: %v", exported, err)
+	}
+}
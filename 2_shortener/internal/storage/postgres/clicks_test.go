@@ -2,8 +2,10 @@ package postgres
 
 import (
 	"context"
+	"database/sql/driver"
 	"regexp"
 	"testing"
+	"time"
 
 	"shortener/internal/domain"
 
@@ -26,10 +28,16 @@ func TestSaveClick(t *testing.T) {
 
 	insRe := regexp.MustCompile(`INSERT\s+INTO\s+clicks`)
 	mock.ExpectExec(insRe.String()).
-		WithArgs("abc123", "ua", "127.0.0.1", "", sqlmock.AnyArg()).
+		WithArgs("abc123", "ua", "127.0.0.1", "", "", sqlmock.AnyArg(), "Chrome", "120.0", "Windows", "desktop", false, "DE", "Berlin", "Berlin", "b", "ios").
 		WillReturnResult(sqlmock.NewResult(0, 1))
 
-	err := s.SaveClick(context.Background(), domain.Click{ShortCode: "abc123", UserAgent: "ua", IP: "127.0.0.1", Referer: ""})
+	err := s.SaveClick(context.Background(), domain.Click{
+		ShortCode: "abc123", UserAgent: "ua", IP: "127.0.0.1", Referer: "",
+		Client:   domain.Client{Browser: "Chrome", BrowserVersion: "120.0", OS: "Windows", Device: "desktop"},
+		Location: domain.Location{Country: "DE", Region: "Berlin", City: "Berlin"},
+		Variant:  "b",
+		Rule:     "ios",
+	})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -38,6 +46,36 @@ func TestSaveClick(t *testing.T) {
 	}
 }
 
+func TestSaveClicks_MultiRow(t *testing.T) {
+	s, mock, done := newClickStorage(t)
+	defer done()
+
+	// the click without an IP is skipped, leaving one full chunk and one single row
+	clicks := make([]domain.Click, clicksPerInsert+2)
+	for i := range clicks {
+		clicks[i] = domain.Click{ShortCode: "abc123", UserAgent: "ua", IP: "127.0.0.1"}
+	}
+	clicks[0].IP = ""
+
+	var first []driver.Value
+	for i := 0; i < clicksPerInsert; i++ {
+		first = append(first, "abc123", "ua", "127.0.0.1", "", "", sqlmock.AnyArg(), "", "", "", "", false, "", "", "", "", "")
+	}
+	mock.ExpectExec(regexp.QuoteMeta(`FROM (VALUES ($1, $2, $3::inet, $4, $5, $6::timestamptz, $7, $8, $9, $10, $11::boolean, $12, $13, $14, $15, $16), ($17,`)).
+		WithArgs(first...).
+		WillReturnResult(sqlmock.NewResult(0, clicksPerInsert))
+	mock.ExpectExec(regexp.QuoteMeta(`FROM (VALUES ($1, $2, $3::inet, $4, $5, $6::timestamptz, $7, $8, $9, $10, $11::boolean, $12, $13, $14, $15, $16)) AS v`)).
+		WithArgs("abc123", "ua", "127.0.0.1", "", "", sqlmock.AnyArg(), "", "", "", "", false, "", "", "", "", "").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := s.SaveClicks(context.Background(), clicks); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
 func TestClicksByDay(t *testing.T) {
 	s, mock, done := newClickStorage(t)
 	defer done()
@@ -62,65 +100,121 @@ func TestAnalytics(t *testing.T) {
 	s, mock, done := newClickStorage(t)
 	defer done()
 
-	mock.ExpectQuery(regexp.QuoteMeta(`SELECT url FROM shortened_urls WHERE short_code = $1`)).
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT url FROM shortened_urls WHERE link_key = $1`)).
 		WithArgs("abc123").
 		WillReturnRows(sqlmock.NewRows([]string{"url"}).AddRow("https://example.com"))
 
-	// ClickCount
-	mock.ExpectQuery(regexp.QuoteMeta(`SELECT COUNT(*) FROM clicks WHERE short_code = $1`)).
-		WithArgs("abc123").
-		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(int64(10)))
+	rolled := time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT rolled_until FROM click_rollup_state`)).
+		WillReturnRows(sqlmock.NewRows([]string{"rolled_until"}).AddRow(rolled))
+
+	// Totals are all-time: every rolled-up hour plus the raw clicks after the watermark, bots left out
+	mock.ExpectQuery(`SELECT \(SELECT COALESCE\(SUM\(CASE WHEN \$3 THEN clicks ELSE clicks - bot_clicks END\), 0\)`).
+		WithArgs("abc123", rolled, false).
+		WillReturnRows(sqlmock.NewRows([]string{"total", "unique", "bots"}).AddRow(int64(10), int64(5), int64(3)))
+
+	epoch := time.Unix(0, 0).UTC()
+	breakdowns := []struct {
+		rollup string
+		limit  bool
+		row    [2]any
+	}{
+		{`TO_CHAR\(hour, 'YYYY-MM-DD'\) AS k, clicks AS n FROM click_rollups_hourly`, false, [2]any{"2025-01-01", int64(1)}},
+		{`TO_CHAR\(date_trunc\('month', hour\), 'YYYY-MM'\) AS k, clicks AS n FROM click_rollups_hourly`, false, [2]any{"2025-01", int64(1)}},
+		{`value AS k, clicks AS n FROM click_rollup_dims WHERE short_code = \$1 AND dim = 'user_agent'`, true, [2]any{"ua", int64(1)}},
+		{`value AS k, clicks AS n FROM click_rollup_dims WHERE short_code = \$1 AND dim = 'referer'`, true, [2]any{"(direct)", int64(1)}},
+		{`ip::text AS k, clicks AS n FROM click_rollup_ips`, true, [2]any{"127.0.0.1", int64(1)}},
+		{`value AS k, clicks AS n FROM click_rollup_dims WHERE short_code = \$1 AND dim = 'source'`, false, [2]any{"qr", int64(1)}},
+		{`value AS k, clicks AS n FROM click_rollup_dims WHERE short_code = \$1 AND dim = 'browser'`, true, [2]any{"Chrome", int64(1)}},
+		{`value AS k, clicks AS n FROM click_rollup_dims WHERE short_code = \$1 AND dim = 'os'`, true, [2]any{"Windows", int64(1)}},
+		{`value AS k, clicks AS n FROM click_rollup_dims WHERE short_code = \$1 AND dim = 'device'`, false, [2]any{"desktop", int64(1)}},
+		{`value AS k, clicks AS n FROM click_rollup_dims WHERE short_code = \$1 AND dim = 'country'`, true, [2]any{"DE", int64(1)}},
+		{`value AS k, clicks AS n FROM click_rollup_dims WHERE short_code = \$1 AND dim = 'city'`, true, [2]any{"Berlin, DE", int64(1)}},
+		{`value AS k, clicks AS n FROM click_rollup_dims WHERE short_code = \$1 AND dim = 'rule'`, false, [2]any{"ios", int64(1)}},
+	}
+	for _, b := range breakdowns {
+		args := []driver.Value{"abc123", nil, nil, epoch, rolled}
+		if b.limit {
+			args = append(args, 10)
+		}
+		mock.ExpectQuery(`SELECT k, SUM\(n\)::bigint FROM \( SELECT ` + b.rollup).
+			WithArgs(args...).
+			WillReturnRows(sqlmock.NewRows([]string{"k", "n"}).AddRow(b.row[0], b.row[1]))
+	}
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT variant, SUM(n)::bigint, (COUNT(DISTINCT NULLIF(ip, '0.0.0.0'::inet)) + SUM(v))::bigint`)).
+		WithArgs("abc123", nil, nil, epoch, rolled, false).
+		WillReturnRows(sqlmock.NewRows([]string{"variant", "clicks", "unique"}).AddRow("a", int64(4), int64(2)))
 
-	// UniqueClickCount
-	mock.ExpectQuery(regexp.QuoteMeta(`SELECT COUNT(DISTINCT ip) FROM clicks WHERE short_code = $1`)).
-		WithArgs("abc123").
-		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(int64(5)))
+	resp, err := s.Analytics(context.Background(), "abc123", nil, nil, 10, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.TotalClicks != 10 || resp.UniqueClicks != 5 || resp.BotClicks != 3 || resp.ClicksBySource["qr"] != 1 ||
+		resp.TopIPs["127.0.0.1"] != 1 || resp.ClicksByDevice["desktop"] != 1 ||
+		resp.ClicksByCountry["DE"] != 1 || resp.ClicksByCity["Berlin, DE"] != 1 || resp.ClicksByRule["ios"] != 1 ||
+		resp.Variants["a"] != (domain.VariantStats{Clicks: 4, UniqueClicks: 2}) {
+		t.Fatalf("unexpected analytics numbers: %+v", resp)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
 
-	// ByDay
-	mock.ExpectQuery(regexp.QuoteMeta(
-		"SELECT TO_CHAR(timestamp, 'YYYY-MM-DD') AS day, COUNT(*) " +
-			"FROM clicks WHERE short_code = $1 GROUP BY day ORDER BY day",
-	)).
-		WithArgs("abc123").
-		WillReturnRows(sqlmock.NewRows([]string{"day", "count"}).AddRow("2025-01-01", int64(1)))
+func TestClicksPage_Cursor(t *testing.T) {
+	s, mock, done := newClickStorage(t)
+	defer done()
 
-	// ByMonth
-	mock.ExpectQuery(regexp.QuoteMeta(
-		"SELECT TO_CHAR(date_trunc('month', timestamp), 'YYYY-MM') AS month, COUNT(*) " +
-			"FROM clicks WHERE short_code = $1 GROUP BY month ORDER BY month",
-	)).
-		WithArgs("abc123").
-		WillReturnRows(sqlmock.NewRows([]string{"month", "count"}).AddRow("2025-01", int64(1)))
-
-	// By User-Agent
-	mock.ExpectQuery(regexp.QuoteMeta(
-		"SELECT user_agent, COUNT(*) FROM clicks WHERE short_code = $1 GROUP BY user_agent ORDER BY COUNT(*) DESC LIMIT $2",
-	)).
-		WithArgs("abc123", 10).
-		WillReturnRows(sqlmock.NewRows([]string{"user_agent", "count"}).AddRow("ua", int64(1)))
-
-	// By Referer
-	mock.ExpectQuery(regexp.QuoteMeta(
-		"SELECT COALESCE(NULLIF(referer, ''), '(direct)') AS ref, COUNT(*) "+
-			"FROM clicks WHERE short_code = $1 "+
-			"GROUP BY ref ORDER BY COUNT(*) DESC LIMIT $2",
-	)).
-		WithArgs("abc123", 10).
-		WillReturnRows(sqlmock.NewRows([]string{"ref", "count"}).AddRow("(direct)", int64(1)))
-
-	// By IP
-	mock.ExpectQuery(regexp.QuoteMeta(
-		"SELECT ip::text, COUNT(*) FROM clicks WHERE short_code = $1 GROUP BY ip::text ORDER BY COUNT(*) DESC LIMIT $2",
-	)).
-		WithArgs("abc123", 10).
-		WillReturnRows(sqlmock.NewRows([]string{"ip", "count"}).AddRow("127.0.0.1", int64(1)))
-
-	resp, err := s.Analytics(context.Background(), "abc123", nil, nil, 10)
+	after := domain.ClickCursor{Timestamp: time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC), ID: "00000000-0000-0000-0000-000000000001"}
+	cols := []string{"id", "short_code", "user_agent", "ip", "referer", "source", "timestamp",
+		"browser", "browser_version", "os", "device", "is_bot", "country", "region", "city", "variant", "rule"}
+	mock.ExpectQuery(regexp.QuoteMeta(`WHERE short_code = $1 AND ($2::timestamptz IS NULL OR (timestamp, id) < ($2, $3::uuid)) ORDER BY timestamp DESC, id DESC LIMIT $4`)).
+		WithArgs("abc123", after.Timestamp, after.ID, 3).
+		WillReturnRows(sqlmock.NewRows(cols).
+			AddRow("00000000-0000-0000-0000-000000000000", "abc123", "ua", "127.0.0.1", "", "", after.Timestamp.Add(-time.Minute),
+				"", "", "", "", false, "DE", "Berlin", "Berlin", "b", ""))
+
+	clicks, err := s.ClicksPage(context.Background(), "abc123", &after, 3)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	if resp.TotalClicks != 10 || resp.UniqueClicks != 5 {
-		t.Fatalf("unexpected analytics numbers: %+v", resp)
+	if len(clicks) != 1 || clicks[0].City != "Berlin" || clicks[0].Variant != "b" {
+		t.Fatalf("unexpected clicks: %+v", clicks)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestExportClicks_FetchesInBatches(t *testing.T) {
+	s, mock, done := newClickStorage(t)
+	defer done()
+
+	cols := []string{"id", "short_code", "user_agent", "ip", "referer", "source", "timestamp",
+		"browser", "browser_version", "os", "device", "is_bot", "country", "region", "city", "variant", "rule"}
+	batch := func(n int) *sqlmock.Rows {
+		rows := sqlmock.NewRows(cols)
+		for i := 0; i < n; i++ {
+			rows.AddRow("id", "abc123", "ua", "127.0.0.1", "", "", time.Now(), "", "", "", "", false, "", "", "", "", "")
+		}
+		return rows
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`DECLARE click_export NO SCROLL CURSOR FOR SELECT`)).
+		WithArgs("abc123", nil, nil).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	// a full batch means there may be more; a short one ends the export
+	mock.ExpectQuery(regexp.QuoteMeta(`FETCH 1000 FROM click_export`)).WillReturnRows(batch(exportBatch))
+	mock.ExpectQuery(regexp.QuoteMeta(`FETCH 1000 FROM click_export`)).WillReturnRows(batch(2))
+	mock.ExpectCommit()
+
+	n := 0
+	err := s.ExportClicks(context.Background(), "abc123", nil, nil, func(domain.Click) error { n++; return nil })
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n != exportBatch+2 {
+		t.Fatalf("expected %d clicks, got %d", exportBatch+2, n)
 	}
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Fatalf("unmet expectations: %v", err)
@@ -0,0 +1,69 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+
+	"shortener/internal/shortcode"
+)
+
+// CodeGenerator makes the short codes of links saved without an alias.
+type CodeGenerator interface {
+	// Generate returns n short codes it has never returned before. They can still be taken by aliases.
+	Generate(ctx context.Context, n int) ([]string, error)
+}
+
+// SetCodeGenerator replaces the generator of short codes, by default Sequence with an unkeyed codec.
+func (s *Storage) SetCodeGenerator(g CodeGenerator) {
+	s.codes = g
+}
+
+// Sequence returns a generator that numbers links from the short_code_seq sequence and encodes the
+// numbers with codec, skipping codes with reserved words.
+func (s *Storage) Sequence(codec *shortcode.Codec) CodeGenerator {
+	return sequenceCodes{s: s, codec: codec}
+}
+
+type sequenceCodes struct {
+	s     *Storage
+	codec *shortcode.Codec
+}
+
+func (g sequenceCodes) Generate(ctx context.Context, n int) ([]string, error) {
+	// nextval is never rolled back, so a number is never handed out twice, even if its insert fails;
+	// it is a write, so it must go to the master
+	const q = `SELECT nextval('short_code_seq') FROM generate_series(1, $1)`
+
+	codes := make([]string, 0, n)
+	for len(codes) < n {
+		rows, err := g.s.db.Master.QueryContext(ctx, q, n-len(codes))
+		if err != nil {
+			return nil, err
+		}
+		drawn := 0
+		for rows.Next() {
+			drawn++
+			var v int64
+			if err := rows.Scan(&v); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			code, err := g.codec.Encode(uint64(v))
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if !g.codec.Reserved(code) {
+				codes = append(codes, code)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		if drawn == 0 {
+			return nil, errors.New("short code sequence returned no numbers")
+		}
+	}
+	return codes, nil
+}
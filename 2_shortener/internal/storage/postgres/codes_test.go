@@ -0,0 +1,125 @@
+package postgres
+
+import (
+	"context"
+	"regexp"
+	"slices"
+	"testing"
+
+	"shortener/internal/domain"
+	"shortener/internal/shortcode"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestSequence_SkipsReservedCodes(t *testing.T) {
+	s, mock, closeFn := newTestStorage(t)
+	defer closeFn()
+
+	codec, err := shortcode.New(shortcode.Options{Secret: "test"})
+	if err != nil {
+		t.Fatalf("shortcode.New: %v", err)
+	}
+	reserved, _ := codec.Encode(2)
+	codec, _ = shortcode.New(shortcode.Options{Secret: "test", Reserved: []string{reserved}})
+	s.SetCodeGenerator(s.Sequence(codec))
+
+	// the reserved code's number is used up, and one more is drawn in its place
+	nextval := regexp.QuoteMeta(`SELECT nextval('short_code_seq') FROM generate_series(1, $1)`)
+	mock.ExpectQuery(nextval).WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"nextval"}).AddRow(int64(1)).AddRow(int64(2)).AddRow(int64(3)))
+	mock.ExpectQuery(nextval).WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"nextval"}).AddRow(int64(4)))
+
+	codes, err := s.codes.Generate(context.Background(), 3)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	for i, n := range []uint64{1, 3, 4} {
+		if want, _ := codec.Encode(n); codes[i] != want {
+			t.Fatalf("expected the codes of 1, 3 and 4, got %v", codes)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestSaveURL_SequenceSkipsTakenCode(t *testing.T) {
+	s, mock, closeFn := newTestStorage(t)
+	defer closeFn()
+
+	codec, err := shortcode.New(shortcode.Options{Secret: "test"})
+	if err != nil {
+		t.Fatalf("shortcode.New: %v", err)
+	}
+	s.SetCodeGenerator(s.Sequence(codec))
+	first, _ := codec.Encode(41)
+	second, _ := codec.Encode(42)
+
+	// an alias took the code of 41, so the insert skips it and the next number is drawn
+	nextval := regexp.QuoteMeta(`SELECT nextval('short_code_seq') FROM generate_series(1, $1)`)
+	mock.ExpectQuery(nextval).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"nextval"}).AddRow(int64(41)))
+	mock.ExpectExec(`INSERT INTO shortened_urls .* ON CONFLICT \(domain, short_code\) DO NOTHING`).
+		WithArgs("https://example.com", first, sqlmock.AnyArg(), nil, nil, nil, "", nil, nil, "", 307, nil).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(nextval).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"nextval"}).AddRow(int64(42)))
+	mock.ExpectExec(`INSERT INTO shortened_urls .* ON CONFLICT \(domain, short_code\) DO NOTHING`).
+		WithArgs("https://example.com", second, sqlmock.AnyArg(), nil, nil, nil, "", nil, nil, "", 307, nil).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	code, err := s.SaveURL(context.Background(), domain.ShortenedURL{URL: "https://example.com"})
+	if err != nil || code != second {
+		t.Fatalf("expected the code of 42, %q, got %q: %v", second, code, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestSaveURLs_SequenceRetriesCollisions(t *testing.T) {
+	s, mock, closeFn := newTestStorage(t)
+	defer closeFn()
+
+	codec, err := shortcode.New(shortcode.Options{Secret: "test"})
+	if err != nil {
+		t.Fatalf("shortcode.New: %v", err)
+	}
+	s.SetCodeGenerator(s.Sequence(codec))
+	codes := make([]string, 4)
+	for i := range codes {
+		codes[i], _ = codec.Encode(uint64(i + 1))
+	}
+
+	// the code of 1 is an alias of the same batch, and the code of 2 was taken by an alias before, so both
+	// generated links are inserted again with the codes of 3 and 4
+	nextval := regexp.QuoteMeta(`SELECT nextval('short_code_seq') FROM generate_series(1, $1)`)
+	insert := `INSERT INTO shortened_urls .* RETURNING link_key, short_code`
+	mock.ExpectQuery(nextval).WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"nextval"}).AddRow(int64(1)).AddRow(int64(2)))
+	mock.ExpectQuery(insert).
+		WithArgs("https://example.com/a", codes[0], sqlmock.AnyArg(), nil, nil, nil, "", nil, nil, "", 307, nil,
+			"https://example.com/h", codes[1], sqlmock.AnyArg(), nil, nil, nil, "", nil, nil, "", 307, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"link_key", "short_code"}).AddRow(codes[0], codes[0]))
+	mock.ExpectQuery(nextval).WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"nextval"}).AddRow(int64(3)).AddRow(int64(4)))
+	mock.ExpectQuery(insert).
+		WithArgs("https://example.com/g", codes[2], sqlmock.AnyArg(), nil, nil, nil, "", nil, nil, "", 307, nil,
+			"https://example.com/h", codes[3], sqlmock.AnyArg(), nil, nil, nil, "", nil, nil, "", 307, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"link_key", "short_code"}).AddRow(codes[2], codes[2]).AddRow(codes[3], codes[3]))
+
+	got, err := s.SaveURLs(context.Background(), []domain.ShortenedURL{
+		{URL: "https://example.com/a", ShortCode: codes[0]},
+		{URL: "https://example.com/g"},
+		{URL: "https://example.com/h"},
+	})
+	if err != nil {
+		t.Fatalf("SaveURLs: %v", err)
+	}
+	if want := []string{codes[0], codes[2], codes[3]}; !slices.Equal(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
@@ -0,0 +1,104 @@
+package postgres
+
+import (
+	"context"
+
+	"shortener/internal/domain"
+	"shortener/internal/storage"
+)
+
+// CreateDomain registers a branded domain for its owner.
+// It returns storage.ErrDomainTaken if the domain is already registered, by anyone.
+func (s *Storage) CreateDomain(ctx context.Context, d domain.Domain) (domain.Domain, error) {
+	// writes must go to the master, QueryWithRetry may pick a replica
+	const q = `
+		INSERT INTO domains (domain, owner_id) VALUES ($1, $2)
+		ON CONFLICT (domain) DO NOTHING
+		RETURNING created_at
+	`
+	rows, err := s.db.Master.QueryContext(ctx, q, d.Domain, d.OwnerID)
+	if err != nil {
+		return domain.Domain{}, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return domain.Domain{}, err
+		}
+		return domain.Domain{}, storage.ErrDomainTaken
+	}
+	if err := rows.Scan(&d.CreatedAt); err != nil {
+		return domain.Domain{}, err
+	}
+	return d, nil
+}
+
+// ListDomains returns the branded domains of the owner, in the order they were registered.
+func (s *Storage) ListDomains(ctx context.Context, ownerID string) ([]domain.Domain, error) {
+	const q = `
+		SELECT domain, owner_id::text, created_at FROM domains
+		WHERE owner_id = $1
+		ORDER BY created_at, domain
+	`
+	return s.queryDomains(ctx, q, ownerID)
+}
+
+// Domains returns every branded domain, leaving out the default one.
+func (s *Storage) Domains(ctx context.Context) ([]domain.Domain, error) {
+	const q = `
+		SELECT domain, owner_id::text, created_at FROM domains
+		WHERE domain <> ''
+		ORDER BY domain
+	`
+	return s.queryDomains(ctx, q)
+}
+
+func (s *Storage) queryDomains(ctx context.Context, q string, args ...any) ([]domain.Domain, error) {
+	rows, err := s.db.QueryWithRetry(ctx, Strategy, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	domains := []domain.Domain{}
+	for rows.Next() {
+		var d domain.Domain
+		if err := rows.Scan(&d.Domain, &d.OwnerID, &d.CreatedAt); err != nil {
+			return nil, err
+		}
+		domains = append(domains, d)
+	}
+	return domains, rows.Err()
+}
+
+// DeleteDomain removes the owner's branded domain. A domain can only be removed once its links are deleted.
+// It returns storage.ErrNotFound if the domain is not registered or belongs to someone else, and
+// storage.ErrDomainInUse if it still has links.
+func (s *Storage) DeleteDomain(ctx context.Context, ownerID, host string) error {
+	// the delete and the check for links are one statement, so a link saved in between can't be orphaned:
+	// its foreign key blocks the delete instead
+	const q = `
+		WITH target AS (
+			SELECT domain FROM domains WHERE domain = $2 AND owner_id = $1
+		), in_use AS (
+			SELECT EXISTS (SELECT 1 FROM shortened_urls u JOIN target t ON u.domain = t.domain) AS used
+		), deleted AS (
+			DELETE FROM domains d USING target t, in_use
+			WHERE d.domain = t.domain AND NOT in_use.used
+			RETURNING d.domain
+		)
+		SELECT EXISTS (SELECT 1 FROM target), (SELECT used FROM in_use), EXISTS (SELECT 1 FROM deleted)
+	`
+	var found, used, deleted bool
+	if err := s.db.Master.QueryRowContext(ctx, q, ownerID, host).Scan(&found, &used, &deleted); err != nil {
+		return err
+	}
+	switch {
+	case !found:
+		return storage.ErrNotFound
+	case used || !deleted:
+		return storage.ErrDomainInUse
+	}
+	return nil
+}
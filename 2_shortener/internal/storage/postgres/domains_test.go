@@ -0,0 +1,66 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"shortener/internal/domain"
+	"shortener/internal/storage"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestCreateDomain(t *testing.T) {
+	s, mock, closeFn := newTestStorage(t)
+	defer closeFn()
+
+	created := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(`INSERT\s+INTO\s+domains .* ON CONFLICT \(domain\) DO NOTHING`).
+		WithArgs("go.brand.com", "owner").
+		WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(created))
+	mock.ExpectQuery(`INSERT\s+INTO\s+domains`).
+		WithArgs("go.brand.com", "other").
+		WillReturnRows(sqlmock.NewRows([]string{"created_at"}))
+
+	d, err := s.CreateDomain(context.Background(), domain.Domain{Domain: "go.brand.com", OwnerID: "owner"})
+	if err != nil || !d.CreatedAt.Equal(created) || d.OwnerID != "owner" {
+		t.Fatalf("unexpected domain %+v: %v", d, err)
+	}
+	if _, err := s.CreateDomain(context.Background(), domain.Domain{Domain: "go.brand.com", OwnerID: "other"}); !errors.Is(err, storage.ErrDomainTaken) {
+		t.Fatalf("expected ErrDomainTaken, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestDeleteDomain(t *testing.T) {
+	tests := []struct {
+		name                 string
+		found, used, deleted bool
+		want                 error
+	}{
+		{"deleted", true, false, true, nil},
+		{"not found", false, false, false, storage.ErrNotFound},
+		{"in use", true, true, false, storage.ErrDomainInUse},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, mock, closeFn := newTestStorage(t)
+			defer closeFn()
+
+			mock.ExpectQuery(`DELETE FROM domains`).
+				WithArgs("owner", "go.brand.com").
+				WillReturnRows(sqlmock.NewRows([]string{"found", "used", "deleted"}).AddRow(tt.found, tt.used, tt.deleted))
+
+			if err := s.DeleteDomain(context.Background(), "owner", "go.brand.com"); !errors.Is(err, tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatalf("unmet expectations: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,260 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"shortener/internal/domain"
+	"shortener/internal/storage"
+)
+
+// groupColumns selects a link group with the keys of its links as a JSON array.
+const groupColumns = `
+	SELECT g.id::text, g.name, g.created_at,
+		COALESCE((SELECT json_agg(m.link_key ORDER BY m.added_at, m.link_key) FROM link_group_members m WHERE m.group_id = g.id), '[]')
+	FROM link_groups g
+`
+
+// CreateGroup creates a link group of the owner with the links with the keys in g.Links. It returns an
+// error wrapping storage.ErrLinksNotFound, and creates nothing, if any of them is not a link of the owner.
+func (s *Storage) CreateGroup(ctx context.Context, g domain.LinkGroup) (domain.LinkGroup, error) {
+	tx, err := s.db.Master.BeginTx(ctx, nil)
+	if err != nil {
+		return domain.LinkGroup{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	err = tx.QueryRowContext(ctx, `INSERT INTO link_groups (owner_id, name) VALUES ($1, $2) RETURNING id::text, created_at`,
+		g.OwnerID, g.Name).Scan(&g.ID, &g.CreatedAt)
+	if err != nil {
+		return domain.LinkGroup{}, err
+	}
+	if err := addGroupLinks(ctx, tx, g.OwnerID, g.ID, g.Links); err != nil {
+		return domain.LinkGroup{}, err
+	}
+	if g.Links == nil {
+		g.Links = []string{}
+	}
+	return g, tx.Commit()
+}
+
+// ListGroups returns the owner's link groups, newest first.
+func (s *Storage) ListGroups(ctx context.Context, ownerID string) ([]domain.LinkGroup, error) {
+	rows, err := s.db.QueryWithRetry(ctx, Strategy, groupColumns+`WHERE g.owner_id = $1 ORDER BY g.created_at DESC, g.id`, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := []domain.LinkGroup{}
+	for rows.Next() {
+		g, err := scanGroup(rows, ownerID)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, g)
+	}
+	return groups, rows.Err()
+}
+
+// GetGroup returns the owner's link group. It returns storage.ErrNotFound if the group does not exist or
+// belongs to someone else.
+func (s *Storage) GetGroup(ctx context.Context, ownerID, id string) (domain.LinkGroup, error) {
+	rows, err := s.db.QueryWithRetry(ctx, Strategy, groupColumns+`WHERE g.id = $1 AND g.owner_id = $2`, id, ownerID)
+	if err != nil {
+		return domain.LinkGroup{}, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return domain.LinkGroup{}, err
+		}
+		return domain.LinkGroup{}, storage.ErrNotFound
+	}
+	return scanGroup(rows, ownerID)
+}
+
+func scanGroup(rows *sql.Rows, ownerID string) (domain.LinkGroup, error) {
+	g := domain.LinkGroup{OwnerID: ownerID}
+	var links []byte
+	if err := rows.Scan(&g.ID, &g.Name, &g.CreatedAt, &links); err != nil {
+		return domain.LinkGroup{}, err
+	}
+	var err error
+	g.Links, err = decodeList[string](links)
+	return g, err
+}
+
+// AddGroupLinks adds the links with the keys to the owner's group; links already in it are left as they are.
+// It returns storage.ErrNotFound if the group does not exist or belongs to someone else, and an error wrapping
+// storage.ErrLinksNotFound, adding nothing, if any of the keys is not a link of the owner.
+func (s *Storage) AddGroupLinks(ctx context.Context, ownerID, id string, keys []string) error {
+	tx, err := s.db.Master.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var found bool
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM link_groups WHERE id = $1 AND owner_id = $2)`, id, ownerID).Scan(&found)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return storage.ErrNotFound
+	}
+	if err := addGroupLinks(ctx, tx, ownerID, id, keys); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// addGroupLinks adds the owner's links with the keys to the group in the transaction.
+func addGroupLinks(ctx context.Context, tx *sql.Tx, ownerID, id string, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	keysJSON, err := json.Marshal(keys)
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT DISTINCT k FROM jsonb_array_elements_text($2::jsonb) k
+		WHERE NOT EXISTS (SELECT 1 FROM shortened_urls WHERE link_key = k AND owner_id = $1)
+		ORDER BY k`, ownerID, string(keysJSON))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var missing []string
+	for rows.Next() {
+		var k string
+		if err := rows.Scan(&k); err != nil {
+			return err
+		}
+		missing = append(missing, k)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: %s", storage.ErrLinksNotFound, strings.Join(missing, ", "))
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO link_group_members (group_id, link_key)
+		SELECT $1, k FROM jsonb_array_elements_text($2::jsonb) k
+		ON CONFLICT DO NOTHING`, id, string(keysJSON))
+	return err
+}
+
+// RemoveGroupLink removes the link with the key from the owner's group. It returns storage.ErrNotFound if the
+// group does not exist, belongs to someone else or does not have the link.
+func (s *Storage) RemoveGroupLink(ctx context.Context, ownerID, id, key string) error {
+	const q = `
+		DELETE FROM link_group_members m USING link_groups g
+		WHERE m.group_id = g.id AND g.id = $1 AND g.owner_id = $2 AND m.link_key = $3
+	`
+	res, err := s.db.ExecWithRetry(ctx, Strategy, q, id, ownerID, key)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+// DeleteGroup deletes the owner's link group; its links are left as they are.
+// It returns storage.ErrNotFound if the group does not exist or belongs to someone else.
+func (s *Storage) DeleteGroup(ctx context.Context, ownerID, id string) error {
+	res, err := s.db.ExecWithRetry(ctx, Strategy, `DELETE FROM link_groups WHERE id = $1 AND owner_id = $2`, id, ownerID)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+// GroupAnalytics builds the analytics of the owner's link group: the response of Analytics for the clicks of
+// all its links together, without variants, and the clicks of each link in the range. A visitor of several
+// links counts once in the group's unique clicks. It returns storage.ErrNotFound if the group does not exist
+// or belongs to someone else.
+func (s *Storage) GroupAnalytics(ctx context.Context, ownerID, id string, from, to *time.Time, topLimit int, includeBots bool) (domain.GroupAnalyticsResponse, error) {
+	start, end := analyticsRange(from, to)
+
+	group, err := s.GetGroup(ctx, ownerID, id)
+	if err != nil {
+		return domain.GroupAnalyticsResponse{}, err
+	}
+	rolled, err := s.rolledUntil(ctx)
+	if err != nil {
+		return domain.GroupAnalyticsResponse{}, err
+	}
+	resp := domain.GroupAnalyticsResponse{GroupID: group.ID, Name: group.Name}
+	if resp.AnalyticsResponse, err = s.analytics(ctx, groupScope(id), start, end, rolled, topLimit, includeBots); err != nil {
+		return domain.GroupAnalyticsResponse{}, err
+	}
+	if resp.Leaderboard, err = s.groupLeaderboard(ctx, id, start, end, newRollupWindow(start, end, rolled), includeBots); err != nil {
+		return domain.GroupAnalyticsResponse{}, err
+	}
+	return resp, nil
+}
+
+// groupLeaderboard returns the clicks and unique visitors of every link in the group in the inclusive
+// [start, end] range, most clicked first, reading the window from rollups and the rest from the raw clicks.
+func (s *Storage) groupLeaderboard(ctx context.Context, id string, start, end *time.Time, w rollupWindow, includeBots bool) ([]domain.LinkStats, error) {
+	q := `
+		SELECT u.short_code, u.domain, u.url, COALESCE(SUM(t.n), 0)::bigint,
+			(COUNT(DISTINCT NULLIF(t.ip, ` + prunedIP + `)) + COALESCE(SUM(t.v), 0))::bigint
+		FROM link_group_members m
+		JOIN shortened_urls u ON u.link_key = m.link_key
+		LEFT JOIN (
+			SELECT short_code, ip, clicks AS n, visitors AS v FROM click_rollup_ips
+			WHERE short_code IN (SELECT link_key FROM link_group_members WHERE group_id = $1)
+				AND hour >= $4 AND hour < $5 AND ($6 OR NOT is_bot)
+			UNION ALL
+			SELECT short_code, ip, COUNT(*) AS n, 0 AS v FROM clicks
+			WHERE short_code IN (SELECT link_key FROM link_group_members WHERE group_id = $1)
+				AND ($2::timestamptz IS NULL OR timestamp >= $2)
+				AND ($3::timestamptz IS NULL OR timestamp <= $3)
+				AND (timestamp < $4 OR timestamp >= $5)
+				AND ($6 OR NOT is_bot)
+			GROUP BY 1, 2
+		) t ON t.short_code = m.link_key
+		WHERE m.group_id = $1
+		GROUP BY m.link_key, u.short_code, u.domain, u.url
+		ORDER BY 4 DESC, m.link_key
+	`
+	rows, err := s.db.QueryWithRetry(ctx, Strategy, q, id, start, end, w.start, w.end, includeBots)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := []domain.LinkStats{}
+	for rows.Next() {
+		var l domain.LinkStats
+		if err := rows.Scan(&l.ShortCode, &l.Domain, &l.URL, &l.Clicks, &l.UniqueClicks); err != nil {
+			return nil, err
+		}
+		stats = append(stats, l)
+	}
+	return stats, rows.Err()
+}
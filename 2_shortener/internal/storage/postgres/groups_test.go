@@ -0,0 +1,132 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"shortener/internal/domain"
+	"shortener/internal/storage"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestCreateGroup(t *testing.T) {
+	s, mock, closeFn := newTestStorage(t)
+	defer closeFn()
+
+	created := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO link_groups \(owner_id, name\)`).
+		WithArgs("owner", "Spring sale").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow("g1", created))
+	mock.ExpectQuery(`SELECT DISTINCT k FROM jsonb_array_elements_text\(\$2::jsonb\) k`).
+		WithArgs("owner", `["sale","go.brand.com/sale"]`).
+		WillReturnRows(sqlmock.NewRows([]string{"k"}))
+	mock.ExpectExec(`INSERT INTO link_group_members \(group_id, link_key\)`).
+		WithArgs("g1", `["sale","go.brand.com/sale"]`).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	g, err := s.CreateGroup(context.Background(), domain.LinkGroup{
+		OwnerID: "owner", Name: "Spring sale", Links: []string{"sale", "go.brand.com/sale"},
+	})
+	if err != nil || g.ID != "g1" || !g.CreatedAt.Equal(created) || len(g.Links) != 2 {
+		t.Fatalf("unexpected group %+v: %v", g, err)
+	}
+
+	// someone else's link fails the whole group
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO link_groups`).
+		WithArgs("owner", "Mixed").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow("g2", created))
+	mock.ExpectQuery(`SELECT DISTINCT k FROM jsonb_array_elements_text`).
+		WithArgs("owner", `["sale","theirs"]`).
+		WillReturnRows(sqlmock.NewRows([]string{"k"}).AddRow("theirs"))
+	mock.ExpectRollback()
+
+	_, err = s.CreateGroup(context.Background(), domain.LinkGroup{OwnerID: "owner", Name: "Mixed", Links: []string{"sale", "theirs"}})
+	if !errors.Is(err, storage.ErrLinksNotFound) || err.Error() != "links not found: theirs" {
+		t.Fatalf("expected ErrLinksNotFound naming the link, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestAddGroupLinks_NotOwned(t *testing.T) {
+	s, mock, closeFn := newTestStorage(t)
+	defer closeFn()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT EXISTS \(SELECT 1 FROM link_groups WHERE id = \$1 AND owner_id = \$2\)`).
+		WithArgs("g1", "other").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectRollback()
+
+	if err := s.AddGroupLinks(context.Background(), "other", "g1", []string{"sale"}); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for someone else's group, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestGroupAnalytics(t *testing.T) {
+	s, mock, closeFn := newTestStorage(t)
+	defer closeFn()
+
+	const members = `short_code IN \(SELECT link_key FROM link_group_members WHERE group_id = \$1\)`
+	mock.ExpectQuery(`FROM link_groups g\s+WHERE g.id = \$1 AND g.owner_id = \$2`).
+		WithArgs("g1", "owner").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "created_at", "links"}).
+			AddRow("g1", "Spring sale", time.Now(), []byte(`["sale","go.brand.com/sale"]`)))
+	rolled := time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(`SELECT rolled_until FROM click_rollup_state`).
+		WillReturnRows(sqlmock.NewRows([]string{"rolled_until"}).AddRow(rolled))
+
+	// every analytics query is over the clicks of the group's links
+	mock.ExpectQuery(`FROM click_rollups_hourly WHERE `+members+` AND hour < \$2`).
+		WithArgs("g1", rolled, false).
+		WillReturnRows(sqlmock.NewRows([]string{"total", "unique", "bots"}).AddRow(int64(30), int64(12), int64(2)))
+	for range 12 {
+		mock.ExpectQuery(`SELECT k, SUM\(n\)::bigint FROM \( SELECT .* WHERE ` + members).
+			WillReturnRows(sqlmock.NewRows([]string{"k", "n"}).AddRow("x", int64(1)))
+	}
+	epoch := time.Unix(0, 0).UTC()
+	mock.ExpectQuery(`SELECT u.short_code, u.domain, u.url, COALESCE\(SUM\(t.n\), 0\)::bigint, \(COUNT\(DISTINCT NULLIF\(t.ip, '0.0.0.0'::inet\)\) \+ COALESCE\(SUM\(t.v\), 0\)\)::bigint`).
+		WithArgs("g1", nil, nil, epoch, rolled, false).
+		WillReturnRows(sqlmock.NewRows([]string{"short_code", "domain", "url", "clicks", "unique"}).
+			AddRow("sale", "go.brand.com", "https://example.com/a", int64(20), int64(8)).
+			AddRow("sale", "", "https://example.com/b", int64(10), int64(5)))
+
+	resp, err := s.GroupAnalytics(context.Background(), "owner", "g1", nil, nil, 10, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.GroupID != "g1" || resp.Name != "Spring sale" || resp.TotalClicks != 30 || resp.UniqueClicks != 12 ||
+		resp.ShortCode != "" || resp.Variants != nil || len(resp.Leaderboard) != 2 ||
+		resp.Leaderboard[0] != (domain.LinkStats{ShortCode: "sale", Domain: "go.brand.com", URL: "https://example.com/a", Clicks: 20, UniqueClicks: 8}) {
+		t.Fatalf("unexpected group analytics: %+v", resp)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestGroupAnalytics_NotFound(t *testing.T) {
+	s, mock, closeFn := newTestStorage(t)
+	defer closeFn()
+
+	mock.ExpectQuery(`FROM link_groups g\s+WHERE g.id = \$1 AND g.owner_id = \$2`).
+		WithArgs("g1", "other").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "created_at", "links"}))
+
+	if _, err := s.GroupAnalytics(context.Background(), "other", "g1", nil, nil, 10, false); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
@@ -0,0 +1,56 @@
+package postgres
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"shortener/internal/domain"
+	"shortener/internal/storage"
+)
+
+// CreateAPIKey issues a new API key for a new owner. Only the hash of the key is stored.
+func (s *Storage) CreateAPIKey(ctx context.Context) (domain.APIKey, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return domain.APIKey{}, err
+	}
+	key := hex.EncodeToString(raw)
+
+	// writes must go to the master, QueryWithRetry may pick a replica
+	const q = `INSERT INTO api_keys (key_hash) VALUES ($1) RETURNING owner_id::text`
+	out := domain.APIKey{Key: key}
+	if err := s.db.Master.QueryRowContext(ctx, q, hashAPIKey(key)).Scan(&out.OwnerID); err != nil {
+		return domain.APIKey{}, err
+	}
+	return out, nil
+}
+
+// OwnerByAPIKey resolves an API key to its owner.
+// It returns storage.ErrUnauthorized if the key is unknown.
+func (s *Storage) OwnerByAPIKey(ctx context.Context, key string) (string, error) {
+	const q = `SELECT owner_id::text FROM api_keys WHERE key_hash = $1`
+	rows, err := s.db.QueryWithRetry(ctx, Strategy, q, hashAPIKey(key))
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return "", err
+		}
+		return "", storage.ErrUnauthorized
+	}
+	var ownerID string
+	if err := rows.Scan(&ownerID); err != nil {
+		return "", err
+	}
+	return ownerID, nil
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,108 @@
+package postgres
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// DailySalt returns the IP salt of the UTC day of day, creating it if needed, and deletes the salts of the
+// days before the one before it.
+func (s *Storage) DailySalt(ctx context.Context, day time.Time) ([]byte, error) {
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	// yesterday's salt stays for clicks made before midnight and saved after it
+	const q = `
+		WITH pruned AS (
+			DELETE FROM ip_salts WHERE day < $1::date - 1
+		), created AS (
+			INSERT INTO ip_salts (day, salt) VALUES ($1, $2)
+			ON CONFLICT (day) DO NOTHING
+			RETURNING salt
+		)
+		SELECT salt FROM created
+		UNION ALL
+		SELECT salt FROM ip_salts WHERE day = $1`
+
+	// a salt created by another instance after the statement began is neither inserted nor seen by it, but
+	// it is by the next one
+	for range 2 {
+		var existing []byte
+		err := s.db.Master.QueryRowContext(ctx, q, day.UTC().Format(time.DateOnly), salt).Scan(&existing)
+		if err == nil {
+			return existing, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+	}
+	return nil, errors.New("ip salt was not created")
+}
+
+// pruneBatch is how many clicks one DELETE of PruneClicks removes, keeping its locks and WAL bounded.
+const pruneBatch = 10000
+
+// PruneClicks deletes the raw clicks before the hour containing before and returns how many it did. With
+// keepRollups, clicks that are not rolled up yet are kept, and the rollups stay, except that the visitor IPs
+// of the pruned hours are merged into prunedIP; otherwise the rollups of the pruned hours go too.
+func (s *Storage) PruneClicks(ctx context.Context, before time.Time, keepRollups bool) (int64, error) {
+	before = before.Truncate(time.Hour)
+	if keepRollups {
+		rolled, err := s.rolledUntil(ctx)
+		if err != nil {
+			return 0, err
+		}
+		if rolled.Before(before) {
+			before = rolled
+		}
+		if before.IsZero() {
+			return 0, nil
+		}
+	}
+
+	var pruned int64
+	for {
+		res, err := s.db.ExecWithRetry(ctx, Strategy,
+			`DELETE FROM clicks WHERE id IN (SELECT id FROM clicks WHERE timestamp < $1 LIMIT $2)`, before, pruneBatch)
+		if err != nil {
+			return pruned, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return pruned, err
+		}
+		pruned += n
+		if n < pruneBatch {
+			break
+		}
+	}
+
+	if keepRollups {
+		// the per-IP rollups would otherwise keep who clicked for good; their clicks and visitor counts stay
+		const q = `
+			WITH pruned AS (
+				DELETE FROM click_rollup_ips WHERE hour < $1 AND ip <> ` + prunedIP + `
+				RETURNING short_code, hour, is_bot, variant, clicks
+			)
+			INSERT INTO click_rollup_ips (short_code, hour, ip, is_bot, variant, clicks, visitors)
+			SELECT short_code, hour, ` + prunedIP + `, is_bot, variant, SUM(clicks), COUNT(*) FROM pruned
+			GROUP BY short_code, hour, is_bot, variant
+			ON CONFLICT (short_code, hour, ip, is_bot, variant) DO UPDATE
+				SET clicks = click_rollup_ips.clicks + EXCLUDED.clicks, visitors = click_rollup_ips.visitors + EXCLUDED.visitors`
+		if _, err := s.db.ExecWithRetry(ctx, Strategy, q, before); err != nil {
+			return pruned, err
+		}
+	} else {
+		for _, table := range []string{"click_rollups_hourly", "click_rollup_ips", "click_rollup_dims"} {
+			if _, err := s.db.ExecWithRetry(ctx, Strategy, `DELETE FROM `+table+` WHERE hour < $1`, before); err != nil {
+				return pruned, err
+			}
+		}
+	}
+	return pruned, nil
+}
@@ -0,0 +1,144 @@
+package postgres
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"shortener/internal/domain"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestDailySalt(t *testing.T) {
+	s, mock, done := newClickStorage(t)
+	defer done()
+
+	// another instance created the salt after the first statement began
+	saltRe := `DELETE FROM ip_salts WHERE day < \$1::date - 1[\s\S]*INSERT INTO ip_salts`
+	mock.ExpectQuery(saltRe).
+		WithArgs("2025-03-01", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"salt"}))
+	mock.ExpectQuery(saltRe).
+		WithArgs("2025-03-01", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"salt"}).AddRow([]byte("theirs")))
+
+	salt, err := s.DailySalt(context.Background(), time.Date(2025, 3, 1, 23, 0, 0, 0, time.UTC))
+	if err != nil || !bytes.Equal(salt, []byte("theirs")) {
+		t.Fatalf("expected the other instance's salt, got %q: %v", salt, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestPruneClicks_KeepRollups(t *testing.T) {
+	s, mock, done := newClickStorage(t)
+	defer done()
+
+	// clicks past the watermark are not rolled up yet, so they stay
+	rolled := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT rolled_until FROM click_rollup_state`)).
+		WillReturnRows(sqlmock.NewRows([]string{"rolled_until"}).AddRow(rolled))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM clicks WHERE id IN (SELECT id FROM clicks WHERE timestamp < $1 LIMIT $2)`)).
+		WithArgs(rolled, pruneBatch).
+		WillReturnResult(sqlmock.NewResult(0, pruneBatch))
+	mock.ExpectExec(`DELETE FROM clicks`).
+		WithArgs(rolled, pruneBatch).
+		WillReturnResult(sqlmock.NewResult(0, 5))
+	// the visitors of the pruned hours are merged away, their clicks and how many they were stay
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM click_rollup_ips WHERE hour < $1 AND ip <> '0.0.0.0'::inet`) +
+		`[\s\S]*` + regexp.QuoteMeta(`SELECT short_code, hour, '0.0.0.0'::inet, is_bot, variant, SUM(clicks), COUNT(*) FROM pruned`)).
+		WithArgs(rolled).
+		WillReturnResult(sqlmock.NewResult(0, 40))
+
+	n, err := s.PruneClicks(context.Background(), rolled.Add(36*time.Hour), true)
+	if err != nil || n != pruneBatch+5 {
+		t.Fatalf("expected %d clicks pruned, got %d: %v", pruneBatch+5, n, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestPruneClicks_KeepsUniqueCounts(t *testing.T) {
+	s, mock, done := newClickStorage(t)
+	defer done()
+
+	rolled := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+	w := rollupWindow{start: time.Unix(0, 0).UTC(), end: rolled}
+	totalsRe := regexp.QuoteMeta(`SELECT ip FROM click_rollup_ips WHERE short_code = $1 AND hour < $2 AND ($3 OR NOT is_bot) AND ip <> '0.0.0.0'::inet`) +
+		`[\s\S]*` + regexp.QuoteMeta(`+ (SELECT COALESCE(SUM(visitors), 0) FROM click_rollup_ips WHERE short_code = $1 AND hour < $2 AND ($3 OR NOT is_bot) AND ip = '0.0.0.0'::inet)::bigint`)
+	variantsRe := regexp.QuoteMeta(`SELECT variant, SUM(n)::bigint, (COUNT(DISTINCT NULLIF(ip, '0.0.0.0'::inet)) + SUM(v))::bigint FROM ( SELECT variant, ip, clicks AS n, visitors AS v FROM click_rollup_ips`)
+
+	// before: the 5 visitors are all IP rows of the rollups
+	mock.ExpectQuery(totalsRe).
+		WithArgs("abc123", rolled, false).
+		WillReturnRows(sqlmock.NewRows([]string{"total", "unique", "bots"}).AddRow(int64(9), int64(5), int64(0)))
+	mock.ExpectQuery(variantsRe).
+		WithArgs("abc123", nil, nil, w.start, w.end, false).
+		WillReturnRows(sqlmock.NewRows([]string{"variant", "clicks", "unique"}).AddRow("a", int64(9), int64(5)))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT rolled_until FROM click_rollup_state`)).
+		WillReturnRows(sqlmock.NewRows([]string{"rolled_until"}).AddRow(rolled))
+	mock.ExpectExec(`DELETE FROM clicks`).
+		WithArgs(rolled, pruneBatch).
+		WillReturnResult(sqlmock.NewResult(0, 9))
+	mock.ExpectExec(regexp.QuoteMeta(`SUM(clicks), COUNT(*) FROM pruned`)).
+		WithArgs(rolled).
+		WillReturnResult(sqlmock.NewResult(0, 5))
+	// after: the same queries count them from the visitors of the merged rows
+	mock.ExpectQuery(totalsRe).
+		WithArgs("abc123", rolled, false).
+		WillReturnRows(sqlmock.NewRows([]string{"total", "unique", "bots"}).AddRow(int64(9), int64(5), int64(0)))
+	mock.ExpectQuery(variantsRe).
+		WithArgs("abc123", nil, nil, w.start, w.end, false).
+		WillReturnRows(sqlmock.NewRows([]string{"variant", "clicks", "unique"}).AddRow("a", int64(9), int64(5)))
+
+	ctx := context.Background()
+	unique := func() (int64, domain.VariantStats) {
+		_, u, _, err := s.rollupTotals(ctx, linkScope("abc123"), w, false)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		variants, err := s.variantStats(ctx, "abc123", nil, nil, w, false)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return u, variants["a"]
+	}
+	before, beforeVariant := unique()
+	if _, err := s.PruneClicks(ctx, rolled, true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if after, afterVariant := unique(); after != before || afterVariant != beforeVariant {
+		t.Fatalf("expected the unique counts to stay %d and %+v, got %d and %+v", before, beforeVariant, after, afterVariant)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestPruneClicks_Delete(t *testing.T) {
+	s, mock, done := newClickStorage(t)
+	defer done()
+
+	before := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+	mock.ExpectExec(`DELETE FROM clicks`).
+		WithArgs(before, pruneBatch).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	for _, table := range []string{"click_rollups_hourly", "click_rollup_ips", "click_rollup_dims"} {
+		mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM ` + table + ` WHERE hour < $1`)).
+			WithArgs(before).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+
+	n, err := s.PruneClicks(context.Background(), before.Add(30*time.Minute), false)
+	if err != nil || n != 3 {
+		t.Fatalf("expected 3 clicks pruned, got %d: %v", n, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
@@ -0,0 +1,360 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"shortener/internal/domain"
+)
+
+// RollupClicks rolls up every click before the hour containing until into the hourly rollup tables
+// and advances the watermark. Each hour is rolled up exactly once, so until must lag behind the clock
+// by more than the time a click can spend in the ingestion buffer.
+func (s *Storage) RollupClicks(ctx context.Context, until time.Time) (time.Time, error) {
+	until = until.Truncate(time.Hour)
+
+	tx, err := s.db.Master.BeginTx(ctx, nil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// the row lock keeps concurrent aggregators from rolling the same hours twice
+	var rolled sql.NullTime
+	if err := tx.QueryRowContext(ctx, `SELECT rolled_until FROM click_rollup_state FOR UPDATE`).Scan(&rolled); err != nil {
+		return time.Time{}, err
+	}
+	if rolled.Valid && !until.After(rolled.Time) {
+		return rolled.Time, tx.Commit()
+	}
+	var from any
+	if rolled.Valid {
+		from = rolled.Time
+	}
+
+	const window = `($1::timestamptz IS NULL OR timestamp >= $1) AND timestamp < $2`
+	dims := make([]string, len(rollupDims))
+	for i, d := range rollupDims {
+		dims[i] = `SELECT short_code, date_trunc('hour', timestamp), '` + d.name + `', ` + d.rawKey + `, COUNT(*)
+		FROM clicks WHERE ` + window + `
+		GROUP BY 1, 2, 4`
+	}
+	statements := []string{
+		`INSERT INTO click_rollups_hourly (short_code, hour, clicks, bot_clicks)
+		SELECT short_code, date_trunc('hour', timestamp), COUNT(*), COUNT(*) FILTER (WHERE is_bot)
+		FROM clicks WHERE ` + window + `
+		GROUP BY 1, 2
+		ON CONFLICT (short_code, hour) DO UPDATE SET
+			clicks = click_rollups_hourly.clicks + EXCLUDED.clicks,
+			bot_clicks = click_rollups_hourly.bot_clicks + EXCLUDED.bot_clicks`,
+
+		`INSERT INTO click_rollup_ips (short_code, hour, ip, is_bot, variant, clicks)
+		SELECT short_code, date_trunc('hour', timestamp), ip, is_bot, variant, COUNT(*)
+		FROM clicks WHERE ` + window + `
+		GROUP BY 1, 2, 3, 4, 5
+		ON CONFLICT (short_code, hour, ip, is_bot, variant) DO UPDATE SET clicks = click_rollup_ips.clicks + EXCLUDED.clicks`,
+
+		`INSERT INTO click_rollup_dims (short_code, hour, dim, value, clicks)
+		` + strings.Join(dims, "\n\t\tUNION ALL\n\t\t") + `
+		ON CONFLICT (short_code, dim, hour, value) DO UPDATE SET clicks = click_rollup_dims.clicks + EXCLUDED.clicks`,
+	}
+	for _, q := range statements {
+		if _, err := tx.ExecContext(ctx, q, from, until); err != nil {
+			return time.Time{}, err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE click_rollup_state SET rolled_until = $1`, until); err != nil {
+		return time.Time{}, err
+	}
+	return until, tx.Commit()
+}
+
+// rolledUntil returns the rollup watermark, or the zero time if nothing has been rolled up yet.
+func (s *Storage) rolledUntil(ctx context.Context) (time.Time, error) {
+	r, err := s.db.QueryWithRetry(ctx, Strategy, `SELECT rolled_until FROM click_rollup_state`)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer r.Close()
+
+	var rolled sql.NullTime
+	if r.Next() {
+		if err := r.Scan(&rolled); err != nil {
+			return time.Time{}, err
+		}
+	}
+	return rolled.Time, r.Err()
+}
+
+// scope is the set of clicks analytics is computed over: those of a link, or those of every link in a group.
+// cond is its condition on short_code, the key of the link of a click, and takes arg as $1.
+type scope struct {
+	cond string
+	arg  string
+}
+
+// linkScope is the clicks of the link with the key.
+func linkScope(key string) scope {
+	return scope{cond: "short_code = $1", arg: key}
+}
+
+// groupScope is the clicks of the links in the group with the ID.
+func groupScope(id string) scope {
+	return scope{cond: "short_code IN (SELECT link_key FROM link_group_members WHERE group_id = $1)", arg: id}
+}
+
+// rollupWindow is the part of a query range that is answered from rollups: the whole hours in
+// [start, end) that are below the watermark. Clicks in the range but outside it are read from the raw table.
+type rollupWindow struct {
+	start, end time.Time
+}
+
+// newRollupWindow splits the inclusive [from, to] range, where nil means unbounded, at whole hours.
+func newRollupWindow(from, to *time.Time, rolled time.Time) rollupWindow {
+	w := rollupWindow{start: time.Unix(0, 0).UTC(), end: rolled}
+	if from != nil {
+		w.start = from.Truncate(time.Hour)
+		if w.start.Before(*from) {
+			w.start = w.start.Add(time.Hour)
+		}
+	}
+	// an hour is covered only if all of it is in the range; timestamps in it are < hour+1h <= to
+	if to != nil && to.Truncate(time.Hour).Before(w.end) {
+		w.end = to.Truncate(time.Hour)
+	}
+	if w.end.Before(w.start) {
+		w.end = w.start
+	}
+	return w
+}
+
+// prunedIP is the address the per-IP rollups of hours whose raw clicks were pruned are merged into, keeping
+// their clicks and the number of distinct visitors, in its visitors column, but not who they were. No client
+// has it, so it is never counted as a visitor itself.
+const prunedIP = `'0.0.0.0'::inet`
+
+// breakdown describes how one analytics breakdown is keyed in the rollups and in the raw clicks.
+type breakdown struct {
+	name      string // dim in click_rollup_dims
+	table     string // rollup table
+	filter    string // extra condition on the rollup table
+	rollupKey string
+	rawKey    string
+}
+
+var (
+	byDay = breakdown{
+		table: "click_rollups_hourly", rollupKey: "TO_CHAR(hour, 'YYYY-MM-DD')", rawKey: "TO_CHAR(timestamp, 'YYYY-MM-DD')",
+	}
+	byMonth = breakdown{
+		table:     "click_rollups_hourly",
+		rollupKey: "TO_CHAR(date_trunc('month', hour), 'YYYY-MM')", rawKey: "TO_CHAR(date_trunc('month', timestamp), 'YYYY-MM')",
+	}
+	byUserAgent = dimBreakdown("user_agent", "user_agent")
+	byReferer   = dimBreakdown("referer", "COALESCE(NULLIF(referer, ''), '(direct)')")
+	bySource    = dimBreakdown("source", "COALESCE(NULLIF(source, ''), '(link)')")
+	byBrowser   = dimBreakdown("browser", "COALESCE(NULLIF(browser, ''), '(unknown)')")
+	byOS        = dimBreakdown("os", "COALESCE(NULLIF(os, ''), '(unknown)')")
+	byDevice    = dimBreakdown("device", "COALESCE(NULLIF(device, ''), '(unknown)')")
+	byCountry   = dimBreakdown("country", "COALESCE(NULLIF(country, ''), '(unknown)')")
+	// city names repeat across countries, so cities are keyed as "City, CC"
+	byCity = dimBreakdown("city", "CASE WHEN city = '' THEN '(unknown)' WHEN country = '' THEN city ELSE city || ', ' || country END")
+	byRule = dimBreakdown("rule", "COALESCE(NULLIF(rule, ''), '(default)')")
+	byIP   = breakdown{
+		table: "click_rollup_ips", filter: "AND ip <> " + prunedIP, rollupKey: "ip::text", rawKey: "ip::text",
+	}
+
+	// rollupDims are the breakdowns kept in click_rollup_dims, keyed by name in its dim column.
+	rollupDims = []breakdown{byUserAgent, byReferer, bySource, byBrowser, byOS, byDevice, byCountry, byCity, byRule}
+)
+
+// dimBreakdown is a breakdown kept in click_rollup_dims with its values normalised by rawKey.
+func dimBreakdown(name, rawKey string) breakdown {
+	return breakdown{
+		name: name, table: "click_rollup_dims", filter: "AND dim = '" + name + "'", rollupKey: "value", rawKey: rawKey,
+	}
+}
+
+// rollupCounts aggregates clicks in the inclusive [start, end] range by the breakdown key, reading the
+// window from rollups and the rest from the raw clicks. A positive limit keeps the top N keys.
+func (s *Storage) rollupCounts(ctx context.Context, sc scope, b breakdown, start, end *time.Time, w rollupWindow, limit int) (map[string]int64, error) {
+	q := `SELECT k, SUM(n)::bigint FROM (
+			SELECT ` + b.rollupKey + ` AS k, clicks AS n FROM ` + b.table + `
+			WHERE ` + sc.cond + ` ` + b.filter + ` AND hour >= $4 AND hour < $5
+			UNION ALL
+			SELECT ` + b.rawKey + ` AS k, COUNT(*) AS n FROM clicks
+			WHERE ` + sc.cond + `
+				AND ($2::timestamptz IS NULL OR timestamp >= $2)
+				AND ($3::timestamptz IS NULL OR timestamp <= $3)
+				AND (timestamp < $4 OR timestamp >= $5)
+			GROUP BY 1
+		) t GROUP BY k ORDER BY SUM(n) DESC, k`
+	args := []any{sc.arg, start, end, w.start, w.end}
+	if limit > 0 {
+		q += ` LIMIT $6`
+		args = append(args, limit)
+	}
+
+	r, err := s.db.QueryWithRetry(ctx, Strategy, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	res := make(map[string]int64)
+	for r.Next() {
+		var k string
+		var v int64
+		if err := r.Scan(&k, &v); err != nil {
+			return nil, err
+		}
+		res[k] = v
+	}
+	return res, r.Err()
+}
+
+// rollupTotals returns the total, unique and bot click counts, reading hours below w.end from the rollups.
+// Unless includeBots is set, bots are left out of the total and unique counts. The per-hour visitor sets
+// are merged with UNION, so unique counts are exact, except that the visitors of pruned hours are counted
+// once per hour they came in.
+func (s *Storage) rollupTotals(ctx context.Context, sc scope, w rollupWindow, includeBots bool) (total, unique, bots int64, err error) {
+	q := `SELECT
+		(SELECT COALESCE(SUM(CASE WHEN $3 THEN clicks ELSE clicks - bot_clicks END), 0)
+			FROM click_rollups_hourly WHERE ` + sc.cond + ` AND hour < $2)::bigint
+			+ (SELECT COUNT(*) FROM clicks WHERE ` + sc.cond + ` AND timestamp >= $2 AND ($3 OR NOT is_bot)),
+		(SELECT COUNT(*) FROM (
+			SELECT ip FROM click_rollup_ips WHERE ` + sc.cond + ` AND hour < $2 AND ($3 OR NOT is_bot) AND ip <> ` + prunedIP + `
+			UNION
+			SELECT ip FROM clicks WHERE ` + sc.cond + ` AND timestamp >= $2 AND ($3 OR NOT is_bot)
+		) v)
+			+ (SELECT COALESCE(SUM(visitors), 0) FROM click_rollup_ips
+				WHERE ` + sc.cond + ` AND hour < $2 AND ($3 OR NOT is_bot) AND ip = ` + prunedIP + `)::bigint,
+		(SELECT COALESCE(SUM(bot_clicks), 0) FROM click_rollups_hourly WHERE ` + sc.cond + ` AND hour < $2)::bigint
+			+ (SELECT COUNT(*) FROM clicks WHERE ` + sc.cond + ` AND timestamp >= $2 AND is_bot)`
+
+	r, err := s.db.QueryWithRetry(ctx, Strategy, q, sc.arg, w.end, includeBots)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer r.Close()
+	if r.Next() {
+		if err := r.Scan(&total, &unique, &bots); err != nil {
+			return 0, 0, 0, err
+		}
+	}
+	return total, unique, bots, r.Err()
+}
+
+// variantStats returns the clicks and unique visitors per variant of a multi-destination link in the inclusive
+// [start, end] range, reading the window from the per-IP rollups and the rest from the raw clicks.
+// Like the totals, it leaves bots out unless includeBots is set. Links without variants get an empty map.
+func (s *Storage) variantStats(ctx context.Context, shortCode string, start, end *time.Time, w rollupWindow, includeBots bool) (map[string]domain.VariantStats, error) {
+	q := `SELECT variant, SUM(n)::bigint, (COUNT(DISTINCT NULLIF(ip, ` + prunedIP + `)) + SUM(v))::bigint FROM (
+			SELECT variant, ip, clicks AS n, visitors AS v FROM click_rollup_ips
+			WHERE short_code = $1 AND variant <> '' AND hour >= $4 AND hour < $5 AND ($6 OR NOT is_bot)
+			UNION ALL
+			SELECT variant, ip, COUNT(*) AS n, 0 AS v FROM clicks
+			WHERE short_code = $1 AND variant <> ''
+				AND ($2::timestamptz IS NULL OR timestamp >= $2)
+				AND ($3::timestamptz IS NULL OR timestamp <= $3)
+				AND (timestamp < $4 OR timestamp >= $5)
+				AND ($6 OR NOT is_bot)
+			GROUP BY 1, 2
+		) t GROUP BY variant`
+
+	r, err := s.db.QueryWithRetry(ctx, Strategy, q, shortCode, start, end, w.start, w.end, includeBots)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	res := make(map[string]domain.VariantStats)
+	for r.Next() {
+		var k string
+		var v domain.VariantStats
+		if err := r.Scan(&k, &v.Clicks, &v.UniqueClicks); err != nil {
+			return nil, err
+		}
+		res[k] = v
+	}
+	return res, r.Err()
+}
+
+// ClickSeries returns the clicks of the link in the inclusive [from, to] range per bucket of the granularity,
+// as the calendar of loc has them, oldest first and with empty buckets included. Unless includeBots is set,
+// bots are left out. The buckets follow the wall clock, so days across a daylight saving change are 23 or
+// 25 hours long; local times a change skips get no bucket.
+//
+// Rollup hours are used when they fall into a single bucket, which is when loc is a whole number of hours
+// off UTC at the time; the clicks of the other hours are counted from the raw table.
+func (s *Storage) ClickSeries(ctx context.Context, shortCode string, from, to time.Time, granularity string, loc *time.Location, includeBots bool) ([]domain.SeriesPoint, error) {
+	return s.clickSeries(ctx, linkScope(shortCode), from, to, granularity, loc, includeBots)
+}
+
+// GroupClickSeries is ClickSeries for the clicks of every link in the group, summed.
+func (s *Storage) GroupClickSeries(ctx context.Context, id string, from, to time.Time, granularity string, loc *time.Location, includeBots bool) ([]domain.SeriesPoint, error) {
+	return s.clickSeries(ctx, groupScope(id), from, to, granularity, loc, includeBots)
+}
+
+// clickSeries is ClickSeries for the clicks of the scope.
+func (s *Storage) clickSeries(ctx context.Context, sc scope, from, to time.Time, granularity string, loc *time.Location, includeBots bool) ([]domain.SeriesPoint, error) {
+	switch granularity {
+	case domain.GranularityHour, domain.GranularityDay, domain.GranularityWeek, domain.GranularityMonth:
+	default:
+		return nil, fmt.Errorf("unknown granularity %q", granularity)
+	}
+	rolled, err := s.rolledUntil(ctx)
+	if err != nil {
+		return nil, err
+	}
+	w := newRollupWindow(&from, &to, rolled)
+
+	// $5 is the timezone; an hour is aligned when it starts on a local hour
+	q := `
+		WITH buckets AS (
+			SELECT b FROM generate_series(
+				date_trunc($4::text, $2::timestamptz AT TIME ZONE $5::text),
+				date_trunc($4, $3::timestamptz AT TIME ZONE $5),
+				('1 ' || $4)::interval
+			) b
+			WHERE (b AT TIME ZONE $5) AT TIME ZONE $5 = b
+		), counts AS (
+			SELECT date_trunc($4, hour AT TIME ZONE $5) AS b, SUM(CASE WHEN $6 THEN clicks ELSE clicks - bot_clicks END) AS n
+			FROM click_rollups_hourly
+			WHERE ` + sc.cond + ` AND hour >= $7 AND hour < $8
+				AND date_trunc('hour', hour AT TIME ZONE $5) = hour AT TIME ZONE $5
+			GROUP BY 1
+			UNION ALL
+			SELECT date_trunc($4, timestamp AT TIME ZONE $5), COUNT(*)
+			FROM clicks
+			WHERE ` + sc.cond + ` AND timestamp >= $2 AND timestamp <= $3 AND ($6 OR NOT is_bot)
+				AND NOT (timestamp >= $7 AND timestamp < $8
+					AND date_trunc('hour', date_trunc('hour', timestamp) AT TIME ZONE $5) = date_trunc('hour', timestamp) AT TIME ZONE $5)
+			GROUP BY 1
+		)
+		SELECT buckets.b AT TIME ZONE $5, COALESCE(SUM(counts.n), 0)::bigint
+		FROM buckets LEFT JOIN counts ON counts.b = buckets.b
+		GROUP BY buckets.b
+		ORDER BY buckets.b`
+
+	r, err := s.db.QueryWithRetry(ctx, Strategy, q, sc.arg, from, to, granularity, loc.String(), includeBots, w.start, w.end)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	series := []domain.SeriesPoint{}
+	for r.Next() {
+		var p domain.SeriesPoint
+		if err := r.Scan(&p.Start, &p.Clicks); err != nil {
+			return nil, err
+		}
+		p.Start = p.Start.In(loc)
+		series = append(series, p)
+	}
+	return series, r.Err()
+}
@@ -0,0 +1,213 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"shortener/internal/domain"
+	"shortener/internal/useragent"
+)
+
+// newIntegrationStorage connects to the database in SHORTENER_TEST_POSTGRES (a postgres:// URL) and
+// applies the migrations to a fresh schema. The test is skipped if the variable is not set.
+func newIntegrationStorage(t *testing.T) *Storage {
+	dsn := os.Getenv("SHORTENER_TEST_POSTGRES")
+	if dsn == "" {
+		t.Skip("SHORTENER_TEST_POSTGRES not set, skipping integration test")
+	}
+	ctx := context.Background()
+
+	admin, err := New(ctx, dsn, nil)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	schema := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	if _, err := admin.db.Master.ExecContext(ctx, `CREATE SCHEMA `+schema); err != nil {
+		t.Fatalf("failed to create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		_, _ = admin.db.Master.ExecContext(ctx, `DROP SCHEMA `+schema+` CASCADE`)
+		admin.Close()
+	})
+
+	sep := "?"
+	if strings.Contains(dsn, "?") {
+		sep = "&"
+	}
+	s, err := New(ctx, dsn+sep+"search_path="+schema, nil)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	files, err := filepath.Glob("../../../migrations/*.up.sql")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no migrations found: %v", err)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		var a, b int
+		fmt.Sscanf(filepath.Base(files[i]), "%d_", &a)
+		fmt.Sscanf(filepath.Base(files[j]), "%d_", &b)
+		return a < b
+	})
+	for _, f := range files {
+		q, err := os.ReadFile(f)
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", f, err)
+		}
+		if _, err := s.db.Master.ExecContext(ctx, string(q)); err != nil {
+			t.Fatalf("failed to apply %s: %v", f, err)
+		}
+	}
+	return s
+}
+
+// rawAnalytics is the analytics response as computed before rollups existed, from the raw clicks alone.
+func rawAnalytics(t *testing.T, s *Storage, shortCode string, start, end *time.Time, limit int) domain.AnalyticsResponse {
+	ctx := context.Background()
+	resp := domain.AnalyticsResponse{ShortCode: shortCode, From: start, To: end}
+	if err := s.db.Master.QueryRowContext(ctx, `SELECT COUNT(*) FILTER (WHERE is_bot) FROM clicks WHERE short_code = $1`, shortCode).
+		Scan(&resp.BotClicks); err != nil {
+		t.Fatalf("raw analytics: %v", err)
+	}
+
+	var errs []error
+	count := func(v int64, err error) int64 { errs = append(errs, err); return v }
+	group := func(v map[string]int64, err error) map[string]int64 { errs = append(errs, err); return v }
+
+	resp.TotalClicks = count(s.ClickCount(ctx, shortCode))
+	resp.UniqueClicks = count(s.UniqueClickCount(ctx, shortCode))
+	resp.ClicksByDay = group(s.ClicksByDay(ctx, shortCode, start, end))
+	resp.ClicksByMonth = group(s.ClicksByMonth(ctx, shortCode, start, end))
+	resp.TopUserAgents = group(s.ClicksByUserAgent(ctx, shortCode, start, end, limit))
+	resp.TopReferers = group(s.ClicksByReferer(ctx, shortCode, start, end, limit))
+	resp.TopIPs = group(s.ClicksByIP(ctx, shortCode, start, end, limit))
+	resp.ClicksBySource = group(s.ClicksBySource(ctx, shortCode, start, end))
+	// there are no per-column methods for the parsed User-Agent; an empty rollup window reads the raw clicks
+	raw := rollupWindow{start: time.Unix(0, 0), end: time.Unix(0, 0)}
+	resp.ClicksByBrowser = group(s.rollupCounts(ctx, linkScope(shortCode), byBrowser, start, end, raw, limit))
+	resp.ClicksByOS = group(s.rollupCounts(ctx, linkScope(shortCode), byOS, start, end, raw, limit))
+	resp.ClicksByDevice = group(s.rollupCounts(ctx, linkScope(shortCode), byDevice, start, end, raw, 0))
+	resp.ClicksByCountry = group(s.rollupCounts(ctx, linkScope(shortCode), byCountry, start, end, raw, limit))
+	resp.ClicksByCity = group(s.rollupCounts(ctx, linkScope(shortCode), byCity, start, end, raw, limit))
+	resp.ClicksByRule = group(s.rollupCounts(ctx, linkScope(shortCode), byRule, start, end, raw, 0))
+	variants, err := s.variantStats(ctx, shortCode, start, end, raw, true)
+	errs = append(errs, err)
+	resp.Variants = variants
+	if err := errors.Join(errs...); err != nil {
+		t.Fatalf("raw analytics: %v", err)
+	}
+	return resp
+}
+
+func TestAnalytics_RollupsMatchRawClicks_Integration(t *testing.T) {
+	s := newIntegrationStorage(t)
+	ctx := context.Background()
+
+	if _, err := s.SaveURL(ctx, domain.ShortenedURL{URL: "https://example.com", ShortCode: "fixture"}); err != nil {
+		t.Fatalf("failed to save url: %v", err)
+	}
+
+	// Three days of clicks at uneven minutes, so range bounds fall inside hours. Every top list has a clear
+	// leader, as the raw queries break ties arbitrarily.
+	base := time.Now().UTC().Truncate(time.Hour).Add(-72 * time.Hour)
+	uas := []string{
+		"curl/8.4.0",
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Mobile/15E148 Safari/604.1",
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+	}
+	refs := []string{"", "https://google.com", "", "https://t.co"}
+	places := []domain.Location{
+		{Country: "DE", Region: "Berlin", City: "Berlin"},
+		{},
+		{Country: "DE", Region: "Berlin", City: "Berlin"},
+		{Country: "US", Region: "Oregon", City: "Portland"},
+		{Country: "DE", Region: "Berlin", City: "Berlin"},
+		{Country: "DE"},
+	}
+	var clicks []domain.Click
+	for i := 0; i < 380; i++ {
+		c := domain.Click{
+			ShortCode: "fixture",
+			UserAgent: uas[i%len(uas)],
+			IP:        fmt.Sprintf("10.0.%d.%d", i%3, i%7),
+			Referer:   refs[i%len(refs)],
+			Timestamp: base.Add(time.Duration(i*11) * time.Minute),
+			Client:    useragent.Parse(uas[i%len(uas)]),
+			Location:  places[i%len(places)],
+		}
+		if i%2 == 0 {
+			c.IP = "10.0.0.1"
+		}
+		if i%5 == 0 {
+			c.Source = domain.ClickSourceQR
+		}
+		if i%4 != 3 {
+			c.Variant = []string{"a", "b"}[i%3%2]
+		} else if i%3 == 0 {
+			c.Rule = "ios"
+		}
+		clicks = append(clicks, c)
+	}
+	if err := s.SaveClicks(ctx, clicks); err != nil {
+		t.Fatalf("failed to save clicks: %v", err)
+	}
+
+	at := func(d time.Duration) *time.Time { v := base.Add(d); return &v }
+	ranges := []struct{ from, to *time.Time }{
+		{nil, nil},
+		{at(90 * time.Minute), nil},
+		{nil, at(50*time.Hour + 17*time.Minute)},
+		{at(5*time.Hour + 30*time.Minute), at(49 * time.Hour)},
+		{at(24 * time.Hour), at(48 * time.Hour)},
+	}
+	check := func(stage string) {
+		for i, r := range ranges {
+			for _, limit := range []int{1, 100} {
+				want := rawAnalytics(t, s, "fixture", r.from, r.to, limit)
+				got, err := s.Analytics(ctx, "fixture", r.from, r.to, limit, true)
+				if err != nil {
+					t.Fatalf("%s: range %d: %v", stage, i, err)
+				}
+				if !reflect.DeepEqual(want, got) {
+					t.Fatalf("%s: range %d, limit %d:\nwant %+v\n got %+v", stage, i, limit, want, got)
+				}
+
+				// Without bots, the curl clicks drop out of the totals but not out of the breakdowns
+				humans, err := s.Analytics(ctx, "fixture", r.from, r.to, limit, false)
+				if err != nil {
+					t.Fatalf("%s: range %d: %v", stage, i, err)
+				}
+				if humans.TotalClicks != want.TotalClicks-want.BotClicks || humans.UniqueClicks > want.UniqueClicks ||
+					!reflect.DeepEqual(humans.ClicksByDay, want.ClicksByDay) {
+					t.Fatalf("%s: range %d: unexpected counts without bots: %+v", stage, i, humans)
+				}
+			}
+		}
+	}
+
+	check("no rollups")
+	if _, err := s.RollupClicks(ctx, base.Add(30*time.Hour+20*time.Minute)); err != nil {
+		t.Fatalf("rollup failed: %v", err)
+	}
+	check("partial rollups")
+	if _, err := s.RollupClicks(ctx, time.Now().Add(-time.Hour)); err != nil {
+		t.Fatalf("rollup failed: %v", err)
+	}
+	check("full rollups")
+
+	// Clicks after the watermark are still read from the raw table
+	if err := s.SaveClicks(ctx, []domain.Click{{ShortCode: "fixture", IP: "10.9.9.9", Timestamp: time.Now()}}); err != nil {
+		t.Fatalf("failed to save click: %v", err)
+	}
+	check("after new clicks")
+}
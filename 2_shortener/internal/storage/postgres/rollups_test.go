@@ -0,0 +1,129 @@
+package postgres
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestNewRollupWindow(t *testing.T) {
+	at := func(h, m int) time.Time { return time.Date(2025, 1, 1, h, m, 0, 0, time.UTC) }
+	ptr := func(t time.Time) *time.Time { return &t }
+
+	tests := []struct {
+		name       string
+		from, to   *time.Time
+		rolled     time.Time
+		start, end time.Time
+	}{
+		{"nothing rolled", nil, nil, time.Time{}, time.Unix(0, 0).UTC(), time.Unix(0, 0).UTC()},
+		{"unbounded", nil, nil, at(12, 0), time.Unix(0, 0).UTC(), at(12, 0)},
+		{"partial hours are raw", ptr(at(9, 30)), ptr(at(11, 15)), at(12, 0), at(10, 0), at(11, 0)},
+		{"aligned bounds", ptr(at(9, 0)), ptr(at(11, 0)), at(12, 0), at(9, 0), at(11, 0)},
+		{"watermark inside range", ptr(at(9, 0)), ptr(at(18, 0)), at(12, 0), at(9, 0), at(12, 0)},
+		{"range after watermark", ptr(at(14, 0)), nil, at(12, 0), at(14, 0), at(14, 0)},
+		{"within one hour", ptr(at(9, 10)), ptr(at(9, 50)), at(12, 0), at(10, 0), at(10, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newRollupWindow(tt.from, tt.to, tt.rolled)
+			if !w.start.Equal(tt.start) || !w.end.Equal(tt.end) {
+				t.Fatalf("expected [%v, %v), got [%v, %v)", tt.start, tt.end, w.start, w.end)
+			}
+		})
+	}
+}
+
+func TestRollupClicks(t *testing.T) {
+	s, mock, done := newClickStorage(t)
+	defer done()
+
+	rolled := time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC)
+	until := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT rolled_until FROM click_rollup_state FOR UPDATE`)).
+		WillReturnRows(sqlmock.NewRows([]string{"rolled_until"}).AddRow(rolled))
+	for _, table := range []string{"click_rollups_hourly", "click_rollup_ips", "click_rollup_dims"} {
+		mock.ExpectExec(`INSERT INTO `+table).
+			WithArgs(rolled, until).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE click_rollup_state SET rolled_until = $1`)).
+		WithArgs(until).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	got, err := s.RollupClicks(context.Background(), until.Add(25*time.Minute))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !got.Equal(until) {
+		t.Fatalf("expected watermark %v, got %v", until, got)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestRollupClicks_UpToDate(t *testing.T) {
+	s, mock, done := newClickStorage(t)
+	defer done()
+
+	rolled := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT rolled_until FROM click_rollup_state FOR UPDATE`)).
+		WillReturnRows(sqlmock.NewRows([]string{"rolled_until"}).AddRow(rolled))
+	mock.ExpectCommit()
+
+	got, err := s.RollupClicks(context.Background(), rolled.Add(59*time.Minute))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !got.Equal(rolled) {
+		t.Fatalf("expected watermark %v, got %v", rolled, got)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestClickSeries(t *testing.T) {
+	s, mock, done := newClickStorage(t)
+	defer done()
+
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatalf("LoadLocation: %v", err)
+	}
+	rolled := time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)
+	from := time.Date(2025, 1, 1, 0, 0, 0, 0, tokyo)
+	to := time.Date(2025, 1, 3, 0, 0, 0, 0, tokyo).Add(-time.Microsecond)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT rolled_until FROM click_rollup_state`)).
+		WillReturnRows(sqlmock.NewRows([]string{"rolled_until"}).AddRow(rolled))
+	// the hours up to the watermark come from the rollups, the rest is counted raw
+	mock.ExpectQuery(`generate_series[\s\S]*FROM click_rollups_hourly[\s\S]*FROM clicks`).
+		WithArgs("abc", from, to, "day", "Asia/Tokyo", false, from, rolled).
+		WillReturnRows(sqlmock.NewRows([]string{"b", "n"}).
+			AddRow(time.Date(2024, 12, 31, 15, 0, 0, 0, time.UTC), int64(7)).
+			AddRow(time.Date(2025, 1, 1, 15, 0, 0, 0, time.UTC), int64(0)))
+
+	series, err := s.ClickSeries(context.Background(), "abc", from, to, "day", tokyo, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(series) != 2 || series[0].Clicks != 7 || series[0].Start.Location() != tokyo || !series[0].Start.Equal(from) {
+		t.Fatalf("unexpected series %+v", series)
+	}
+	if _, err := s.ClickSeries(context.Background(), "abc", from, to, "year", tokyo, false); err == nil {
+		t.Fatal("expected an error for an unknown granularity")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
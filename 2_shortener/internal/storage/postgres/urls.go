@@ -2,11 +2,14 @@ package postgres
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"shortener/internal/domain"
+	"shortener/internal/shortcode"
 	"shortener/internal/storage"
+	"strings"
 	"time"
 
-	"github.com/google/uuid"
 	"github.com/kxddry/wbf/dbpg"
 	"github.com/kxddry/wbf/retry"
 )
@@ -18,12 +21,14 @@ var Strategy = retry.Strategy{
 	Backoff:  2,
 }
 
-// maxGenerateAttempts is the maximum number of attempts to generate a unique short code.
+// maxGenerateAttempts is the maximum number of attempts to generate a unique short code. Generated codes are
+// never repeated, so only an alias that took one can use up an attempt.
 const maxGenerateAttempts = 10
 
 // Storage is the storage for the URLs.
 type Storage struct {
-	db *dbpg.DB
+	db    *dbpg.DB
+	codes CodeGenerator
 }
 
 // New creates a new Storage instance.
@@ -36,7 +41,13 @@ func New(ctx context.Context, master string, slaves []string) (*Storage, error)
 	if err != nil {
 		return nil, err
 	}
-	return &Storage{db: db}, nil
+	codec, err := shortcode.New(shortcode.Options{})
+	if err != nil {
+		return nil, err
+	}
+	s := &Storage{db: db}
+	s.codes = s.Sequence(codec)
+	return s, nil
 }
 
 // Close closes the Storage instance.
@@ -47,38 +58,101 @@ func (s *Storage) Close() error {
 	return s.db.Master.Close()
 }
 
-// SaveURL inserts a new URL with a generated short code.
-// If withAlias is true, the URL is inserted with the given alias.
-// If withAlias is false, the URL is inserted with a generated short code.
-// If the alias already exists, an error is returned.
-// If the short code cannot be generated after maxGenerateAttempts, an error is returned.
-func (s *Storage) SaveURL(ctx context.Context, url string, withAlias bool, alias string) (string, error) {
-	// handle edge cases
-	if alias == "" {
-		withAlias = false
-	}
-	const insertQuery = `
-		INSERT INTO shortened_urls (url, short_code, created_at)
-		VALUES ($1, $2, $3)
-		ON CONFLICT (short_code) DO NOTHING
-	`
+// linkColumns are the columns written for a new link, in the order of linkValues.
+var linkColumns = []struct{ name, cast string }{
+	{"url", ""},
+	{"short_code", ""},
+	{"created_at", ""},
+	{"expires_at", ""},
+	{"max_clicks", ""},
+	{"owner_id", ""},
+	{"password_hash", ""},
+	{"variants", "::jsonb"},
+	{"rules", "::jsonb"},
+	{"domain", ""},
+	{"redirect_type", ""},
+	{"card", "::jsonb"},
+}
+
+func linkValues(link domain.ShortenedURL, now time.Time) ([]any, error) {
+	variants, err := encodeList(link.Variants)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := encodeList(link.Rules)
+	if err != nil {
+		return nil, err
+	}
+	card, err := encodeCard(link.Card)
+	if err != nil {
+		return nil, err
+	}
+	return []any{
+		link.URL, link.ShortCode, now, link.ExpiresAt, link.MaxClicks,
+		nullIfEmpty(link.OwnerID), link.PasswordHash, variants, rules, link.Domain,
+		link.RedirectStatus(), card,
+	}, nil
+}
+
+// shortCodeArg is the position of short_code in linkValues.
+const shortCodeArg = 1
+
+// insertLinks returns an INSERT of n new links that skips the short codes already taken on their domain.
+func insertLinks(n int) string {
+	names := make([]string, len(linkColumns))
+	for i, col := range linkColumns {
+		names[i] = col.name
+	}
 
-	if withAlias {
-		res, err := s.db.ExecWithRetry(ctx, Strategy, insertQuery, url, alias, time.Now().UTC())
+	var q strings.Builder
+	q.WriteString(`INSERT INTO shortened_urls (` + strings.Join(names, ", ") + `) VALUES `)
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			q.WriteString(", ")
+		}
+		q.WriteString("(")
+		for j, col := range linkColumns {
+			if j > 0 {
+				q.WriteString(", ")
+			}
+			q.WriteString("$" + itoa(i*len(linkColumns)+j+1) + col.cast)
+		}
+		q.WriteString(")")
+	}
+	q.WriteString(` ON CONFLICT (domain, short_code) DO NOTHING`)
+	return q.String()
+}
+
+// SaveURL inserts a new link.
+// If link.ShortCode is set, it is used as the alias and an error is returned if it already exists on the link's domain.
+// Otherwise a short code is generated; if every one of maxGenerateAttempts is taken, an error is returned.
+func (s *Storage) SaveURL(ctx context.Context, link domain.ShortenedURL) (string, error) {
+	insertQuery := insertLinks(1)
+	args, err := linkValues(link, time.Now().UTC())
+	if err != nil {
+		return "", err
+	}
+
+	if link.ShortCode != "" {
+		res, err := s.db.ExecWithRetry(ctx, Strategy, insertQuery, args...)
 		if err != nil {
 			return "", err
 		}
 		if rows, err := res.RowsAffected(); err == nil && rows == 1 {
-			return alias, nil
+			return link.ShortCode, nil
 		}
 		return "", errors.New("alias already exists")
 	}
-	// we are using a cycle here because we want to generate a new short code if the first one is already taken
+	// a generated code can only be taken by an alias, in which case the next one is tried
 	for range maxGenerateAttempts {
-		shortCode := uuid.New().String()[:6]
-		now := time.Now().UTC()
+		codes, err := s.codes.Generate(ctx, 1)
+		if err != nil {
+			return "", err
+		}
+		shortCode := codes[0]
+		args[shortCodeArg] = shortCode
 
-		res, err := s.db.ExecWithRetry(ctx, Strategy, insertQuery, url, shortCode, now)
+		res, err := s.db.ExecWithRetry(ctx, Strategy, insertQuery, args...)
 		if err != nil {
 			return "", err
 		}
@@ -89,13 +163,111 @@ func (s *Storage) SaveURL(ctx context.Context, url string, withAlias bool, alias
 	return "", errors.New("could not generate unique short code after multiple attempts")
 }
 
-// GetURL retrieves the original URL for a given short code.
-func (s *Storage) GetURL(ctx context.Context, shortCode string) (string, error) {
+// linksPerInsert caps the rows of one multi-row INSERT of links, well below the 65535 parameter limit.
+const linksPerInsert = 1000
+
+// SaveURLs inserts new links with multi-row inserts and returns their short codes, in order. Links without
+// a short code get a generated one, as in SaveURL. A link whose alias already exists on its domain, or repeats
+// an alias earlier in links on the same domain, is not saved and gets "". If an insert fails, the links of
+// the inserts before it stay saved.
+func (s *Storage) SaveURLs(ctx context.Context, links []domain.ShortenedURL) ([]string, error) {
+	codes := make([]string, len(links))
+	now := time.Now().UTC()
+	values := make([][]any, len(links))
+	for i, link := range links {
+		v, err := linkValues(link, now)
+		if err != nil {
+			return codes, err
+		}
+		values[i] = v
+	}
+
+	for start := 0; start < len(links); start += linksPerInsert {
+		end := min(start+linksPerInsert, len(links))
+		if err := s.insertChunk(ctx, links[start:end], values[start:end], codes[start:end]); err != nil {
+			return codes, err
+		}
+	}
+	return codes, nil
+}
+
+// insertChunk inserts one chunk of SaveURLs, filling in codes. Rows whose generated short code was taken by
+// an alias are inserted again with a new one.
+func (s *Storage) insertChunk(ctx context.Context, links []domain.ShortenedURL, values [][]any, codes []string) error {
+	pending := make([]int, len(links))
+	for i := range pending {
+		pending[i] = i
+	}
+	for attempt := 0; len(pending) > 0; attempt++ {
+		if attempt == maxGenerateAttempts {
+			return errors.New("could not generate unique short codes after multiple attempts")
+		}
+
+		generate := 0
+		for _, i := range pending {
+			if links[i].ShortCode == "" {
+				generate++
+			}
+		}
+		generated, err := s.codes.Generate(ctx, generate)
+		if err != nil {
+			return err
+		}
+
+		// link keys are distinct within an insert, so the returned ones map back to their rows (index + 1);
+		// a generated code that an alias of the chunk has taken waits for the next attempt
+		rows := make(map[string]int, len(pending))
+		args := make([]any, 0, len(pending)*len(linkColumns))
+		for _, i := range pending {
+			link := links[i]
+			if link.ShortCode == "" {
+				link.ShortCode, generated = generated[0], generated[1:]
+			}
+			if rows[link.Key()] != 0 {
+				continue
+			}
+			rows[link.Key()] = i + 1
+			values[i][shortCodeArg] = link.ShortCode
+			args = append(args, values[i]...)
+		}
+
+		// writes must go to the master, QueryWithRetry may pick a replica, and a retried insert whose first
+		// try went through would find its own rows taken
+		r, err := s.db.Master.QueryContext(ctx, insertLinks(len(rows))+` RETURNING link_key, short_code`, args...)
+		if err != nil {
+			return err
+		}
+		for r.Next() {
+			var key, code string
+			if err := r.Scan(&key, &code); err != nil {
+				r.Close()
+				return err
+			}
+			codes[rows[key]-1] = code
+		}
+		r.Close()
+		if err := r.Err(); err != nil {
+			return err
+		}
+
+		retry := pending[:0]
+		for _, i := range pending {
+			if codes[i] == "" && links[i].ShortCode == "" {
+				retry = append(retry, i)
+			}
+		}
+		pending = retry
+	}
+	return nil
+}
+
+// GetURL retrieves the original URL of the link with the given key.
+func (s *Storage) GetURL(ctx context.Context, key string) (string, error) {
 	const query = `
-		SELECT url FROM shortened_urls WHERE short_code = $1
+		SELECT url FROM shortened_urls WHERE link_key = $1
 	`
 
-	rows, err := s.db.QueryWithRetry(ctx, Strategy, query, shortCode)
+	rows, err := s.db.QueryWithRetry(ctx, Strategy, query, key)
 	if err != nil {
 		return "", err
 	}
@@ -111,3 +283,243 @@ func (s *Storage) GetURL(ctx context.Context, shortCode string) (string, error)
 	}
 	return url, nil
 }
+
+// GetLink retrieves the full record of the link with the given key.
+func (s *Storage) GetLink(ctx context.Context, key string) (domain.ShortenedURL, error) {
+	const query = `
+		SELECT id, url, short_code, domain, created_at, expires_at, max_clicks, clicks_used, COALESCE(owner_id::text, ''), password_hash, variants, rules,
+		       disabled_at, disabled_reason, redirect_type, card
+		FROM shortened_urls WHERE link_key = $1
+	`
+
+	rows, err := s.db.QueryWithRetry(ctx, Strategy, query, key)
+	if err != nil {
+		return domain.ShortenedURL{}, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return domain.ShortenedURL{}, err
+		}
+		return domain.ShortenedURL{}, storage.ErrNotFound
+	}
+
+	var link domain.ShortenedURL
+	var variants, rules, card []byte
+	if err := rows.Scan(&link.ID, &link.URL, &link.ShortCode, &link.Domain, &link.CreatedAt, &link.ExpiresAt, &link.MaxClicks, &link.ClicksUsed, &link.OwnerID, &link.PasswordHash, &variants, &rules,
+		&link.DisabledAt, &link.DisabledReason, &link.RedirectType, &card); err != nil {
+		return domain.ShortenedURL{}, err
+	}
+	if link.Variants, err = decodeList[domain.Variant](variants); err != nil {
+		return domain.ShortenedURL{}, err
+	}
+	if link.Rules, err = decodeList[domain.Rule](rules); err != nil {
+		return domain.ShortenedURL{}, err
+	}
+	if link.Card, err = decodeCard(card); err != nil {
+		return domain.ShortenedURL{}, err
+	}
+	return link, nil
+}
+
+// UseClick atomically spends one click of the link's budget.
+// The row lock taken by UPDATE serialises concurrent redirects, so a budget of N admits exactly N clicks.
+// It returns storage.ErrExpired if the link is expired or its budget is exhausted.
+func (s *Storage) UseClick(ctx context.Context, key string) error {
+	const query = `
+		UPDATE shortened_urls SET clicks_used = clicks_used + 1
+		WHERE link_key = $1
+		  AND (max_clicks IS NULL OR clicks_used < max_clicks)
+		  AND (expires_at IS NULL OR expires_at > NOW())
+	`
+
+	// a retry after a commit whose reply was lost would spend a second click
+	res, err := s.db.Master.ExecContext(ctx, query, key)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return storage.ErrExpired
+	}
+	return nil
+}
+
+// ListLinks returns a page of the owner's links, newest first.
+// A non-empty query filters by a case-insensitive substring of the URL or the short code.
+func (s *Storage) ListLinks(ctx context.Context, ownerID, query string, limit, offset int) (domain.LinksPage, error) {
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	page := domain.LinksPage{Links: []domain.ShortenedURL{}, Limit: limit, Offset: offset}
+
+	const q = `
+		SELECT id, url, short_code, domain, created_at, expires_at, max_clicks, clicks_used, owner_id::text, variants, rules,
+		       disabled_at, disabled_reason, redirect_type, card, COUNT(*) OVER ()
+		FROM shortened_urls
+		WHERE owner_id = $1
+		  AND ($2::text = '' OR url ILIKE '%' || $2::text || '%' OR short_code ILIKE '%' || $2::text || '%')
+		ORDER BY created_at DESC, id DESC
+		LIMIT $3 OFFSET $4
+	`
+
+	rows, err := s.db.QueryWithRetry(ctx, Strategy, q, ownerID, escapeLike(query), limit, offset)
+	if err != nil {
+		return page, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var link domain.ShortenedURL
+		var variants, rules, card []byte
+		if err := rows.Scan(&link.ID, &link.URL, &link.ShortCode, &link.Domain, &link.CreatedAt, &link.ExpiresAt, &link.MaxClicks, &link.ClicksUsed, &link.OwnerID, &variants, &rules,
+			&link.DisabledAt, &link.DisabledReason, &link.RedirectType, &card, &page.Total); err != nil {
+			return page, err
+		}
+		if link.Variants, err = decodeList[domain.Variant](variants); err != nil {
+			return page, err
+		}
+		if link.Rules, err = decodeList[domain.Rule](rules); err != nil {
+			return page, err
+		}
+		if link.Card, err = decodeCard(card); err != nil {
+			return page, err
+		}
+		page.Links = append(page.Links, link)
+	}
+	return page, rows.Err()
+}
+
+// UpdateURL changes the destination of the owner's link. A multi-destination link becomes a plain one;
+// targeting rules are kept.
+// It returns storage.ErrNotFound if the link does not exist or belongs to someone else.
+func (s *Storage) UpdateURL(ctx context.Context, ownerID, key, url string) error {
+	const q = `UPDATE shortened_urls SET url = $3, variants = NULL WHERE link_key = $2 AND owner_id = $1`
+
+	return s.execOwned(ctx, q, ownerID, key, url)
+}
+
+// UpdateRedirect changes how the owner's link redirects: the status, unless redirectType is nil, and the card
+// preview bots are shown, unless card is nil. An empty card removes the link's own one.
+// It returns storage.ErrNotFound if the link does not exist or belongs to someone else.
+func (s *Storage) UpdateRedirect(ctx context.Context, ownerID, key string, redirectType *int, card *domain.Card) error {
+	encoded, err := encodeCard(card)
+	if err != nil {
+		return err
+	}
+	const q = `
+		UPDATE shortened_urls SET redirect_type = COALESCE($3, redirect_type),
+			card = CASE WHEN $4 THEN $5::jsonb ELSE card END
+		WHERE link_key = $2 AND owner_id = $1
+	`
+
+	return s.execOwned(ctx, q, ownerID, key, redirectType, card != nil, encoded)
+}
+
+// DeleteURL deletes the owner's link together with its clicks.
+// It returns storage.ErrNotFound if the link does not exist or belongs to someone else.
+func (s *Storage) DeleteURL(ctx context.Context, ownerID, key string) error {
+	const q = `DELETE FROM shortened_urls WHERE link_key = $2 AND owner_id = $1`
+
+	return s.execOwned(ctx, q, ownerID, key)
+}
+
+// DisableLink takes a link down, whoever owns it, with the reason given. Disabling a disabled link keeps
+// the time it was first disabled.
+// It returns storage.ErrNotFound if the link does not exist.
+func (s *Storage) DisableLink(ctx context.Context, key, reason string) error {
+	const q = `
+		UPDATE shortened_urls SET disabled_at = COALESCE(disabled_at, NOW()), disabled_reason = $2
+		WHERE link_key = $1
+	`
+
+	return s.execOwned(ctx, q, key, reason)
+}
+
+// EnableLink puts a disabled link back up.
+// It returns storage.ErrNotFound if the link does not exist.
+func (s *Storage) EnableLink(ctx context.Context, key string) error {
+	const q = `UPDATE shortened_urls SET disabled_at = NULL, disabled_reason = '' WHERE link_key = $1`
+
+	return s.execOwned(ctx, q, key)
+}
+
+// execOwned runs a mutation on a single link and maps "no rows" to storage.ErrNotFound.
+func (s *Storage) execOwned(ctx context.Context, query string, args ...any) error {
+	res, err := s.db.ExecWithRetry(ctx, Strategy, query, args...)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+// encodeList marshals a list such as the variants or rules of a link for its JSONB column, as text since
+// lib/pq would send bytes as bytea. An empty list is stored as NULL.
+func encodeList[T any](items []T) (any, error) {
+	if len(items) == 0 {
+		return nil, nil
+	}
+	raw, err := json.Marshal(items)
+	if err != nil {
+		return nil, err
+	}
+	return string(raw), nil
+}
+
+// encodeCard marshals the card of a link for its JSONB column, like encodeList. A missing or empty card is
+// stored as NULL.
+func encodeCard(card *domain.Card) (any, error) {
+	if card == nil || card.Empty() {
+		return nil, nil
+	}
+	raw, err := json.Marshal(card)
+	if err != nil {
+		return nil, err
+	}
+	return string(raw), nil
+}
+
+func decodeCard(raw []byte) (*domain.Card, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	var card domain.Card
+	err := json.Unmarshal(raw, &card)
+	return &card, err
+}
+
+func decodeList[T any](raw []byte) ([]T, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	var items []T
+	err := json.Unmarshal(raw, &items)
+	return items, err
+}
+
+// nullIfEmpty maps an empty string to SQL NULL.
+func nullIfEmpty(v string) any {
+	if v == "" {
+		return nil
+	}
+	return v
+}
+
+// escapeLike escapes the LIKE wildcards in a user-supplied search string.
+func escapeLike(v string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(v)
+}
@@ -0,0 +1,108 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"shortener/internal/domain"
+	"shortener/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+func TestSaveURLs_Integration(t *testing.T) {
+	s := newIntegrationStorage(t)
+	ctx := context.Background()
+
+	if _, err := s.SaveURL(ctx, domain.ShortenedURL{URL: "https://example.com", ShortCode: "taken"}); err != nil {
+		t.Fatalf("failed to save url: %v", err)
+	}
+	// more links than fit one insert, with a taken alias in the second chunk
+	links := make([]domain.ShortenedURL, linksPerInsert+5)
+	for i := range links {
+		links[i] = domain.ShortenedURL{URL: fmt.Sprintf("https://example.com/%d", i)}
+	}
+	links[3].ShortCode = "alias-3"
+	links[linksPerInsert+1].ShortCode = "taken"
+	links[linksPerInsert+2].Rules = []domain.Rule{{Name: "ios", URL: "https://apps.apple.com", Devices: []string{"ios"}}}
+
+	codes, err := s.SaveURLs(ctx, links)
+	if err != nil {
+		t.Fatalf("SaveURLs: %v", err)
+	}
+	seen := make(map[string]bool)
+	for i, code := range codes {
+		if i == linksPerInsert+1 {
+			if code != "" {
+				t.Fatalf("expected the taken alias to be refused, got %q", code)
+			}
+			continue
+		}
+		if code == "" || seen[code] {
+			t.Fatalf("row %d: expected a unique short code, got %q", i, code)
+		}
+		seen[code] = true
+	}
+	if codes[3] != "alias-3" {
+		t.Fatalf("expected the alias to be kept, got %q", codes[3])
+	}
+
+	link, err := s.GetLink(ctx, codes[linksPerInsert+2])
+	if err != nil || link.URL != links[linksPerInsert+2].URL || len(link.Rules) != 1 {
+		t.Fatalf("expected the saved link with its rules, got %+v: %v", link, err)
+	}
+}
+
+func TestShortCodesPerDomain_Integration(t *testing.T) {
+	s := newIntegrationStorage(t)
+	ctx := context.Background()
+
+	owner := uuid.NewString()
+	for _, host := range []string{"go.brand-a.com", "go.brand-b.com"} {
+		if _, err := s.CreateDomain(ctx, domain.Domain{Domain: host, OwnerID: owner}); err != nil {
+			t.Fatalf("CreateDomain(%s): %v", host, err)
+		}
+	}
+	if _, err := s.CreateDomain(ctx, domain.Domain{Domain: "go.brand-a.com", OwnerID: uuid.NewString()}); !errors.Is(err, storage.ErrDomainTaken) {
+		t.Fatalf("expected ErrDomainTaken, got %v", err)
+	}
+
+	// the same code on the default domain and both branded ones is three links
+	for _, host := range []string{"", "go.brand-a.com", "go.brand-b.com"} {
+		link := domain.ShortenedURL{URL: "https://example.com/" + host, ShortCode: "sale", Domain: host, OwnerID: owner}
+		if _, err := s.SaveURL(ctx, link); err != nil {
+			t.Fatalf("SaveURL on %q: %v", host, err)
+		}
+	}
+	if _, err := s.SaveURL(ctx, domain.ShortenedURL{URL: "https://example.com", ShortCode: "sale", Domain: "go.brand-a.com"}); err == nil {
+		t.Fatal("expected the alias to be taken on its domain")
+	}
+
+	link, err := s.GetLink(ctx, domain.LinkKey("go.brand-b.com", "sale"))
+	if err != nil || link.URL != "https://example.com/go.brand-b.com" || link.Domain != "go.brand-b.com" || link.ShortCode != "sale" {
+		t.Fatalf("unexpected link %+v: %v", link, err)
+	}
+	if err := s.SaveClick(ctx, domain.Click{ShortCode: link.Key(), IP: "192.0.2.1", Timestamp: time.Now()}); err != nil {
+		t.Fatalf("SaveClick: %v", err)
+	}
+	if n, err := s.ClickCount(ctx, "sale"); err != nil || n != 0 {
+		t.Fatalf("expected no clicks on the default domain's link, got %d: %v", n, err)
+	}
+
+	if err := s.DeleteDomain(ctx, owner, "go.brand-b.com"); !errors.Is(err, storage.ErrDomainInUse) {
+		t.Fatalf("expected ErrDomainInUse, got %v", err)
+	}
+	if err := s.DeleteURL(ctx, owner, link.Key()); err != nil {
+		t.Fatalf("DeleteURL: %v", err)
+	}
+	if err := s.DeleteDomain(ctx, owner, "go.brand-b.com"); err != nil {
+		t.Fatalf("DeleteDomain: %v", err)
+	}
+	domains, err := s.Domains(ctx)
+	if err != nil || len(domains) != 1 || domains[0].Domain != "go.brand-a.com" {
+		t.Fatalf("unexpected domains %+v: %v", domains, err)
+	}
+}
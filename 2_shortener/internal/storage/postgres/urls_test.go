@@ -2,8 +2,15 @@ package postgres
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"reflect"
 	"regexp"
 	"testing"
+	"time"
+
+	"shortener/internal/domain"
+	"shortener/internal/storage"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/kxddry/wbf/dbpg"
@@ -15,7 +22,19 @@ func newTestStorage(t *testing.T) (*Storage, sqlmock.Sqlmock, func()) {
 		t.Fatalf("failed to create sqlmock: %v", err)
 	}
 	wrap := &dbpg.DB{Master: db}
-	return &Storage{db: wrap}, mock, func() { _ = db.Close() }
+	return &Storage{db: wrap, codes: &stubCodes{}}, mock, func() { _ = db.Close() }
+}
+
+// stubCodes hands out gen001, gen002 and so on, without a sequence to mock.
+type stubCodes struct{ n int }
+
+func (g *stubCodes) Generate(ctx context.Context, n int) ([]string, error) {
+	codes := make([]string, n)
+	for i := range codes {
+		g.n++
+		codes[i] = fmt.Sprintf("gen%03d", g.n)
+	}
+	return codes, nil
 }
 
 func TestSaveURL_Success(t *testing.T) {
@@ -24,10 +43,10 @@ func TestSaveURL_Success(t *testing.T) {
 
 	insertRe := regexp.MustCompile(`INSERT\s+INTO\s+shortened_urls`)
 	mock.ExpectExec(insertRe.String()).
-		WithArgs("https://example.com", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WithArgs("https://example.com", sqlmock.AnyArg(), sqlmock.AnyArg(), nil, nil, nil, "", nil, nil, "", 307, nil).
 		WillReturnResult(sqlmock.NewResult(0, 1))
 
-	code, err := s.SaveURL(context.Background(), "https://example.com", false, "")
+	code, err := s.SaveURL(context.Background(), domain.ShortenedURL{URL: "https://example.com"})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -39,6 +58,67 @@ func TestSaveURL_Success(t *testing.T) {
 	}
 }
 
+func TestSaveURLs_Aliases(t *testing.T) {
+	s, mock, closeFn := newTestStorage(t)
+	defer closeFn()
+
+	// the repeated alias stays out of the insert, the same alias on another domain goes in, and the taken
+	// one is missing from RETURNING
+	mock.ExpectQuery(regexp.QuoteMeta(`VALUES ($1, $2, $3, $4, $5, $6, $7, $8::jsonb, $9::jsonb, $10, $11, $12::jsonb), ($13, $14, $15, $16, $17, $18, $19, $20::jsonb, $21::jsonb, $22, $23, $24::jsonb), ($25,`)+
+		`.*`+regexp.QuoteMeta(`ON CONFLICT (domain, short_code) DO NOTHING RETURNING link_key, short_code`)).
+		WithArgs(
+			"https://example.com/a", "a", sqlmock.AnyArg(), nil, nil, nil, "", nil, nil, "", 307, nil,
+			"https://example.com/b", "b", sqlmock.AnyArg(), nil, nil, nil, "", nil, nil, "", 307, nil,
+			"https://example.com/ba", "a", sqlmock.AnyArg(), nil, nil, nil, "", nil, nil, "go.brand.com", 307, nil,
+			"https://example.com/t", "taken", sqlmock.AnyArg(), nil, nil, nil, "", nil, nil, "", 307, nil,
+		).
+		WillReturnRows(sqlmock.NewRows([]string{"link_key", "short_code"}).AddRow("b", "b").AddRow("go.brand.com/a", "a").AddRow("a", "a"))
+
+	codes, err := s.SaveURLs(context.Background(), []domain.ShortenedURL{
+		{URL: "https://example.com/a", ShortCode: "a"},
+		{URL: "https://example.com/b", ShortCode: "b"},
+		{URL: "https://example.com/a2", ShortCode: "a"},
+		{URL: "https://example.com/ba", ShortCode: "a", Domain: "go.brand.com"},
+		{URL: "https://example.com/t", ShortCode: "taken"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"a", "b", "", "a", ""}; !reflect.DeepEqual(codes, want) {
+		t.Fatalf("expected %v, got %v", want, codes)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestSaveURLs_RetriesCollidedCodes(t *testing.T) {
+	s, mock, closeFn := newTestStorage(t)
+	defer closeFn()
+
+	// the alias goes in on the first insert; only the generated code that collided is tried again
+	mock.ExpectQuery(`INSERT INTO shortened_urls .* RETURNING link_key, short_code`).
+		WithArgs("https://example.com/a", "a", sqlmock.AnyArg(), nil, nil, nil, "", nil, nil, "", 307, nil,
+			"https://example.com/g", sqlmock.AnyArg(), sqlmock.AnyArg(), nil, nil, nil, "", nil, nil, "", 307, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"link_key", "short_code"}).AddRow("a", "a"))
+	for range maxGenerateAttempts - 1 {
+		mock.ExpectQuery(`INSERT INTO shortened_urls .* RETURNING link_key, short_code`).
+			WithArgs("https://example.com/g", sqlmock.AnyArg(), sqlmock.AnyArg(), nil, nil, nil, "", nil, nil, "", 307, nil).
+			WillReturnRows(sqlmock.NewRows([]string{"link_key", "short_code"}))
+	}
+
+	codes, err := s.SaveURLs(context.Background(), []domain.ShortenedURL{
+		{URL: "https://example.com/a", ShortCode: "a"},
+		{URL: "https://example.com/g"},
+	})
+	if err == nil || codes[0] != "a" || codes[1] != "" {
+		t.Fatalf("expected the alias saved and the generated code to give up, got %v, %v", codes, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
 func TestGetURL_Found(t *testing.T) {
 	s, mock, closeFn := newTestStorage(t)
 	defer closeFn()
@@ -82,10 +162,10 @@ func TestSaveURL_WithAlias_Success(t *testing.T) {
 
 	insertRe := regexp.MustCompile(`INSERT\s+INTO\s+shortened_urls`)
 	mock.ExpectExec(insertRe.String()).
-		WithArgs("https://example.com", "my-alias", sqlmock.AnyArg()).
+		WithArgs("https://example.com", "my-alias", sqlmock.AnyArg(), nil, nil, nil, "", nil, nil, "", 307, nil).
 		WillReturnResult(sqlmock.NewResult(0, 1))
 
-	code, err := s.SaveURL(context.Background(), "https://example.com", true, "my-alias")
+	code, err := s.SaveURL(context.Background(), domain.ShortenedURL{URL: "https://example.com", ShortCode: "my-alias"})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -103,10 +183,10 @@ func TestSaveURL_WithAlias_AlreadyExists(t *testing.T) {
 
 	insertRe := regexp.MustCompile(`INSERT\s+INTO\s+shortened_urls`)
 	mock.ExpectExec(insertRe.String()).
-		WithArgs("https://example.com", "existing-alias", sqlmock.AnyArg()).
+		WithArgs("https://example.com", "existing-alias", sqlmock.AnyArg(), nil, nil, nil, "", nil, nil, "", 307, nil).
 		WillReturnResult(sqlmock.NewResult(0, 0)) // 0 rows affected = conflict
 
-	_, err := s.SaveURL(context.Background(), "https://example.com", true, "existing-alias")
+	_, err := s.SaveURL(context.Background(), domain.ShortenedURL{URL: "https://example.com", ShortCode: "existing-alias"})
 	if err == nil {
 		t.Fatalf("expected error for existing alias, got nil")
 	}
@@ -116,4 +196,212 @@ func TestSaveURL_WithAlias_AlreadyExists(t *testing.T) {
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Fatalf("unmet expectations: %v", err)
 	}
-}
\ No newline at end of file
+}
+
+func TestSaveURL_WithExpiration(t *testing.T) {
+	s, mock, closeFn := newTestStorage(t)
+	defer closeFn()
+
+	expires := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	budget := int64(5)
+	insertRe := regexp.MustCompile(`INSERT\s+INTO\s+shortened_urls`)
+	mock.ExpectExec(insertRe.String()).
+		WithArgs("https://example.com", "promo", sqlmock.AnyArg(), &expires, &budget, nil, "", nil, nil, "", 307, nil).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	_, err := s.SaveURL(context.Background(), domain.ShortenedURL{
+		URL: "https://example.com", ShortCode: "promo", ExpiresAt: &expires, MaxClicks: &budget,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestUseClick_BudgetExhausted(t *testing.T) {
+	s, mock, closeFn := newTestStorage(t)
+	defer closeFn()
+
+	updateRe := regexp.MustCompile(`UPDATE\s+shortened_urls\s+SET\s+clicks_used\s+=\s+clicks_used\s+\+\s+1`)
+	mock.ExpectExec(updateRe.String()).
+		WithArgs("promo").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(updateRe.String()).
+		WithArgs("promo").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	if err := s.UseClick(context.Background(), "promo"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := s.UseClick(context.Background(), "promo"); !errors.Is(err, storage.ErrExpired) {
+		t.Fatalf("expected ErrExpired, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestSaveURL_WithVariants(t *testing.T) {
+	s, mock, closeFn := newTestStorage(t)
+	defer closeFn()
+
+	// JSONB goes over the wire as text; lib/pq would send a []byte as bytea
+	mock.ExpectExec(`INSERT\s+INTO\s+shortened_urls`).
+		WithArgs("https://example.com/a", "ab", sqlmock.AnyArg(), nil, nil, nil, "",
+			`[{"name":"a","url":"https://example.com/a","weight":1},{"name":"b","url":"https://example.com/b","weight":1}]`, nil, "", 307, nil).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	_, err := s.SaveURL(context.Background(), domain.ShortenedURL{URL: "https://example.com/a", ShortCode: "ab", Variants: []domain.Variant{
+		{Name: "a", URL: "https://example.com/a", Weight: 1},
+		{Name: "b", URL: "https://example.com/b", Weight: 1},
+	}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestListLinks(t *testing.T) {
+	s, mock, closeFn := newTestStorage(t)
+	defer closeFn()
+
+	created := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	rows := sqlmock.NewRows([]string{"id", "url", "short_code", "domain", "created_at", "expires_at", "max_clicks", "clicks_used", "owner_id", "variants", "rules", "disabled_at", "disabled_reason", "redirect_type", "card", "count"}).
+		AddRow("1", "https://example.com/a_b", "promo", "", created, nil, nil, int64(0), "owner",
+			[]byte(`[{"name":"a","url":"https://example.com/a","weight":1},{"name":"b","url":"https://example.com/b","weight":3}]`),
+			[]byte(`[{"name":"ios","url":"https://apps.apple.com/app/id1","devices":["ios"]}]`), created, "phishing",
+			301, []byte(`{"title":"Sale"}`), int64(7))
+	mock.ExpectQuery(`FROM\s+shortened_urls\s+WHERE\s+owner_id = \$1`).
+		WithArgs("owner", `a\_b`, 5, 5).
+		WillReturnRows(rows)
+
+	page, err := s.ListLinks(context.Background(), "owner", "a_b", 5, 5)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if page.Total != 7 || len(page.Links) != 1 || page.Links[0].ShortCode != "promo" {
+		t.Fatalf("unexpected page: %+v", page)
+	}
+	if v := page.Links[0].Variants; len(v) != 2 || v[1].Name != "b" || v[1].Weight != 3 {
+		t.Fatalf("unexpected variants: %+v", v)
+	}
+	if r := page.Links[0].Rules; len(r) != 1 || r[0].Name != "ios" || len(r[0].Devices) != 1 {
+		t.Fatalf("unexpected rules: %+v", r)
+	}
+	if l := page.Links[0]; l.DisabledAt == nil || !l.DisabledAt.Equal(created) || l.DisabledReason != "phishing" {
+		t.Fatalf("unexpected disabled state: %v %q", l.DisabledAt, l.DisabledReason)
+	}
+	if l := page.Links[0]; l.RedirectType != 301 || l.Card == nil || l.Card.Title != "Sale" {
+		t.Fatalf("unexpected redirect: %d %+v", l.RedirectType, l.Card)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestUpdateURL_NotOwned(t *testing.T) {
+	s, mock, closeFn := newTestStorage(t)
+	defer closeFn()
+
+	mock.ExpectExec(`UPDATE\s+shortened_urls\s+SET\s+url`).
+		WithArgs("owner", "promo", "https://example.org").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	err := s.UpdateURL(context.Background(), "owner", "promo", "https://example.org")
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestUpdateRedirect(t *testing.T) {
+	s, mock, closeFn := newTestStorage(t)
+	defer closeFn()
+
+	// a nil card is left alone, an empty one is removed
+	permanent := 308
+	mock.ExpectExec(`UPDATE\s+shortened_urls\s+SET\s+redirect_type = COALESCE\(\$3, redirect_type\)`).
+		WithArgs("owner", "promo", &permanent, false, nil).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE\s+shortened_urls`).
+		WithArgs("owner", "promo", nil, true, nil).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE\s+shortened_urls`).
+		WithArgs("other", "promo", nil, true, `{"title":"Sale"}`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	if err := s.UpdateRedirect(context.Background(), "owner", "promo", &permanent, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := s.UpdateRedirect(context.Background(), "owner", "promo", nil, &domain.Card{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err := s.UpdateRedirect(context.Background(), "other", "promo", nil, &domain.Card{Title: "Sale"})
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestDeleteURL_Success(t *testing.T) {
+	s, mock, closeFn := newTestStorage(t)
+	defer closeFn()
+
+	mock.ExpectExec(`DELETE\s+FROM\s+shortened_urls`).
+		WithArgs("owner", "promo").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := s.DeleteURL(context.Background(), "owner", "promo"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestDisableLink(t *testing.T) {
+	s, mock, closeFn := newTestStorage(t)
+	defer closeFn()
+
+	mock.ExpectExec(`UPDATE\s+shortened_urls\s+SET\s+disabled_at = COALESCE\(disabled_at, NOW\(\)\)`).
+		WithArgs("promo", "phishing").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE\s+shortened_urls\s+SET\s+disabled_at = NULL`).
+		WithArgs("gone").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	if err := s.DisableLink(context.Background(), "promo", "phishing"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := s.EnableLink(context.Background(), "gone"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestOwnerByAPIKey_Unknown(t *testing.T) {
+	s, mock, closeFn := newTestStorage(t)
+	defer closeFn()
+
+	mock.ExpectQuery(`SELECT\s+owner_id::text\s+FROM\s+api_keys`).
+		WithArgs(hashAPIKey("nope")).
+		WillReturnRows(sqlmock.NewRows([]string{"owner_id"}))
+
+	_, err := s.OwnerByAPIKey(context.Background(), "nope")
+	if !errors.Is(err, storage.ErrUnauthorized) {
+		t.Fatalf("expected ErrUnauthorized, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
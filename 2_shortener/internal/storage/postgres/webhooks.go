@@ -0,0 +1,284 @@
+package postgres
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"shortener/internal/domain"
+	"shortener/internal/storage"
+)
+
+// Defaults of spike webhooks.
+const (
+	DefaultSpikeWindow = 5 * time.Minute
+	DefaultSpikeFactor = 3
+)
+
+// CreateWebhook subscribes the owner of a link to one of its click events, generating the secret the
+// callbacks are signed with. It returns storage.ErrNotFound if the link does not exist or belongs to
+// someone else.
+func (s *Storage) CreateWebhook(ctx context.Context, w domain.Webhook) (domain.Webhook, error) {
+	raw := make([]byte, 24)
+	if _, err := rand.Read(raw); err != nil {
+		return domain.Webhook{}, err
+	}
+	w.Secret = "whsec_" + hex.EncodeToString(raw)
+	if w.Event == domain.WebhookSpike {
+		if w.WindowSeconds == 0 {
+			w.WindowSeconds = int(DefaultSpikeWindow / time.Second)
+		}
+		if w.Factor == 0 {
+			w.Factor = DefaultSpikeFactor
+		}
+	} else {
+		w.WindowSeconds, w.Factor = 0, 0
+	}
+
+	// writes must go to the master, QueryWithRetry may pick a replica; the parameters are cast because in
+	// a SELECT list they would be taken for text
+	const q = `
+		INSERT INTO webhooks (link_key, owner_id, url, secret, event, threshold, window_seconds, factor)
+		SELECT link_key, owner_id, $3::text, $4::text, $5::text, $6::bigint, $7::integer, $8::double precision FROM shortened_urls
+		WHERE link_key = $1 AND owner_id = $2
+		RETURNING id::text, created_at
+	`
+	rows, err := s.db.Master.QueryContext(ctx, q, domain.LinkKey(w.Domain, w.ShortCode), w.OwnerID,
+		w.URL, w.Secret, w.Event, w.Threshold, w.WindowSeconds, w.Factor)
+	if err != nil {
+		return domain.Webhook{}, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return domain.Webhook{}, err
+		}
+		return domain.Webhook{}, storage.ErrNotFound
+	}
+	if err := rows.Scan(&w.ID, &w.CreatedAt); err != nil {
+		return domain.Webhook{}, err
+	}
+	return w, nil
+}
+
+// ListWebhooks returns the owner's webhooks of the link, oldest first, without their secrets.
+func (s *Storage) ListWebhooks(ctx context.Context, ownerID, key string) ([]domain.Webhook, error) {
+	const q = `
+		SELECT id::text, link_key, url, event, threshold, window_seconds, factor, fired_at, created_at
+		FROM webhooks
+		WHERE owner_id = $1 AND link_key = $2
+		ORDER BY created_at, id
+	`
+	rows, err := s.db.QueryWithRetry(ctx, Strategy, q, ownerID, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []domain.Webhook{}
+	for rows.Next() {
+		var w domain.Webhook
+		var key string
+		if err := rows.Scan(&w.ID, &key, &w.URL, &w.Event, &w.Threshold, &w.WindowSeconds, &w.Factor, &w.FiredAt, &w.CreatedAt); err != nil {
+			return nil, err
+		}
+		w.Domain, w.ShortCode = domain.SplitLinkKey(key)
+		webhooks = append(webhooks, w)
+	}
+	return webhooks, rows.Err()
+}
+
+// DeleteWebhook removes the owner's webhook along with its delivery log.
+// It returns storage.ErrNotFound if the webhook does not exist or belongs to someone else.
+func (s *Storage) DeleteWebhook(ctx context.Context, ownerID, id string) error {
+	res, err := s.db.ExecWithRetry(ctx, Strategy, `DELETE FROM webhooks WHERE id = $1 AND owner_id = $2`, id, ownerID)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+// WebhookDeliveries returns the latest limit deliveries of the owner's webhook, newest first.
+// It returns storage.ErrNotFound if the webhook does not exist or belongs to someone else.
+func (s *Storage) WebhookDeliveries(ctx context.Context, ownerID, id string, limit int) ([]domain.WebhookDelivery, error) {
+	if limit <= 0 {
+		limit = 50
+	}
+	var found bool
+	exists, err := s.db.QueryWithRetry(ctx, Strategy, `SELECT EXISTS (SELECT 1 FROM webhooks WHERE id = $1 AND owner_id = $2)`, id, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer exists.Close()
+	if exists.Next() {
+		if err := exists.Scan(&found); err != nil {
+			return nil, err
+		}
+	}
+	if err := exists.Err(); err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, storage.ErrNotFound
+	}
+
+	const q = `
+		SELECT id::text, webhook_id::text, event, payload, status, attempts, response_status, error,
+			created_at, next_attempt_at, delivered_at
+		FROM webhook_deliveries
+		WHERE webhook_id = $1
+		ORDER BY created_at DESC, id
+		LIMIT $2
+	`
+	rows, err := s.db.QueryWithRetry(ctx, Strategy, q, id, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []domain.WebhookDelivery{}
+	for rows.Next() {
+		var d domain.WebhookDelivery
+		var payload []byte
+		if err := rows.Scan(&d.ID, &d.WebhookID, &d.Event, &payload, &d.Status, &d.Attempts, &d.ResponseStatus, &d.Error,
+			&d.CreatedAt, &d.NextAttemptAt, &d.DeliveredAt); err != nil {
+			return nil, err
+		}
+		d.Payload = json.RawMessage(payload)
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// FireWebhooks checks the webhooks of the links with the keys against their clicks at the time at, and queues
+// a delivery for each one that fires. It returns the number of deliveries queued.
+//
+// Threshold webhooks count human clicks from the rollups and the raw clicks after them, and fire once. Spike
+// webhooks compare the human clicks of their window with the clicks of the day before it, scaled down to one
+// window, and fire at most once per window. Marking a webhook fired and queueing its delivery is one statement,
+// and the firing condition is checked again on the row it updates, so concurrent batches fire it only once.
+func (s *Storage) FireWebhooks(ctx context.Context, keys []string, at time.Time) (int, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	keysJSON, err := json.Marshal(keys)
+	if err != nil {
+		return 0, err
+	}
+
+	const q = `
+		WITH state AS (
+			SELECT COALESCE(MAX(rolled_until), '-infinity') AS rolled FROM click_rollup_state
+		), due AS (
+			SELECT w.id, w.link_key, w.event, w.threshold, w.window_seconds, w.factor,
+				make_interval(secs => w.window_seconds) AS win
+			FROM webhooks w
+			WHERE w.link_key IN (SELECT jsonb_array_elements_text($1::jsonb))
+				AND (w.fired_at IS NULL OR w.event = 'spike' AND w.fired_at <= $2::timestamptz - make_interval(secs => w.window_seconds))
+		), counted AS (
+			SELECT d.*,
+				CASE WHEN d.event = 'threshold' THEN
+					(SELECT COALESCE(SUM(r.clicks - r.bot_clicks), 0) FROM click_rollups_hourly r, state
+						WHERE r.short_code = d.link_key AND r.hour < state.rolled)::bigint
+					+ (SELECT COUNT(*) FROM clicks c, state
+						WHERE c.short_code = d.link_key AND c.timestamp >= state.rolled AND NOT c.is_bot)
+				ELSE
+					(SELECT COUNT(*) FROM clicks c
+						WHERE c.short_code = d.link_key AND c.timestamp > $2::timestamptz - d.win AND c.timestamp <= $2::timestamptz AND NOT c.is_bot)
+				END AS clicks,
+				CASE WHEN d.event = 'spike' THEN
+					(SELECT COUNT(*) FROM clicks c
+						WHERE c.short_code = d.link_key AND NOT c.is_bot
+							AND c.timestamp > $2::timestamptz - d.win - interval '1 day' AND c.timestamp <= $2::timestamptz - d.win)
+						* d.window_seconds / 86400.0
+				END AS usual
+			FROM due d
+		), fired AS (
+			UPDATE webhooks w SET fired_at = $2::timestamptz
+			FROM counted c
+			WHERE w.id = c.id AND c.clicks >= c.threshold
+				AND (c.event = 'threshold' OR c.clicks >= c.factor * c.usual)
+				AND (w.fired_at IS NULL OR w.event = 'spike' AND w.fired_at <= $2::timestamptz - c.win)
+			RETURNING w.id, w.link_key, c.event, c.threshold, c.window_seconds, c.clicks, c.usual
+		)
+		INSERT INTO webhook_deliveries (webhook_id, event, payload, next_attempt_at)
+		SELECT f.id, f.event, jsonb_strip_nulls(jsonb_build_object(
+				'event', f.event,
+				'webhook_id', f.id,
+				'short_code', u.short_code,
+				'domain', NULLIF(u.domain, ''),
+				'clicks', f.clicks,
+				'threshold', f.threshold,
+				'window_seconds', NULLIF(f.window_seconds, 0),
+				'usual_clicks', round(f.usual::numeric, 2),
+				'fired_at', $2::timestamptz
+			)), $2::timestamptz
+		FROM fired f JOIN shortened_urls u ON u.link_key = f.link_key
+	`
+	res, err := s.db.ExecWithRetry(ctx, Strategy, q, string(keysJSON), at)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// ClaimDeliveries returns up to limit pending deliveries that are due, with the URL and secret of their
+// webhook, and puts their next attempt lease from now. A delivery is retried once its lease runs out, so a
+// delivery claimed by an instance that dies before finishing it is not lost, and SKIP LOCKED keeps instances
+// from claiming the same ones.
+func (s *Storage) ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]domain.WebhookDelivery, error) {
+	const q = `
+		WITH due AS (
+			SELECT id FROM webhook_deliveries
+			WHERE status = 'pending' AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		UPDATE webhook_deliveries d SET next_attempt_at = NOW() + make_interval(secs => $2)
+		FROM due, webhooks w
+		WHERE d.id = due.id AND w.id = d.webhook_id
+		RETURNING d.id::text, d.webhook_id::text, d.event, d.payload, d.status, d.attempts, d.created_at, w.url, w.secret
+	`
+	rows, err := s.db.Master.QueryContext(ctx, q, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []domain.WebhookDelivery
+	for rows.Next() {
+		var d domain.WebhookDelivery
+		var payload []byte
+		if err := rows.Scan(&d.ID, &d.WebhookID, &d.Event, &payload, &d.Status, &d.Attempts, &d.CreatedAt, &d.URL, &d.Secret); err != nil {
+			return nil, err
+		}
+		d.Payload = json.RawMessage(payload)
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// FinishDelivery records the outcome of an attempt: the delivery's status, attempts, response, error and
+// when it is attempted next, if at all.
+func (s *Storage) FinishDelivery(ctx context.Context, d domain.WebhookDelivery) error {
+	const q = `
+		UPDATE webhook_deliveries
+		SET status = $2, attempts = $3, response_status = $4, error = $5, next_attempt_at = $6, delivered_at = $7
+		WHERE id = $1
+	`
+	_, err := s.db.ExecWithRetry(ctx, Strategy, q, d.ID, d.Status, d.Attempts, d.ResponseStatus, d.Error,
+		d.NextAttemptAt, d.DeliveredAt)
+	return err
+}
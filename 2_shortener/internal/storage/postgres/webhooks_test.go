@@ -0,0 +1,84 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"shortener/internal/domain"
+	"shortener/internal/storage"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestCreateWebhook(t *testing.T) {
+	s, mock, closeFn := newTestStorage(t)
+	defer closeFn()
+
+	created := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	// a spike webhook gets the default window and factor
+	mock.ExpectQuery(`INSERT\s+INTO\s+webhooks .* FROM shortened_urls\s+WHERE link_key = \$1 AND owner_id = \$2`).
+		WithArgs("go.brand.com/sale", "owner", "https://hooks.example.com", sqlmock.AnyArg(), "spike", int64(50), 300, 3.0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow("wh1", created))
+	mock.ExpectQuery(`INSERT\s+INTO\s+webhooks`).
+		WithArgs("sale", "other", "https://hooks.example.com", sqlmock.AnyArg(), "threshold", int64(1000), 0, 0.0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}))
+
+	w, err := s.CreateWebhook(context.Background(), domain.Webhook{
+		ShortCode: "sale", Domain: "go.brand.com", OwnerID: "owner", URL: "https://hooks.example.com",
+		Event: domain.WebhookSpike, Threshold: 50,
+	})
+	if err != nil || w.ID != "wh1" || !strings.HasPrefix(w.Secret, "whsec_") || w.WindowSeconds != 300 || w.Factor != 3 {
+		t.Fatalf("unexpected webhook %+v: %v", w, err)
+	}
+	_, err = s.CreateWebhook(context.Background(), domain.Webhook{
+		ShortCode: "sale", OwnerID: "other", URL: "https://hooks.example.com",
+		Event: domain.WebhookThreshold, Threshold: 1000, WindowSeconds: 60, Factor: 2,
+	})
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for someone else's link, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestFireWebhooks(t *testing.T) {
+	s, mock, closeFn := newTestStorage(t)
+	defer closeFn()
+
+	at := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	mock.ExpectExec(`UPDATE webhooks w SET fired_at = \$2::timestamptz[\s\S]*INSERT INTO webhook_deliveries`).
+		WithArgs(`["sale","go.brand.com/sale"]`, at).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	n, err := s.FireWebhooks(context.Background(), []string{"sale", "go.brand.com/sale"}, at)
+	if err != nil || n != 2 {
+		t.Fatalf("expected 2 deliveries, got %d: %v", n, err)
+	}
+	// no clicks, no query
+	if n, err := s.FireWebhooks(context.Background(), nil, at); err != nil || n != 0 {
+		t.Fatalf("expected nothing fired without keys, got %d: %v", n, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestWebhookDeliveries_UnknownWebhook(t *testing.T) {
+	s, mock, closeFn := newTestStorage(t)
+	defer closeFn()
+
+	mock.ExpectQuery(`SELECT EXISTS \(SELECT 1 FROM webhooks WHERE id = \$1 AND owner_id = \$2\)`).
+		WithArgs("wh1", "other").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
+	if _, err := s.WebhookDeliveries(context.Background(), "other", "wh1", 10); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
@@ -4,7 +4,19 @@ import (
 	"errors"
 )
 
-// ErrNotFound is the error for the not found links.
 var (
+	// ErrNotFound is the error for the not found links.
 	ErrNotFound = errors.New("not found")
+	// ErrExpired is the error for links past their expiration date or click budget.
+	ErrExpired = errors.New("link expired")
+	// ErrUnknownLink is the error for keys the cache remembers no link has.
+	ErrUnknownLink = errors.New("unknown link")
+	// ErrLinksNotFound is the error for adding links to a group that do not exist or belong to someone else.
+	ErrLinksNotFound = errors.New("links not found")
+	// ErrUnauthorized is the error for unknown API keys.
+	ErrUnauthorized = errors.New("invalid api key")
+	// ErrDomainTaken is the error for registering a domain that is already registered.
+	ErrDomainTaken = errors.New("domain already registered")
+	// ErrDomainInUse is the error for removing a domain that still has links.
+	ErrDomainInUse = errors.New("domain has links")
 )
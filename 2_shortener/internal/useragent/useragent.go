@@ -0,0 +1,85 @@
+// Package useragent classifies the clients behind clicks.
+package useragent
+
+import (
+	"strings"
+
+	"shortener/internal/domain"
+
+	ua "github.com/mileusna/useragent"
+)
+
+// Device classes.
+const (
+	Desktop = "desktop"
+	Mobile  = "mobile"
+	Tablet  = "tablet"
+	Bot     = "bot"
+	Other   = "other"
+)
+
+// botMarkers are lower-cased substrings of crawlers and link-preview fetchers the parser does not flag itself.
+// Messengers and social networks fetch a link as soon as it is posted, which would otherwise count as a click.
+var botMarkers = []string{
+	"bot", "crawler", "spider", "preview", "facebookexternalhit", "facebookcatalog", "whatsapp/",
+	"vkshare", "embedly", "iframely", "pinterest", "google-pagerenderer", "headless",
+	"python-requests", "curl/", "wget/", "go-http-client",
+}
+
+// Parse classifies a User-Agent header.
+func Parse(s string) domain.Client {
+	parsed := ua.Parse(s)
+	c := domain.Client{
+		Browser:        parsed.Name,
+		BrowserVersion: parsed.Version,
+		OS:             parsed.OS,
+		IsBot:          parsed.Bot || IsBot(s),
+	}
+
+	switch {
+	case c.IsBot:
+		c.Device = Bot
+	case parsed.Tablet:
+		c.Device = Tablet
+	case parsed.Mobile:
+		c.Device = Mobile
+	case parsed.Desktop:
+		c.Device = Desktop
+	default:
+		c.Device = Other
+	}
+	return c
+}
+
+// IsBot reports whether the User-Agent belongs to a known crawler or link-preview fetcher.
+// An empty User-Agent is treated as a bot, as browsers always send one.
+func IsBot(s string) bool {
+	if strings.TrimSpace(s) == "" {
+		return true
+	}
+	lower := strings.ToLower(s)
+	for _, m := range botMarkers {
+		if strings.Contains(lower, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// previewMarkers are lower-cased substrings of the fetchers messengers and social networks send to unfurl a
+// posted link into a card.
+var previewMarkers = []string{
+	"slackbot", "slack-imgproxy", "telegrambot", "twitterbot", "facebookexternalhit", "whatsapp/",
+	"discordbot", "linkedinbot", "skypeuripreview", "vkshare", "redditbot", "mastodon/",
+}
+
+// IsPreviewBot reports whether the User-Agent belongs to a known link-preview fetcher.
+func IsPreviewBot(s string) bool {
+	lower := strings.ToLower(s)
+	for _, m := range previewMarkers {
+		if strings.Contains(lower, m) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+package useragent
+
+import (
+	"testing"
+
+	"shortener/internal/domain"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		ua   string
+		want domain.Client
+	}{
+		{
+			"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+			domain.Client{Browser: "Chrome", BrowserVersion: "120.0.0.0", OS: "Windows", Device: Desktop},
+		},
+		{
+			"Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Mobile/15E148 Safari/604.1",
+			domain.Client{Browser: "Safari", BrowserVersion: "17.0", OS: "iOS", Device: Mobile},
+		},
+		{
+			"Mozilla/5.0 (iPad; CPU OS 16_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/16.6 Mobile/15E148 Safari/604.1",
+			domain.Client{Browser: "Safari", BrowserVersion: "16.6", OS: "iOS", Device: Tablet},
+		},
+	}
+	for _, tt := range tests {
+		got := Parse(tt.ua)
+		if got != tt.want {
+			t.Errorf("Parse(%q) = %+v, want %+v", tt.ua, got, tt.want)
+		}
+	}
+}
+
+func TestParse_Bots(t *testing.T) {
+	bots := []string{
+		"",
+		"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+		"facebookexternalhit/1.1 (+http://www.facebook.com/externalhit_uatext.php)",
+		"Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)",
+		"TelegramBot (like TwitterBot)",
+		"WhatsApp/2.23.20.0 A",
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_10_1) AppleWebKit/600.2.5 (KHTML, like Gecko) Version/8.0.2 Safari/600.2.5 (Applebot/0.1)",
+		"Mozilla/5.0 (compatible; Discordbot/2.0; +https://discordapp.com)",
+		"curl/8.4.0",
+	}
+	for _, ua := range bots {
+		got := Parse(ua)
+		if !got.IsBot || got.Device != Bot {
+			t.Errorf("Parse(%q) = %+v, want a bot", ua, got)
+		}
+	}
+}
+
+func TestIsPreviewBot(t *testing.T) {
+	for ua, want := range map[string]bool{
+		"Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)":                    true,
+		"TelegramBot (like TwitterBot)":                                                 true,
+		"Twitterbot/1.0":                                                                true,
+		"facebookexternalhit/1.1 (+http://www.facebook.com/externalhit_uatext.php)":     true,
+		"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)":      false,
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 Chrome/120.0.0.0": false,
+		"": false,
+	} {
+		if got := IsPreviewBot(ua); got != want {
+			t.Errorf("IsPreviewBot(%q) = %v, want %v", ua, got, want)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+// Package webhook fires the webhooks of link owners as clicks come in and delivers their signed callbacks.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"shortener/internal/domain"
+	"shortener/internal/netguard"
+
+	"github.com/kxddry/wbf/zlog"
+)
+
+// Callback headers. The signature is "sha256=" and the hex HMAC-SHA256, keyed with the webhook's secret, of
+// the timestamp header, a dot and the body; see Sign.
+const (
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// Sign returns the signature of a callback body sent at the unix timestamp.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// ClickSaver saves click batches.
+type ClickSaver interface {
+	SaveClicks(ctx context.Context, clicks []domain.Click) error
+}
+
+// Firer fires the webhooks of links whose clicks changed.
+type Firer interface {
+	// FireWebhooks checks the webhooks of the links with the keys at the time at and queues a delivery for
+	// each one that fires, returning how many did.
+	FireWebhooks(ctx context.Context, keys []string, at time.Time) (int, error)
+}
+
+// Sink is a click sink that fires webhooks: it saves each batch and then checks the webhooks of the links the
+// batch was for. A failed check is logged and never fails the batch.
+type Sink struct {
+	next  ClickSaver
+	firer Firer
+	now   func() time.Time
+}
+
+// NewSink returns a sink saving batches to next and checking webhooks with firer.
+func NewSink(next ClickSaver, firer Firer) *Sink {
+	return &Sink{next: next, firer: firer, now: time.Now}
+}
+
+// SaveClicks saves the clicks and fires the webhooks of their links.
+func (s *Sink) SaveClicks(ctx context.Context, clicks []domain.Click) error {
+	if err := s.next.SaveClicks(ctx, clicks); err != nil {
+		return err
+	}
+	seen := make(map[string]bool)
+	var keys []string
+	for _, c := range clicks {
+		if !seen[c.ShortCode] {
+			seen[c.ShortCode] = true
+			keys = append(keys, c.ShortCode)
+		}
+	}
+	fired, err := s.firer.FireWebhooks(ctx, keys, s.now())
+	if err != nil {
+		zlog.Logger.Error().Err(err).Int("links", len(keys)).Msg("failed to fire webhooks")
+	} else if fired > 0 {
+		zlog.Logger.Debug().Int("deliveries", fired).Msg("webhooks fired")
+	}
+	return nil
+}
+
+// Store is the storage of webhook deliveries.
+type Store interface {
+	// ClaimDeliveries returns up to limit due deliveries and holds them for lease.
+	ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]domain.WebhookDelivery, error)
+	// FinishDelivery records the outcome of an attempt.
+	FinishDelivery(ctx context.Context, d domain.WebhookDelivery) error
+}
+
+// Options configures the deliverer. Zero values fall back to the defaults.
+type Options struct {
+	// Interval is how often due deliveries are looked for.
+	Interval time.Duration
+	// Timeout bounds a single callback.
+	Timeout time.Duration
+	// MaxAttempts is the number of attempts before a delivery is given up on.
+	MaxAttempts int
+	// Backoff is the wait before the first retry; it doubles with every further one.
+	Backoff time.Duration
+	// BatchSize is the number of deliveries sent at once.
+	BatchSize int
+}
+
+// Defaults for Options. With them a failing endpoint is retried for about an hour.
+const (
+	DefaultInterval    = 5 * time.Second
+	DefaultTimeout     = 10 * time.Second
+	DefaultMaxAttempts = 8
+	DefaultBackoff     = 30 * time.Second
+	DefaultBatchSize   = 50
+)
+
+func (o Options) withDefaults() Options {
+	if o.Interval <= 0 {
+		o.Interval = DefaultInterval
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = DefaultTimeout
+	}
+	if o.MaxAttempts <= 0 {
+		o.MaxAttempts = DefaultMaxAttempts
+	}
+	if o.Backoff <= 0 {
+		o.Backoff = DefaultBackoff
+	}
+	if o.BatchSize <= 0 {
+		o.BatchSize = DefaultBatchSize
+	}
+	return o
+}
+
+// Deliverer sends the queued callbacks and retries failed ones with exponential backoff. Several instances
+// can run it; each delivery is claimed by one of them at a time.
+type Deliverer struct {
+	store  Store
+	client *http.Client
+	opts   Options
+	now    func() time.Time
+}
+
+// NewDeliverer creates a new Deliverer.
+func NewDeliverer(store Store, opts Options) *Deliverer {
+	opts = opts.withDefaults()
+	return &Deliverer{store: store, client: newClient(opts.Timeout, netguard.PublicOnly), opts: opts, now: time.Now}
+}
+
+// newClient returns the client sending callbacks. control vets every address it connects to, so owners can't
+// point webhooks at the network the service runs in and read the answers from the delivery log.
+func newClient(timeout time.Duration, control func(network, address string, c syscall.RawConn) error) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout, Control: control}
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: timeout, ResponseHeaderTimeout: timeout},
+		// a redirect is an answer like any other non-2xx one, not somewhere else to send the payload
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+}
+
+// Run delivers immediately and then every interval until ctx is done.
+func (d *Deliverer) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		d.Tick(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Tick sends a batch of due deliveries, all at once, and records how each went.
+func (d *Deliverer) Tick(ctx context.Context) {
+	// the lease outlasts the callbacks, so no other instance picks them up while they are in flight
+	deliveries, err := d.store.ClaimDeliveries(ctx, d.opts.BatchSize, 2*d.opts.Timeout)
+	if err != nil {
+		if ctx.Err() == nil {
+			zlog.Logger.Error().Err(err).Msg("failed to claim webhook deliveries")
+		}
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, delivery := range deliveries {
+		wg.Add(1)
+		go func(delivery domain.WebhookDelivery) {
+			defer wg.Done()
+			delivery = d.attempt(ctx, delivery)
+			if err := d.store.FinishDelivery(context.WithoutCancel(ctx), delivery); err != nil {
+				zlog.Logger.Error().Err(err).Str("delivery", delivery.ID).Msg("failed to record webhook delivery")
+			}
+		}(delivery)
+	}
+	wg.Wait()
+}
+
+// attempt sends the callback and returns the delivery updated with the outcome.
+func (d *Deliverer) attempt(ctx context.Context, delivery domain.WebhookDelivery) domain.WebhookDelivery {
+	delivery.Attempts++
+	delivery.ResponseStatus, delivery.Error = 0, ""
+
+	status, err := d.send(ctx, delivery)
+	now := d.now()
+	delivery.ResponseStatus = status
+	if err == nil {
+		delivery.Status = domain.DeliveryDelivered
+		delivery.NextAttemptAt, delivery.DeliveredAt = nil, &now
+		return delivery
+	}
+
+	delivery.Error = err.Error()
+	if delivery.Attempts >= d.opts.MaxAttempts {
+		delivery.Status = domain.DeliveryFailed
+		delivery.NextAttemptAt = nil
+		return delivery
+	}
+	next := now.Add(d.opts.Backoff << (delivery.Attempts - 1))
+	delivery.Status = domain.DeliveryPending
+	delivery.NextAttemptAt = &next
+	return delivery
+}
+
+// errStatus is the error for an answer other than 2xx.
+var errStatus = errors.New("unexpected response status")
+
+// send posts the signed payload and returns the response status.
+func (d *Deliverer) send(ctx context.Context, delivery domain.WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := d.now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "shortener-webhooks")
+	req.Header.Set(HeaderDelivery, delivery.ID)
+	req.Header.Set(HeaderEvent, delivery.Event)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(delivery.Secret, timestamp, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("%w %d", errStatus, resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
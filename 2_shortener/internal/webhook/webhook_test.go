@@ -0,0 +1,176 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"shortener/internal/domain"
+	"shortener/internal/netguard"
+)
+
+type mockSaver struct {
+	err error
+}
+
+func (m *mockSaver) SaveClicks(ctx context.Context, clicks []domain.Click) error {
+	return m.err
+}
+
+type mockFirer struct {
+	calls [][]string
+	err   error
+}
+
+func (m *mockFirer) FireWebhooks(ctx context.Context, keys []string, at time.Time) (int, error) {
+	m.calls = append(m.calls, keys)
+	return len(keys), m.err
+}
+
+type mockStore struct {
+	mu       sync.Mutex
+	due      []domain.WebhookDelivery
+	finished map[string]domain.WebhookDelivery
+}
+
+func (m *mockStore) ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]domain.WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := min(limit, len(m.due))
+	claimed := m.due[:n]
+	m.due = m.due[n:]
+	return claimed, nil
+}
+
+func (m *mockStore) FinishDelivery(ctx context.Context, d domain.WebhookDelivery) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.finished[d.ID] = d
+	return nil
+}
+
+func TestSink_FiresOncePerLink(t *testing.T) {
+	firer := &mockFirer{}
+	s := NewSink(&mockSaver{}, firer)
+	clicks := []domain.Click{{ShortCode: "a"}, {ShortCode: "b"}, {ShortCode: "a"}}
+
+	if err := s.SaveClicks(context.Background(), clicks); err != nil {
+		t.Fatalf("SaveClicks: %v", err)
+	}
+	if len(firer.calls) != 1 || !slices.Equal(firer.calls[0], []string{"a", "b"}) {
+		t.Fatalf("expected one check of a and b, got %v", firer.calls)
+	}
+
+	// a failed check doesn't lose the batch, a failed save doesn't fire anything
+	firer.err = errors.New("db down")
+	if err := s.SaveClicks(context.Background(), clicks); err != nil {
+		t.Fatalf("expected a failed check to be logged only, got %v", err)
+	}
+	s = NewSink(&mockSaver{err: errors.New("db down")}, firer)
+	if err := s.SaveClicks(context.Background(), clicks); err == nil {
+		t.Fatal("expected the save error")
+	}
+	if len(firer.calls) != 2 {
+		t.Fatalf("expected no check after a failed save, got %d checks", len(firer.calls))
+	}
+}
+
+func TestDeliverer_SignsAndRetries(t *testing.T) {
+	var mu sync.Mutex
+	failing := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		ts, _ := strconv.ParseInt(r.Header.Get(HeaderTimestamp), 10, 64)
+		if r.Header.Get(HeaderSignature) != Sign("whsec_test", ts, body) || r.Header.Get(HeaderEvent) != "threshold" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if failing {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	store := &mockStore{finished: make(map[string]domain.WebhookDelivery)}
+	d := NewDeliverer(store, Options{Backoff: time.Minute, MaxAttempts: 3})
+	// the test server is on loopback
+	d.client = newClient(time.Second, nil)
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	d.now = func() time.Time { return now }
+	delivery := domain.WebhookDelivery{
+		ID: "d1", Event: "threshold", Payload: []byte(`{"clicks":1000}`), URL: srv.URL, Secret: "whsec_test",
+		Status: domain.DeliveryPending,
+	}
+
+	// the first two attempts fail and are retried a minute and then two minutes later
+	for attempt, wait := range []time.Duration{time.Minute, 2 * time.Minute} {
+		store.due = []domain.WebhookDelivery{delivery}
+		d.Tick(context.Background())
+		delivery = store.finished["d1"]
+		if delivery.Status != domain.DeliveryPending || delivery.Attempts != attempt+1 || delivery.ResponseStatus != http.StatusBadGateway ||
+			delivery.NextAttemptAt == nil || !delivery.NextAttemptAt.Equal(now.Add(wait)) {
+			t.Fatalf("attempt %d: unexpected outcome %+v", attempt+1, delivery)
+		}
+	}
+
+	mu.Lock()
+	failing = false
+	mu.Unlock()
+	store.due = []domain.WebhookDelivery{delivery}
+	d.Tick(context.Background())
+	delivery = store.finished["d1"]
+	if delivery.Status != domain.DeliveryDelivered || delivery.Attempts != 3 || delivery.Error != "" ||
+		delivery.NextAttemptAt != nil || delivery.DeliveredAt == nil {
+		t.Fatalf("expected the third attempt to be delivered, got %+v", delivery)
+	}
+}
+
+func TestDeliverer_GivesUp(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// a redirect is not followed, and counts as a failure
+		http.Redirect(w, r, "/elsewhere", http.StatusFound)
+	}))
+	defer srv.Close()
+
+	store := &mockStore{finished: make(map[string]domain.WebhookDelivery)}
+	d := NewDeliverer(store, Options{MaxAttempts: 2})
+	d.client = newClient(time.Second, nil)
+	store.due = []domain.WebhookDelivery{{ID: "d1", URL: srv.URL, Attempts: 1, Payload: []byte(`{}`)}}
+	d.Tick(context.Background())
+
+	delivery := store.finished["d1"]
+	if delivery.Status != domain.DeliveryFailed || delivery.ResponseStatus != http.StatusFound || delivery.NextAttemptAt != nil {
+		t.Fatalf("expected the delivery to be given up on, got %+v", delivery)
+	}
+}
+
+func TestDeliverer_RefusesPrivateAddresses(t *testing.T) {
+	reached := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	}))
+	defer srv.Close()
+
+	store := &mockStore{finished: make(map[string]domain.WebhookDelivery)}
+	d := NewDeliverer(store, Options{MaxAttempts: 1})
+	store.due = []domain.WebhookDelivery{{ID: "d1", URL: srv.URL, Payload: []byte(`{}`)}}
+	d.Tick(context.Background())
+
+	delivery := store.finished["d1"]
+	if reached || delivery.Status != domain.DeliveryFailed || delivery.ResponseStatus != 0 ||
+		!strings.Contains(delivery.Error, netguard.ErrPrivate.Error()) {
+		t.Fatalf("expected the loopback callback to be refused before it was sent, got %+v", delivery)
+	}
+}
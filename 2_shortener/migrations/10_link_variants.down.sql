@@ -0,0 +1,14 @@
+-- Rows that differ only by variant would collide in the old key; rebuild the rollups instead
+TRUNCATE click_rollups_hourly, click_rollup_ips, click_rollup_dims;
+UPDATE click_rollup_state SET rolled_until = NULL;
+
+ALTER TABLE click_rollup_ips
+  DROP CONSTRAINT IF EXISTS click_rollup_ips_pkey,
+  DROP COLUMN IF EXISTS variant,
+  ADD PRIMARY KEY (short_code, hour, ip, is_bot);
+
+ALTER TABLE clicks
+  DROP COLUMN IF EXISTS variant;
+
+ALTER TABLE shortened_urls
+  DROP COLUMN IF EXISTS variants;
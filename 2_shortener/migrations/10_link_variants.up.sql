@@ -0,0 +1,12 @@
+-- Weighted destinations of A/B links: [{"name": "a", "url": "https://…", "weight": 50}, …]
+ALTER TABLE shortened_urls
+  ADD COLUMN IF NOT EXISTS variants JSONB;
+
+ALTER TABLE clicks
+  ADD COLUMN IF NOT EXISTS variant TEXT NOT NULL DEFAULT '';
+
+-- Earlier clicks had no variant, so the existing rollups stay valid with the empty one
+ALTER TABLE click_rollup_ips
+  ADD COLUMN IF NOT EXISTS variant TEXT NOT NULL DEFAULT '',
+  DROP CONSTRAINT IF EXISTS click_rollup_ips_pkey,
+  ADD PRIMARY KEY (short_code, hour, ip, is_bot, variant);
@@ -0,0 +1,7 @@
+DELETE FROM click_rollup_dims WHERE dim = 'rule';
+
+ALTER TABLE clicks
+  DROP COLUMN IF EXISTS rule;
+
+ALTER TABLE shortened_urls
+  DROP COLUMN IF EXISTS rules;
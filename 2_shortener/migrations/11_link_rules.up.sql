@@ -0,0 +1,10 @@
+-- Targeting rules of a link, in evaluation order: [{"name": "ios", "url": "https://…", "devices": ["ios"]}, …]
+ALTER TABLE shortened_urls
+  ADD COLUMN IF NOT EXISTS rules JSONB;
+
+ALTER TABLE clicks
+  ADD COLUMN IF NOT EXISTS rule TEXT NOT NULL DEFAULT '';
+
+-- Rollups are derived data; rebuild them so every rolled-up hour carries the rule dimension
+TRUNCATE click_rollups_hourly, click_rollup_ips, click_rollup_dims;
+UPDATE click_rollup_state SET rolled_until = NULL;
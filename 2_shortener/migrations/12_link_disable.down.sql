@@ -0,0 +1,3 @@
+ALTER TABLE shortened_urls
+  DROP COLUMN IF EXISTS disabled_reason,
+  DROP COLUMN IF EXISTS disabled_at;
@@ -0,0 +1,4 @@
+-- Links taken down by an administrator stop redirecting until they are enabled again
+ALTER TABLE shortened_urls
+  ADD COLUMN IF NOT EXISTS disabled_at TIMESTAMPTZ,
+  ADD COLUMN IF NOT EXISTS disabled_reason TEXT NOT NULL DEFAULT '';
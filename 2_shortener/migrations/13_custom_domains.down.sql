@@ -0,0 +1,32 @@
+-- Links on branded domains can't keep their codes once codes are global again
+DELETE FROM shortened_urls WHERE domain <> '';
+
+ALTER TABLE clicks DROP CONSTRAINT IF EXISTS fk_clicks_link_key;
+ALTER TABLE click_rollups_hourly DROP CONSTRAINT IF EXISTS click_rollups_hourly_link_key_fkey;
+ALTER TABLE click_rollup_ips DROP CONSTRAINT IF EXISTS click_rollup_ips_link_key_fkey;
+ALTER TABLE click_rollup_dims DROP CONSTRAINT IF EXISTS click_rollup_dims_link_key_fkey;
+
+ALTER TABLE shortened_urls
+  DROP CONSTRAINT IF EXISTS shortened_urls_link_key_key,
+  DROP CONSTRAINT IF EXISTS shortened_urls_domain_short_code_key,
+  DROP COLUMN IF EXISTS link_key,
+  DROP COLUMN IF EXISTS domain,
+  ADD CONSTRAINT shortened_urls_short_code_key UNIQUE (short_code);
+
+ALTER TABLE clicks
+  ALTER COLUMN short_code TYPE VARCHAR(16),
+  ADD CONSTRAINT fk_clicks_short_code FOREIGN KEY (short_code) REFERENCES shortened_urls (short_code) ON DELETE CASCADE;
+
+ALTER TABLE click_rollups_hourly
+  ALTER COLUMN short_code TYPE VARCHAR(16),
+  ADD CONSTRAINT click_rollups_hourly_short_code_fkey FOREIGN KEY (short_code) REFERENCES shortened_urls (short_code) ON DELETE CASCADE;
+
+ALTER TABLE click_rollup_ips
+  ALTER COLUMN short_code TYPE VARCHAR(16),
+  ADD CONSTRAINT click_rollup_ips_short_code_fkey FOREIGN KEY (short_code) REFERENCES shortened_urls (short_code) ON DELETE CASCADE;
+
+ALTER TABLE click_rollup_dims
+  ALTER COLUMN short_code TYPE VARCHAR(16),
+  ADD CONSTRAINT click_rollup_dims_short_code_fkey FOREIGN KEY (short_code) REFERENCES shortened_urls (short_code) ON DELETE CASCADE;
+
+DROP TABLE IF EXISTS domains;
@@ -0,0 +1,44 @@
+-- Domains short links are served on. The empty domain is the default one, which every existing link is on;
+-- the others are branded domains registered by their owners.
+CREATE TABLE IF NOT EXISTS domains (
+  domain TEXT PRIMARY KEY,
+  owner_id UUID,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_domains_owner_id ON domains (owner_id);
+
+INSERT INTO domains (domain) VALUES ('') ON CONFLICT DO NOTHING;
+
+-- Short codes become unique per domain. Clicks and rollups refer to a link by its key instead: the short code
+-- on the default domain and domain/short_code on a branded one, so the rows they already have stay valid.
+ALTER TABLE clicks DROP CONSTRAINT IF EXISTS fk_clicks_short_code;
+ALTER TABLE click_rollups_hourly DROP CONSTRAINT IF EXISTS click_rollups_hourly_short_code_fkey;
+ALTER TABLE click_rollup_ips DROP CONSTRAINT IF EXISTS click_rollup_ips_short_code_fkey;
+ALTER TABLE click_rollup_dims DROP CONSTRAINT IF EXISTS click_rollup_dims_short_code_fkey;
+
+ALTER TABLE shortened_urls
+  ADD COLUMN IF NOT EXISTS domain TEXT NOT NULL DEFAULT '' REFERENCES domains (domain);
+
+ALTER TABLE shortened_urls
+  ADD COLUMN IF NOT EXISTS link_key TEXT NOT NULL
+    GENERATED ALWAYS AS (CASE WHEN domain = '' THEN short_code ELSE domain || '/' || short_code END) STORED,
+  DROP CONSTRAINT IF EXISTS shortened_urls_short_code_key,
+  ADD CONSTRAINT shortened_urls_domain_short_code_key UNIQUE (domain, short_code),
+  ADD CONSTRAINT shortened_urls_link_key_key UNIQUE (link_key);
+
+ALTER TABLE clicks
+  ALTER COLUMN short_code TYPE TEXT,
+  ADD CONSTRAINT fk_clicks_link_key FOREIGN KEY (short_code) REFERENCES shortened_urls (link_key) ON DELETE CASCADE;
+
+ALTER TABLE click_rollups_hourly
+  ALTER COLUMN short_code TYPE TEXT,
+  ADD CONSTRAINT click_rollups_hourly_link_key_fkey FOREIGN KEY (short_code) REFERENCES shortened_urls (link_key) ON DELETE CASCADE;
+
+ALTER TABLE click_rollup_ips
+  ALTER COLUMN short_code TYPE TEXT,
+  ADD CONSTRAINT click_rollup_ips_link_key_fkey FOREIGN KEY (short_code) REFERENCES shortened_urls (link_key) ON DELETE CASCADE;
+
+ALTER TABLE click_rollup_dims
+  ALTER COLUMN short_code TYPE TEXT,
+  ADD CONSTRAINT click_rollup_dims_link_key_fkey FOREIGN KEY (short_code) REFERENCES shortened_urls (link_key) ON DELETE CASCADE;
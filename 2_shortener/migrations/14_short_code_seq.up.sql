@@ -0,0 +1,2 @@
+-- Numbers for generated short codes; the service shuffles and base62-encodes them.
+CREATE SEQUENCE IF NOT EXISTS short_code_seq AS BIGINT MINVALUE 0 START WITH 0;
@@ -0,0 +1,36 @@
+-- Webhook subscriptions of link owners. A threshold webhook fires once; fired_at is when it did. A spike
+-- webhook fires again once window_seconds have passed since fired_at.
+CREATE TABLE IF NOT EXISTS webhooks (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  link_key TEXT NOT NULL REFERENCES shortened_urls (link_key) ON DELETE CASCADE,
+  owner_id UUID NOT NULL,
+  url TEXT NOT NULL,
+  secret TEXT NOT NULL,
+  event TEXT NOT NULL CHECK (event IN ('threshold', 'spike')),
+  threshold BIGINT NOT NULL CHECK (threshold > 0),
+  window_seconds INTEGER NOT NULL DEFAULT 0,
+  factor DOUBLE PRECISION NOT NULL DEFAULT 0,
+  fired_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhooks_link_key ON webhooks (link_key);
+CREATE INDEX IF NOT EXISTS idx_webhooks_owner_id ON webhooks (owner_id);
+
+-- Callbacks of the webhooks, kept as the delivery log. Pending ones are retried at next_attempt_at.
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  webhook_id UUID NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+  event TEXT NOT NULL,
+  payload JSONB NOT NULL,
+  status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'delivered', 'failed')),
+  attempts INTEGER NOT NULL DEFAULT 0,
+  response_status INTEGER NOT NULL DEFAULT 0,
+  error TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  next_attempt_at TIMESTAMPTZ,
+  delivered_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook_id ON webhook_deliveries (webhook_id, created_at DESC);
@@ -0,0 +1,6 @@
+-- Salts IPs are hashed with in the hash privacy mode, one per UTC day. A salt is deleted the day after its
+-- own, after which the hashes of its day can no longer be tied back to addresses.
+CREATE TABLE IF NOT EXISTS ip_salts (
+  day DATE PRIMARY KEY,
+  salt BYTEA NOT NULL
+);
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS destination_cards;
+
+ALTER TABLE shortened_urls
+  DROP COLUMN IF EXISTS card,
+  DROP COLUMN IF EXISTS redirect_type;
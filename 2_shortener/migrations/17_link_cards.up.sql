@@ -0,0 +1,15 @@
+-- The status links redirect with, and the Open Graph card their owner set for preview bots:
+-- {"title": "…", "description": "…", "image": "https://…"}
+ALTER TABLE shortened_urls
+  ADD COLUMN IF NOT EXISTS redirect_type SMALLINT NOT NULL DEFAULT 307 CHECK (redirect_type IN (301, 302, 307, 308)),
+  ADD COLUMN IF NOT EXISTS card JSONB;
+
+-- Open Graph metadata fetched from destinations, filling in what link owners left out of their cards.
+-- Failed fetches are kept too, empty, so a destination is fetched at most once per refresh.
+CREATE TABLE IF NOT EXISTS destination_cards (
+  url TEXT PRIMARY KEY,
+  title TEXT NOT NULL DEFAULT '',
+  description TEXT NOT NULL DEFAULT '',
+  image TEXT NOT NULL DEFAULT '',
+  fetched_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS link_group_members;
+DROP TABLE IF EXISTS link_groups;
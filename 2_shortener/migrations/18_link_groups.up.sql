@@ -0,0 +1,19 @@
+-- Groups of an owner's links, such as the links of a campaign, with their analytics aggregated.
+CREATE TABLE IF NOT EXISTS link_groups (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  owner_id UUID NOT NULL,
+  name TEXT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_link_groups_owner_id ON link_groups (owner_id, created_at DESC);
+
+-- A link can be in any number of groups; deleting either side drops the membership.
+CREATE TABLE IF NOT EXISTS link_group_members (
+  group_id UUID NOT NULL REFERENCES link_groups (id) ON DELETE CASCADE,
+  link_key TEXT NOT NULL REFERENCES shortened_urls (link_key) ON DELETE CASCADE,
+  added_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (group_id, link_key)
+);
+
+CREATE INDEX IF NOT EXISTS idx_link_group_members_link_key ON link_group_members (link_key);
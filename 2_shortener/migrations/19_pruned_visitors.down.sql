@@ -0,0 +1 @@
+ALTER TABLE click_rollup_ips DROP COLUMN IF EXISTS visitors;
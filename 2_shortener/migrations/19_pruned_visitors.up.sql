@@ -0,0 +1,4 @@
+-- Retention merges the per-IP rollups of pruned hours into one 0.0.0.0 row per hour, bot flag and variant;
+-- visitors keeps how many distinct IPs went into it, so unique counts survive the merge.
+ALTER TABLE click_rollup_ips
+  ADD COLUMN IF NOT EXISTS visitors BIGINT NOT NULL DEFAULT 0;
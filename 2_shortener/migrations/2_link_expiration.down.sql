@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_shortened_urls_expires_at;
+
+ALTER TABLE shortened_urls
+  DROP COLUMN IF EXISTS clicks_used,
+  DROP COLUMN IF EXISTS max_clicks,
+  DROP COLUMN IF EXISTS expires_at;
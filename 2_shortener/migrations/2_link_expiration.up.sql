@@ -0,0 +1,6 @@
+ALTER TABLE shortened_urls
+  ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ,
+  ADD COLUMN IF NOT EXISTS max_clicks BIGINT CHECK (max_clicks > 0),
+  ADD COLUMN IF NOT EXISTS clicks_used BIGINT NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS idx_shortened_urls_expires_at ON shortened_urls (expires_at) WHERE expires_at IS NOT NULL;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_shortened_urls_owner_id;
+ALTER TABLE shortened_urls DROP COLUMN IF EXISTS owner_id;
+DROP TABLE IF EXISTS api_keys;
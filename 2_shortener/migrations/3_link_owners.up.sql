@@ -0,0 +1,13 @@
+-- Create api_keys table; only the SHA-256 of a key is stored
+CREATE TABLE IF NOT EXISTS api_keys (
+  id BIGSERIAL PRIMARY KEY,
+  owner_id UUID NOT NULL DEFAULT gen_random_uuid(),
+  key_hash TEXT NOT NULL UNIQUE,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_owner_id ON api_keys (owner_id);
+
+ALTER TABLE shortened_urls ADD COLUMN IF NOT EXISTS owner_id UUID;
+
+CREATE INDEX IF NOT EXISTS idx_shortened_urls_owner_id ON shortened_urls (owner_id, created_at DESC);
@@ -0,0 +1,2 @@
+ALTER TABLE clicks
+  ADD COLUMN IF NOT EXISTS source TEXT NOT NULL DEFAULT '';
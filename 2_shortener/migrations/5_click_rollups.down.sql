@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS click_rollup_state;
+DROP TABLE IF EXISTS click_rollup_dims;
+DROP TABLE IF EXISTS click_rollup_ips;
+DROP TABLE IF EXISTS click_rollups_hourly;
@@ -0,0 +1,34 @@
+-- Hourly click rollups, maintained by the background aggregator.
+-- Every hour before click_rollup_state.rolled_until is fully rolled up; later clicks are read from the raw table.
+CREATE TABLE IF NOT EXISTS click_rollups_hourly (
+  short_code VARCHAR(16) NOT NULL REFERENCES shortened_urls (short_code) ON DELETE CASCADE,
+  hour TIMESTAMPTZ NOT NULL,
+  clicks BIGINT NOT NULL,
+  PRIMARY KEY (short_code, hour)
+);
+
+-- Per-hour distinct visitor sets, so unique counts over any set of hours stay exact
+CREATE TABLE IF NOT EXISTS click_rollup_ips (
+  short_code VARCHAR(16) NOT NULL REFERENCES shortened_urls (short_code) ON DELETE CASCADE,
+  hour TIMESTAMPTZ NOT NULL,
+  ip INET NOT NULL,
+  clicks BIGINT NOT NULL,
+  PRIMARY KEY (short_code, hour, ip)
+);
+
+-- Per-hour counts of the breakdown dimensions ('user_agent', 'referer', 'source'), with values normalised as in the API
+CREATE TABLE IF NOT EXISTS click_rollup_dims (
+  short_code VARCHAR(16) NOT NULL REFERENCES shortened_urls (short_code) ON DELETE CASCADE,
+  hour TIMESTAMPTZ NOT NULL,
+  dim TEXT NOT NULL,
+  value TEXT NOT NULL,
+  clicks BIGINT NOT NULL,
+  PRIMARY KEY (short_code, dim, hour, value)
+);
+
+CREATE TABLE IF NOT EXISTS click_rollup_state (
+  id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+  rolled_until TIMESTAMPTZ
+);
+
+INSERT INTO click_rollup_state (id, rolled_until) VALUES (TRUE, NULL) ON CONFLICT DO NOTHING;
@@ -0,0 +1,17 @@
+TRUNCATE click_rollups_hourly, click_rollup_ips, click_rollup_dims;
+UPDATE click_rollup_state SET rolled_until = NULL;
+
+ALTER TABLE click_rollup_ips
+  DROP CONSTRAINT IF EXISTS click_rollup_ips_pkey,
+  DROP COLUMN IF EXISTS is_bot,
+  ADD PRIMARY KEY (short_code, hour, ip);
+
+ALTER TABLE click_rollups_hourly
+  DROP COLUMN IF EXISTS bot_clicks;
+
+ALTER TABLE clicks
+  DROP COLUMN IF EXISTS is_bot,
+  DROP COLUMN IF EXISTS device,
+  DROP COLUMN IF EXISTS os,
+  DROP COLUMN IF EXISTS browser_version,
+  DROP COLUMN IF EXISTS browser;
@@ -0,0 +1,22 @@
+ALTER TABLE clicks
+  ADD COLUMN IF NOT EXISTS browser TEXT NOT NULL DEFAULT '',
+  ADD COLUMN IF NOT EXISTS browser_version TEXT NOT NULL DEFAULT '',
+  ADD COLUMN IF NOT EXISTS os TEXT NOT NULL DEFAULT '',
+  ADD COLUMN IF NOT EXISTS device TEXT NOT NULL DEFAULT '',
+  ADD COLUMN IF NOT EXISTS is_bot BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Earlier clicks were never parsed; flag the obvious crawlers so they drop out of the totals
+UPDATE clicks SET is_bot = TRUE, device = 'bot'
+WHERE user_agent = '' OR user_agent ~* '(bot|crawler|spider|preview|facebookexternalhit|whatsapp/|curl/|wget/)';
+
+ALTER TABLE click_rollups_hourly
+  ADD COLUMN IF NOT EXISTS bot_clicks BIGINT NOT NULL DEFAULT 0;
+
+ALTER TABLE click_rollup_ips
+  ADD COLUMN IF NOT EXISTS is_bot BOOLEAN NOT NULL DEFAULT FALSE,
+  DROP CONSTRAINT IF EXISTS click_rollup_ips_pkey,
+  ADD PRIMARY KEY (short_code, hour, ip, is_bot);
+
+-- Rollups are derived data; rebuild them so they carry the bot flag and the new dimensions
+TRUNCATE click_rollups_hourly, click_rollup_ips, click_rollup_dims;
+UPDATE click_rollup_state SET rolled_until = NULL;
@@ -0,0 +1,6 @@
+DELETE FROM click_rollup_dims WHERE dim IN ('country', 'city');
+
+ALTER TABLE clicks
+  DROP COLUMN IF EXISTS city,
+  DROP COLUMN IF EXISTS region,
+  DROP COLUMN IF EXISTS country;
@@ -0,0 +1,8 @@
+ALTER TABLE clicks
+  ADD COLUMN IF NOT EXISTS country TEXT NOT NULL DEFAULT '',
+  ADD COLUMN IF NOT EXISTS region TEXT NOT NULL DEFAULT '',
+  ADD COLUMN IF NOT EXISTS city TEXT NOT NULL DEFAULT '';
+
+-- Rollups are derived data; rebuild them so every rolled-up hour carries the country and city dimensions
+TRUNCATE click_rollups_hourly, click_rollup_ips, click_rollup_dims;
+UPDATE click_rollup_state SET rolled_until = NULL;
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS idx_clicks_short_code_timestamp_id;
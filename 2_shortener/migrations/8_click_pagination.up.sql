@@ -0,0 +1,2 @@
+-- Serves the newest-first click pages and, scanned backwards, the oldest-first export
+CREATE INDEX IF NOT EXISTS idx_clicks_short_code_timestamp_id ON clicks (short_code, timestamp DESC, id DESC);
@@ -0,0 +1,2 @@
+ALTER TABLE shortened_urls
+  ADD COLUMN IF NOT EXISTS password_hash TEXT NOT NULL DEFAULT '';
@@ -6,6 +6,7 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/kxddry/wbf/config"
 	"github.com/kxddry/wbf/dbpg"
@@ -32,6 +33,10 @@ func main() {
 
 	st := postgres.New(db)
 	srv := api.New(st)
+	srv.Configure(api.Options{
+		EditWindow: durationOption(cfg, "comments.edit_window"),
+		AdminToken: cfg.GetString("comments.admin_token"),
+	})
 
 	if err := srv.Run(cfg.GetString("server.addr")); err != nil {
 		zlog.Logger.Fatal().Msg("failed to run server")
@@ -48,3 +53,18 @@ func main() {
 
 	zlog.Logger.Info().Msg("server shutdown")
 }
+
+// durationOption reads an optional duration setting such as 15m; it returns 0, which means the default, if the
+// setting is missing or invalid.
+func durationOption(cfg *config.Config, key string) time.Duration {
+	v := cfg.GetString(key)
+	if v == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		zlog.Logger.Warn().Err(err).Str("key", key).Msg("invalid duration setting, using the default")
+		return 0
+	}
+	return d
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"comment-tree/internal/domain"
+	"comment-tree/internal/storage"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kxddry/wbf/ginext"
+)
+
+// authorKey is the context key of the id of the authenticated author.
+const authorKey = "author_id"
+
+// maxAuthorNameLength is the longest name an author can have.
+const maxAuthorNameLength = 100
+
+// authenticate admits requests carrying an author token in the Authorization header as "Bearer <token>",
+// setting the author's id on the context.
+func (s *Server) authenticate() gin.HandlerFunc {
+	return func(c *ginext.Context) {
+		token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "token is required"})
+			return
+		}
+
+		authorID, err := s.st.AuthorByToken(c.Request.Context(), token)
+		if err != nil {
+			if errors.Is(err, storage.ErrUnauthorized) {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.Set(authorKey, authorID)
+		c.Next()
+	}
+}
+
+// requireAdmin admits requests carrying the admin token in the X-Admin-Token header. Without a configured
+// token the admin API is switched off and answers 404.
+func (s *Server) requireAdmin() gin.HandlerFunc {
+	return func(c *ginext.Context) {
+		if s.opts.AdminToken == "" {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "admin api is disabled"})
+			return
+		}
+		token := c.GetHeader("X-Admin-Token")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.opts.AdminToken)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid admin token"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// postAuthor is the handler for the POST /authors route.
+func (s *Server) postAuthor() gin.HandlerFunc {
+	return func(c *ginext.Context) {
+		var req domain.AddAuthorRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		name := strings.TrimSpace(req.Name)
+		if name == "" || len(name) > maxAuthorNameLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid name"})
+			return
+		}
+
+		author, err := s.st.AddAuthor(c.Request.Context(), name)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusCreated, author)
+	}
+}
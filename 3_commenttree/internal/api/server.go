@@ -9,6 +9,7 @@ import (
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -19,15 +20,32 @@ import (
 // Storage is the interface that wraps the basic methods for storing and retrieving comments.
 type Storage interface {
 	AddComment(ctx context.Context, comment domain.Comment) (domain.Comment, error)
-	GetComments(ctx context.Context, parentID string, asc bool, limit, offset int) (*domain.CommentTree, error)
+	GetComments(ctx context.Context, threadID, parentID string, asc bool, limit, offset int) (*domain.CommentTree, error)
+	SoftDeleteComment(ctx context.Context, id, authorID string) error
 	DeleteComments(ctx context.Context, id string) error
-	SearchComments(ctx context.Context, query string, limit, offset int) ([]domain.Comment, error)
+	SearchComments(ctx context.Context, threadID, query string, limit, offset int) ([]domain.Comment, error)
+	EditComment(ctx context.Context, id, authorID, content string, editableSince, now time.Time) (domain.Comment, error)
+	Revisions(ctx context.Context, id string) ([]domain.Revision, error)
+	AddAuthor(ctx context.Context, name string) (domain.Author, error)
+	AuthorByToken(ctx context.Context, token string) (string, error)
 }
 
-// Server is the main server struct that contains the engine and the storage.
+// DefaultEditWindow is how long after posting a comment its author can edit it, unless configured otherwise.
+const DefaultEditWindow = 15 * time.Minute
+
+// Options is the struct that contains the settings of the server.
+type Options struct {
+	// EditWindow is how long after posting a comment its author can edit it.
+	EditWindow time.Duration
+	// AdminToken is the secret the admin API expects in the X-Admin-Token header. If empty, the admin API is off.
+	AdminToken string
+}
+
+// Server is the main server struct that contains the engine, the storage and the settings.
 type Server struct {
-	r  *ginext.Engine
-	st Storage
+	r    *ginext.Engine
+	st   Storage
+	opts Options
 }
 
 // New creates a new server with the given storage.
@@ -35,6 +53,7 @@ func New(st Storage) *Server {
 	r := ginext.New()
 
 	s := &Server{r: r, st: st}
+	s.Configure(Options{})
 
 	s.setMiddlewares()
 
@@ -42,12 +61,20 @@ func New(st Storage) *Server {
 	return s
 }
 
+// Configure applies the settings, using the defaults for the ones left zero.
+func (s *Server) Configure(opts Options) {
+	if opts.EditWindow <= 0 {
+		opts.EditWindow = DefaultEditWindow
+	}
+	s.opts = opts
+}
+
 // setMiddlewares sets the middlewares for the server.
 func (s *Server) setMiddlewares() {
 	s.r.Use(cors.New(cors.Config{
 		AllowOrigins: []string{"*"},
-		AllowMethods: []string{"GET", "POST", "DELETE"},
-		AllowHeaders: []string{"Content-Type"},
+		AllowMethods: []string{"GET", "POST", "PATCH", "DELETE"},
+		AllowHeaders: []string{"Content-Type", "Authorization", "X-Admin-Token"},
 	}))
 	s.r.Use(gin.Logger())
 	s.r.Use(gin.Recovery())
@@ -55,16 +82,39 @@ func (s *Server) setMiddlewares() {
 
 // setRoutes sets the routes for the server.
 func (s *Server) setRoutes() {
-	s.r.POST("/comments", s.postComment())
+	s.r.POST("/authors", s.postAuthor())
+	s.r.POST("/comments", s.authenticate(), s.postComment())
 	s.r.GET("/comments", s.getComment())
 	s.r.GET("/comments/search", s.searchComments())
-	s.r.DELETE("/comments/:id", s.deleteComment())
+	s.r.PATCH("/comments/:id", s.authenticate(), s.patchComment())
+	s.r.GET("/comments/:id/revisions", s.getRevisions())
+	s.r.DELETE("/comments/:id", s.authenticate(), s.deleteComment())
+	s.r.DELETE("/admin/comments/:id", s.requireAdmin(), s.adminDeleteComment())
 	s.r.StaticFile("/", "./static/index.html")
 }
 
+// validThreadID reports whether id can be a thread id: a non-empty key of printable characters without spaces.
+func validThreadID(id string) bool {
+	if id == "" || len(id) > domain.MaxThreadIDLength {
+		return false
+	}
+	for _, r := range id {
+		if !unicode.IsPrint(r) || unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
+
 // getComment is the handler for the GET /comments route.
 func (s *Server) getComment() gin.HandlerFunc {
 	return func(c *ginext.Context) {
+		threadID := c.Query("thread")
+		if !validThreadID(threadID) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid thread id"})
+			return
+		}
+
 		parentID := c.Query("parent")
 		if _, err := uuid.Parse(parentID); parentID != "" && err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid parent id"})
@@ -86,7 +136,7 @@ func (s *Server) getComment() gin.HandlerFunc {
 		}
 		offset := (page - 1) * limit
 
-		commentTree, err := s.st.GetComments(c.Request.Context(), parentID, asc, limit, offset)
+		commentTree, err := s.st.GetComments(c.Request.Context(), threadID, parentID, asc, limit, offset)
 		if err != nil {
 			if errors.Is(err, storage.ErrNotFound) {
 				c.JSON(http.StatusNotFound, gin.H{"error": "comments not found"})
@@ -123,12 +173,19 @@ func (s *Server) postComment() gin.HandlerFunc {
 			return
 		}
 
+		if !validThreadID(req.ThreadID) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid thread id"})
+			return
+		}
+
 		if _, err := uuid.Parse(req.ParentID); req.ParentID != "" && err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid parent id"})
 			return
 		}
 
 		comment := domain.Comment{
+			ThreadID:  req.ThreadID,
+			AuthorID:  c.GetString(authorKey),
 			Content:   req.Content,
 			ParentID:  req.ParentID,
 			CreatedAt: time.Now(),
@@ -136,6 +193,10 @@ func (s *Server) postComment() gin.HandlerFunc {
 
 		comment, err := s.st.AddComment(c.Request.Context(), comment)
 		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "parent comment not found in thread"})
+				return
+			}
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
@@ -144,7 +205,8 @@ func (s *Server) postComment() gin.HandlerFunc {
 	}
 }
 
-// deleteComment is the handler for the DELETE /comments/:id route.
+// deleteComment is the handler for the DELETE /comments/:id route. Authors delete their own comments, and the
+// replies of the comment are kept.
 func (s *Server) deleteComment() gin.HandlerFunc {
 	return func(c *ginext.Context) {
 		id := c.Param("id")
@@ -153,7 +215,36 @@ func (s *Server) deleteComment() gin.HandlerFunc {
 			return
 		}
 
+		if err := s.st.SoftDeleteComment(c.Request.Context(), id, c.GetString(authorKey)); err != nil {
+			switch {
+			case errors.Is(err, storage.ErrNotFound):
+				c.JSON(http.StatusNotFound, gin.H{"error": "comment not found"})
+			case errors.Is(err, storage.ErrForbidden):
+				c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			default:
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			}
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "comment deleted"})
+	}
+}
+
+// adminDeleteComment is the handler for the DELETE /admin/comments/:id route. It deletes the comment with all its replies.
+func (s *Server) adminDeleteComment() gin.HandlerFunc {
+	return func(c *ginext.Context) {
+		id := c.Param("id")
+		if _, err := uuid.Parse(id); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+			return
+		}
+
 		if err := s.st.DeleteComments(c.Request.Context(), id); err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "comment not found"})
+				return
+			}
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
@@ -162,9 +253,75 @@ func (s *Server) deleteComment() gin.HandlerFunc {
 	}
 }
 
+// patchComment is the handler for the PATCH /comments/:id route.
+func (s *Server) patchComment() gin.HandlerFunc {
+	return func(c *ginext.Context) {
+		id := c.Param("id")
+		if _, err := uuid.Parse(id); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+			return
+		}
+
+		var req domain.EditCommentRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if strings.TrimSpace(req.Content) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "content is required"})
+			return
+		}
+
+		now := time.Now()
+		comment, err := s.st.EditComment(c.Request.Context(), id, c.GetString(authorKey), req.Content, now.Add(-s.opts.EditWindow), now)
+		if err != nil {
+			switch {
+			case errors.Is(err, storage.ErrNotFound):
+				c.JSON(http.StatusNotFound, gin.H{"error": "comment not found"})
+			case errors.Is(err, storage.ErrForbidden), errors.Is(err, storage.ErrEditWindowClosed):
+				c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			default:
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			}
+			return
+		}
+
+		c.JSON(http.StatusOK, comment)
+	}
+}
+
+// getRevisions is the handler for the GET /comments/:id/revisions route.
+func (s *Server) getRevisions() gin.HandlerFunc {
+	return func(c *ginext.Context) {
+		id := c.Param("id")
+		if _, err := uuid.Parse(id); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+			return
+		}
+
+		revisions, err := s.st.Revisions(c.Request.Context(), id)
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "comment not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, revisions)
+	}
+}
+
 // searchComments is the handler for the GET /comments/search route.
 func (s *Server) searchComments() gin.HandlerFunc {
 	return func(c *ginext.Context) {
+		threadID := c.Query("thread")
+		if !validThreadID(threadID) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid thread id"})
+			return
+		}
+
 		query := c.Query("q")
 		if query == "" {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "query is required"})
@@ -184,7 +341,7 @@ func (s *Server) searchComments() gin.HandlerFunc {
 		}
 		offset := (page - 1) * limit
 
-		res, err := s.st.SearchComments(c.Request.Context(), query, limit, offset)
+		res, err := s.st.SearchComments(c.Request.Context(), threadID, query, limit, offset)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
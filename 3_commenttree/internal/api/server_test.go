@@ -7,6 +7,7 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -19,9 +20,14 @@ import (
 // MockStorage is a mock implementation of Storage interface
 type MockStorage struct {
 	addCommentFunc     func(ctx context.Context, comment domain.Comment) (domain.Comment, error)
-	getCommentsFunc    func(ctx context.Context, parentID string, asc bool, limit, offset int) (*domain.CommentTree, error)
+	getCommentsFunc    func(ctx context.Context, threadID, parentID string, asc bool, limit, offset int) (*domain.CommentTree, error)
+	softDeleteFunc     func(ctx context.Context, id, authorID string) error
 	deleteCommentsFunc func(ctx context.Context, id string) error
-	searchCommentsFunc func(ctx context.Context, query string, limit, offset int) ([]domain.Comment, error)
+	searchCommentsFunc func(ctx context.Context, threadID, query string, limit, offset int) ([]domain.Comment, error)
+	editCommentFunc    func(ctx context.Context, id, authorID, content string, editableSince, now time.Time) (domain.Comment, error)
+	revisionsFunc      func(ctx context.Context, id string) ([]domain.Revision, error)
+	// tokens maps author tokens to author ids; without it every token is author-1's
+	tokens map[string]string
 }
 
 func (m *MockStorage) AddComment(ctx context.Context, comment domain.Comment) (domain.Comment, error) {
@@ -31,13 +37,20 @@ func (m *MockStorage) AddComment(ctx context.Context, comment domain.Comment) (d
 	return domain.Comment{}, nil
 }
 
-func (m *MockStorage) GetComments(ctx context.Context, parentID string, asc bool, limit, offset int) (*domain.CommentTree, error) {
+func (m *MockStorage) GetComments(ctx context.Context, threadID, parentID string, asc bool, limit, offset int) (*domain.CommentTree, error) {
 	if m.getCommentsFunc != nil {
-		return m.getCommentsFunc(ctx, parentID, asc, limit, offset)
+		return m.getCommentsFunc(ctx, threadID, parentID, asc, limit, offset)
 	}
 	return nil, nil
 }
 
+func (m *MockStorage) SoftDeleteComment(ctx context.Context, id, authorID string) error {
+	if m.softDeleteFunc != nil {
+		return m.softDeleteFunc(ctx, id, authorID)
+	}
+	return nil
+}
+
 func (m *MockStorage) DeleteComments(ctx context.Context, id string) error {
 	if m.deleteCommentsFunc != nil {
 		return m.deleteCommentsFunc(ctx, id)
@@ -45,13 +58,57 @@ func (m *MockStorage) DeleteComments(ctx context.Context, id string) error {
 	return nil
 }
 
-func (m *MockStorage) SearchComments(ctx context.Context, query string, limit, offset int) ([]domain.Comment, error) {
+func (m *MockStorage) SearchComments(ctx context.Context, threadID, query string, limit, offset int) ([]domain.Comment, error) {
 	if m.searchCommentsFunc != nil {
-		return m.searchCommentsFunc(ctx, query, limit, offset)
+		return m.searchCommentsFunc(ctx, threadID, query, limit, offset)
 	}
 	return nil, nil
 }
 
+func (m *MockStorage) EditComment(ctx context.Context, id, authorID, content string, editableSince, now time.Time) (domain.Comment, error) {
+	if m.editCommentFunc != nil {
+		return m.editCommentFunc(ctx, id, authorID, content, editableSince, now)
+	}
+	return domain.Comment{}, nil
+}
+
+func (m *MockStorage) Revisions(ctx context.Context, id string) ([]domain.Revision, error) {
+	if m.revisionsFunc != nil {
+		return m.revisionsFunc(ctx, id)
+	}
+	return []domain.Revision{}, nil
+}
+
+func (m *MockStorage) AddAuthor(ctx context.Context, name string) (domain.Author, error) {
+	return domain.Author{ID: uuid.NewString(), Name: name, Token: "token", CreatedAt: time.Now()}, nil
+}
+
+func (m *MockStorage) AuthorByToken(ctx context.Context, token string) (string, error) {
+	if m.tokens == nil {
+		return "author-1", nil
+	}
+	if id, ok := m.tokens[token]; ok {
+		return id, nil
+	}
+	return "", storage.ErrUnauthorized
+}
+
+// postAs posts a JSON body with the author token.
+func postAs(token, url string, body io.Reader) (*http.Response, error) {
+	return sendAs(token, http.MethodPost, url, body)
+}
+
+// sendAs sends a JSON body with the author token.
+func sendAs(token, method, url string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	return http.DefaultClient.Do(req)
+}
+
 func TestStorageInterface(t *testing.T) {
 	tests := []struct {
 		name         string
@@ -76,7 +133,7 @@ func TestStorageInterface(t *testing.T) {
 					comment.CreatedAt = time.Now()
 					return comment, nil
 				},
-				getCommentsFunc: func(ctx context.Context, parentID string, asc bool, limit, offset int) (*domain.CommentTree, error) {
+				getCommentsFunc: func(ctx context.Context, threadID, parentID string, asc bool, limit, offset int) (*domain.CommentTree, error) {
 					return &domain.CommentTree{
 						ID:        "comment-123",
 						Content:   "Test comment",
@@ -87,7 +144,7 @@ func TestStorageInterface(t *testing.T) {
 				deleteCommentsFunc: func(ctx context.Context, id string) error {
 					return nil
 				},
-				searchCommentsFunc: func(ctx context.Context, query string, limit, offset int) ([]domain.Comment, error) {
+				searchCommentsFunc: func(ctx context.Context, threadID, query string, limit, offset int) ([]domain.Comment, error) {
 					return []domain.Comment{
 						{
 							ID:        "comment-123",
@@ -136,13 +193,13 @@ func TestStorageInterface(t *testing.T) {
 				addCommentFunc: func(ctx context.Context, comment domain.Comment) (domain.Comment, error) {
 					return domain.Comment{}, errors.New("add comment failed")
 				},
-				getCommentsFunc: func(ctx context.Context, parentID string, asc bool, limit, offset int) (*domain.CommentTree, error) {
+				getCommentsFunc: func(ctx context.Context, threadID, parentID string, asc bool, limit, offset int) (*domain.CommentTree, error) {
 					return nil, errors.New("get comments failed")
 				},
 				deleteCommentsFunc: func(ctx context.Context, id string) error {
 					return errors.New("delete comment failed")
 				},
-				searchCommentsFunc: func(ctx context.Context, query string, limit, offset int) ([]domain.Comment, error) {
+				searchCommentsFunc: func(ctx context.Context, threadID, query string, limit, offset int) ([]domain.Comment, error) {
 					return nil, errors.New("search comments failed")
 				},
 			},
@@ -180,7 +237,7 @@ func TestStorageInterface(t *testing.T) {
 			}
 
 			// Test GetComments
-			commentTree, err := tt.storage.GetComments(ctx, tt.parentID, tt.asc, tt.limit, tt.offset)
+			commentTree, err := tt.storage.GetComments(ctx, "article:1", tt.parentID, tt.asc, tt.limit, tt.offset)
 			if tt.expectError && err == nil {
 				t.Errorf("Expected error but got none")
 			}
@@ -201,7 +258,7 @@ func TestStorageInterface(t *testing.T) {
 			}
 
 			// Test SearchComments
-			comments, err := tt.storage.SearchComments(ctx, tt.query, tt.limit, tt.offset)
+			comments, err := tt.storage.SearchComments(ctx, "article:1", tt.query, tt.limit, tt.offset)
 			if tt.expectError && err == nil {
 				t.Errorf("Expected error but got none")
 			}
@@ -234,7 +291,7 @@ func TestNewServer(t *testing.T) {
 
 func TestStorageInterfaceWithNestedComments(t *testing.T) {
 	storage := &MockStorage{
-		getCommentsFunc: func(ctx context.Context, parentID string, asc bool, limit, offset int) (*domain.CommentTree, error) {
+		getCommentsFunc: func(ctx context.Context, threadID, parentID string, asc bool, limit, offset int) (*domain.CommentTree, error) {
 			// Create a nested comment tree
 			childComment := &domain.CommentTree{
 				ID:        "child-123",
@@ -255,7 +312,7 @@ func TestStorageInterfaceWithNestedComments(t *testing.T) {
 	}
 
 	ctx := context.Background()
-	commentTree, err := storage.GetComments(ctx, "", true, 10, 0)
+	commentTree, err := storage.GetComments(ctx, "article:1", "", true, 10, 0)
 
 	if err != nil {
 		t.Errorf("Expected no error but got: %v", err)
@@ -274,7 +331,7 @@ func TestStorageInterfaceWithNestedComments(t *testing.T) {
 
 func TestStorageInterfaceWithSearch(t *testing.T) {
 	storage := &MockStorage{
-		searchCommentsFunc: func(ctx context.Context, query string, limit, offset int) ([]domain.Comment, error) {
+		searchCommentsFunc: func(ctx context.Context, threadID, query string, limit, offset int) ([]domain.Comment, error) {
 			// Return multiple comments that match the search query
 			return []domain.Comment{
 				{
@@ -294,7 +351,7 @@ func TestStorageInterfaceWithSearch(t *testing.T) {
 	}
 
 	ctx := context.Background()
-	comments, err := storage.SearchComments(ctx, "test", 10, 0)
+	comments, err := storage.SearchComments(ctx, "article:1", "test", 10, 0)
 
 	if err != nil {
 		t.Errorf("Expected no error but got: %v", err)
@@ -315,7 +372,7 @@ func TestStorageInterfaceWithSearch(t *testing.T) {
 func TestGetComments_Handler(t *testing.T) {
     // happy path: returns empty JSON when no comments overall (nil tree)
     st := &MockStorage{
-        getCommentsFunc: func(ctx context.Context, parentID string, asc bool, limit, offset int) (*domain.CommentTree, error) {
+        getCommentsFunc: func(ctx context.Context, threadID, parentID string, asc bool, limit, offset int) (*domain.CommentTree, error) {
             return nil, nil
         },
     }
@@ -323,7 +380,7 @@ func TestGetComments_Handler(t *testing.T) {
     ts := httptest.NewServer(s.r)
     defer ts.Close()
 
-    resp, err := http.Get(ts.URL + "/comments")
+    resp, err := http.Get(ts.URL + "/comments?thread=article:1")
     if err != nil {
         t.Fatalf("http get error: %v", err)
     }
@@ -332,7 +389,7 @@ func TestGetComments_Handler(t *testing.T) {
     }
 
     // invalid parent id should return 400
-    resp, err = http.Get(ts.URL + "/comments?parent=not-a-uuid")
+    resp, err = http.Get(ts.URL + "/comments?thread=article:1&parent=not-a-uuid")
     if err != nil {
         t.Fatalf("http get error: %v", err)
     }
@@ -343,7 +400,7 @@ func TestGetComments_Handler(t *testing.T) {
 
 func TestGetComments_NotFound(t *testing.T) {
     st := &MockStorage{
-        getCommentsFunc: func(ctx context.Context, parentID string, asc bool, limit, offset int) (*domain.CommentTree, error) {
+        getCommentsFunc: func(ctx context.Context, threadID, parentID string, asc bool, limit, offset int) (*domain.CommentTree, error) {
             return nil, storage.ErrNotFound
         },
     }
@@ -353,7 +410,7 @@ func TestGetComments_NotFound(t *testing.T) {
 
     // when storage says not found for specific parent, expect 404
     id := uuid.NewString()
-    resp, err := http.Get(ts.URL + "/comments?parent=" + url.QueryEscape(id))
+    resp, err := http.Get(ts.URL + "/comments?thread=article:1&parent=" + url.QueryEscape(id))
     if err != nil {
         t.Fatalf("http get error: %v", err)
     }
@@ -374,7 +431,7 @@ func TestPostComment_Handler(t *testing.T) {
     defer ts.Close()
 
     // invalid body
-    resp, err := http.Post(ts.URL+"/comments", "application/json", bytes.NewBufferString("{"))
+    resp, err := postAs("token", ts.URL+"/comments", bytes.NewBufferString("{"))
     if err != nil {
         t.Fatalf("post error: %v", err)
     }
@@ -383,8 +440,8 @@ func TestPostComment_Handler(t *testing.T) {
     }
 
     // invalid parent id
-    body, _ := json.Marshal(domain.AddCommentRequest{Content: "hi", ParentID: "not-a-uuid"})
-    resp, err = http.Post(ts.URL+"/comments", "application/json", bytes.NewReader(body))
+    body, _ := json.Marshal(domain.AddCommentRequest{ThreadID: "article:1", Content: "hi", ParentID: "not-a-uuid"})
+    resp, err = postAs("token", ts.URL+"/comments", bytes.NewReader(body))
     if err != nil {
         t.Fatalf("post error: %v", err)
     }
@@ -393,8 +450,8 @@ func TestPostComment_Handler(t *testing.T) {
     }
 
     // success
-    body, _ = json.Marshal(domain.AddCommentRequest{Content: "hello"})
-    resp, err = http.Post(ts.URL+"/comments", "application/json", bytes.NewReader(body))
+    body, _ = json.Marshal(domain.AddCommentRequest{ThreadID: "article:1", Content: "hello"})
+    resp, err = postAs("token", ts.URL+"/comments", bytes.NewReader(body))
     if err != nil {
         t.Fatalf("post error: %v", err)
     }
@@ -404,10 +461,25 @@ func TestPostComment_Handler(t *testing.T) {
 }
 
 func TestDeleteComment_Handler(t *testing.T) {
-    called := false
+    mine, theirs, missing := uuid.NewString(), uuid.NewString(), uuid.NewString()
+    var deleted []string
     st := &MockStorage{
+        tokens: map[string]string{"alice-token": "alice"},
+        softDeleteFunc: func(ctx context.Context, id, authorID string) error {
+            switch id {
+            case missing:
+                return storage.ErrNotFound
+            case theirs:
+                return storage.ErrForbidden
+            }
+            if authorID != "alice" {
+                t.Fatalf("expected alice to delete, got %q", authorID)
+            }
+            deleted = append(deleted, id)
+            return nil
+        },
         deleteCommentsFunc: func(ctx context.Context, id string) error {
-            called = true
+            t.Fatalf("expected the replies to be kept")
             return nil
         },
     }
@@ -416,34 +488,34 @@ func TestDeleteComment_Handler(t *testing.T) {
     ts := httptest.NewServer(s.r)
     defer ts.Close()
 
-    // invalid id
-    req, _ := http.NewRequest(http.MethodDelete, ts.URL+"/comments/not-a-uuid", nil)
-    resp, err := http.DefaultClient.Do(req)
-    if err != nil {
-        t.Fatalf("delete error: %v", err)
-    }
-    if resp.StatusCode != http.StatusBadRequest {
-        t.Fatalf("expected 400, got %d", resp.StatusCode)
-    }
-
-    // valid id
-    id := uuid.NewString()
-    req, _ = http.NewRequest(http.MethodDelete, ts.URL+"/comments/"+id, nil)
-    resp, err = http.DefaultClient.Do(req)
-    if err != nil {
-        t.Fatalf("delete error: %v", err)
-    }
-    if resp.StatusCode != http.StatusOK {
-        t.Fatalf("expected 200, got %d", resp.StatusCode)
+    for _, tc := range []struct {
+        name, token, id string
+        want            int
+    }{
+        {"no token", "", mine, http.StatusUnauthorized},
+        {"unknown token", "mallory-token", mine, http.StatusUnauthorized},
+        {"invalid id", "alice-token", "not-a-uuid", http.StatusBadRequest},
+        {"own comment", "alice-token", mine, http.StatusOK},
+        {"someone else's", "alice-token", theirs, http.StatusForbidden},
+        {"already deleted", "alice-token", missing, http.StatusNotFound},
+    } {
+        resp, err := sendAs(tc.token, http.MethodDelete, ts.URL+"/comments/"+tc.id, nil)
+        if err != nil {
+            t.Fatalf("%s: delete error: %v", tc.name, err)
+        }
+        resp.Body.Close()
+        if resp.StatusCode != tc.want {
+            t.Fatalf("%s: expected %d, got %d", tc.name, tc.want, resp.StatusCode)
+        }
     }
-    if !called {
-        t.Fatalf("expected delete to be called")
+    if len(deleted) != 1 || deleted[0] != mine {
+        t.Fatalf("expected only the own comment to be deleted, got %v", deleted)
     }
 }
 
 func TestSearchComments_Handler(t *testing.T) {
     st := &MockStorage{
-        searchCommentsFunc: func(ctx context.Context, query string, limit, offset int) ([]domain.Comment, error) {
+        searchCommentsFunc: func(ctx context.Context, threadID, query string, limit, offset int) ([]domain.Comment, error) {
             return []domain.Comment{{ID: "1", Content: "ok"}}, nil
         },
     }
@@ -461,7 +533,7 @@ func TestSearchComments_Handler(t *testing.T) {
     }
 
     // ok
-    resp, err = http.Get(ts.URL + "/comments/search?q=hello&page=1&limit=10")
+    resp, err = http.Get(ts.URL + "/comments/search?thread=article:1&q=hello&page=1&limit=10")
     if err != nil {
         t.Fatalf("get error: %v", err)
     }
@@ -469,3 +541,275 @@ func TestSearchComments_Handler(t *testing.T) {
         t.Fatalf("expected 200, got %d", resp.StatusCode)
     }
 }
+
+func TestThreads_Handler(t *testing.T) {
+	parentID := uuid.NewString()
+	var gotThreads []string
+	st := &MockStorage{
+		addCommentFunc: func(ctx context.Context, comment domain.Comment) (domain.Comment, error) {
+			gotThreads = append(gotThreads, comment.ThreadID)
+			// the parent is in another thread
+			if comment.ParentID == parentID {
+				return domain.Comment{}, storage.ErrNotFound
+			}
+			comment.ID = uuid.NewString()
+			return comment, nil
+		},
+		getCommentsFunc: func(ctx context.Context, threadID, parentID string, asc bool, limit, offset int) (*domain.CommentTree, error) {
+			gotThreads = append(gotThreads, threadID)
+			return &domain.CommentTree{}, nil
+		},
+		searchCommentsFunc: func(ctx context.Context, threadID, query string, limit, offset int) ([]domain.Comment, error) {
+			gotThreads = append(gotThreads, threadID)
+			return []domain.Comment{}, nil
+		},
+	}
+	s := New(st)
+	ts := httptest.NewServer(s.r)
+	defer ts.Close()
+
+	// every route needs a thread
+	for _, target := range []string{"/comments", "/comments?thread=", "/comments/search?q=hello", "/comments?thread=" + url.QueryEscape("article 1")} {
+		resp, err := http.Get(ts.URL + target)
+		if err != nil {
+			t.Fatalf("get error: %v", err)
+		}
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("expected 400 for %s, got %d", target, resp.StatusCode)
+		}
+	}
+	body, _ := json.Marshal(domain.AddCommentRequest{Content: "hello"})
+	resp, err := postAs("token", ts.URL+"/comments", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("post error: %v", err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected a comment without a thread to be refused with 400, got %d", resp.StatusCode)
+	}
+
+	body, _ = json.Marshal(domain.AddCommentRequest{ThreadID: "product:7", Content: "reply", ParentID: parentID})
+	resp, err = postAs("token", ts.URL+"/comments", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("post error: %v", err)
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected a reply to a parent of another thread to be 404, got %d", resp.StatusCode)
+	}
+
+	for _, target := range []string{"/comments?thread=article:1", "/comments/search?thread=article:2&q=hello"} {
+		resp, err := http.Get(ts.URL + target)
+		if err != nil {
+			t.Fatalf("get error: %v", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected 200 for %s, got %d", target, resp.StatusCode)
+		}
+	}
+	want := []string{"product:7", "article:1", "article:2"}
+	if len(gotThreads) != len(want) {
+		t.Fatalf("expected threads %v, got %v", want, gotThreads)
+	}
+	for i := range want {
+		if gotThreads[i] != want[i] {
+			t.Fatalf("expected threads %v, got %v", want, gotThreads)
+		}
+	}
+}
+
+func TestAuthors_Handler(t *testing.T) {
+	var gotAuthor string
+	st := &MockStorage{
+		tokens: map[string]string{"alice-token": "alice"},
+		addCommentFunc: func(ctx context.Context, comment domain.Comment) (domain.Comment, error) {
+			gotAuthor = comment.AuthorID
+			comment.ID = uuid.NewString()
+			return comment, nil
+		},
+	}
+	s := New(st)
+	ts := httptest.NewServer(s.r)
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL+"/authors", "application/json", bytes.NewBufferString(`{"name": "  "}`))
+	if err != nil {
+		t.Fatalf("post error: %v", err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected an author without a name to be refused with 400, got %d", resp.StatusCode)
+	}
+	resp, err = http.Post(ts.URL+"/authors", "application/json", bytes.NewBufferString(`{"name": "Alice"}`))
+	if err != nil {
+		t.Fatalf("post error: %v", err)
+	}
+	var author domain.Author
+	if err := json.NewDecoder(resp.Body).Decode(&author); err != nil || resp.StatusCode != http.StatusCreated || author.Token == "" {
+		t.Fatalf("expected the new author with a token, got %d %+v: %v", resp.StatusCode, author, err)
+	}
+
+	body, _ := json.Marshal(domain.AddCommentRequest{ThreadID: "article:1", Content: "hello"})
+	resp, err = http.Post(ts.URL+"/comments", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("post error: %v", err)
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected a comment without a token to be 401, got %d", resp.StatusCode)
+	}
+	resp, err = postAs("stolen-token", ts.URL+"/comments", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("post error: %v", err)
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected a comment with an unknown token to be 401, got %d", resp.StatusCode)
+	}
+	resp, err = postAs("alice-token", ts.URL+"/comments", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("post error: %v", err)
+	}
+	if resp.StatusCode != http.StatusCreated || gotAuthor != "alice" {
+		t.Fatalf("expected the comment to be alice's, got %d by %q", resp.StatusCode, gotAuthor)
+	}
+}
+
+func TestPatchComment_Handler(t *testing.T) {
+	mine, theirs, old, missing := uuid.NewString(), uuid.NewString(), uuid.NewString(), uuid.NewString()
+	var gotSince, gotNow time.Time
+	st := &MockStorage{
+		tokens: map[string]string{"alice-token": "alice"},
+		editCommentFunc: func(ctx context.Context, id, authorID, content string, editableSince, now time.Time) (domain.Comment, error) {
+			gotSince, gotNow = editableSince, now
+			switch id {
+			case theirs:
+				return domain.Comment{}, storage.ErrForbidden
+			case old:
+				return domain.Comment{}, storage.ErrEditWindowClosed
+			case missing:
+				return domain.Comment{}, storage.ErrNotFound
+			}
+			return domain.Comment{ID: id, AuthorID: authorID, Content: content, EditedAt: &now}, nil
+		},
+	}
+	s := New(st)
+	s.Configure(Options{EditWindow: time.Hour})
+	ts := httptest.NewServer(s.r)
+	defer ts.Close()
+
+	patch := func(token, id, content string) *http.Response {
+		body, _ := json.Marshal(domain.EditCommentRequest{Content: content})
+		resp, err := sendAs(token, http.MethodPatch, ts.URL+"/comments/"+id, bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("patch error: %v", err)
+		}
+		return resp
+	}
+
+	for _, tc := range []struct {
+		name, token, id, content string
+		want                     int
+	}{
+		{"unknown token", "stolen-token", mine, "edited", http.StatusUnauthorized},
+		{"invalid id", "alice-token", "not-a-uuid", "edited", http.StatusBadRequest},
+		{"empty content", "alice-token", mine, " ", http.StatusBadRequest},
+		{"someone else's", "alice-token", theirs, "edited", http.StatusForbidden},
+		{"window closed", "alice-token", old, "edited", http.StatusForbidden},
+		{"missing", "alice-token", missing, "edited", http.StatusNotFound},
+	} {
+		if resp := patch(tc.token, tc.id, tc.content); resp.StatusCode != tc.want {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.want, resp.StatusCode)
+		}
+	}
+
+	resp := patch("alice-token", mine, "edited")
+	var comment domain.Comment
+	if err := json.NewDecoder(resp.Body).Decode(&comment); err != nil || resp.StatusCode != http.StatusOK ||
+		comment.Content != "edited" || comment.AuthorID != "alice" || comment.EditedAt == nil {
+		t.Fatalf("expected the edited comment, got %d %+v: %v", resp.StatusCode, comment, err)
+	}
+	if gotNow.Sub(gotSince) != time.Hour {
+		t.Fatalf("expected comments of the last hour to be editable, got since %v at %v", gotSince, gotNow)
+	}
+}
+
+func TestRevisions_Handler(t *testing.T) {
+	id := uuid.NewString()
+	written := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	st := &MockStorage{
+		revisionsFunc: func(ctx context.Context, commentID string) ([]domain.Revision, error) {
+			if commentID != id {
+				return nil, storage.ErrNotFound
+			}
+			return []domain.Revision{{ID: "r1", CommentID: id, Content: "first", WrittenAt: written, ReplacedAt: written.Add(time.Minute)}}, nil
+		},
+	}
+	s := New(st)
+	ts := httptest.NewServer(s.r)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/comments/" + id + "/revisions")
+	if err != nil {
+		t.Fatalf("get error: %v", err)
+	}
+	var revisions []domain.Revision
+	if err := json.NewDecoder(resp.Body).Decode(&revisions); err != nil || resp.StatusCode != http.StatusOK ||
+		len(revisions) != 1 || revisions[0].Content != "first" {
+		t.Fatalf("expected the revision, got %d %+v: %v", resp.StatusCode, revisions, err)
+	}
+
+	resp, err = http.Get(ts.URL + "/comments/" + uuid.NewString() + "/revisions")
+	if err != nil {
+		t.Fatalf("get error: %v", err)
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown comment, got %d", resp.StatusCode)
+	}
+}
+
+func TestAdminDeleteComment_Handler(t *testing.T) {
+	id, missing := uuid.NewString(), uuid.NewString()
+	var deleted []string
+	st := &MockStorage{
+		deleteCommentsFunc: func(ctx context.Context, commentID string) error {
+			if commentID == missing {
+				return storage.ErrNotFound
+			}
+			deleted = append(deleted, commentID)
+			return nil
+		},
+	}
+	s := New(st)
+	ts := httptest.NewServer(s.r)
+	defer ts.Close()
+
+	del := func(adminToken, commentID string) int {
+		req, _ := http.NewRequest(http.MethodDelete, ts.URL+"/admin/comments/"+commentID, nil)
+		if adminToken != "" {
+			req.Header.Set("X-Admin-Token", adminToken)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("delete error: %v", err)
+		}
+		return resp.StatusCode
+	}
+
+	if code := del("secret", id); code != http.StatusNotFound {
+		t.Fatalf("expected the admin api to be off without a token, got %d", code)
+	}
+	s.Configure(Options{AdminToken: "secret"})
+	for _, tc := range []struct {
+		name, token, id string
+		want            int
+	}{
+		{"no token", "", id, http.StatusUnauthorized},
+		{"wrong token", "guess", id, http.StatusUnauthorized},
+		{"invalid id", "secret", "not-a-uuid", http.StatusBadRequest},
+		{"missing", "secret", missing, http.StatusNotFound},
+		{"deleted", "secret", id, http.StatusOK},
+	} {
+		if code := del(tc.token, tc.id); code != tc.want {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.want, code)
+		}
+	}
+	if len(deleted) != 1 || deleted[0] != id {
+		t.Fatalf("expected only %s to be deleted with its replies, got %v", id, deleted)
+	}
+}
@@ -2,24 +2,70 @@ package domain
 
 import "time"
 
-// Comment is the main comment struct that contains the comment's id, content, parent id, and creation time.
+// MaxThreadIDLength is the longest thread id a comment can have.
+const MaxThreadIDLength = 255
+
+// DeletedContent is the content a deleted comment shows while it stays in the tree for its replies.
+const DeletedContent = "[deleted]"
+
+// Comment is the main comment struct that contains the comment's id, thread id, author id, content, parent id,
+// creation time and, if it was edited, the time of the last edit. A deleted comment has DeletedContent and no author.
+// The thread id is the key of the resource the comment is about, e.g. article:123; a reply is in the thread of its parent.
 type Comment struct {
-	ID        string    `json:"id"`
-	Content   string    `json:"content"`
-	ParentID  string    `json:"parent_id"`
-	CreatedAt time.Time `json:"created_at"`
+	ID        string     `json:"id"`
+	ThreadID  string     `json:"thread_id"`
+	AuthorID  string     `json:"author_id,omitempty"`
+	Content   string     `json:"content"`
+	ParentID  string     `json:"parent_id"`
+	CreatedAt time.Time  `json:"created_at"`
+	EditedAt  *time.Time `json:"edited_at,omitempty"`
+	Deleted   bool       `json:"deleted,omitempty"`
 }
 
-// CommentTree is the struct that contains the comment's id, content, creation time, and children comments.
+// CommentTree is the struct that contains the comment's id, author id, content, creation and edit time, and children comments.
+// A deleted comment is only in the tree as a tombstone for its children.
 type CommentTree struct {
 	ID        string         `json:"id"`
+	AuthorID  string         `json:"author_id,omitempty"`
 	Content   string         `json:"content"`
 	CreatedAt time.Time      `json:"created_at"`
+	EditedAt  *time.Time     `json:"edited_at,omitempty"`
+	Deleted   bool           `json:"deleted,omitempty"`
 	Children  []*CommentTree `json:"children"`
 }
 
-// AddCommentRequest is the struct that contains the comment's content and parent id.
+// AddCommentRequest is the struct that contains the comment's thread id, content and parent id.
 type AddCommentRequest struct {
+	ThreadID string `json:"thread_id"`
 	Content  string `json:"content"`
 	ParentID string `json:"parent_id,omitempty"`
 }
+
+// EditCommentRequest is the struct that contains the new content of a comment.
+type EditCommentRequest struct {
+	Content string `json:"content"`
+}
+
+// Revision is the struct that contains a content a comment had before an edit, when it was written and when
+// it was edited away.
+type Revision struct {
+	ID         string    `json:"id"`
+	CommentID  string    `json:"comment_id"`
+	Content    string    `json:"content"`
+	WrittenAt  time.Time `json:"written_at"`
+	ReplacedAt time.Time `json:"replaced_at"`
+}
+
+// Author is the struct that contains the author's id, name and creation time. The token authenticates the
+// author; it is only returned when the author is created.
+type Author struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Token     string    `json:"token,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AddAuthorRequest is the struct that contains the new author's name.
+type AddAuthorRequest struct {
+	Name string `json:"name"`
+}
@@ -0,0 +1,56 @@
+package postgres
+
+import (
+	"comment-tree/internal/domain"
+	"comment-tree/internal/storage"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+)
+
+// AddAuthor adds a new author with a freshly generated token to the database.
+func (s *Storage) AddAuthor(ctx context.Context, name string) (domain.Author, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return domain.Author{}, err
+	}
+	author := domain.Author{Name: name, Token: hex.EncodeToString(raw)}
+
+	query := `
+		INSERT INTO authors (name, token_hash)
+		VALUES ($1, $2)
+		RETURNING id, created_at
+	`
+
+	if err := s.db.Master.QueryRowContext(ctx, query, name, hashToken(author.Token)).Scan(&author.ID, &author.CreatedAt); err != nil {
+		return domain.Author{}, err
+	}
+
+	return author, nil
+}
+
+// AuthorByToken resolves a token to the id of its author.
+// It returns storage.ErrUnauthorized if the token is unknown.
+func (s *Storage) AuthorByToken(ctx context.Context, token string) (string, error) {
+	query := `
+		SELECT id FROM authors WHERE token_hash = $1
+	`
+
+	var id string
+	if err := s.db.Master.QueryRowContext(ctx, query, hashToken(token)).Scan(&id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", storage.ErrUnauthorized
+		}
+		return "", err
+	}
+
+	return id, nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
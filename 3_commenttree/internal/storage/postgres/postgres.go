@@ -7,6 +7,7 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/kxddry/wbf/dbpg"
 )
@@ -22,10 +23,12 @@ func New(db *dbpg.DB) *Storage {
 }
 
 // AddComment adds a new comment to the database.
+// It returns storage.ErrNotFound if the comment is a reply and its parent is not a comment of the same thread, or is deleted.
 func (s *Storage) AddComment(ctx context.Context, comment domain.Comment) (domain.Comment, error) {
 	query := `
-		INSERT INTO comments (content, parent_id, created_at)
-		VALUES ($1, $2, $3)
+		INSERT INTO comments (thread_id, content, parent_id, created_at, author_id)
+		SELECT $1::text, $2::text, $3::uuid, $4::timestamptz, $5::uuid
+		WHERE $3::uuid IS NULL OR EXISTS (SELECT 1 FROM comments WHERE id = $3 AND thread_id = $1 AND deleted_at IS NULL)
 		RETURNING id
 	`
 
@@ -35,7 +38,12 @@ func (s *Storage) AddComment(ctx context.Context, comment domain.Comment) (domai
 		parentParam = sql.NullString{String: comment.ParentID, Valid: true}
 	}
 
-	rows, err := s.db.QueryContext(ctx, query, comment.Content, parentParam, comment.CreatedAt)
+	var authorParam sql.NullString
+	if comment.AuthorID != "" {
+		authorParam = sql.NullString{String: comment.AuthorID, Valid: true}
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, comment.ThreadID, comment.Content, parentParam, comment.CreatedAt, authorParam)
 	if err != nil {
 		return domain.Comment{}, err
 	}
@@ -53,8 +61,9 @@ func (s *Storage) AddComment(ctx context.Context, comment domain.Comment) (domai
 	return comment, nil
 }
 
-// GetComments retrieves comments from the database.
-func (s *Storage) GetComments(ctx context.Context, parentID string, asc bool, limit, offset int) (*domain.CommentTree, error) {
+// GetComments retrieves comments of the thread from the database: the comment with parentID and its replies,
+// or a page of the thread's root comments with their replies if parentID is empty.
+func (s *Storage) GetComments(ctx context.Context, threadID, parentID string, asc bool, limit, offset int) (*domain.CommentTree, error) {
 	order := "ASC"
 	if !asc {
 		order = "DESC"
@@ -65,19 +74,19 @@ func (s *Storage) GetComments(ctx context.Context, parentID string, asc bool, li
 
 	if parentID == "" {
 		query = fmt.Sprintf(`
-		WITH RECURSIVE roots AS ( SELECT id FROM comments WHERE parent_id IS NULL ORDER BY created_at %s LIMIT $1 OFFSET $2),
-		thread AS ( SELECT c.id, c.content, c.parent_id, c.created_at FROM comments c JOIN roots r ON c.id = r.id UNION ALL
-		SELECT c.id, c.content, c.parent_id, c.created_at FROM comments c INNER JOIN thread t ON c.parent_id = t.id)
-		SELECT id, content, parent_id, created_at FROM thread ORDER BY created_at %s;`, order, order)
-		args = []any{limit, offset}
+		WITH RECURSIVE roots AS ( SELECT id FROM comments WHERE thread_id = $1 AND parent_id IS NULL ORDER BY created_at %s LIMIT $2 OFFSET $3),
+		thread AS ( SELECT c.id, c.content, c.parent_id, c.created_at, c.author_id, c.edited_at, c.deleted_at IS NOT NULL AS deleted FROM comments c JOIN roots r ON c.id = r.id UNION ALL
+		SELECT c.id, c.content, c.parent_id, c.created_at, c.author_id, c.edited_at, c.deleted_at IS NOT NULL FROM comments c INNER JOIN thread t ON c.parent_id = t.id)
+		SELECT id, content, parent_id, created_at, author_id, edited_at, deleted FROM thread ORDER BY created_at %s;`, order, order)
+		args = []any{threadID, limit, offset}
 	} else {
 		query = fmt.Sprintf(`
 		WITH RECURSIVE thread AS (
-			SELECT id, content, parent_id, created_at
+			SELECT id, content, parent_id, created_at, author_id, edited_at, deleted_at IS NOT NULL AS deleted
 			FROM comments
-			WHERE id = $1 UNION ALL SELECT c.id, c.content, c.parent_id, c.created_at FROM comments c INNER JOIN thread t ON c.parent_id = t.id)
-			SELECT id, content, parent_id, created_at FROM thread ORDER BY created_at %s;`, order)
-		args = []any{parentID}
+			WHERE id = $1 AND thread_id = $2 UNION ALL SELECT c.id, c.content, c.parent_id, c.created_at, c.author_id, c.edited_at, c.deleted_at IS NOT NULL FROM comments c INNER JOIN thread t ON c.parent_id = t.id)
+			SELECT id, content, parent_id, created_at, author_id, edited_at, deleted FROM thread ORDER BY created_at %s;`, order)
+		args = []any{parentID, threadID}
 	}
 
 	rows, err := s.db.QueryContext(ctx, query, args...)
@@ -91,20 +100,19 @@ func (s *Storage) GetComments(ctx context.Context, parentID string, asc bool, li
 	foundAny := false
 
 	for rows.Next() {
-		var c domain.Comment
-		var parentNullable sql.NullString
-		if err := rows.Scan(&c.ID, &c.Content, &parentNullable, &c.CreatedAt); err != nil {
+		c, err := scanComment(rows)
+		if err != nil {
 			return nil, err
 		}
 		foundAny = true
-		if parentNullable.Valid {
-			c.ParentID = parentNullable.String
-		}
 
 		node := &domain.CommentTree{
 			ID:        c.ID,
+			AuthorID:  c.AuthorID,
 			Content:   c.Content,
 			CreatedAt: c.CreatedAt,
+			EditedAt:  c.EditedAt,
+			Deleted:   c.Deleted,
 		}
 
 		if c.ID == parentID {
@@ -139,17 +147,17 @@ func (s *Storage) GetComments(ctx context.Context, parentID string, asc bool, li
 	return rootComment, nil
 }
 
-// SearchComments searches for comments in the database.
-func (s *Storage) SearchComments(ctx context.Context, q string, limit, offset int) ([]domain.Comment, error) {
+// SearchComments searches for comments of the thread in the database, leaving deleted comments out.
+func (s *Storage) SearchComments(ctx context.Context, threadID, q string, limit, offset int) ([]domain.Comment, error) {
 	query := `
-		SELECT id, content, parent_id, created_at
+		SELECT id, content, parent_id, created_at, author_id, edited_at, deleted_at IS NOT NULL
 		FROM comments
-		WHERE to_tsvector('simple', content) @@ to_tsquery('simple', $1 || ':*')
+		WHERE thread_id = $1 AND deleted_at IS NULL AND to_tsvector('simple', content) @@ to_tsquery('simple', $2 || ':*')
 		ORDER BY created_at DESC
-		LIMIT $2 OFFSET $3
+		LIMIT $3 OFFSET $4
 	`
 
-	rows, err := s.db.QueryContext(ctx, query, q, limit, offset)
+	rows, err := s.db.QueryContext(ctx, query, threadID, q, limit, offset)
 	if err != nil {
 		return nil, err
 	}
@@ -157,34 +165,238 @@ func (s *Storage) SearchComments(ctx context.Context, q string, limit, offset in
 
 	out := []domain.Comment{}
 	for rows.Next() {
-		var c domain.Comment
-		var parentNullable sql.NullString
-		if err := rows.Scan(&c.ID, &c.Content, &parentNullable, &c.CreatedAt); err != nil {
+		c, err := scanComment(rows)
+		if err != nil {
 			return nil, err
 		}
-		if parentNullable.Valid {
-			c.ParentID = parentNullable.String
-		}
+		c.ThreadID = threadID
 		out = append(out, c)
 	}
 
 	return out, nil
 }
 
-// DeleteComments deletes a comment from the database.
+// scanComment scans the id, content, parent id, creation time, author id, edit time and whether it is deleted of a comment.
+func scanComment(rows *sql.Rows) (domain.Comment, error) {
+	var c domain.Comment
+	var parentNullable, authorNullable sql.NullString
+	var editedNullable sql.NullTime
+	if err := rows.Scan(&c.ID, &c.Content, &parentNullable, &c.CreatedAt, &authorNullable, &editedNullable, &c.Deleted); err != nil {
+		return domain.Comment{}, err
+	}
+	c.ParentID = parentNullable.String
+	c.AuthorID = authorNullable.String
+	if editedNullable.Valid {
+		c.EditedAt = &editedNullable.Time
+	}
+	if c.Deleted {
+		c.Content = domain.DeletedContent
+	}
+	return c, nil
+}
+
+// EditComment replaces the content of the author's comment, keeping the content it had as a revision.
+// It returns storage.ErrNotFound if there is no such comment or it is deleted, storage.ErrForbidden if someone else wrote it,
+// and storage.ErrEditWindowClosed if it was created before editableSince.
+func (s *Storage) EditComment(ctx context.Context, id, authorID, content string, editableSince, now time.Time) (domain.Comment, error) {
+	tx, err := s.db.Master.BeginTx(ctx, nil)
+	if err != nil {
+		return domain.Comment{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// the row lock keeps concurrent edits from losing a revision
+	var owner sql.NullString
+	var createdAt time.Time
+	err = tx.QueryRowContext(ctx, `SELECT author_id, created_at FROM comments WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, id).Scan(&owner, &createdAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return domain.Comment{}, storage.ErrNotFound
+		}
+		return domain.Comment{}, err
+	}
+	if !owner.Valid || owner.String != authorID {
+		return domain.Comment{}, storage.ErrForbidden
+	}
+	if createdAt.Before(editableSince) {
+		return domain.Comment{}, storage.ErrEditWindowClosed
+	}
+
+	query := `
+		INSERT INTO comment_revisions (comment_id, content, written_at, replaced_at)
+		SELECT id, content, COALESCE(edited_at, created_at), $2
+		FROM comments
+		WHERE id = $1
+	`
+	if _, err := tx.ExecContext(ctx, query, id, now); err != nil {
+		return domain.Comment{}, err
+	}
+
+	query = `
+		UPDATE comments SET content = $2, edited_at = $3
+		WHERE id = $1
+		RETURNING id, content, parent_id, created_at, author_id, edited_at, thread_id
+	`
+	var c domain.Comment
+	var parentNullable sql.NullString
+	err = tx.QueryRowContext(ctx, query, id, content, now).
+		Scan(&c.ID, &c.Content, &parentNullable, &c.CreatedAt, &c.AuthorID, &c.EditedAt, &c.ThreadID)
+	if err != nil {
+		return domain.Comment{}, err
+	}
+	c.ParentID = parentNullable.String
+
+	return c, tx.Commit()
+}
+
+// Revisions returns the contents the comment had before its edits, oldest first.
+// It returns storage.ErrNotFound if there is no such comment.
+func (s *Storage) Revisions(ctx context.Context, id string) ([]domain.Revision, error) {
+	query := `
+		SELECT r.id, r.comment_id, r.content, r.written_at, r.replaced_at
+		FROM comments c
+		LEFT JOIN comment_revisions r ON r.comment_id = c.id
+		WHERE c.id = $1
+		ORDER BY r.replaced_at, r.id
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	found := false
+	out := []domain.Revision{}
+	for rows.Next() {
+		found = true
+		var revID, commentID, content sql.NullString
+		var writtenAt, replacedAt sql.NullTime
+		if err := rows.Scan(&revID, &commentID, &content, &writtenAt, &replacedAt); err != nil {
+			return nil, err
+		}
+		// a comment without revisions is a single row of NULLs
+		if !revID.Valid {
+			continue
+		}
+		out = append(out, domain.Revision{
+			ID:         revID.String,
+			CommentID:  commentID.String,
+			Content:    content.String,
+			WrittenAt:  writtenAt.Time,
+			ReplacedAt: replacedAt.Time,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, storage.ErrNotFound
+	}
+
+	return out, nil
+}
+
+// SoftDeleteComment deletes the author's comment. While it has replies, it stays in the tree as a tombstone
+// without its content, author and revisions so that the replies stay visible; without replies it is removed,
+// along with the tombstones above it that are left without replies. It returns storage.ErrNotFound if there is
+// no such comment or it is already deleted, and storage.ErrForbidden if someone else wrote it.
+func (s *Storage) SoftDeleteComment(ctx context.Context, id, authorID string) error {
+	tx, err := s.db.Master.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// the row lock keeps a reply from arriving between the check for replies and the delete
+	var owner sql.NullString
+	err = tx.QueryRowContext(ctx, `SELECT author_id FROM comments WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, id).Scan(&owner)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return storage.ErrNotFound
+		}
+		return err
+	}
+	if !owner.Valid || owner.String != authorID {
+		return storage.ErrForbidden
+	}
+
+	query := `
+		DELETE FROM comments c
+		WHERE id = $1 AND deleted_at IS NULL AND NOT EXISTS (SELECT 1 FROM comments r WHERE r.parent_id = c.id)
+		RETURNING parent_id
+	`
+	var parentID sql.NullString
+	err = tx.QueryRowContext(ctx, query, id).Scan(&parentID)
+	switch {
+	case err == nil:
+		if err := pruneTombstones(ctx, tx, parentID); err != nil {
+			return err
+		}
+		return tx.Commit()
+	case !errors.Is(err, sql.ErrNoRows):
+		return err
+	}
+
+	// the comment has replies
+	query = `
+		UPDATE comments SET content = '', author_id = NULL, edited_at = NULL, deleted_at = NOW()
+		WHERE id = $1
+	`
+	if _, err := tx.ExecContext(ctx, query, id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM comment_revisions WHERE comment_id = $1`, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteComments deletes a comment from the database with all its replies, and the tombstones above it that
+// are left without replies. It returns storage.ErrNotFound if there is no such comment.
 func (s *Storage) DeleteComments(ctx context.Context, id string) error {
+	tx, err := s.db.Master.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
 	query := `
 		DELETE FROM comments
 		WHERE id = $1
+		RETURNING parent_id
 	`
 
-	if _, err := s.db.ExecContext(ctx, query, id); err != nil {
+	var parentID sql.NullString
+	if err := tx.QueryRowContext(ctx, query, id).Scan(&parentID); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return storage.ErrNotFound
 		}
 		return err
 	}
+	if err := pruneTombstones(ctx, tx, parentID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
 
+// pruneTombstones removes the comment with the id, if it is a tombstone without replies, and so on up the tree.
+func pruneTombstones(ctx context.Context, tx *sql.Tx, id sql.NullString) error {
+	query := `
+		DELETE FROM comments c
+		WHERE id = $1 AND deleted_at IS NOT NULL AND NOT EXISTS (SELECT 1 FROM comments r WHERE r.parent_id = c.id)
+		RETURNING parent_id
+	`
+	for id.Valid {
+		if err := tx.QueryRowContext(ctx, query, id.String).Scan(&id); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil
+			}
+			return err
+		}
+	}
 	return nil
 }
 
@@ -2,7 +2,13 @@ package storage
 
 import "errors"
 
-// ErrNotFound is the error that is returned when a comment is not found.
 var (
+	// ErrNotFound is the error that is returned when a comment is not found.
 	ErrNotFound = errors.New("not found")
+	// ErrUnauthorized is the error that is returned when an author token is unknown.
+	ErrUnauthorized = errors.New("invalid token")
+	// ErrForbidden is the error that is returned when an author changes a comment of someone else.
+	ErrForbidden = errors.New("not the author of the comment")
+	// ErrEditWindowClosed is the error that is returned when a comment is edited after its edit window.
+	ErrEditWindowClosed = errors.New("edit window has closed")
 )
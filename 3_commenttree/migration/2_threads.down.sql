@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_comments_thread_id;
+DROP INDEX IF EXISTS idx_comments_thread_roots;
+ALTER TABLE comments
+	DROP CONSTRAINT IF EXISTS comments_parent_thread_fkey,
+	ADD CONSTRAINT comments_parent_id_fkey FOREIGN KEY (parent_id) REFERENCES comments(id) ON DELETE CASCADE;
+ALTER TABLE comments DROP CONSTRAINT IF EXISTS comments_id_thread_id_key;
+ALTER TABLE comments DROP COLUMN IF EXISTS thread_id;
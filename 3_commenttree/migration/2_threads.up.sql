@@ -0,0 +1,14 @@
+-- Comments belong to a thread, keyed by the resource they are about, e.g. article:123.
+-- Existing comments are moved to the "default" thread.
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS thread_id TEXT;
+UPDATE comments SET thread_id = 'default' WHERE thread_id IS NULL;
+ALTER TABLE comments ALTER COLUMN thread_id SET NOT NULL;
+
+-- a reply must be in the thread of its parent
+ALTER TABLE comments ADD CONSTRAINT comments_id_thread_id_key UNIQUE (id, thread_id);
+ALTER TABLE comments
+	DROP CONSTRAINT IF EXISTS comments_parent_id_fkey,
+	ADD CONSTRAINT comments_parent_thread_fkey FOREIGN KEY (parent_id, thread_id) REFERENCES comments(id, thread_id) ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS idx_comments_thread_roots ON comments(thread_id, created_at) WHERE parent_id IS NULL;
+CREATE INDEX IF NOT EXISTS idx_comments_thread_id ON comments(thread_id);
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_comment_revisions_comment_id;
+DROP INDEX IF EXISTS idx_comments_author_id;
+DROP TABLE IF EXISTS comment_revisions;
+ALTER TABLE comments
+	DROP COLUMN IF EXISTS edited_at,
+	DROP COLUMN IF EXISTS author_id;
+DROP TABLE IF EXISTS authors;
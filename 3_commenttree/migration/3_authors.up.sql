@@ -0,0 +1,24 @@
+-- Authors post and edit comments with an API token; only the SHA-256 of the token is kept.
+CREATE TABLE IF NOT EXISTS authors (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	name TEXT NOT NULL,
+	token_hash TEXT NOT NULL UNIQUE,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- comments made before authors have none and can't be edited
+ALTER TABLE comments
+	ADD COLUMN IF NOT EXISTS author_id UUID REFERENCES authors(id),
+	ADD COLUMN IF NOT EXISTS edited_at TIMESTAMPTZ;
+
+-- Every content a comment had before an edit: written_at is when it was written, replaced_at when it was edited away.
+CREATE TABLE IF NOT EXISTS comment_revisions (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	comment_id UUID NOT NULL REFERENCES comments(id) ON DELETE CASCADE,
+	content TEXT NOT NULL,
+	written_at TIMESTAMPTZ NOT NULL,
+	replaced_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_comments_author_id ON comments(author_id);
+CREATE INDEX IF NOT EXISTS idx_comment_revisions_comment_id ON comment_revisions(comment_id, replaced_at);
@@ -0,0 +1,3 @@
+-- Tombstones still hold their replies, so they are kept as ordinary comments with placeholder content.
+UPDATE comments SET content = '[deleted]' WHERE deleted_at IS NOT NULL;
+ALTER TABLE comments DROP COLUMN IF EXISTS deleted_at;
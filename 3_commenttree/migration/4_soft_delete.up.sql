@@ -0,0 +1,3 @@
+-- A deleted comment with replies stays in the tree as a tombstone: deleted_at is set and its content,
+-- author and revisions are removed.
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;